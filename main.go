@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/0xForked/goca/server"
+	"github.com/0xForked/goca/server/hof"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	_ "github.com/glebarez/go-sqlite"
@@ -38,9 +39,12 @@ var allowHeaders = []string{
 
 func createNewEngine() *gin.Engine {
 	engine := gin.Default()
+	if err := engine.SetTrustedProxies(hof.GetTrustedProxies()); err != nil {
+		log.Fatal(err)
+	}
 	engine.Use(cors.New(cors.Config{
 		AllowOrigins:     allowOrigins,
-		AllowMethods:     []string{"GET, POST, PATCH, DELETE"},
+		AllowMethods:     []string{"GET, POST, PUT, PATCH, DELETE"},
 		AllowHeaders:     allowHeaders,
 		ExposeHeaders:    []string{"Content-Length"},
 		AllowCredentials: true,
@@ -13,6 +13,7 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/0xForked/goca/server/hof"
 	"github.com/0xForked/goca/server/user"
 	"github.com/0xForked/goca/web"
 	"github.com/gin-gonic/gin"
@@ -22,7 +23,13 @@ func Run(db *sql.DB, engine *gin.Engine) {
 	ctx, stop := signal.NotifyContext(context.Background(),
 		syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
-	registerRouteAndModule(db, engine)
+	jobs := hof.NewSQLJobScheduler(db)
+	registerRouteAndModule(db, engine, jobs)
+	jobsDone := make(chan struct{})
+	go func() {
+		jobs.Run(ctx)
+		close(jobsDone)
+	}()
 	server := &http.Server{
 		Addr:              ":8000",
 		Handler:           engine,
@@ -44,6 +51,12 @@ func Run(db *sql.DB, engine *gin.Engine) {
 	if err := server.Shutdown(ctx); err != nil {
 		log.Printf("Server forced to shutdown: %s\n", err)
 	}
+	// let a job that is running finish before the database closes
+	select {
+	case <-jobsDone:
+	case <-ctx.Done():
+		log.Println("Jobs forced to shutdown")
+	}
 	if err := db.Close(); err != nil {
 		log.Printf("Database close: %s\n", err)
 	}
@@ -62,11 +75,12 @@ func (f *embeddedFile) Seek(offset int64, whence int) (int64, error) {
 	return f.File.(io.Seeker).Seek(offset, whence)
 }
 
-func registerRouteAndModule(db *sql.DB, router *gin.Engine) {
+func registerRouteAndModule(db *sql.DB, router *gin.Engine, jobs hof.IJobScheduler) {
 	router.GET("/", func(ctx *gin.Context) {
 		ctx.Redirect(http.StatusTemporaryRedirect, "/fe")
 	})
 	router.StaticFS("/fe", http.FS(web.SPAAssets()))
+	router.GET("/.well-known/jwks.json", hof.JWKS)
 	router.NoRoute(func(ctx *gin.Context) {
 		if !strings.Contains(ctx.FullPath(), "/fe/") {
 			ctx.String(http.StatusNotFound,
@@ -91,5 +105,5 @@ func registerRouteAndModule(db *sql.DB, router *gin.Engine) {
 			fileInfo.ModTime(), &embeddedFile{file})
 	})
 	apiRG := router.Group("/api/v1")
-	user.NewUserModuleProvider(apiRG, db)
+	user.NewUserModuleProvider(apiRG, db, jobs)
 }
@@ -1,53 +1,142 @@
 package hof
 
 import (
+	"context"
 	"net/http"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
-func Auth(ctx *gin.Context) {
-	var accessToken string
-	// token from cookie
-	if cookie, err := ctx.Request.Cookie("ACCESS_TOKEN"); err == nil {
-		accessToken = cookie.Value
+const APIKeyPrefix = "goca_"
+
+// APIKeyPrincipal is the owner and grants of a valid api key.
+type APIKeyPrincipal struct {
+	KeyID    int
+	UserID   int
+	Username string
+	Role     string
+	Scopes   []string
+}
+
+type IAuthStore interface {
+	IsSessionRevoked(ctx context.Context, jti string) (bool, error)
+	AuthenticateAPIKey(ctx context.Context, key string) (*APIKeyPrincipal, error)
+}
+
+func Auth(store IAuthStore) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var accessToken string
+		// token from cookie
+		if cookie, err := ctx.Request.Cookie("ACCESS_TOKEN"); err == nil {
+			accessToken = cookie.Value
+		}
+		// token from header
+		if authHeader := ctx.Request.Header.Get("Authorization"); authHeader != "" {
+			header := strings.Split(authHeader, " ")
+			if len(header) == 2 {
+				accessToken = header[1]
+			}
+		}
+		// if token empty remove it if exist
+		if accessToken == "" {
+			ClearCookie(ctx, "ACCESS_TOKEN")
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, "ACCESS_TOKEN_NOT_PROVIDE")
+			return
+		}
+		// personal api key
+		if strings.HasPrefix(accessToken, APIKeyPrefix) {
+			principal, err := store.AuthenticateAPIKey(ctx, accessToken)
+			if err != nil {
+				ctx.AbortWithStatusJSON(http.StatusUnauthorized, "API_KEY_NOT_VALID")
+				return
+			}
+			ctx.Set("api_key_id", principal.KeyID)
+			ctx.Set("uid", float64(principal.UserID))
+			ctx.Set("uname", principal.Username)
+			ctx.Set("urole", principal.Role)
+			ctx.Set("scopes", principal.Scopes)
+			ctx.Next()
+			return
+		}
+		// extract jwt
+		claim, err := ExtractAndValidateJWT(GetJWTKeySet(), accessToken)
+		if err != nil {
+			ClearCookie(ctx, "ACCESS_TOKEN")
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, err.Error())
+			return
+		}
+		// check the session behind the token is still alive
+		revoked, err := store.IsSessionRevoked(ctx, claim.ID)
+		if err != nil || revoked {
+			ClearCookie(ctx, "ACCESS_TOKEN")
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, "ACCESS_TOKEN_REVOKED")
+			return
+		}
+		// set item
+		ctx.Set("jti", claim.ID)
+		ctx.Set("uid", claim.Payload["id"])
+		ctx.Set("uname", claim.Payload["username"])
+		ctx.Set("urole", claim.Payload["role"])
+		if impersonator, ok := claim.Payload["impersonator"]; ok {
+			ctx.Set("impersonator", impersonator)
+		}
+		ctx.Next()
 	}
-	// token from header
-	if authHeader := ctx.Request.Header.Get("Authorization"); authHeader != "" {
-		header := strings.Split(authHeader, " ")
-		accessToken = header[1]
+}
+
+// RequireRole only lets users with one of roles through.
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if role, ok := ctx.MustGet("urole").(string); !ok || !slices.Contains(roles, role) {
+			ctx.AbortWithStatusJSON(http.StatusForbidden, "ACCESS_FORBIDDEN")
+			return
+		}
+		ctx.Next()
 	}
-	// if token empty remove it if exist
-	if accessToken == "" {
-		http.SetCookie(ctx.Writer, &http.Cookie{
-			Name:    "ACCESS_TOKEN",
-			Value:   "",
-			MaxAge:  -1,
-			Domain:  "http://localhost:8000",
-			Path:    "/",
-			Expires: time.Now().Add(-time.Hour),
-		})
-		ctx.AbortWithStatusJSON(http.StatusUnauthorized, "ACCESS_TOKEN_NOT_PROVIDE")
+}
+
+// RequireScope only lets api keys through when they were granted scope,
+// login sessions are not limited by scopes.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if scopes, ok := ctx.Get("scopes"); ok &&
+			!slices.Contains(scopes.([]string), scope) {
+			ctx.AbortWithStatusJSON(http.StatusForbidden, "API_KEY_SCOPE_MISSING")
+			return
+		}
+		ctx.Next()
+	}
+}
+
+// SessionOnly refuses api keys, for account and credential management.
+func SessionOnly(ctx *gin.Context) {
+	if _, ok := ctx.Get("api_key_id"); ok {
+		ctx.AbortWithStatusJSON(http.StatusForbidden, "API_KEY_NOT_ALLOWED")
 		return
 	}
-	// extract jwt
-	claim, err := ExtractAndValidateJWT(TokenSecret, accessToken)
-	if err != nil {
-		http.SetCookie(ctx.Writer, &http.Cookie{
-			Name:    "ACCESS_TOKEN",
-			Value:   "",
-			MaxAge:  -1,
-			Domain:  "http://localhost:8000",
-			Path:    "/",
-			Expires: time.Now().Add(-time.Hour),
-		})
-		ctx.AbortWithStatusJSON(http.StatusUnauthorized, err.Error())
+	ctx.Next()
+}
+
+// NotImpersonating refuses sessions started by an admin impersonating
+// the user, support staff must not change credentials on their behalf.
+func NotImpersonating(ctx *gin.Context) {
+	if _, ok := ctx.Get("impersonator"); ok {
+		ctx.AbortWithStatusJSON(http.StatusForbidden, "IMPERSONATION_NOT_ALLOWED")
 		return
 	}
-	// set item
-	ctx.Set("uid", claim.Payload["id"])
-	ctx.Set("uname", claim.Payload["username"])
 	ctx.Next()
 }
+
+func ClearCookie(ctx *gin.Context, name string) {
+	http.SetCookie(ctx.Writer, &http.Cookie{
+		Name:    name,
+		Value:   "",
+		MaxAge:  -1,
+		Domain:  "http://localhost:8000",
+		Path:    "/",
+		Expires: time.Now().Add(-time.Hour),
+	})
+}
@@ -2,14 +2,18 @@ package hof
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/option"
 	"google.golang.org/api/people/v1"
 )
@@ -44,14 +48,16 @@ func SetGoogleNewMeeting(
 	summary, description,
 	timezone, oEmail, cEmail string,
 	date int64, timeInt, duration int,
+	recurrence []string,
+	coHostEmails ...string,
 ) (*calendar.Event, error) {
 	randStr, err := generateRandomString(12)
 	if err != nil {
-		log.Fatalf("Unable to generate random string: %v", err)
+		return nil, fmt.Errorf("unable to generate random string: %v", err)
 	}
 	loc, err := time.LoadLocation(timezone)
 	if err != nil {
-		log.Fatalf("Unable to load timezone: %v", err)
+		return nil, fmt.Errorf("unable to load timezone: %v", err)
 	}
 	dateObj := time.Unix(date, 0).In(loc)
 	dateObj = time.Date(dateObj.Year(), dateObj.Month(), dateObj.Day(), 0, 0, 0, 0, loc)
@@ -78,41 +84,306 @@ func SetGoogleNewMeeting(
 				RequestId: randStr,
 			},
 		},
+		Recurrence: recurrence,
+	}
+	for _, email := range coHostEmails {
+		event.Attendees = append(event.Attendees,
+			&calendar.EventAttendee{Email: email})
 	}
 	return svr.Events.Insert("primary", event).
 		ConferenceDataVersion(1).Do()
 }
 
+// AddGoogleEventAttendee adds email to the attendees of an event
+// of the primary calendar, used when an invitee takes a seat.
+func AddGoogleEventAttendee(
+	svr *calendar.Service,
+	eventID, email string,
+) (*calendar.Event, error) {
+	event, err := svr.Events.Get("primary", eventID).Do()
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve event: %v", err)
+	}
+	for _, attendee := range event.Attendees {
+		if strings.EqualFold(attendee.Email, email) {
+			return event, nil
+		}
+	}
+	attendees := append(event.Attendees,
+		&calendar.EventAttendee{Email: email, ResponseStatus: "accepted"})
+	return svr.Events.Patch("primary", eventID,
+		&calendar.Event{Attendees: attendees}).Do()
+}
+
+// GoogleRecurrence returns the recurrence of an event repeating count
+// times every interval weeks, nil for a single event.
+func GoogleRecurrence(interval, count int) []string {
+	if count < 2 {
+		return nil
+	}
+	return []string{"RRULE:" + WeeklyRule(interval, count)}
+}
+
+// CancelGoogleEvent deletes an event of the primary calendar, for a
+// recurring event every occurrence, attendees are notified.
+func CancelGoogleEvent(svr *calendar.Service, eventID string) error {
+	if err := svr.Events.Delete("primary", eventID).
+		SendUpdates("all").Do(); err != nil {
+		return fmt.Errorf("unable to cancel event: %v", err)
+	}
+	return nil
+}
+
+// RescheduleGoogleEvent moves an event of the primary calendar to start
+// and end, attendees are notified.
+func RescheduleGoogleEvent(
+	svr *calendar.Service,
+	eventID, timezone string,
+	start, end time.Time,
+) (*calendar.Event, error) {
+	event, err := svr.Events.Patch("primary", eventID, &calendar.Event{
+		Start: &calendar.EventDateTime{
+			DateTime: start.Format(time.RFC3339),
+			TimeZone: timezone,
+		},
+		End: &calendar.EventDateTime{
+			DateTime: end.Format(time.RFC3339),
+			TimeZone: timezone,
+		},
+	}).SendUpdates("all").Do()
+	if err != nil {
+		return nil, fmt.Errorf("unable to reschedule event: %v", err)
+	}
+	return event, nil
+}
+
+// TruncateGoogleEvent ends the recurring event eventID of the primary
+// calendar with the occurrence starting at until, the occurrences that
+// took place stay on the calendar. Attendees are notified.
+func TruncateGoogleEvent(
+	svr *calendar.Service,
+	eventID string,
+	until time.Time,
+) error {
+	event, err := svr.Events.Get("primary", eventID).Do()
+	if err != nil {
+		return fmt.Errorf("unable to retrieve event: %v", err)
+	}
+	recurrence := make([]string, 0, len(event.Recurrence))
+	for _, rule := range event.Recurrence {
+		if strings.HasPrefix(rule, "RRULE:") {
+			var parts []string
+			for _, part := range strings.Split(strings.TrimPrefix(rule, "RRULE:"), ";") {
+				if !strings.HasPrefix(part, "COUNT=") && !strings.HasPrefix(part, "UNTIL=") {
+					parts = append(parts, part)
+				}
+			}
+			parts = append(parts, "UNTIL="+until.UTC().Format("20060102T150405Z"))
+			rule = "RRULE:" + strings.Join(parts, ";")
+		}
+		recurrence = append(recurrence, rule)
+	}
+	if _, err := svr.Events.Patch("primary", eventID,
+		&calendar.Event{Recurrence: recurrence}).SendUpdates("all").Do(); err != nil {
+		return fmt.Errorf("unable to truncate event: %v", err)
+	}
+	return nil
+}
+
+// CancelGoogleEventOccurrence cancels the occurrence of a recurring event
+// of the primary calendar originally starting at start.
+func CancelGoogleEventOccurrence(
+	svr *calendar.Service,
+	eventID string,
+	start time.Time,
+) error {
+	instances, err := svr.Events.Instances("primary", eventID).
+		OriginalStart(start.Format(time.RFC3339)).Do()
+	if err != nil {
+		return fmt.Errorf("unable to retrieve event occurrence: %v", err)
+	}
+	if len(instances.Items) == 0 {
+		return fmt.Errorf("event occurrence at %s not found", start.Format(time.RFC3339))
+	}
+	return CancelGoogleEvent(svr, instances.Items[0].Id)
+}
+
+// ErrGoogleSyncTokenExpired is returned by ListGoogleEventChanges and
+// ListGoogleOccurrenceChanges when the sync token is no longer valid, a
+// full sync gets a new one.
+var ErrGoogleSyncTokenExpired = errors.New("google sync token expired")
+
+// WatchGoogleEvents opens a notification channel on the primary calendar,
+// Google posts to address with token in X-Goog-Channel-Token whenever an
+// event changes, until the returned channel expires.
+func WatchGoogleEvents(
+	svr *calendar.Service,
+	channelID, token, address string,
+	ttl time.Duration,
+) (*calendar.Channel, error) {
+	channel, err := svr.Events.Watch("primary", &calendar.Channel{
+		Id:         channelID,
+		Type:       "web_hook",
+		Address:    address,
+		Token:      token,
+		Expiration: time.Now().Add(ttl).UnixMilli(),
+	}).Do()
+	if err != nil {
+		return nil, fmt.Errorf("unable to watch events: %v", err)
+	}
+	return channel, nil
+}
+
+// StopGoogleChannel stops the notifications of a channel.
+func StopGoogleChannel(svr *calendar.Service, channelID, resourceID string) error {
+	if err := svr.Channels.Stop(&calendar.Channel{
+		Id:         channelID,
+		ResourceId: resourceID,
+	}).Do(); err != nil {
+		return fmt.Errorf("unable to stop channel: %v", err)
+	}
+	return nil
+}
+
+// ListGoogleEventChanges returns the events of the primary calendar that
+// changed since syncToken, deleted ones with the status cancelled, and the
+// token to continue from. Without syncToken every event is listed.
+func ListGoogleEventChanges(
+	svr *calendar.Service,
+	syncToken string,
+) ([]*calendar.Event, string, error) {
+	return listGoogleEventChanges(svr, syncToken, false, time.Time{})
+}
+
+// ListGoogleOccurrenceChanges is ListGoogleEventChanges with recurring
+// events expanded into their occurrences, without syncToken every event
+// ending after timeMin is listed.
+func ListGoogleOccurrenceChanges(
+	svr *calendar.Service,
+	syncToken string,
+	timeMin time.Time,
+) ([]*calendar.Event, string, error) {
+	return listGoogleEventChanges(svr, syncToken, true, timeMin)
+}
+
+func listGoogleEventChanges(
+	svr *calendar.Service,
+	syncToken string,
+	singleEvents bool,
+	timeMin time.Time,
+) ([]*calendar.Event, string, error) {
+	var events []*calendar.Event
+	var pageToken string
+	for {
+		call := svr.Events.List("primary").ShowDeleted(true).MaxResults(250).
+			SingleEvents(singleEvents)
+		if syncToken != "" {
+			call = call.SyncToken(syncToken)
+		} else if !timeMin.IsZero() {
+			// a sync token can not be combined with a time range
+			call = call.TimeMin(timeMin.Format(time.RFC3339))
+		}
+		if pageToken != "" {
+			call = call.PageToken(pageToken)
+		}
+		page, err := call.Do()
+		if err != nil {
+			var apiErr *googleapi.Error
+			if errors.As(err, &apiErr) && apiErr.Code == http.StatusGone {
+				return nil, "", ErrGoogleSyncTokenExpired
+			}
+			return nil, "", fmt.Errorf("unable to list event changes: %v", err)
+		}
+		events = append(events, page.Items...)
+		if page.NextPageToken == "" {
+			return events, page.NextSyncToken, nil
+		}
+		pageToken = page.NextPageToken
+	}
+}
+
+// GetGoogleCalendarData returns up to limit events of the primary
+// calendar between timeMin and timeMax ordered by start, recurring events
+// expanded into their occurrences.
 func GetGoogleCalendarData(
 	svr *calendar.Service,
+	timeMin, timeMax time.Time,
+	limit int,
 ) ([]*calendar.Event, error) {
-	t := time.Now().Format(time.RFC3339)
-	events, err := svr.Events.List("primary").
-		ShowDeleted(false).
-		SingleEvents(true).
-		TimeMin(t).
-		MaxResults(10).
-		OrderBy("startTime").
-		Do()
+	events := []*calendar.Event{}
+	var pageToken string
+	for len(events) < limit {
+		call := svr.Events.List("primary").
+			ShowDeleted(false).
+			SingleEvents(true).
+			TimeMin(timeMin.Format(time.RFC3339)).
+			TimeMax(timeMax.Format(time.RFC3339)).
+			MaxResults(int64(min(limit-len(events), 250))).
+			OrderBy("startTime")
+		if pageToken != "" {
+			call = call.PageToken(pageToken)
+		}
+		page, err := call.Do()
+		if err != nil {
+			return nil, fmt.Errorf("unable to retrieve the user's events: %v", err)
+		}
+		events = append(events, page.Items...)
+		if page.NextPageToken == "" {
+			break
+		}
+		pageToken = page.NextPageToken
+	}
+	return events, nil
+}
+
+// GetGoogleBusyTimes returns the busy periods of the primary calendar
+// between timeMin and timeMax using the free/busy query.
+func GetGoogleBusyTimes(
+	svr *calendar.Service,
+	timeMin, timeMax time.Time,
+) ([]BusyTime, error) {
+	resp, err := svr.Freebusy.Query(&calendar.FreeBusyRequest{
+		TimeMin: timeMin.Format(time.RFC3339),
+		TimeMax: timeMax.Format(time.RFC3339),
+		Items:   []*calendar.FreeBusyRequestItem{{Id: "primary"}},
+	}).Do()
 	if err != nil {
-		return nil, fmt.Errorf(
-			"unable to retrieve next ten of the user's events: %v",
-			err)
+		return nil, fmt.Errorf("unable to retrieve free/busy: %v", err)
+	}
+	primary, ok := resp.Calendars["primary"]
+	if !ok {
+		return nil, nil
+	}
+	if len(primary.Errors) > 0 {
+		return nil, fmt.Errorf("unable to retrieve free/busy: %s",
+			primary.Errors[0].Reason)
+	}
+	busy := make([]BusyTime, 0, len(primary.Busy))
+	for _, period := range primary.Busy {
+		start, err := time.Parse(time.RFC3339, period.Start)
+		if err != nil {
+			return nil, err
+		}
+		end, err := time.Parse(time.RFC3339, period.End)
+		if err != nil {
+			return nil, err
+		}
+		busy = append(busy, BusyTime{Start: start, End: end})
 	}
-	return events.Items, nil
+	return busy, nil
 }
 
 func GetGoogleCalendarService(
 	ctx context.Context,
 	tok *oauth2.Token,
 	config *oauth2.Config,
-) *calendar.Service {
+) (*calendar.Service, error) {
 	client := config.Client(ctx, tok)
 	srv, err := calendar.NewService(ctx, option.WithHTTPClient(client))
 	if err != nil {
-		log.Fatalf("Unable to retrieve Calendar client: %v", err)
+		return nil, fmt.Errorf("unable to retrieve Calendar client: %v", err)
 	}
-	return srv
+	return srv, nil
 }
 
 func GetGoogleOAuthConfig() *oauth2.Config {
@@ -0,0 +1,219 @@
+package hof
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// GraphBaseURL is where Microsoft Graph v1.0 is served.
+const GraphBaseURL = "https://graph.microsoft.com/v1.0"
+
+const (
+	graphTimeout      = time.Second * 30
+	graphMaxRetries   = 3
+	graphMaxRetryWait = time.Second * 30
+)
+
+// GraphClient calls Microsoft Graph for the user the tokens of TokenSource
+// belong to. Throttled requests are sent again after the Retry-After graph
+// asks for.
+type GraphClient struct {
+	BaseURL     string
+	HTTPClient  *http.Client
+	TokenSource oauth2.TokenSource
+	// MaxRetries is how often a throttled request is sent again and
+	// MaxRetryWait the longest Retry-After it waits for.
+	MaxRetries   int
+	MaxRetryWait time.Duration
+}
+
+func NewGraphClient(ts oauth2.TokenSource) *GraphClient {
+	return &GraphClient{
+		BaseURL:      GraphBaseURL,
+		HTTPClient:   &http.Client{Timeout: graphTimeout},
+		TokenSource:  ts,
+		MaxRetries:   graphMaxRetries,
+		MaxRetryWait: graphMaxRetryWait,
+	}
+}
+
+// GetGraphClientFactory returns the constructor of the graph clients of
+// the graph section of microsoft.json, e.g. to use a stand-in of graph:
+//
+//	"graph": {"base_url": "http://localhost:9292/v1.0", "timeout": 10}
+//
+// Graph itself with the defaults of NewGraphClient is used without it.
+func GetGraphClientFactory() func(ts oauth2.TokenSource) *GraphClient {
+	b, err := os.ReadFile("microsoft.json")
+	if errors.Is(err, os.ErrNotExist) {
+		return NewGraphClient
+	}
+	if err != nil {
+		log.Fatalf("Unable to read client secret file: %v", err)
+	}
+	var cfg struct {
+		Graph struct {
+			BaseURL string `json:"base_url"`
+			// seconds, graphTimeout when left out
+			Timeout int `json:"timeout"`
+		} `json:"graph"`
+	}
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		log.Fatalf("Unable to parse client secret file to config: %v", err)
+	}
+	return func(ts oauth2.TokenSource) *GraphClient {
+		client := NewGraphClient(ts)
+		if cfg.Graph.BaseURL != "" {
+			client.BaseURL = cfg.Graph.BaseURL
+		}
+		if cfg.Graph.Timeout > 0 {
+			client.HTTPClient.Timeout = time.Duration(cfg.Graph.Timeout) * time.Second
+		}
+		return client
+	}
+}
+
+// GraphError is an error response of graph, RetryAfter is set when graph
+// asked to wait before trying again.
+type GraphError struct {
+	Status     int
+	Code       string
+	Message    string
+	RetryAfter time.Duration
+}
+
+func (e *GraphError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("graph responded %d %s", e.Status, http.StatusText(e.Status))
+	}
+	return fmt.Sprintf("graph responded %d %s: %s", e.Status, e.Code, e.Message)
+}
+
+// IsGraphStatus tells whether err is a GraphError with status.
+func IsGraphStatus(err error, status int) bool {
+	var graphErr *GraphError
+	return errors.As(err, &graphErr) && graphErr.Status == status
+}
+
+// graphRequest is one call, Path is relative to the base url unless it is
+// a link graph returned, e.g. @odata.nextLink.
+type graphRequest struct {
+	Method string
+	Path   string
+	Prefer string
+	Body   interface{}
+}
+
+// do sends req and decodes the response into out when it is not nil, a
+// response other than 2xx is returned as *GraphError.
+func (c *GraphClient) do(ctx context.Context, req *graphRequest, out interface{}) error {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = json.Marshal(req.Body); err != nil {
+			return fmt.Errorf("error marshalling request body: %s", err.Error())
+		}
+	}
+	target := req.Path
+	if !strings.HasPrefix(target, "https://") && !strings.HasPrefix(target, "http://") {
+		target = strings.TrimSuffix(c.BaseURL, "/") + target
+	}
+	for attempt := 0; ; attempt++ {
+		httpReq, err := http.NewRequestWithContext(ctx, req.Method, target, bytes.NewReader(body))
+		if err != nil {
+			return fmt.Errorf("error creating http request: %s", err.Error())
+		}
+		// an expired access token is refreshed here
+		tok, err := c.TokenSource.Token()
+		if err != nil {
+			return fmt.Errorf("unable to get access token: %w", err)
+		}
+		tok.SetAuthHeader(httpReq)
+		if body != nil {
+			httpReq.Header.Set("Content-Type", "application/json")
+		}
+		if req.Prefer != "" {
+			httpReq.Header.Set("Prefer", req.Prefer)
+		}
+		resp, err := c.HTTPClient.Do(httpReq)
+		if err != nil {
+			return fmt.Errorf("error making http request: %s", err.Error())
+		}
+		if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+			defer func() { _ = resp.Body.Close() }()
+			if out == nil || resp.StatusCode == http.StatusNoContent {
+				return nil
+			}
+			if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+				return fmt.Errorf("error unmarshalling response body: %s", err.Error())
+			}
+			return nil
+		}
+		graphErr := graphResponseError(resp)
+		_ = resp.Body.Close()
+		if !graphRetryable(req.Method, graphErr.Status) || attempt >= c.MaxRetries {
+			return graphErr
+		}
+		wait := graphErr.RetryAfter
+		if wait <= 0 {
+			wait = time.Second << attempt
+		}
+		if wait > c.MaxRetryWait {
+			return graphErr
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// graphRetryable tells whether a request answered with status is sent
+// again. A throttled request was not run by graph, while a request that
+// timed out or found graph unavailable may have been, so only requests
+// that change nothing when run twice are sent again then, a POST could
+// create an event twice.
+func graphRetryable(method string, status int) bool {
+	if status == http.StatusTooManyRequests {
+		return true
+	}
+	if status != http.StatusServiceUnavailable && status != http.StatusGatewayTimeout {
+		return false
+	}
+	return method == http.MethodGet || method == http.MethodPatch || method == http.MethodDelete
+}
+
+func graphResponseError(resp *http.Response) *GraphError {
+	graphErr := &GraphError{Status: resp.StatusCode}
+	var body struct {
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&body); err == nil {
+		graphErr.Code, graphErr.Message = body.Error.Code, body.Error.Message
+	}
+	// Retry-After is either seconds or a date
+	if value := resp.Header.Get("Retry-After"); value != "" {
+		if seconds, err := strconv.Atoi(value); err == nil {
+			graphErr.RetryAfter = time.Duration(seconds) * time.Second
+		} else if at, err := http.ParseTime(value); err == nil {
+			graphErr.RetryAfter = time.Until(at)
+		}
+	}
+	return graphErr
+}
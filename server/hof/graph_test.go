@@ -0,0 +1,231 @@
+package hof
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// newTestGraphClient returns a client of a stand-in of graph serving
+// handler.
+func newTestGraphClient(t *testing.T, handler http.HandlerFunc) *GraphClient {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	client := NewGraphClient(oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token-1"}))
+	client.BaseURL, client.HTTPClient = srv.URL, srv.Client()
+	client.MaxRetryWait = 5 * time.Second
+	return client
+}
+
+// throttled answers the first n requests with status and Retry-After,
+// then 200 with an empty object, counting the requests in calls.
+func throttled(n int32, status int, retryAfter func() string, calls *int32) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(calls, 1) <= n {
+			if value := retryAfter(); value != "" {
+				w.Header().Set("Retry-After", value)
+			}
+			w.WriteHeader(status)
+			return
+		}
+		_, _ = w.Write([]byte("{}"))
+	}
+}
+
+func TestGraphError(t *testing.T) {
+	client := newTestGraphClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token-1" {
+			t.Errorf("Authorization = %q", r.Header.Get("Authorization"))
+		}
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"error":{"code":"ErrorItemNotFound",` +
+			`"message":"The specified object was not found in the store."}}`))
+	})
+	_, err := client.Calendar(context.Background())
+	var graphErr *GraphError
+	if !errors.As(err, &graphErr) {
+		t.Fatalf("Calendar() error = %v, want a *GraphError", err)
+	}
+	if graphErr.Status != http.StatusNotFound || graphErr.Code != "ErrorItemNotFound" ||
+		graphErr.Message != "The specified object was not found in the store." {
+		t.Errorf("GraphError = %+v", graphErr)
+	}
+	if !IsGraphStatus(err, http.StatusNotFound) || IsGraphStatus(err, http.StatusGone) {
+		t.Error("IsGraphStatus() does not match the status of the error")
+	}
+}
+
+func TestGraphResponseErrorRetryAfter(t *testing.T) {
+	tests := []struct {
+		name       string
+		retryAfter string
+		min, max   time.Duration
+	}{
+		{"seconds", "7", 7 * time.Second, 7 * time.Second},
+		{"http date", time.Now().Add(30 * time.Second).UTC().Format(http.TimeFormat),
+			28 * time.Second, 30 * time.Second},
+		{"unreadable", "soon", 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{
+				StatusCode: http.StatusTooManyRequests,
+				Header:     http.Header{"Retry-After": {tt.retryAfter}},
+				Body:       http.NoBody,
+			}
+			got := graphResponseError(resp).RetryAfter
+			if got < tt.min || got > tt.max {
+				t.Errorf("RetryAfter = %s, want between %s and %s", got, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+func TestGraphRetriesThrottledRequest(t *testing.T) {
+	tests := []struct {
+		name       string
+		retryAfter func() string
+	}{
+		{"seconds", func() string { return "1" }},
+		{"http date", func() string {
+			return time.Now().Add(2 * time.Second).UTC().Format(http.TimeFormat)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			var calls int32
+			client := newTestGraphClient(t, throttled(1, http.StatusTooManyRequests, tt.retryAfter, &calls))
+			started := time.Now()
+			// a POST that was throttled was not run, it is sent again
+			if _, err := client.CreateEvent(context.Background(), &MSEvent{}); err != nil {
+				t.Fatalf("CreateEvent() error = %v", err)
+			}
+			if got := atomic.LoadInt32(&calls); got != 2 {
+				t.Errorf("%d requests, want 2", got)
+			}
+			if elapsed := time.Since(started); elapsed < 500*time.Millisecond {
+				t.Errorf("sent again after %s, before Retry-After", elapsed)
+			}
+		})
+	}
+}
+
+func TestGraphGivesUp(t *testing.T) {
+	tests := []struct {
+		name       string
+		maxRetries int
+		retryAfter func() string
+		wantCalls  int32
+	}{
+		{"after MaxRetries", 1, func() string { return "1" }, 2},
+		{"when Retry-After is past MaxRetryWait", 3, func() string { return "120" }, 1},
+		{"when the Retry-After date is past MaxRetryWait", 3, func() string {
+			return time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+		}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			var calls int32
+			client := newTestGraphClient(t, throttled(10, http.StatusTooManyRequests, tt.retryAfter, &calls))
+			client.MaxRetries = tt.maxRetries
+			_, err := client.Calendar(context.Background())
+			if !IsGraphStatus(err, http.StatusTooManyRequests) {
+				t.Fatalf("Calendar() error = %v, want the 429", err)
+			}
+			if got := atomic.LoadInt32(&calls); got != tt.wantCalls {
+				t.Errorf("%d requests, want %d", got, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestGraphRetriesUnavailableIdempotentRequests(t *testing.T) {
+	tests := []struct {
+		name      string
+		call      func(c *GraphClient) error
+		wantCalls int32
+	}{
+		{"POST is not sent twice", func(c *GraphClient) error {
+			_, err := c.CreateEvent(context.Background(), &MSEvent{})
+			return err
+		}, 1},
+		{"GET is sent again", func(c *GraphClient) error {
+			_, err := c.Calendar(context.Background())
+			return err
+		}, 2},
+		{"DELETE is sent again", func(c *GraphClient) error {
+			return c.CancelEvent(context.Background(), "event-1")
+		}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			var calls int32
+			client := newTestGraphClient(t, throttled(1, http.StatusServiceUnavailable,
+				func() string { return "1" }, &calls))
+			err := tt.call(client)
+			if tt.wantCalls == 1 && !IsGraphStatus(err, http.StatusServiceUnavailable) {
+				t.Errorf("error = %v, want the 503", err)
+			}
+			if tt.wantCalls > 1 && err != nil {
+				t.Errorf("error = %v", err)
+			}
+			if got := atomic.LoadInt32(&calls); got != tt.wantCalls {
+				t.Errorf("%d requests, want %d", got, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestGraphCalendarViewFollowsNextLink(t *testing.T) {
+	var srvURL string
+	var pages []string
+	client := newTestGraphClient(t, func(w http.ResponseWriter, r *http.Request) {
+		pages = append(pages, r.URL.RawQuery)
+		if r.URL.Path != "/me/calendarView" {
+			t.Errorf("path = %q", r.URL.Path)
+		}
+		if r.Header.Get("Authorization") != "Bearer token-1" {
+			t.Errorf("Authorization = %q on %q", r.Header.Get("Authorization"), r.URL)
+		}
+		if r.URL.Query().Get("$skiptoken") == "" {
+			_, _ = w.Write([]byte(`{"value":[{"id":"a"},{"id":"b","isCancelled":true},{"id":"c"}],` +
+				`"@odata.nextLink":"` + srvURL + `/me/calendarView?$skiptoken=page-2"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"value":[{"id":"d"},{"id":"e"}]}`))
+	})
+	srvURL = client.BaseURL
+	now := time.Now()
+	events, err := client.CalendarView(context.Background(), now, now.Add(time.Hour), 10)
+	if err != nil {
+		t.Fatalf("CalendarView() error = %v", err)
+	}
+	var ids []string
+	for _, event := range events {
+		ids = append(ids, event.ID)
+	}
+	if strings.Join(ids, ",") != "a,c,d,e" {
+		t.Errorf("events %q, want a,c,d,e", ids)
+	}
+	if len(pages) != 2 || pages[1] != "$skiptoken=page-2" {
+		t.Errorf("pages read %q, want the next link followed", pages)
+	}
+	pages = nil
+	if events, err = client.CalendarView(context.Background(), now, now.Add(time.Hour), 2); err != nil {
+		t.Fatalf("CalendarView() error = %v", err)
+	}
+	if len(events) != 2 || len(pages) != 1 {
+		t.Errorf("%d events from %d pages, want 2 from the first page", len(events), len(pages))
+	}
+}
@@ -0,0 +1,125 @@
+package hof
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	ICSMethodRequest = "REQUEST"
+	ICSMethodCancel  = "CANCEL"
+)
+
+// ICSEvent is a single VEVENT, calendar clients match updates and
+// cancellations to the event they already have by UID, the highest
+// Sequence wins.
+type ICSEvent struct {
+	UID         string
+	Sequence    int
+	Summary     string
+	Description string
+	Location    string
+	Start       time.Time
+	End         time.Time
+	// RRule repeats the event, e.g. WeeklyRule(1, 4).
+	RRule string
+	// RecurrenceID names the occurrence of a recurring event that is
+	// changed or cancelled, zero for the whole event.
+	RecurrenceID   time.Time
+	OrganizerName  string
+	OrganizerEmail string
+	Attendees      []ICSAttendee
+}
+
+type ICSAttendee struct {
+	Name  string
+	Email string
+}
+
+// ComposeICS renders event as an RFC 5545 calendar object for method,
+// ICSMethodRequest for new and updated events or ICSMethodCancel.
+func ComposeICS(method string, event *ICSEvent) []byte {
+	status := "CONFIRMED"
+	if method == ICSMethodCancel {
+		status = "CANCELLED"
+	}
+	var lines []string
+	add := func(line string) {
+		lines = append(lines, foldICSLine(line))
+	}
+	add("BEGIN:VCALENDAR")
+	add("VERSION:2.0")
+	add("PRODID:-//goca//booking//EN")
+	add("CALSCALE:GREGORIAN")
+	add("METHOD:" + method)
+	add("BEGIN:VEVENT")
+	add("UID:" + event.UID)
+	add(fmt.Sprintf("SEQUENCE:%d", event.Sequence))
+	add("DTSTAMP:" + icsTime(time.Now()))
+	add("DTSTART:" + icsTime(event.Start))
+	add("DTEND:" + icsTime(event.End))
+	if !event.RecurrenceID.IsZero() {
+		add("RECURRENCE-ID:" + icsTime(event.RecurrenceID))
+	} else if event.RRule != "" {
+		add("RRULE:" + event.RRule)
+	}
+	add("SUMMARY:" + escapeICSText(event.Summary))
+	if event.Description != "" {
+		add("DESCRIPTION:" + escapeICSText(event.Description))
+	}
+	if event.Location != "" {
+		add("LOCATION:" + escapeICSText(event.Location))
+	}
+	if event.OrganizerEmail != "" {
+		add(fmt.Sprintf("ORGANIZER;CN=%s:mailto:%s",
+			quoteICSParam(event.OrganizerName), stripLineBreaks(event.OrganizerEmail)))
+	}
+	for _, attendee := range event.Attendees {
+		add(fmt.Sprintf("ATTENDEE;CN=%s;ROLE=REQ-PARTICIPANT;RSVP=TRUE:mailto:%s",
+			quoteICSParam(attendee.Name), stripLineBreaks(attendee.Email)))
+	}
+	add("STATUS:" + status)
+	add("END:VEVENT")
+	add("END:VCALENDAR")
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+func icsTime(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z")
+}
+
+var icsTextEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	";", `\;`,
+	",", `\,`,
+	"\r\n", `\n`,
+	"\n", `\n`,
+)
+
+func escapeICSText(s string) string {
+	return icsTextEscaper.Replace(s)
+}
+
+// quoteICSParam quotes a parameter value, which can not hold a double
+// quote or a line break.
+func quoteICSParam(s string) string {
+	return `"` + strings.ReplaceAll(stripLineBreaks(s), `"`, "'") + `"`
+}
+
+// foldICSLine splits line into lines of at most 75 octets, continuation
+// lines start with a space, without splitting a multibyte character.
+func foldICSLine(line string) string {
+	var b strings.Builder
+	width := 0
+	for _, r := range line {
+		size := len(string(r))
+		if width+size > 75 {
+			b.WriteString("\r\n ")
+			width = 1
+		}
+		b.WriteRune(r)
+		width += size
+	}
+	return b.String()
+}
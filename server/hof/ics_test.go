@@ -0,0 +1,127 @@
+package hof
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func testICSEvent() *ICSEvent {
+	start := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	return &ICSEvent{
+		UID:            "booking-7@goca",
+		Sequence:       3,
+		Summary:        "Intro call",
+		Start:          start,
+		End:            start.Add(30 * time.Minute),
+		RRule:          WeeklyRule(1, 4),
+		OrganizerName:  "mentor",
+		OrganizerEmail: "mentor@example.com",
+		Attendees:      []ICSAttendee{{Name: "Ann", Email: "ann@example.com"}},
+	}
+}
+
+// icsLines unfolds the content lines of an ics object.
+func icsLines(t *testing.T, ics []byte) []string {
+	t.Helper()
+	s := string(ics)
+	if !strings.HasSuffix(s, "\r\n") {
+		t.Fatal("ics does not end with CRLF")
+	}
+	return strings.Split(strings.TrimSuffix(strings.ReplaceAll(s, "\r\n ", ""), "\r\n"), "\r\n")
+}
+
+func hasLine(lines []string, want string) bool {
+	for _, line := range lines {
+		if line == want {
+			return true
+		}
+	}
+	return false
+}
+
+func TestComposeICS(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		mutate func(e *ICSEvent)
+		want   []string
+		absent []string
+	}{
+		{
+			name:   "request",
+			method: ICSMethodRequest,
+			want: []string{"METHOD:REQUEST", "UID:booking-7@goca", "SEQUENCE:3",
+				"DTSTART:20260302T090000Z", "DTEND:20260302T093000Z",
+				"RRULE:FREQ=WEEKLY;INTERVAL=1;COUNT=4", "STATUS:CONFIRMED",
+				`ORGANIZER;CN="mentor":mailto:mentor@example.com`,
+				`ATTENDEE;CN="Ann";ROLE=REQ-PARTICIPANT;RSVP=TRUE:mailto:ann@example.com`},
+		},
+		{
+			name:   "cancel keeps the uid",
+			method: ICSMethodCancel,
+			mutate: func(e *ICSEvent) { e.Sequence = 4 },
+			want:   []string{"METHOD:CANCEL", "UID:booking-7@goca", "SEQUENCE:4", "STATUS:CANCELLED"},
+		},
+		{
+			name:   "occurrence",
+			method: ICSMethodCancel,
+			mutate: func(e *ICSEvent) { e.RecurrenceID = e.Start.AddDate(0, 0, 7) },
+			want:   []string{"UID:booking-7@goca", "RECURRENCE-ID:20260309T090000Z"},
+			absent: []string{"RRULE:FREQ=WEEKLY;INTERVAL=1;COUNT=4"},
+		},
+		{
+			name:   "escaped text",
+			method: ICSMethodRequest,
+			mutate: func(e *ICSEvent) { e.Description = "a,b;c\\d\nnext" },
+			want:   []string{`DESCRIPTION:a\,b\;c\\d\nnext`},
+		},
+		{
+			name:   "line breaks in names and addresses",
+			method: ICSMethodRequest,
+			mutate: func(e *ICSEvent) {
+				e.Attendees = []ICSAttendee{{Name: "Ann\r\nX-INJECTED:1", Email: "ann@example.com\nX-INJECTED:2"}}
+			},
+			want: []string{`ATTENDEE;CN="AnnX-INJECTED:1";ROLE=REQ-PARTICIPANT;RSVP=TRUE:` +
+				`mailto:ann@example.comX-INJECTED:2`},
+			absent: []string{"X-INJECTED:1", "X-INJECTED:2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := testICSEvent()
+			if tt.mutate != nil {
+				tt.mutate(event)
+			}
+			lines := icsLines(t, ComposeICS(tt.method, event))
+			for _, want := range tt.want {
+				if !hasLine(lines, want) {
+					t.Errorf("ComposeICS() has no line %q in\n%s", want, strings.Join(lines, "\n"))
+				}
+			}
+			for _, absent := range tt.absent {
+				if hasLine(lines, absent) {
+					t.Errorf("ComposeICS() has line %q", absent)
+				}
+			}
+		})
+	}
+}
+
+func TestComposeICSFoldsLongLines(t *testing.T) {
+	event := testICSEvent()
+	event.Summary = strings.Repeat("Überprüfung des Termins ", 10)
+	ics := ComposeICS(ICSMethodRequest, event)
+	for _, line := range strings.Split(strings.TrimSuffix(string(ics), "\r\n"), "\r\n") {
+		if len(line) > 75 {
+			t.Errorf("line of %d octets: %q", len(line), line)
+		}
+		if !utf8.ValidString(line) {
+			t.Errorf("line splits a character: %q", line)
+		}
+	}
+	if !hasLine(icsLines(t, ics), "SUMMARY:"+event.Summary) {
+		t.Error("folded summary does not unfold to the original")
+	}
+}
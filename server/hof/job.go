@@ -0,0 +1,223 @@
+package hof
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// JobHandler runs one job with the payload it was scheduled with, a
+// returned error schedules another attempt.
+type JobHandler func(ctx context.Context, payload []byte) error
+
+type IJobScheduler interface {
+	// Schedule stores a job of kind running at runAt, payload is kept as json.
+	Schedule(ctx context.Context, kind string, payload interface{}, runAt time.Time) error
+	// Handle registers the handler running the jobs of kind.
+	Handle(kind string, handler JobHandler)
+}
+
+const (
+	jobMaxAttempts = 8
+	// a claimed job is left alone for this long, after that another
+	// instance takes it over as its runner is assumed to be gone
+	jobLease = time.Minute * 5
+	// retries wait jobBackoffBase doubled every attempt up to jobBackoffMax
+	jobBackoffBase = time.Second * 30
+	jobBackoffMax  = time.Hour
+	// finished jobs are kept this long for inspection
+	jobRetention = time.Hour * 24 * 7
+)
+
+type jobAttemptKey struct{}
+
+type jobAttempt struct {
+	attempt, maxAttempts int
+}
+
+// WithJobAttempt returns ctx of a job run for its attempt out of
+// maxAttempts.
+func WithJobAttempt(ctx context.Context, attempt, maxAttempts int) context.Context {
+	return context.WithValue(ctx, jobAttemptKey{}, jobAttempt{attempt, maxAttempts})
+}
+
+// LastJobAttempt reports whether the job run with ctx is not tried again
+// when it fails, so its handler can clean up instead.
+func LastJobAttempt(ctx context.Context) bool {
+	a, ok := ctx.Value(jobAttemptKey{}).(jobAttempt)
+	return ok && a.attempt >= a.maxAttempts
+}
+
+// SQLJobScheduler keeps jobs in the jobs table so they survive restarts,
+// every instance using the database polls it for due jobs.
+type SQLJobScheduler struct {
+	db       *sql.DB
+	mu       sync.RWMutex
+	handlers map[string]JobHandler
+	poll     time.Duration
+	sweptAt  time.Time
+}
+
+func NewSQLJobScheduler(db *sql.DB) *SQLJobScheduler {
+	return &SQLJobScheduler{
+		db:       db,
+		handlers: map[string]JobHandler{},
+		poll:     time.Second * 5,
+	}
+}
+
+func (s *SQLJobScheduler) Handle(kind string, handler JobHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[kind] = handler
+}
+
+func (s *SQLJobScheduler) Schedule(
+	ctx context.Context,
+	kind string,
+	payload interface{},
+	runAt time.Time,
+) error {
+	return ScheduleJob(ctx, s.db, kind, payload, runAt)
+}
+
+// SQLExecutor runs statements, either a *sql.DB or a *sql.Tx.
+type SQLExecutor interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// ScheduleJob stores a job of kind running at runAt with exec, a job stored
+// in a transaction only exists, and runs, once the transaction commits.
+//
+//goland:noinspection ALL
+func ScheduleJob(
+	ctx context.Context,
+	exec SQLExecutor,
+	kind string,
+	payload interface{},
+	runAt time.Time,
+) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	q := "INSERT INTO jobs (kind, payload, run_at, max_attempts, created_at) "
+	q += "VALUES ($1, $2, $3, $4, $5)"
+	_, err = exec.ExecContext(ctx, q, kind, string(data), runAt.Unix(),
+		jobMaxAttempts, time.Now().Unix())
+	return err
+}
+
+// Run runs due jobs until ctx is done, a job already running is
+// finished first so shutting down does not cut it off.
+func (s *SQLJobScheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.poll)
+	defer ticker.Stop()
+	for {
+		s.runDue(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runDue runs every job that is due one after the other.
+func (s *SQLJobScheduler) runDue(ctx context.Context) {
+	for ctx.Err() == nil {
+		job, err := s.claim(ctx)
+		if err != nil {
+			if !errors.Is(err, sql.ErrNoRows) && ctx.Err() == nil {
+				log.Printf("unable to claim job: %s", err)
+			}
+			break
+		}
+		s.run(ctx, job)
+	}
+	if time.Since(s.sweptAt) > time.Hour {
+		q := "DELETE FROM jobs WHERE status = 'done' AND finished_at < ?"
+		if _, err := s.db.ExecContext(ctx, q, time.Now().Add(-jobRetention).Unix()); err != nil {
+			log.Printf("unable to delete finished jobs: %s", err)
+		}
+		s.sweptAt = time.Now()
+	}
+}
+
+type claimedJob struct {
+	id          int
+	kind        string
+	payload     string
+	attempts    int
+	maxAttempts int
+}
+
+// claim takes the job due first, leasing it in the same statement so
+// concurrent instances can not both run it.
+//
+//goland:noinspection ALL
+func (s *SQLJobScheduler) claim(ctx context.Context) (*claimedJob, error) {
+	now := time.Now()
+	q := "UPDATE jobs SET attempts = attempts + 1, locked_until = $1 "
+	q += "WHERE id = (SELECT id FROM jobs WHERE status = 'pending' AND run_at <= $2 "
+	q += "AND (locked_until IS NULL OR locked_until <= $2) ORDER BY run_at LIMIT 1) "
+	q += "RETURNING id, kind, payload, attempts, max_attempts"
+	var job claimedJob
+	err := s.db.QueryRowContext(ctx, q, now.Add(jobLease).Unix(), now.Unix()).
+		Scan(&job.id, &job.kind, &job.payload, &job.attempts, &job.maxAttempts)
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+//goland:noinspection ALL
+func (s *SQLJobScheduler) run(ctx context.Context, job *claimedJob) {
+	s.mu.RLock()
+	handler, ok := s.handlers[job.kind]
+	s.mu.RUnlock()
+	err := fmt.Errorf("no handler for job kind %s", job.kind)
+	if ok {
+		// the job may outlive ctx during shutdown, only the lease bounds it
+		jobCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), jobLease)
+		jobCtx = WithJobAttempt(jobCtx, job.attempts, job.maxAttempts)
+		err = handler(jobCtx, []byte(job.payload))
+		cancel()
+	}
+	now := time.Now()
+	if err == nil {
+		q := "UPDATE jobs SET status = 'done', locked_until = NULL, finished_at = ? WHERE id = ?"
+		if _, err := s.db.ExecContext(context.WithoutCancel(ctx), q, now.Unix(), job.id); err != nil {
+			log.Printf("unable to finish job %d: %s", job.id, err)
+		}
+		return
+	}
+	log.Printf("job %d (%s) attempt %d failed: %s", job.id, job.kind, job.attempts, err)
+	q := "UPDATE jobs SET run_at = $1, last_error = $2, locked_until = NULL WHERE id = $3"
+	args := []interface{}{now.Add(jobBackoff(job.attempts)).Unix(), err.Error(), job.id}
+	if !ok || job.attempts >= job.maxAttempts {
+		q = "UPDATE jobs SET status = 'failed', last_error = $2, locked_until = NULL, "
+		q += "finished_at = $1 WHERE id = $3"
+		args[0] = now.Unix()
+	}
+	if _, err := s.db.ExecContext(context.WithoutCancel(ctx), q, args...); err != nil {
+		log.Printf("unable to reschedule job %d: %s", job.id, err)
+	}
+}
+
+// jobBackoff is the wait before the attempt following attempt.
+func jobBackoff(attempt int) time.Duration {
+	wait := jobBackoffBase
+	for i := 1; i < attempt && wait < jobBackoffMax; i++ {
+		wait *= 2
+	}
+	if wait > jobBackoffMax {
+		wait = jobBackoffMax
+	}
+	return wait
+}
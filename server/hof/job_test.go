@@ -0,0 +1,163 @@
+package hof
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+)
+
+const testJobsSchema = `CREATE TABLE jobs
+(
+    id           INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+    kind         VARCHAR(64) NOT NULL,
+    payload      TEXT NOT NULL,
+    status       VARCHAR(16) NOT NULL DEFAULT 'pending',
+    run_at       BIGINT NOT NULL,
+    attempts     INTEGER NOT NULL DEFAULT 0,
+    max_attempts INTEGER NOT NULL,
+    last_error   TEXT,
+    locked_until BIGINT,
+    created_at   BIGINT NOT NULL,
+    finished_at  BIGINT
+)`
+
+type testJobRow struct {
+	status    string
+	attempts  int
+	runAt     int64
+	lastError string
+}
+
+func findTestJob(t *testing.T, db *sql.DB, id int) testJobRow {
+	t.Helper()
+	var row testJobRow
+	err := db.QueryRow("SELECT status, attempts, run_at, COALESCE(last_error, '') FROM jobs WHERE id = ?", id).
+		Scan(&row.status, &row.attempts, &row.runAt, &row.lastError)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return row
+}
+
+func TestJobBackoff(t *testing.T) {
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{7, 32 * time.Minute},
+		{8, time.Hour},
+		{50, time.Hour},
+	}
+	for _, tt := range tests {
+		if got := jobBackoff(tt.attempt); got != tt.want {
+			t.Errorf("jobBackoff(%d) = %s, want %s", tt.attempt, got, tt.want)
+		}
+	}
+}
+
+func TestSQLJobSchedulerClaim(t *testing.T) {
+	db := newTestDB(t, testJobsSchema)
+	s := NewSQLJobScheduler(db)
+	ctx := context.Background()
+	now := time.Now()
+	if err := s.Schedule(ctx, "later", nil, now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Schedule(ctx, "due", map[string]int{"id": 7}, now.Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	job, err := s.claim(ctx)
+	if err != nil {
+		t.Fatalf("claim() error = %v", err)
+	}
+	if job.kind != "due" || job.payload != `{"id":7}` || job.attempts != 1 || job.maxAttempts != jobMaxAttempts {
+		t.Errorf("claimed %+v, want the due job on its first attempt", job)
+	}
+	// the claimed job is leased and the other one is not due
+	if _, err := s.claim(ctx); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("claim() of a leased job error = %v, want sql.ErrNoRows", err)
+	}
+	// a runner that is gone lets its lease expire
+	if _, err := db.Exec("UPDATE jobs SET locked_until = ? WHERE id = ?",
+		now.Add(-time.Second).Unix(), job.id); err != nil {
+		t.Fatal(err)
+	}
+	again, err := s.claim(ctx)
+	if err != nil {
+		t.Fatalf("claim() after the lease expired error = %v", err)
+	}
+	if again.id != job.id || again.attempts != 2 {
+		t.Errorf("claimed %+v, want job %d on its second attempt", again, job.id)
+	}
+}
+
+func TestSQLJobSchedulerRun(t *testing.T) {
+	failing := errors.New("calendar unavailable")
+	tests := []struct {
+		name         string
+		kind         string
+		attempts     int
+		err          error
+		wantStatus   string
+		wantBackoff  time.Duration
+		wantLastSeen bool
+	}{
+		{name: "done", kind: "job", err: nil, wantStatus: "done"},
+		{name: "failed attempt backs off", kind: "job", attempts: 2, err: failing,
+			wantStatus: "pending", wantBackoff: 2 * time.Minute},
+		{name: "last attempt fails the job", kind: "job", attempts: jobMaxAttempts - 1, err: failing,
+			wantStatus: "failed", wantLastSeen: true},
+		{name: "unknown kind fails at once", kind: "unknown", wantStatus: "failed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t, testJobsSchema)
+			s := NewSQLJobScheduler(db)
+			var lastSeen bool
+			s.Handle("job", func(ctx context.Context, payload []byte) error {
+				lastSeen = LastJobAttempt(ctx)
+				return tt.err
+			})
+			ctx := context.Background()
+			if err := s.Schedule(ctx, tt.kind, nil, time.Now()); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := db.Exec("UPDATE jobs SET attempts = ?", tt.attempts); err != nil {
+				t.Fatal(err)
+			}
+			job, err := s.claim(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ranAt := time.Now()
+			s.run(ctx, job)
+			row := findTestJob(t, db, job.id)
+			if row.status != tt.wantStatus {
+				t.Errorf("status = %s, want %s", row.status, tt.wantStatus)
+			}
+			if row.attempts != tt.attempts+1 {
+				t.Errorf("attempts = %d, want %d", row.attempts, tt.attempts+1)
+			}
+			if lastSeen != tt.wantLastSeen {
+				t.Errorf("LastJobAttempt() = %v, want %v", lastSeen, tt.wantLastSeen)
+			}
+			if tt.wantStatus == "done" {
+				return
+			}
+			if row.lastError == "" {
+				t.Error("last_error is empty")
+			}
+			if tt.wantBackoff > 0 {
+				want := ranAt.Add(tt.wantBackoff).Unix()
+				if row.runAt < want-1 || row.runAt > want+1 {
+					t.Errorf("run_at = %d, want %d", row.runAt, want)
+				}
+			}
+		})
+	}
+}
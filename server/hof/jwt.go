@@ -1,14 +1,27 @@
 package hof
 
 import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
 	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
 	"time"
 
+	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 )
 
-const TokenSecret = "this_MY_secret_b120"
-
 type IJSONWebToken interface {
 	Claim(payload interface{}) (string, error)
 }
@@ -19,33 +32,54 @@ type JSONWebTokenClaim struct {
 }
 
 type JSONWebToken struct {
+	ID        string
 	Issuer    string
 	IssuedAt  time.Time
 	ExpiredAt time.Time
 }
 
 func (j *JSONWebToken) Claim(payload map[string]interface{}) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, JSONWebTokenClaim{
+	keySet := GetJWTKeySet()
+	issuer := j.Issuer
+	if issuer == "" {
+		issuer = keySet.Issuer
+	}
+	key := keySet.SigningKey()
+	token := jwt.NewWithClaims(key.Method, JSONWebTokenClaim{
 		RegisteredClaims: jwt.RegisteredClaims{
-			Issuer:    j.Issuer,
+			ID:        j.ID,
+			Issuer:    issuer,
 			IssuedAt:  &jwt.NumericDate{Time: j.IssuedAt},
 			ExpiresAt: &jwt.NumericDate{Time: j.ExpiredAt},
 		},
 		Payload: payload,
 	})
-	return token.SignedString([]byte(TokenSecret))
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.Private)
 }
 
 func ExtractAndValidateJWT(
-	secret string, token string,
+	keySet *JWTKeySet, token string,
 ) (claim *JSONWebTokenClaim, err error) {
 	var parseToken *jwt.Token
 	var ok bool
+	opts := []jwt.ParserOption{jwt.WithValidMethods(keySet.Algorithms())}
+	if keySet.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(keySet.Issuer))
+	}
 	if parseToken, err = jwt.ParseWithClaims(
 		token, &JSONWebTokenClaim{},
 		func(token *jwt.Token) (interface{}, error) {
-			return []byte(secret), nil
-		},
+			kid, _ := token.Header["kid"].(string)
+			key, ok := keySet.Key(kid)
+			if !ok {
+				return nil, fmt.Errorf("unknown signing key %q", kid)
+			}
+			if token.Method.Alg() != key.Method.Alg() {
+				return nil, errors.New("signing method does not match key")
+			}
+			return key.Public, nil
+		}, opts...,
 	); err != nil {
 		return nil, err
 	}
@@ -57,3 +91,188 @@ func ExtractAndValidateJWT(
 	}
 	return claim, nil
 }
+
+// JWTKey is one asymmetric key of the key set, identified by its kid.
+type JWTKey struct {
+	ID      string
+	Method  jwt.SigningMethod
+	Private crypto.Signer
+	Public  crypto.PublicKey
+}
+
+// JWTKeySet holds every key that is accepted for verification and
+// the one currently used for signing. Rotating keys is done by adding
+// the new key, pointing signing_kid at it and removing the old key
+// once the tokens it signed have expired.
+type JWTKeySet struct {
+	Issuer     string
+	signingKID string
+	keys       map[string]*JWTKey
+	order      []string
+}
+
+func (s *JWTKeySet) SigningKey() *JWTKey {
+	return s.keys[s.signingKID]
+}
+
+func (s *JWTKeySet) Key(kid string) (*JWTKey, bool) {
+	key, ok := s.keys[kid]
+	return key, ok
+}
+
+func (s *JWTKeySet) Algorithms() []string {
+	var algs []string
+	seen := map[string]bool{}
+	for _, kid := range s.order {
+		alg := s.keys[kid].Method.Alg()
+		if !seen[alg] {
+			seen[alg] = true
+			algs = append(algs, alg)
+		}
+	}
+	return algs
+}
+
+// JWKS returns the public part of the key set as described in RFC 7517.
+func (s *JWTKeySet) JWKS() map[string]interface{} {
+	keys := make([]map[string]interface{}, 0, len(s.order))
+	for _, kid := range s.order {
+		key := s.keys[kid]
+		jwk := map[string]interface{}{
+			"kid": key.ID,
+			"use": "sig",
+			"alg": key.Method.Alg(),
+		}
+		switch pub := key.Public.(type) {
+		case *rsa.PublicKey:
+			jwk["kty"] = "RSA"
+			jwk["n"] = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk["e"] = base64.RawURLEncoding.EncodeToString(
+				big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk["kty"] = "OKP"
+			jwk["crv"] = "Ed25519"
+			jwk["x"] = base64.RawURLEncoding.EncodeToString(pub)
+		}
+		keys = append(keys, jwk)
+	}
+	return map[string]interface{}{"keys": keys}
+}
+
+func JWKS(ctx *gin.Context) {
+	ctx.Header("Cache-Control", "public, max-age=300")
+	ctx.JSON(http.StatusOK, GetJWTKeySet().JWKS())
+}
+
+var (
+	jwtKeySet     *JWTKeySet
+	jwtKeySetOnce sync.Once
+)
+
+// GetJWTKeySet loads jwt.json once. When the file does not exist an
+// ephemeral Ed25519 key is generated, so tokens do not survive a restart.
+func GetJWTKeySet() *JWTKeySet {
+	jwtKeySetOnce.Do(func() {
+		var err error
+		if _, err = os.Stat("jwt.json"); errors.Is(err, os.ErrNotExist) {
+			log.Println("jwt.json not found, signing tokens with an ephemeral key")
+			if jwtKeySet, err = newEphemeralJWTKeySet(); err != nil {
+				log.Fatalf("Unable to generate jwt signing key: %v", err)
+			}
+			return
+		}
+		if jwtKeySet, err = LoadJWTKeySet("jwt.json"); err != nil {
+			log.Fatalf("Unable to load jwt signing keys: %v", err)
+		}
+	})
+	return jwtKeySet
+}
+
+func LoadJWTKeySet(path string) (*JWTKeySet, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg struct {
+		Issuer     string `json:"issuer"`
+		SigningKID string `json:"signing_kid"`
+		Keys       []struct {
+			KID            string `json:"kid"`
+			PrivateKeyFile string `json:"private_key_file"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return nil, err
+	}
+	set := &JWTKeySet{
+		Issuer:     cfg.Issuer,
+		signingKID: cfg.SigningKID,
+		keys:       map[string]*JWTKey{},
+	}
+	for _, k := range cfg.Keys {
+		pemBytes, err := os.ReadFile(k.PrivateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		key, err := parseJWTPrivateKey(k.KID, pemBytes)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %v", k.KID, err)
+		}
+		if _, ok := set.keys[k.KID]; ok {
+			return nil, fmt.Errorf("duplicate key id %s", k.KID)
+		}
+		set.keys[k.KID] = key
+		set.order = append(set.order, k.KID)
+	}
+	if _, ok := set.keys[set.signingKID]; !ok {
+		return nil, fmt.Errorf("signing key %q is not in keys", set.signingKID)
+	}
+	return set, nil
+}
+
+func parseJWTPrivateKey(kid string, pemBytes []byte) (*JWTKey, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, errors.New("no pem block found")
+	}
+	var private interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		private, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported pem block %s", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch key := private.(type) {
+	case *rsa.PrivateKey:
+		return &JWTKey{ID: kid, Method: jwt.SigningMethodRS256,
+			Private: key, Public: &key.PublicKey}, nil
+	case ed25519.PrivateKey:
+		return &JWTKey{ID: kid, Method: jwt.SigningMethodEdDSA,
+			Private: key, Public: key.Public()}, nil
+	default:
+		return nil, errors.New("only RSA and Ed25519 keys are supported")
+	}
+}
+
+func newEphemeralJWTKeySet() (*JWTKeySet, error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	kid, err := GenerateRandomToken(8)
+	if err != nil {
+		return nil, err
+	}
+	return &JWTKeySet{
+		signingKID: kid,
+		keys: map[string]*JWTKey{kid: {ID: kid, Method: jwt.SigningMethodEdDSA,
+			Private: private, Public: public}},
+		order: []string{kid},
+	}, nil
+}
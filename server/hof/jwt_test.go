@@ -0,0 +1,250 @@
+package hof
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// writeTestJWTKeys writes an RSA key "rsa-1" and an Ed25519 key "ed-1" to
+// dir and returns their pem files by kid.
+func writeTestJWTKeys(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edDER, err := x509.MarshalPKCS8PrivateKey(edKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks := map[string]*pem.Block{
+		"rsa-1": {Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)},
+		"ed-1":  {Type: "PRIVATE KEY", Bytes: edDER},
+	}
+	files := map[string]string{}
+	for kid, block := range blocks {
+		files[kid] = filepath.Join(dir, kid+".pem")
+		if err := os.WriteFile(files[kid], pem.EncodeToMemory(block), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return files
+}
+
+// writeTestJWTConfig writes a jwt.json signing with signingKID over keys,
+// given as kid and pem file pairs.
+func writeTestJWTConfig(t *testing.T, dir, signingKID string, keys ...string) string {
+	t.Helper()
+	var entries []map[string]string
+	for i := 0; i+1 < len(keys); i += 2 {
+		entries = append(entries, map[string]string{"kid": keys[i], "private_key_file": keys[i+1]})
+	}
+	b, _ := json.Marshal(map[string]interface{}{
+		"issuer":      "http://localhost:8000",
+		"signing_kid": signingKID,
+		"keys":        entries,
+	})
+	path := filepath.Join(dir, "jwt.json")
+	if err := os.WriteFile(path, b, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// signTestJWT signs claims with the key kid of set the way Claim does.
+func signTestJWT(t *testing.T, set *JWTKeySet, kid string) string {
+	t.Helper()
+	key, ok := set.Key(kid)
+	if !ok {
+		t.Fatalf("key %s is not in the set", kid)
+	}
+	now := time.Now()
+	token := jwt.NewWithClaims(key.Method, JSONWebTokenClaim{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        "session-1",
+			Issuer:    set.Issuer,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+		},
+		Payload: map[string]interface{}{"username": "mentor"},
+	})
+	token.Header["kid"] = key.ID
+	signed, err := token.SignedString(key.Private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+// jwksKeyFunc verifies tokens only with the public keys of a JWKS
+// document, as a client of /.well-known/jwks.json does.
+func jwksKeyFunc(t *testing.T, document []byte) jwt.Keyfunc {
+	t.Helper()
+	var jwks struct {
+		Keys []struct {
+			KID string `json:"kid"`
+			Kty string `json:"kty"`
+			Alg string `json:"alg"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(document, &jwks); err != nil {
+		t.Fatal(err)
+	}
+	keys := map[string]interface{}{}
+	for _, k := range jwks.Keys {
+		if k.Use != "sig" {
+			t.Errorf("key %s use = %q, want sig", k.KID, k.Use)
+		}
+		switch k.Kty {
+		case "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(k.N)
+			e, errE := base64.RawURLEncoding.DecodeString(k.E)
+			if errN != nil || errE != nil || k.Alg != "RS256" {
+				t.Fatalf("unreadable RSA key %+v", k)
+			}
+			keys[k.KID] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case "OKP":
+			x, err := base64.RawURLEncoding.DecodeString(k.X)
+			if err != nil || k.Crv != "Ed25519" || k.Alg != "EdDSA" {
+				t.Fatalf("unreadable OKP key %+v", k)
+			}
+			keys[k.KID] = ed25519.PublicKey(x)
+		default:
+			t.Fatalf("key %s of type %q", k.KID, k.Kty)
+		}
+	}
+	return func(token *jwt.Token) (interface{}, error) {
+		key, ok := keys[token.Header["kid"].(string)]
+		if !ok {
+			return nil, fmt.Errorf("kid %v is not in the jwks", token.Header["kid"])
+		}
+		return key, nil
+	}
+}
+
+func TestLoadJWTKeySetVerifiesThroughJWKS(t *testing.T) {
+	dir := t.TempDir()
+	files := writeTestJWTKeys(t, dir)
+	set, err := LoadJWTKeySet(writeTestJWTConfig(t, dir, "ed-1",
+		"rsa-1", files["rsa-1"], "ed-1", files["ed-1"]))
+	if err != nil {
+		t.Fatalf("LoadJWTKeySet() error = %v", err)
+	}
+	if set.SigningKey().ID != "ed-1" {
+		t.Errorf("signing key = %s, want ed-1", set.SigningKey().ID)
+	}
+	if got := strings.Join(set.Algorithms(), ","); got != "RS256,EdDSA" {
+		t.Errorf("Algorithms() = %s, want RS256,EdDSA", got)
+	}
+	document, err := json.Marshal(set.JWKS())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(document), `"d"`) {
+		t.Errorf("jwks holds a private key: %s", document)
+	}
+	keyFunc := jwksKeyFunc(t, document)
+	for _, kid := range []string{"rsa-1", "ed-1"} {
+		t.Run(kid, func(t *testing.T) {
+			signed := signTestJWT(t, set, kid)
+			if _, err := jwt.Parse(signed, keyFunc); err != nil {
+				t.Errorf("token of %s does not verify with the jwks: %v", kid, err)
+			}
+			claim, err := ExtractAndValidateJWT(set, signed)
+			if err != nil {
+				t.Fatalf("ExtractAndValidateJWT() error = %v", err)
+			}
+			if claim.ID != "session-1" || claim.Payload["username"] != "mentor" {
+				t.Errorf("claim = %+v", claim)
+			}
+		})
+	}
+}
+
+func TestExtractAndValidateJWTRejectsUnknownKeys(t *testing.T) {
+	dir := t.TempDir()
+	files := writeTestJWTKeys(t, dir)
+	// the rotated out key still signs, the server no longer knows it
+	old, err := LoadJWTKeySet(writeTestJWTConfig(t, dir, "rsa-1",
+		"rsa-1", files["rsa-1"], "ed-1", files["ed-1"]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := LoadJWTKeySet(writeTestJWTConfig(t, dir, "ed-1", "ed-1", files["ed-1"]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// another key claiming the kid of the current one
+	forged, err := newEphemeralJWTKeySet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	forgedKey := forged.SigningKey()
+	forged.keys = map[string]*JWTKey{"ed-1": {ID: "ed-1", Method: forgedKey.Method,
+		Private: forgedKey.Private, Public: forgedKey.Public}}
+	forged.Issuer = current.Issuer
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"unknown kid", signTestJWT(t, old, "rsa-1")},
+		{"known kid of another key", signTestJWT(t, forged, "ed-1")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ExtractAndValidateJWT(current, tt.token); err == nil {
+				t.Error("ExtractAndValidateJWT() error = nil, want the token rejected")
+			}
+		})
+	}
+	document, _ := json.Marshal(current.JWKS())
+	if _, err := jwt.Parse(tests[0].token, jwksKeyFunc(t, document)); err == nil {
+		t.Error("token of a key missing from the jwks verifies")
+	}
+}
+
+func TestLoadJWTKeySetErrors(t *testing.T) {
+	dir := t.TempDir()
+	files := writeTestJWTKeys(t, dir)
+	tests := []struct {
+		name       string
+		signingKID string
+		keys       []string
+		want       string
+	}{
+		{"signing key missing", "ed-2", []string{"ed-1", files["ed-1"]}, "not in keys"},
+		{"duplicate kid", "ed-1", []string{"ed-1", files["ed-1"], "ed-1", files["rsa-1"]}, "duplicate"},
+		{"missing file", "ed-1", []string{"ed-1", filepath.Join(dir, "none.pem")}, "no such file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadJWTKeySet(writeTestJWTConfig(t, dir, tt.signingKID, tt.keys...))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("LoadJWTKeySet() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,268 @@
+package hof
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type MailMessage struct {
+	From        string           `json:"from"`
+	To          []string         `json:"to"`
+	Subject     string           `json:"subject"`
+	Text        string           `json:"text"`
+	Attachments []MailAttachment `json:"attachments,omitempty"`
+}
+
+type MailAttachment struct {
+	Name        string `json:"name"`
+	ContentType string `json:"content_type"`
+	Data        []byte `json:"data"`
+}
+
+var lineBreaks = strings.NewReplacer("\r", "", "\n", "")
+
+// stripLineBreaks removes CR and LF from a value written into a header,
+// where they would start another header.
+func stripLineBreaks(s string) string {
+	return lineBreaks.Replace(s)
+}
+
+// composeMail renders msg as an RFC 5322 message, multipart
+// when it carries attachments.
+func composeMail(msg *MailMessage) ([]byte, error) {
+	var b bytes.Buffer
+	to := make([]string, len(msg.To))
+	for i, address := range msg.To {
+		to[i] = stripLineBreaks(address)
+	}
+	b.WriteString("From: " + stripLineBreaks(msg.From) + "\r\n")
+	b.WriteString("To: " + strings.Join(to, ", ") + "\r\n")
+	b.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", msg.Subject) + "\r\n")
+	b.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	if len(msg.Attachments) == 0 {
+		b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+		b.WriteString(msg.Text)
+		return b.Bytes(), nil
+	}
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	b.WriteString("Content-Type: multipart/mixed; boundary=" + w.Boundary() + "\r\n\r\n")
+	part, err := w.CreatePart(textproto.MIMEHeader{
+		"Content-Type": {"text/plain; charset=utf-8"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := part.Write([]byte(msg.Text)); err != nil {
+		return nil, err
+	}
+	for _, attachment := range msg.Attachments {
+		part, err := w.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {attachment.ContentType},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Name})},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		encoded := base64.StdEncoding.EncodeToString(attachment.Data)
+		// base64 bodies are wrapped at 76 characters
+		for len(encoded) > 76 {
+			if _, err := part.Write([]byte(encoded[:76] + "\r\n")); err != nil {
+				return nil, err
+			}
+			encoded = encoded[76:]
+		}
+		if _, err := part.Write([]byte(encoded + "\r\n")); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	b.Write(body.Bytes())
+	return b.Bytes(), nil
+}
+
+type IMailSender interface {
+	Send(ctx context.Context, msg *MailMessage) error
+}
+
+// LogMailSender prints every message to the server log,
+// meant for local development only.
+type LogMailSender struct {
+	From string
+}
+
+func (s LogMailSender) Send(_ context.Context, msg *MailMessage) error {
+	if msg.From == "" {
+		msg.From = s.From
+	}
+	var names []string
+	for _, attachment := range msg.Attachments {
+		names = append(names, attachment.Name)
+	}
+	log.Printf("MAIL from=%s to=%s subject=%q attachments=%s\n%s",
+		msg.From, strings.Join(msg.To, ","), msg.Subject, strings.Join(names, ","), msg.Text)
+	return nil
+}
+
+// FileMailSender writes every message as an .eml file into Dir,
+// so it can be opened with any mail client.
+type FileMailSender struct {
+	From string
+	Dir  string
+}
+
+func (s FileMailSender) Send(_ context.Context, msg *MailMessage) error {
+	if msg.From == "" {
+		msg.From = s.From
+	}
+	if err := os.MkdirAll(s.Dir, 0o755); err != nil {
+		return err
+	}
+	suffix, err := generateRandomString(6)
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), suffix)
+	data, err := composeMail(msg)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(s.Dir, name), data, 0o600)
+}
+
+// smtpTimeout bounds a whole SMTP conversation when the sender has no
+// timeout of its own.
+const smtpTimeout = time.Second * 30
+
+// SMTPMailSender delivers every message through an SMTP server,
+// locally a sink such as Mailpit or MailHog.
+type SMTPMailSender struct {
+	From     string
+	Host     string
+	Port     int
+	Username string
+	Password string
+	// Timeout bounds connecting and delivering one message.
+	Timeout time.Duration
+}
+
+func (s SMTPMailSender) Send(ctx context.Context, msg *MailMessage) error {
+	if msg.From == "" {
+		msg.From = s.From
+	}
+	data, err := composeMail(msg)
+	if err != nil {
+		return err
+	}
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = smtpTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	addr := net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	// a server that stops answering fails the message instead of blocking
+	deadline, _ := ctx.Deadline()
+	if err := conn.SetDeadline(deadline); err != nil {
+		_ = conn.Close()
+		return err
+	}
+	c, err := smtp.NewClient(conn, s.Host)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	defer func() { _ = c.Close() }()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: s.Host}); err != nil {
+			return err
+		}
+	}
+	if s.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", s.Username, s.Password, s.Host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(msg.From); err != nil {
+		return err
+	}
+	for _, to := range msg.To {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// GetMailSender builds the sender configured in mail.json,
+// falling back to LogMailSender when the file does not exist.
+func GetMailSender() IMailSender {
+	b, err := os.ReadFile("mail.json")
+	if errors.Is(err, os.ErrNotExist) {
+		return LogMailSender{From: "no-reply@localhost"}
+	}
+	if err != nil {
+		log.Fatalf("Unable to read mail config file: %v", err)
+	}
+	var cfg struct {
+		Driver   string `json:"driver"`
+		From     string `json:"from"`
+		Dir      string `json:"dir"`
+		Host     string `json:"host"`
+		Port     int    `json:"port"`
+		Username string `json:"username"`
+		Password string `json:"password"`
+		// seconds, smtpTimeout when left out
+		Timeout int `json:"timeout"`
+	}
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		log.Fatalf("Unable to parse mail config file: %v", err)
+	}
+	switch cfg.Driver {
+	case "file":
+		return FileMailSender{From: cfg.From, Dir: cfg.Dir}
+	case "smtp":
+		return SMTPMailSender{From: cfg.From, Host: cfg.Host, Port: cfg.Port,
+			Username: cfg.Username, Password: cfg.Password,
+			Timeout: time.Duration(cfg.Timeout) * time.Second}
+	case "log", "":
+		return LogMailSender{From: cfg.From}
+	default:
+		log.Fatalf("Unknown mail driver: %s", cfg.Driver)
+		return nil
+	}
+}
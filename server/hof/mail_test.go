@@ -0,0 +1,259 @@
+package hof
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestComposeMail(t *testing.T) {
+	data, err := composeMail(&MailMessage{
+		From:    "no-reply@example.com",
+		To:      []string{"ann@example.com", "mentor@example.com"},
+		Subject: "Bestätigt: Intro",
+		Text:    "See you soon.",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := msg.Header.Get("To"); got != "ann@example.com, mentor@example.com" {
+		t.Errorf("To = %q", got)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil || subject != "Bestätigt: Intro" {
+		t.Errorf("Subject = %q, %v", subject, err)
+	}
+	body, _ := io.ReadAll(msg.Body)
+	if string(body) != "See you soon." {
+		t.Errorf("body = %q", body)
+	}
+}
+
+func TestComposeMailStripsLineBreaksFromAddresses(t *testing.T) {
+	data, err := composeMail(&MailMessage{
+		From:    "no-reply@example.com",
+		To:      []string{"ann@example.com\r\nBcc: victim@example.com"},
+		Subject: "Confirmed",
+		Text:    "Confirmed.",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := msg.Header.Get("Bcc"); got != "" {
+		t.Errorf("Bcc = %q, want no header added", got)
+	}
+	if got := msg.Header.Get("To"); got != "ann@example.comBcc: victim@example.com" {
+		t.Errorf("To = %q", got)
+	}
+}
+
+func TestComposeMailAttachment(t *testing.T) {
+	ics := ComposeICS(ICSMethodRequest, testICSEvent())
+	data, err := composeMail(&MailMessage{
+		From:    "no-reply@example.com",
+		To:      []string{"ann@example.com"},
+		Subject: "Confirmed",
+		Text:    "Confirmed.",
+		Attachments: []MailAttachment{{
+			Name:        "invite.ics",
+			ContentType: "text/calendar; charset=utf-8; method=REQUEST",
+			Data:        ics,
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/mixed" {
+		t.Fatalf("Content-Type = %q, %v", mediaType, err)
+	}
+	r := multipart.NewReader(msg.Body, params["boundary"])
+	text, err := r.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := io.ReadAll(text); string(b) != "Confirmed." {
+		t.Errorf("text part = %q", b)
+	}
+	attachment, err := r.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attachment.FileName() != "invite.ics" {
+		t.Errorf("attachment name = %q", attachment.FileName())
+	}
+	// multipart.Reader decodes quoted-printable only, base64 is left as is
+	encoded, _ := io.ReadAll(attachment)
+	for _, line := range strings.Split(string(encoded), "\r\n") {
+		if len(line) > 76 {
+			t.Errorf("base64 line of %d characters", len(line))
+		}
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(string(encoded), "\r\n", ""))
+	if err != nil || !bytes.Equal(decoded, ics) {
+		t.Errorf("attachment does not decode to the invite: %v", err)
+	}
+}
+
+// smtpSink is an SMTP server keeping the messages delivered to it.
+type smtpSink struct {
+	net.Listener
+	messages chan sinkMessage
+}
+
+type sinkMessage struct {
+	From string
+	To   []string
+	Data string
+}
+
+func newSMTPSink(t *testing.T) *smtpSink {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := &smtpSink{Listener: l, messages: make(chan sinkMessage, 1)}
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go sink.serve(conn)
+		}
+	}()
+	return sink
+}
+
+func (s *smtpSink) serve(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { _, _ = conn.Write([]byte(line + "\r\n")) }
+	reply("220 sink ESMTP")
+	var msg sinkMessage
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch cmd {
+		case "EHLO", "HELO":
+			reply("250 sink")
+		case "MAIL":
+			msg.From = strings.Trim(strings.TrimPrefix(line[5:], "FROM:"), "<>")
+			reply("250 ok")
+		case "RCPT":
+			msg.To = append(msg.To, strings.Trim(strings.TrimPrefix(line[5:], "TO:"), "<>"))
+			reply("250 ok")
+		case "DATA":
+			reply("354 go ahead")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(l)
+			}
+			msg.Data = data.String()
+			s.messages <- msg
+			reply("250 queued")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+func (s *smtpSink) sender() SMTPMailSender {
+	host, port, _ := net.SplitHostPort(s.Addr().String())
+	p, _ := strconv.Atoi(port)
+	return SMTPMailSender{From: "no-reply@example.com", Host: host, Port: p, Timeout: 5 * time.Second}
+}
+
+func TestSMTPMailSenderSend(t *testing.T) {
+	sink := newSMTPSink(t)
+	err := sink.sender().Send(context.Background(), &MailMessage{
+		To:      []string{"ann@example.com", "mentor@example.com"},
+		Subject: "Confirmed",
+		Text:    "Confirmed.",
+	})
+	if err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	select {
+	case msg := <-sink.messages:
+		if msg.From != "no-reply@example.com" {
+			t.Errorf("MAIL FROM = %q", msg.From)
+		}
+		if strings.Join(msg.To, ",") != "ann@example.com,mentor@example.com" {
+			t.Errorf("RCPT TO = %q", msg.To)
+		}
+		if !strings.Contains(msg.Data, "Subject: Confirmed\r\n") ||
+			!strings.HasSuffix(msg.Data, "Confirmed.\r\n") {
+			t.Errorf("DATA = %q", msg.Data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("sink received no message")
+	}
+}
+
+func TestSMTPMailSenderTimeout(t *testing.T) {
+	// accepts the connection but never greets
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = l.Close() }()
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			defer func() { _ = conn.Close() }()
+			time.Sleep(5 * time.Second)
+		}
+	}()
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+	p, _ := strconv.Atoi(port)
+	sender := SMTPMailSender{Host: host, Port: p, Timeout: 200 * time.Millisecond}
+	started := time.Now()
+	err = sender.Send(context.Background(), &MailMessage{
+		From: "no-reply@example.com", To: []string{"ann@example.com"}, Subject: "s", Text: "t",
+	})
+	if err == nil {
+		t.Fatal("Send() to a silent server succeeded")
+	}
+	if elapsed := time.Since(started); elapsed > 2*time.Second {
+		t.Fatalf("Send() gave up after %s, want about the 200ms timeout", elapsed)
+	}
+}
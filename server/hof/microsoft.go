@@ -1,16 +1,18 @@
 package hof
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"strings"
 	"time"
 
+	"github.com/golang-jwt/jwt/v5"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/microsoft"
 )
@@ -22,7 +24,8 @@ type MSEvent struct {
 	End                   MSEventStartEnd `json:"end"`
 	Attendees             []MSAttendee    `json:"attendees"`
 	IsOnlineMeeting       bool            `json:"isOnlineMeeting"`
-	OnlineMeetingProvider string          `json:"onlineMeetingProvider"`
+	OnlineMeetingProvider string          `json:"onlineMeetingProvider,omitempty"`
+	Recurrence            *MSRecurrence   `json:"recurrence,omitempty"`
 }
 
 type MSBody struct {
@@ -45,21 +48,60 @@ type MSEmailAddress struct {
 	Name    string `json:"name"`
 }
 
+type MSRecurrence struct {
+	Pattern MSRecurrencePattern `json:"pattern"`
+	Range   MSRecurrenceRange   `json:"range"`
+}
+
+type MSRecurrencePattern struct {
+	Type       string   `json:"type"`
+	Interval   int      `json:"interval"`
+	DaysOfWeek []string `json:"daysOfWeek"`
+}
+
+type MSRecurrenceRange struct {
+	Type                string `json:"type"`
+	StartDate           string `json:"startDate"`
+	EndDate             string `json:"endDate,omitempty"`
+	NumberOfOccurrences int    `json:"numberOfOccurrences,omitempty"`
+	RecurrenceTimeZone  string `json:"recurrenceTimeZone,omitempty"`
+}
+
+// ComposeMSRecurrence returns the recurrence of an event starting at start
+// and repeating count times every interval weeks, nil for a single event.
+func ComposeMSRecurrence(start time.Time, interval, count int) *MSRecurrence {
+	if count < 2 {
+		return nil
+	}
+	return &MSRecurrence{
+		Pattern: MSRecurrencePattern{
+			Type:       "weekly",
+			Interval:   interval,
+			DaysOfWeek: []string{strings.ToLower(start.Weekday().String())},
+		},
+		Range: MSRecurrenceRange{
+			Type:                "numbered",
+			StartDate:           start.Format("2006-01-02"),
+			NumberOfOccurrences: count,
+		},
+	}
+}
+
 func ComposeMSMeetingData(
 	timezone, summary string,
 	date int64, timeInt, duration int,
 	menteeName, menteeMail string,
-) MSEvent {
+) (*MSEvent, error) {
 	loc, err := time.LoadLocation(timezone)
 	if err != nil {
-		log.Fatalf("Unable to load timezone: %v", err)
+		return nil, fmt.Errorf("unable to load timezone: %v", err)
 	}
 	dateObj := time.Unix(date, 0).In(loc)
 	dateObj = time.Date(dateObj.Year(), dateObj.Month(), dateObj.Day(), 0, 0, 0, 0, loc)
 	dateObj = dateObj.Add(time.Duration(timeInt/100) * time.Hour)
 	dateObj = dateObj.Add(time.Duration(timeInt%100) * time.Minute)
 	endObj := dateObj.Add(time.Duration(duration) * time.Minute)
-	return MSEvent{
+	return &MSEvent{
 		Subject: summary,
 		Start: MSEventStartEnd{
 			DateTime: dateObj.Format(time.RFC3339),
@@ -78,123 +120,598 @@ func ComposeMSMeetingData(
 				Type: "required",
 			},
 		},
-		IsOnlineMeeting:       true,
-		OnlineMeetingProvider: "teamsForBusiness",
+	}, nil
+}
+
+// MSEventResult is an event of the signed in user as graph saved it.
+type MSEventResult struct {
+	ID                    string           `json:"id"`
+	ICalUID               string           `json:"iCalUId"`
+	Subject               string           `json:"subject"`
+	WebLink               string           `json:"webLink"`
+	Start                 MSEventStartEnd  `json:"start"`
+	End                   MSEventStartEnd  `json:"end"`
+	Attendees             []MSAttendee     `json:"attendees"`
+	Organizer             *MSAttendee      `json:"organizer"`
+	Recurrence            *MSRecurrence    `json:"recurrence"`
+	IsOnlineMeeting       bool             `json:"isOnlineMeeting"`
+	OnlineMeetingProvider string           `json:"onlineMeetingProvider"`
+	OnlineMeeting         *MSOnlineMeeting `json:"onlineMeeting"`
+}
+
+// MSOnlineMeeting is how to join the online meeting of an event.
+type MSOnlineMeeting struct {
+	JoinURL string `json:"joinUrl"`
+}
+
+// CreateEvent adds event to the default calendar of the signed in user,
+// graph sends the attendees an invite.
+func (c *GraphClient) CreateEvent(ctx context.Context, event *MSEvent) (*MSEventResult, error) {
+	var saved MSEventResult
+	if err := c.do(ctx, &graphRequest{
+		Method: "POST",
+		Path:   "/me/calendar/events",
+		Body:   event,
+	}, &saved); err != nil {
+		return nil, fmt.Errorf("unable to create event: %w", err)
 	}
+	return &saved, nil
 }
 
-func SetMicrosoftNewCalendarEvent(
-	event MSEvent,
-	accessToken string,
-) (map[string]interface{}, error) {
-	// Convert event to JSON
-	eventJSON, err := json.Marshal(event)
-	if err != nil {
-		return nil, fmt.Errorf("error marshalling event to JSON: %s", err.Error())
+// AddEventAttendee adds attendee to an event of the signed in user, graph
+// replaces the whole attendee list so it is read first. It returns nil
+// when attendee was invited already.
+func (c *GraphClient) AddEventAttendee(
+	ctx context.Context,
+	eventID string,
+	attendee MSAttendee,
+) (*MSEventResult, error) {
+	var current struct {
+		Attendees []MSAttendee `json:"attendees"`
 	}
-	// Make request to Microsoft Graph API to create event
-	url := "https://graph.microsoft.com/v1.0/me/calendar/events"
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(eventJSON))
-	if err != nil {
-		return nil, fmt.Errorf("error creating HTTP request: %s", err.Error())
+	if err := c.do(ctx, &graphRequest{
+		Method: "GET",
+		Path:   "/me/events/" + url.PathEscape(eventID) + "?$select=attendees",
+	}, &current); err != nil {
+		return nil, fmt.Errorf("unable to read event: %w", err)
 	}
-	req.Header.Set("Authorization", "Bearer "+accessToken)
-	req.Header.Set("Content-Type", "application/json")
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("error making HTTP request:%s", err.Error())
+	for _, a := range current.Attendees {
+		if strings.EqualFold(a.EmailAddress.Address, attendee.EmailAddress.Address) {
+			return nil, nil
+		}
 	}
-	defer func() { _ = resp.Body.Close() }()
-	// Parse the response to get the meeting link
-	responseBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("error reading response body: %s", err.Error())
+	var saved MSEventResult
+	if err := c.do(ctx, &graphRequest{
+		Method: "PATCH",
+		Path:   "/me/events/" + url.PathEscape(eventID),
+		Body: map[string]interface{}{
+			"attendees": append(current.Attendees, attendee),
+		},
+	}, &saved); err != nil {
+		return nil, fmt.Errorf("unable to add event attendee: %w", err)
 	}
-	var eventData map[string]interface{}
-	if err := json.Unmarshal(responseBody, &eventData); err != nil {
-		return nil, fmt.Errorf("error unmarshalling response body: %s", err.Error())
+	return &saved, nil
+}
+
+// CancelEvent deletes an event of the signed in user, for the series
+// master of a recurring event every occurrence. An event that is gone
+// already is not an error.
+func (c *GraphClient) CancelEvent(ctx context.Context, eventID string) error {
+	err := c.do(ctx, &graphRequest{
+		Method: "DELETE",
+		Path:   "/me/events/" + url.PathEscape(eventID),
+	}, nil)
+	if err != nil && !IsGraphStatus(err, http.StatusNotFound) {
+		return fmt.Errorf("unable to cancel event: %w", err)
 	}
-	return eventData, nil
+	return nil
 }
 
-func SetMicrosoftNewMeeting(
-	startDate, endDate, subject, accessToken string,
-) (map[string]interface{}, error) {
-	// Convert event to JSON
-	eventJSON, err := json.Marshal(map[string]interface{}{
-		"startDateTime": startDate,
-		"endDateTime":   endDate,
-		"subject":       subject,
-	})
-	if err != nil {
-		return nil, fmt.Errorf("error marshalling event to JSON: %s", err.Error())
+// RescheduleEvent moves an event of the signed in user to start and end,
+// graph sends the attendees an update.
+func (c *GraphClient) RescheduleEvent(
+	ctx context.Context,
+	eventID, timezone string,
+	start, end time.Time,
+) (*MSEventResult, error) {
+	var saved MSEventResult
+	if err := c.do(ctx, &graphRequest{
+		Method: "PATCH",
+		Path:   "/me/events/" + url.PathEscape(eventID),
+		Body: map[string]interface{}{
+			"start": MSEventStartEnd{DateTime: start.Format(time.RFC3339), TimeZone: timezone},
+			"end":   MSEventStartEnd{DateTime: end.Format(time.RFC3339), TimeZone: timezone},
+		},
+	}, &saved); err != nil {
+		return nil, fmt.Errorf("unable to reschedule event: %w", err)
 	}
-	// Make request to Microsoft Graph API to create event
-	url := "https://graph.microsoft.com/v1.0/me/onlineMeetings"
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(eventJSON))
-	if err != nil {
-		return nil, fmt.Errorf("error creating HTTP request: %s", err.Error())
+	return &saved, nil
+}
+
+// TruncateEventSeries ends the recurring event eventID of the signed in
+// user on the day of until, in the timezone of the series, the occurrences
+// that took place stay on the calendar. Graph sends the attendees an update.
+func (c *GraphClient) TruncateEventSeries(
+	ctx context.Context,
+	eventID string,
+	until time.Time,
+) error {
+	var current struct {
+		Recurrence *MSRecurrence `json:"recurrence"`
 	}
-	req.Header.Set("Authorization", "Bearer "+accessToken)
-	req.Header.Set("Content-Type", "application/json")
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("error making HTTP request:%s", err.Error())
+	if err := c.do(ctx, &graphRequest{
+		Method: "GET",
+		Path:   "/me/events/" + url.PathEscape(eventID) + "?$select=recurrence",
+	}, &current); err != nil {
+		return fmt.Errorf("unable to read event: %w", err)
 	}
-	defer func() { _ = resp.Body.Close() }()
-	// Parse the response to get the meeting link
-	responseBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("error reading response body: %s", err.Error())
+	if current.Recurrence == nil {
+		return fmt.Errorf("event %s is not recurring", eventID)
 	}
-	var meetingData map[string]interface{}
-	if err := json.Unmarshal(responseBody, &meetingData); err != nil {
-		return nil, fmt.Errorf("error unmarshalling response body: %s", err.Error())
+	current.Recurrence.Range.Type = "endDate"
+	current.Recurrence.Range.EndDate = until.Format("2006-01-02")
+	current.Recurrence.Range.NumberOfOccurrences = 0
+	if err := c.do(ctx, &graphRequest{
+		Method: "PATCH",
+		Path:   "/me/events/" + url.PathEscape(eventID),
+		Body:   map[string]interface{}{"recurrence": current.Recurrence},
+	}, nil); err != nil {
+		return fmt.Errorf("unable to truncate event: %w", err)
 	}
-	return meetingData, nil
+	return nil
 }
 
-func GetMicrosoftUserProfile(accessToken string) (map[string]string, error) {
-	url := "https://graph.microsoft.com/v1.0/me"
-	req, err := http.NewRequest("GET", url, http.NoBody)
-	if err != nil {
-		return nil, fmt.Errorf("error creating http request: %s", err.Error())
+// CancelEventOccurrence cancels the occurrence of the recurring event
+// eventID starting at start.
+func (c *GraphClient) CancelEventOccurrence(
+	ctx context.Context,
+	eventID string,
+	start time.Time,
+) error {
+	v := url.Values{}
+	v.Set("startDateTime", start.UTC().Format(time.RFC3339))
+	v.Set("endDateTime", start.Add(time.Minute).UTC().Format(time.RFC3339))
+	v.Set("$select", "id")
+	var instances struct {
+		Value []struct {
+			ID string `json:"id"`
+		} `json:"value"`
 	}
-	req.Header.Set("Authorization", "Bearer "+accessToken)
-	req.Header.Set("Content-Type", "application/json")
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("error making http request: %s", err.Error())
+	if err := c.do(ctx, &graphRequest{
+		Method: "GET",
+		Path:   "/me/events/" + url.PathEscape(eventID) + "/instances?" + v.Encode(),
+	}, &instances); err != nil {
+		return fmt.Errorf("unable to read event occurrences: %w", err)
+	}
+	if len(instances.Value) == 0 {
+		return fmt.Errorf("event occurrence at %s not found", start.Format(time.RFC3339))
 	}
-	defer func() { _ = resp.Body.Close() }()
-	responseBody, err := io.ReadAll(resp.Body)
+	return c.CancelEvent(ctx, instances.Value[0].ID)
+}
+
+// MSMeeting is a standalone online meeting of the signed in user.
+type MSMeeting struct {
+	ID            string `json:"id,omitempty"`
+	Subject       string `json:"subject"`
+	StartDateTime string `json:"startDateTime"`
+	EndDateTime   string `json:"endDateTime"`
+	JoinWebURL    string `json:"joinWebUrl,omitempty"`
+}
+
+// CreateMeeting creates a Teams meeting from start to end that is not on
+// any calendar, only work or school accounts have them.
+func (c *GraphClient) CreateMeeting(
+	ctx context.Context,
+	subject string,
+	start, end time.Time,
+) (*MSMeeting, error) {
+	var meeting MSMeeting
+	if err := c.do(ctx, &graphRequest{
+		Method: "POST",
+		Path:   "/me/onlineMeetings",
+		Body: &MSMeeting{
+			Subject:       subject,
+			StartDateTime: start.UTC().Format(time.RFC3339),
+			EndDateTime:   end.UTC().Format(time.RFC3339),
+		},
+	}, &meeting); err != nil {
+		return nil, fmt.Errorf("unable to create online meeting: %w", err)
+	}
+	return &meeting, nil
+}
+
+// Account types of Microsoft users, only work or school accounts have
+// Teams meetings of their own.
+const (
+	MSAccountWork     = "work"
+	MSAccountPersonal = "personal"
+)
+
+// msConsumerTenant is the tenant every personal Microsoft account is in.
+const msConsumerTenant = "9188040d-6c67-4c5b-b112-36a304b66dad"
+
+// AccountType tells whether the signed in user has a personal account or a
+// work or school one by the tenant (tid) its access token was issued for.
+// Access tokens of personal accounts are not always JWTs, a token without
+// a tenant is taken for a personal account.
+func (c *GraphClient) AccountType() (string, error) {
+	tok, err := c.TokenSource.Token()
 	if err != nil {
-		return nil, fmt.Errorf("error reading response body: %s", err.Error())
+		return "", fmt.Errorf("unable to get access token: %w", err)
 	}
-	var userData map[string]interface{}
-	if err := json.Unmarshal(responseBody, &userData); err != nil {
-		return nil, fmt.Errorf("error unmarshalling response body: %s", err.Error())
+	// graph checks the token, only its claims are of interest here
+	claims := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(tok.AccessToken, claims); err != nil {
+		return MSAccountPersonal, nil
+	}
+	if tid, _ := claims["tid"].(string); tid != "" && tid != msConsumerTenant {
+		return MSAccountWork, nil
+	}
+	return MSAccountPersonal, nil
+}
+
+// MSCalendar is the default calendar of the signed in user, the online
+// meeting providers are what its events can be joined with.
+type MSCalendar struct {
+	AllowedOnlineMeetingProviders []string `json:"allowedOnlineMeetingProviders"`
+	DefaultOnlineMeetingProvider  string   `json:"defaultOnlineMeetingProvider"`
+}
+
+// OnlineMeetingProvider returns the provider events of the calendar get
+// their online meeting from, teamsForBusiness when it is allowed and the
+// default otherwise. It is empty when no provider is allowed.
+func (c *MSCalendar) OnlineMeetingProvider() string {
+	var provider string
+	for _, allowed := range c.AllowedOnlineMeetingProviders {
+		switch {
+		case allowed == "teamsForBusiness":
+			return allowed
+		case allowed == "unknown":
+		case allowed == c.DefaultOnlineMeetingProvider || provider == "":
+			provider = allowed
+		}
+	}
+	return provider
+}
+
+// Calendar reads the online meeting providers of the default calendar of
+// the signed in user.
+func (c *GraphClient) Calendar(ctx context.Context) (*MSCalendar, error) {
+	var calendar MSCalendar
+	if err := c.do(ctx, &graphRequest{
+		Method: "GET",
+		Path:   "/me/calendar?$select=allowedOnlineMeetingProviders,defaultOnlineMeetingProvider",
+	}, &calendar); err != nil {
+		return nil, fmt.Errorf("unable to read calendar: %w", err)
 	}
-	if userData == nil {
+	return &calendar, nil
+}
+
+// msPreferUTC asks graph for times in utc so they need no timezone lookup.
+const msPreferUTC = `outlook.timezone="UTC"`
+
+// BusyTimes returns the events of the default calendar between start and
+// end that are not shown as free, following every result page.
+func (c *GraphClient) BusyTimes(ctx context.Context, start, end time.Time) ([]BusyTime, error) {
+	v := url.Values{}
+	v.Set("startDateTime", start.UTC().Format(time.RFC3339))
+	v.Set("endDateTime", end.UTC().Format(time.RFC3339))
+	v.Set("$select", "start,end,showAs,isCancelled")
+	v.Set("$top", "100")
+	next := "/me/calendarView?" + v.Encode()
+	var busy []BusyTime
+	for next != "" {
+		var page struct {
+			Value    []MSCalendarEvent `json:"value"`
+			NextLink string            `json:"@odata.nextLink"`
+		}
+		if err := c.do(ctx, &graphRequest{Method: "GET", Path: next, Prefer: msPreferUTC},
+			&page); err != nil {
+			return nil, fmt.Errorf("unable to read calendar view: %w", err)
+		}
+		for _, event := range page.Value {
+			if event.IsCancelled || event.ShowAs == "free" {
+				continue
+			}
+			startAt, endAt, err := event.StartEnd()
+			if err != nil {
+				return nil, err
+			}
+			busy = append(busy, BusyTime{Start: startAt, End: endAt})
+		}
+		next = page.NextLink
+	}
+	return busy, nil
+}
+
+// MSSubscription is a graph subscription posting change notifications of
+// Resource to NotificationURL with ClientState until it expires.
+type MSSubscription struct {
+	ID                 string `json:"id,omitempty"`
+	ChangeType         string `json:"changeType,omitempty"`
+	NotificationURL    string `json:"notificationUrl,omitempty"`
+	Resource           string `json:"resource,omitempty"`
+	ClientState        string `json:"clientState,omitempty"`
+	ExpirationDateTime string `json:"expirationDateTime"`
+}
+
+// MSNotification is one change graph posts to a subscription, ClientState
+// is the secret the subscription was created with.
+type MSNotification struct {
+	SubscriptionID string `json:"subscriptionId"`
+	ClientState    string `json:"clientState"`
+	ChangeType     string `json:"changeType"`
+	Resource       string `json:"resource"`
+	ResourceData   struct {
+		ID string `json:"id"`
+	} `json:"resourceData"`
+}
+
+// CreateSubscription subscribes to changes of the events of the signed in
+// user until expiration. Graph validates notificationURL before it
+// answers, so it has to be reachable already.
+func (c *GraphClient) CreateSubscription(
+	ctx context.Context,
+	notificationURL, clientState string,
+	expiration time.Time,
+) (*MSSubscription, error) {
+	var saved MSSubscription
+	if err := c.do(ctx, &graphRequest{
+		Method: "POST",
+		Path:   "/subscriptions",
+		Body: &MSSubscription{
+			ChangeType:         "created,updated,deleted",
+			NotificationURL:    notificationURL,
+			Resource:           "me/events",
+			ClientState:        clientState,
+			ExpirationDateTime: expiration.UTC().Format(time.RFC3339),
+		},
+	}, &saved); err != nil {
+		return nil, fmt.Errorf("unable to create subscription: %w", err)
+	}
+	return &saved, nil
+}
+
+// RenewSubscription moves the expiration of a subscription.
+func (c *GraphClient) RenewSubscription(
+	ctx context.Context,
+	subscriptionID string,
+	expiration time.Time,
+) (*MSSubscription, error) {
+	var saved MSSubscription
+	if err := c.do(ctx, &graphRequest{
+		Method: "PATCH",
+		Path:   "/subscriptions/" + url.PathEscape(subscriptionID),
+		Body:   &MSSubscription{ExpirationDateTime: expiration.UTC().Format(time.RFC3339)},
+	}, &saved); err != nil {
+		return nil, fmt.Errorf("unable to renew subscription: %w", err)
+	}
+	return &saved, nil
+}
+
+// DeleteSubscription ends a subscription, one that expired already is
+// gone.
+func (c *GraphClient) DeleteSubscription(ctx context.Context, subscriptionID string) error {
+	err := c.do(ctx, &graphRequest{
+		Method: "DELETE",
+		Path:   "/subscriptions/" + url.PathEscape(subscriptionID),
+	}, nil)
+	if err != nil && !IsGraphStatus(err, http.StatusNotFound) {
+		return fmt.Errorf("unable to delete subscription: %w", err)
+	}
+	return nil
+}
+
+// MSEventState is what tells whether an event of a booking changed, times
+// are in utc. Type is singleInstance, occurrence, exception or seriesMaster.
+type MSEventState struct {
+	ID             string          `json:"id"`
+	Type           string          `json:"type"`
+	SeriesMasterID string          `json:"seriesMasterId"`
+	OriginalStart  string          `json:"originalStart"`
+	Start          MSEventStartEnd `json:"start"`
+	End            MSEventStartEnd `json:"end"`
+	IsCancelled    bool            `json:"isCancelled"`
+}
+
+// StartEnd parses the start and end of the event.
+func (e *MSEventState) StartEnd() (start, end time.Time, err error) {
+	if start, err = time.Parse(msDateTimeLayout, e.Start.DateTime); err != nil {
+		return
+	}
+	end, err = time.Parse(msDateTimeLayout, e.End.DateTime)
+	return
+}
+
+// EventState reads an event of the signed in user, nil when it was
+// deleted.
+func (c *GraphClient) EventState(ctx context.Context, eventID string) (*MSEventState, error) {
+	var event MSEventState
+	err := c.do(ctx, &graphRequest{
+		Method: "GET",
+		Path: "/me/events/" + url.PathEscape(eventID) +
+			"?$select=type,seriesMasterId,originalStart,start,end,isCancelled",
+		Prefer: msPreferUTC,
+	}, &event)
+	if IsGraphStatus(err, http.StatusNotFound) {
 		return nil, nil
 	}
-	return map[string]string{
-		"name": func() string {
-			if name, ok := userData["displayName"].(string); ok {
-				return name
+	if err != nil {
+		return nil, fmt.Errorf("unable to read event: %w", err)
+	}
+	return &event, nil
+}
+
+// EventOccurrences returns the original starts of the occurrences of the
+// recurring event eventID between start and end that are not cancelled.
+func (c *GraphClient) EventOccurrences(
+	ctx context.Context,
+	eventID string,
+	start, end time.Time,
+) ([]time.Time, error) {
+	v := url.Values{}
+	v.Set("startDateTime", start.UTC().Format(time.RFC3339))
+	v.Set("endDateTime", end.UTC().Format(time.RFC3339))
+	v.Set("$select", "originalStart,isCancelled")
+	v.Set("$top", "100")
+	next := "/me/events/" + url.PathEscape(eventID) + "/instances?" + v.Encode()
+	var starts []time.Time
+	for next != "" {
+		var page struct {
+			Value    []MSEventState `json:"value"`
+			NextLink string         `json:"@odata.nextLink"`
+		}
+		if err := c.do(ctx, &graphRequest{Method: "GET", Path: next}, &page); err != nil {
+			return nil, fmt.Errorf("unable to read event occurrences: %w", err)
+		}
+		for _, occurrence := range page.Value {
+			if occurrence.IsCancelled {
+				continue
 			}
-			return ""
-		}(),
-		"email": func() string {
-			if mail, ok := userData["mail"].(string); ok {
-				return mail
+			originalStart, err := time.Parse(time.RFC3339, occurrence.OriginalStart)
+			if err != nil {
+				return nil, err
 			}
-			return ""
-		}(),
-	}, err
+			starts = append(starts, originalStart)
+		}
+		next = page.NextLink
+	}
+	return starts, nil
+}
+
+// MSCalendarEvent is an occurrence in the calendar view of the signed in
+// user, times are in utc.
+type MSCalendarEvent struct {
+	ID            string           `json:"id"`
+	Subject       string           `json:"subject"`
+	WebLink       string           `json:"webLink"`
+	Start         MSEventStartEnd  `json:"start"`
+	End           MSEventStartEnd  `json:"end"`
+	IsAllDay      bool             `json:"isAllDay"`
+	IsCancelled   bool             `json:"isCancelled"`
+	ShowAs        string           `json:"showAs"`
+	OnlineMeeting *MSOnlineMeeting `json:"onlineMeeting"`
+}
+
+// StartEnd parses the start and end of the occurrence.
+func (e *MSCalendarEvent) StartEnd() (start, end time.Time, err error) {
+	if start, err = time.Parse(msDateTimeLayout, e.Start.DateTime); err != nil {
+		return
+	}
+	end, err = time.Parse(msDateTimeLayout, e.End.DateTime)
+	return
+}
+
+// MSDeltaEvent is a change of the calendar view, Removed is set when the
+// occurrence was deleted or left the view.
+type MSDeltaEvent struct {
+	MSCalendarEvent
+	Removed *struct {
+		Reason string `json:"reason"`
+	} `json:"@removed"`
+}
+
+// CalendarView returns up to limit occurrences of the default calendar
+// between start and end ordered by start, following the result pages
+// until limit is reached. Cancelled occurrences are left out.
+func (c *GraphClient) CalendarView(
+	ctx context.Context,
+	start, end time.Time,
+	limit int,
+) ([]MSCalendarEvent, error) {
+	v := url.Values{}
+	v.Set("startDateTime", start.UTC().Format(time.RFC3339))
+	v.Set("endDateTime", end.UTC().Format(time.RFC3339))
+	v.Set("$select", "subject,webLink,start,end,isAllDay,isCancelled,showAs,onlineMeeting")
+	v.Set("$orderby", "start/dateTime")
+	v.Set("$top", "50")
+	next := "/me/calendarView?" + v.Encode()
+	events := []MSCalendarEvent{}
+	for next != "" && len(events) < limit {
+		var page struct {
+			Value    []MSCalendarEvent `json:"value"`
+			NextLink string            `json:"@odata.nextLink"`
+		}
+		if err := c.do(ctx, &graphRequest{Method: "GET", Path: next, Prefer: msPreferUTC},
+			&page); err != nil {
+			return nil, fmt.Errorf("unable to read calendar view: %w", err)
+		}
+		for _, event := range page.Value {
+			if !event.IsCancelled && len(events) < limit {
+				events = append(events, event)
+			}
+		}
+		next = page.NextLink
+	}
+	return events, nil
+}
+
+// ErrMicrosoftDeltaExpired is returned by EventDelta when the delta link
+// is no longer valid, a full sync gets a new one.
+var ErrMicrosoftDeltaExpired = errors.New("microsoft delta link expired")
+
+// EventDelta returns the occurrences of the calendar view that changed
+// since deltaLink and the delta link to continue from. Without deltaLink
+// every occurrence between start and end is returned, the view keeps that
+// range for every following delta.
+func (c *GraphClient) EventDelta(
+	ctx context.Context,
+	deltaLink string,
+	start, end time.Time,
+) ([]MSDeltaEvent, string, error) {
+	next := deltaLink
+	if next == "" {
+		v := url.Values{}
+		v.Set("startDateTime", start.UTC().Format(time.RFC3339))
+		v.Set("endDateTime", end.UTC().Format(time.RFC3339))
+		next = "/me/calendarView/delta?" + v.Encode()
+	}
+	var events []MSDeltaEvent
+	for {
+		var page struct {
+			Value     []MSDeltaEvent `json:"value"`
+			NextLink  string         `json:"@odata.nextLink"`
+			DeltaLink string         `json:"@odata.deltaLink"`
+		}
+		// the delta query takes no $top or $select, the page size is a preference
+		err := c.do(ctx, &graphRequest{
+			Method: "GET",
+			Path:   next,
+			Prefer: msPreferUTC + ", odata.maxpagesize=100",
+		}, &page)
+		if deltaLink != "" && IsGraphStatus(err, http.StatusGone) {
+			return nil, "", ErrMicrosoftDeltaExpired
+		}
+		if err != nil {
+			return nil, "", fmt.Errorf("unable to read calendar view delta: %w", err)
+		}
+		events = append(events, page.Value...)
+		if page.NextLink == "" {
+			return events, page.DeltaLink, nil
+		}
+		next = page.NextLink
+	}
+}
+
+// msDateTimeLayout is the layout of dateTime values returned by graph.
+const msDateTimeLayout = "2006-01-02T15:04:05.9999999"
+
+// MSUserProfile is the signed in user, Mail is empty for accounts without
+// a mailbox and UserPrincipalName is what they sign in with.
+type MSUserProfile struct {
+	ID                string `json:"id"`
+	DisplayName       string `json:"displayName"`
+	Mail              string `json:"mail"`
+	UserPrincipalName string `json:"userPrincipalName"`
+}
+
+// UserProfile reads the profile of the signed in user.
+func (c *GraphClient) UserProfile(ctx context.Context) (*MSUserProfile, error) {
+	var profile MSUserProfile
+	if err := c.do(ctx, &graphRequest{Method: "GET", Path: "/me"}, &profile); err != nil {
+		return nil, fmt.Errorf("unable to read user profile: %w", err)
+	}
+	return &profile, nil
 }
 
 func GetMicrosoftOAuthConfig() *oauth2.Config {
@@ -0,0 +1,41 @@
+package hof
+
+import (
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+)
+
+func TestGraphClientAccountType(t *testing.T) {
+	issued := func(claims jwt.MapClaims) string {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("key"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	tests := []struct {
+		name        string
+		accessToken string
+		want        string
+	}{
+		{"work or school tenant", issued(jwt.MapClaims{"tid": "72f988bf-86f1-41af-91ab-2d7cd011db47"}),
+			MSAccountWork},
+		{"consumer tenant", issued(jwt.MapClaims{"tid": msConsumerTenant}), MSAccountPersonal},
+		{"token without tenant", issued(jwt.MapClaims{"sub": "user-1"}), MSAccountPersonal},
+		{"opaque token", "EwBwA8l6BAAU7p9QDpi", MSAccountPersonal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewGraphClient(oauth2.StaticTokenSource(&oauth2.Token{AccessToken: tt.accessToken}))
+			got, err := client.AccountType()
+			if err != nil {
+				t.Fatalf("AccountType() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("AccountType() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,238 @@
+package hof
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+)
+
+const (
+	GoogleOIDCIssuer    = "https://accounts.google.com"
+	MicrosoftOIDCIssuer = "https://login.microsoftonline.com/common/v2.0"
+)
+
+type OIDCClaims struct {
+	jwt.RegisteredClaims
+	Nonce         string `json:"nonce"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+	TenantID      string `json:"tid"`
+	// microsoft only sets this when the email domain is verified
+	// by the tenant, it has no email_verified claim
+	EmailDomainOwnerVerified bool `json:"xms_edov"`
+}
+
+// IsEmailVerified reports whether the provider vouches for the email.
+func (c *OIDCClaims) IsEmailVerified() bool {
+	return c.Email != "" && (c.EmailVerified || c.EmailDomainOwnerVerified)
+}
+
+// OIDCVerifier validates ID tokens issued by a single OpenID provider.
+// The signing keys are discovered from the issuer and cached,
+// an unknown kid triggers one refetch so provider key rotation works.
+type OIDCVerifier struct {
+	Issuer   string
+	ClientID string
+	Client   *http.Client
+
+	mu        sync.Mutex
+	discovery *oidcDiscovery
+	keys      map[string]*rsa.PublicKey
+	fetchedAt time.Time
+}
+
+type oidcDiscovery struct {
+	Issuer  string `json:"issuer"`
+	JWKSURI string `json:"jwks_uri"`
+}
+
+func NewOIDCVerifier(issuer, clientID string, client *http.Client) *OIDCVerifier {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &OIDCVerifier{
+		Issuer:   strings.TrimSuffix(issuer, "/"),
+		ClientID: clientID,
+		Client:   client,
+	}
+}
+
+func (v *OIDCVerifier) Verify(
+	ctx context.Context,
+	rawIDToken, nonce string,
+) (*OIDCClaims, error) {
+	discovery, err := v.getDiscovery(ctx)
+	if err != nil {
+		return nil, err
+	}
+	token, err := jwt.ParseWithClaims(rawIDToken, &OIDCClaims{},
+		func(token *jwt.Token) (interface{}, error) {
+			kid, _ := token.Header["kid"].(string)
+			return v.getKey(ctx, kid)
+		},
+		jwt.WithValidMethods([]string{"RS256"}),
+		jwt.WithAudience(v.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid id token: %v", err)
+	}
+	claims, ok := token.Claims.(*OIDCClaims)
+	if !ok || !token.Valid {
+		return nil, errors.New("invalid id token claims")
+	}
+	issuer := strings.ReplaceAll(discovery.Issuer, "{tenantid}", claims.TenantID)
+	if claims.Issuer != issuer {
+		return nil, fmt.Errorf("unexpected id token issuer %s", claims.Issuer)
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("id token has no subject")
+	}
+	if claims.Nonce != nonce {
+		return nil, errors.New("id token nonce does not match")
+	}
+	return claims, nil
+}
+
+func (v *OIDCVerifier) getDiscovery(ctx context.Context) (*oidcDiscovery, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.discovery != nil {
+		return v.discovery, nil
+	}
+	var discovery oidcDiscovery
+	if err := v.getJSON(ctx,
+		v.Issuer+"/.well-known/openid-configuration", &discovery,
+	); err != nil {
+		return nil, fmt.Errorf("unable to discover oidc issuer: %v", err)
+	}
+	if discovery.JWKSURI == "" || discovery.Issuer == "" {
+		return nil, errors.New("oidc discovery document is incomplete")
+	}
+	v.discovery = &discovery
+	return v.discovery, nil
+}
+
+func (v *OIDCVerifier) getKey(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if key, ok := v.keys[kid]; ok {
+		return key, nil
+	}
+	// avoid hammering the provider with unknown kids
+	if time.Since(v.fetchedAt) < time.Minute && v.keys != nil {
+		return nil, fmt.Errorf("unknown id token signing key %q", kid)
+	}
+	var set struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := v.getJSON(ctx, v.discovery.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("unable to fetch oidc signing keys: %v", err)
+	}
+	keys := map[string]*rsa.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	v.keys = keys
+	v.fetchedAt = time.Now()
+	if key, ok := v.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown id token signing key %q", kid)
+}
+
+func (v *OIDCVerifier) getJSON(ctx context.Context, url string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, http.NoBody)
+	if err != nil {
+		return err
+	}
+	resp, err := v.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s from %s", resp.Status, url)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// OIDCProvider pairs the oauth2 client of a provider with its ID token verifier.
+type OIDCProvider struct {
+	Name     string
+	config   *oauth2.Config
+	Verifier *OIDCVerifier
+}
+
+// OAuthConfig returns a copy of the client config for sign in,
+// asking only for the identity scopes.
+func (p *OIDCProvider) OAuthConfig(redirectURL string) *oauth2.Config {
+	cfg := *p.config
+	cfg.RedirectURL = redirectURL
+	cfg.Scopes = []string{"openid", "email", "profile"}
+	return &cfg
+}
+
+var (
+	oidcProviders   = map[string]*OIDCProvider{}
+	oidcProvidersMu sync.Mutex
+)
+
+// GetOIDCProvider returns the sign in provider for google or microsoft,
+// reusing the client credentials from google.json and microsoft.json.
+func GetOIDCProvider(name string) (*OIDCProvider, error) {
+	oidcProvidersMu.Lock()
+	defer oidcProvidersMu.Unlock()
+	if provider, ok := oidcProviders[name]; ok {
+		return provider, nil
+	}
+	var cfg *oauth2.Config
+	var issuer string
+	switch name {
+	case "google":
+		cfg, issuer = GetGoogleOAuthConfig(), GoogleOIDCIssuer
+	case "microsoft":
+		cfg, issuer = GetMicrosoftOAuthConfig(), MicrosoftOIDCIssuer
+	default:
+		return nil, fmt.Errorf("unknown oidc provider %s", name)
+	}
+	provider := &OIDCProvider{
+		Name:     name,
+		config:   cfg,
+		Verifier: NewOIDCVerifier(issuer, cfg.ClientID, nil),
+	}
+	oidcProviders[name] = provider
+	return provider, nil
+}
@@ -0,0 +1,201 @@
+package hof
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// fakeIssuer serves the discovery document and signing keys of an OpenID
+// provider, issuer may hold {tenantid} like the microsoft common endpoint.
+type fakeIssuer struct {
+	*httptest.Server
+	issuer      string
+	keys        map[string]*rsa.PrivateKey
+	jwksFetches atomic.Int32
+}
+
+func newFakeIssuer(t *testing.T, issuer string) *fakeIssuer {
+	t.Helper()
+	f := &fakeIssuer{keys: map[string]*rsa.PrivateKey{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":   strings.ReplaceAll(f.issuer, "{server}", f.URL),
+			"jwks_uri": f.URL + "/keys",
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		f.jwksFetches.Add(1)
+		var keys []map[string]string
+		for kid, key := range f.keys {
+			keys = append(keys, map[string]string{
+				"kid": kid,
+				"kty": "RSA",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			})
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+	})
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+	f.issuer = issuer
+	f.addKey(t, "k1")
+	return f
+}
+
+func (f *fakeIssuer) addKey(t *testing.T, kid string) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.keys[kid] = key
+}
+
+func (f *fakeIssuer) sign(t *testing.T, kid string, claims *OIDCClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	raw, err := token.SignedString(f.keys[kid])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func validClaims(issuer string) *OIDCClaims {
+	now := time.Now()
+	return &OIDCClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    issuer,
+			Subject:   "subject-1",
+			Audience:  jwt.ClaimStrings{"client-1"},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+		},
+		Nonce:         "nonce-1",
+		Email:         "ann@example.com",
+		EmailVerified: true,
+	}
+}
+
+func TestOIDCVerifierVerify(t *testing.T) {
+	f := newFakeIssuer(t, "{server}")
+	tests := []struct {
+		name    string
+		kid     string
+		nonce   string
+		mutate  func(c *OIDCClaims)
+		wantErr string
+	}{
+		{name: "valid", kid: "k1", nonce: "nonce-1"},
+		{name: "wrong audience", kid: "k1", nonce: "nonce-1",
+			mutate: func(c *OIDCClaims) { c.Audience = jwt.ClaimStrings{"other"} }, wantErr: "audience"},
+		{name: "expired", kid: "k1", nonce: "nonce-1",
+			mutate:  func(c *OIDCClaims) { c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Hour)) },
+			wantErr: "expired"},
+		{name: "wrong issuer", kid: "k1", nonce: "nonce-1",
+			mutate: func(c *OIDCClaims) { c.Issuer = "https://evil.example.com" }, wantErr: "issuer"},
+		{name: "no subject", kid: "k1", nonce: "nonce-1",
+			mutate: func(c *OIDCClaims) { c.Subject = "" }, wantErr: "subject"},
+		{name: "wrong nonce", kid: "k1", nonce: "nonce-2", wantErr: "nonce"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := NewOIDCVerifier(f.URL, "client-1", f.Client())
+			claims := validClaims(f.URL)
+			if tt.mutate != nil {
+				tt.mutate(claims)
+			}
+			got, err := v.Verify(context.Background(), f.sign(t, tt.kid, claims), tt.nonce)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Verify() error = %v, want it to mention %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Verify() error = %v", err)
+			}
+			if got.Subject != "subject-1" || !got.IsEmailVerified() {
+				t.Fatalf("Verify() claims = %+v", got)
+			}
+		})
+	}
+}
+
+func TestOIDCVerifierRejectsForeignKey(t *testing.T) {
+	f := newFakeIssuer(t, "{server}")
+	other := newFakeIssuer(t, "{server}")
+	v := NewOIDCVerifier(f.URL, "client-1", f.Client())
+	_, err := v.Verify(context.Background(), other.sign(t, "k1", validClaims(f.URL)), "nonce-1")
+	if err == nil {
+		t.Fatal("Verify() accepted a token signed by another issuer")
+	}
+}
+
+func TestOIDCVerifierKeyRotation(t *testing.T) {
+	f := newFakeIssuer(t, "{server}")
+	v := NewOIDCVerifier(f.URL, "client-1", f.Client())
+	ctx := context.Background()
+	if _, err := v.Verify(ctx, f.sign(t, "k1", validClaims(f.URL)), "nonce-1"); err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	f.addKey(t, "k2")
+	rotated := f.sign(t, "k2", validClaims(f.URL))
+	// unknown kids right after a fetch are not fetched again
+	if _, err := v.Verify(ctx, rotated, "nonce-1"); err == nil {
+		t.Fatal("Verify() refetched keys within a minute of the last fetch")
+	}
+	v.fetchedAt = time.Now().Add(-2 * time.Minute)
+	if _, err := v.Verify(ctx, rotated, "nonce-1"); err != nil {
+		t.Fatalf("Verify() with rotated key error = %v", err)
+	}
+	if n := f.jwksFetches.Load(); n != 2 {
+		t.Fatalf("keys fetched %d times, want 2", n)
+	}
+}
+
+func TestOIDCVerifierTenantIssuer(t *testing.T) {
+	f := newFakeIssuer(t, "{server}/{tenantid}/v2.0")
+	v := NewOIDCVerifier(f.URL, "client-1", f.Client())
+	claims := validClaims(f.URL + "/tenant-1/v2.0")
+	claims.TenantID = "tenant-1"
+	if _, err := v.Verify(context.Background(), f.sign(t, "k1", claims), "nonce-1"); err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	claims.TenantID = "tenant-2"
+	if _, err := v.Verify(context.Background(), f.sign(t, "k1", claims), "nonce-1"); err == nil {
+		t.Fatal("Verify() accepted an issuer of another tenant")
+	}
+}
+
+func TestOIDCClaimsIsEmailVerified(t *testing.T) {
+	tests := []struct {
+		claims OIDCClaims
+		want   bool
+	}{
+		{OIDCClaims{Email: "a@example.com", EmailVerified: true}, true},
+		{OIDCClaims{Email: "a@example.com", EmailDomainOwnerVerified: true}, true},
+		{OIDCClaims{Email: "a@example.com"}, false},
+		{OIDCClaims{EmailVerified: true}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.claims.IsEmailVerified(); got != tt.want {
+			t.Errorf("IsEmailVerified(%+v) = %v, want %v", tt.claims, got, tt.want)
+		}
+	}
+}
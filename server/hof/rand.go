@@ -2,6 +2,8 @@ package hof
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"math/big"
 )
 
@@ -18,3 +20,20 @@ func generateRandomString(length int) (string, error) {
 	}
 	return string(randomString), nil
 }
+
+// GenerateRandomToken returns a hex encoded random value of size bytes,
+// suitable for opaque tokens such as refresh tokens.
+func GenerateRandomToken(size int) (string, error) {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// HashToken returns the sha256 hex digest of an opaque token,
+// so only the digest has to be stored.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,254 @@
+package hof
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RateLimit allows Burst requests at once, refilled evenly over Per.
+type RateLimit struct {
+	Name  string
+	Burst int
+	Per   time.Duration
+}
+
+func (r RateLimit) refillPerSecond() float64 {
+	return float64(r.Burst) / r.Per.Seconds()
+}
+
+type IRateLimitStore interface {
+	// Take consumes one token of the bucket behind key and reports how
+	// long to wait before retrying when the bucket is empty.
+	Take(ctx context.Context, key string, limit RateLimit) (bool, time.Duration, error)
+}
+
+// RetryAfterError is returned when the caller has to back off.
+type RetryAfterError struct {
+	Message    string
+	RetryAfter time.Duration
+}
+
+func (e *RetryAfterError) Error() string {
+	return fmt.Sprintf("%s, try again in %s", e.Message, e.RetryAfter.Round(time.Second))
+}
+
+// SetRetryAfter writes the Retry-After header in whole seconds.
+func SetRetryAfter(ctx *gin.Context, wait time.Duration) {
+	ctx.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+}
+
+// RateLimitByIP keys the bucket by the client address.
+func RateLimitByIP(ctx *gin.Context) string {
+	return "ip:" + ctx.ClientIP()
+}
+
+// RateLimitByBodyField keys the bucket by a field of the json or form body,
+// the body is restored so handlers can still bind it.
+func RateLimitByBodyField(field string) func(ctx *gin.Context) string {
+	return func(ctx *gin.Context) string {
+		var value string
+		if strings.HasPrefix(ctx.ContentType(), "application/json") && ctx.Request.Body != nil {
+			body, err := io.ReadAll(io.LimitReader(ctx.Request.Body, 1<<20))
+			if err != nil {
+				return ""
+			}
+			ctx.Request.Body = io.NopCloser(bytes.NewReader(body))
+			var fields map[string]interface{}
+			if err := json.Unmarshal(body, &fields); err == nil {
+				value, _ = fields[field].(string)
+			}
+		} else {
+			value = ctx.PostForm(field)
+		}
+		if value == "" {
+			return ""
+		}
+		return field + ":" + strings.ToLower(value)
+	}
+}
+
+// RateLimiter rejects requests with 429 once the bucket picked by key is empty.
+// An empty key skips the limit.
+func RateLimiter(
+	store IRateLimitStore,
+	limit RateLimit,
+	key func(ctx *gin.Context) string,
+) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		k := key(ctx)
+		if k == "" {
+			ctx.Next()
+			return
+		}
+		allowed, wait, err := store.Take(ctx, limit.Name+":"+k, limit)
+		if err != nil {
+			// do not lock everyone out when the store is unavailable
+			log.Printf("rate limit store: %s", err)
+			ctx.Next()
+			return
+		}
+		if !allowed {
+			SetRetryAfter(ctx, wait)
+			ctx.AbortWithStatusJSON(http.StatusTooManyRequests,
+				gin.H{"error": "TOO_MANY_REQUESTS"})
+			return
+		}
+		ctx.Next()
+	}
+}
+
+type memoryBucket struct {
+	tokens    float64
+	updatedAt time.Time
+}
+
+// MemoryRateLimitStore keeps buckets in process memory,
+// limits are per instance and reset on restart.
+type MemoryRateLimitStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*memoryBucket
+	idleAfter time.Duration
+	sweptAt   time.Time
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{
+		buckets:   map[string]*memoryBucket{},
+		idleAfter: time.Hour,
+		sweptAt:   time.Now(),
+	}
+}
+
+func (s *MemoryRateLimitStore) Take(
+	_ context.Context,
+	key string,
+	limit RateLimit,
+) (bool, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if now.Sub(s.sweptAt) > time.Minute {
+		for k, b := range s.buckets {
+			if now.Sub(b.updatedAt) > s.idleAfter {
+				delete(s.buckets, k)
+			}
+		}
+		s.sweptAt = now
+	}
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &memoryBucket{tokens: float64(limit.Burst), updatedAt: now}
+		s.buckets[key] = b
+	}
+	rate := limit.refillPerSecond()
+	b.tokens = math.Min(float64(limit.Burst), b.tokens+now.Sub(b.updatedAt).Seconds()*rate)
+	b.updatedAt = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / rate * float64(time.Second)), nil
+	}
+	b.tokens--
+	return true, 0, nil
+}
+
+// SQLRateLimitStore keeps buckets in the rate_limits table,
+// so limits are shared by every instance using the database.
+type SQLRateLimitStore struct {
+	db *sql.DB
+}
+
+func NewSQLRateLimitStore(db *sql.DB) *SQLRateLimitStore {
+	return &SQLRateLimitStore{db: db}
+}
+
+//goland:noinspection ALL
+func (s *SQLRateLimitStore) Take(
+	ctx context.Context,
+	key string,
+	limit RateLimit,
+) (bool, time.Duration, error) {
+	now := float64(time.Now().UnixNano()) / float64(time.Second)
+	rate := limit.refillPerSecond()
+	burst := float64(limit.Burst)
+	// refill and consume in one statement so concurrent requests cannot
+	// both spend the last token, no row is returned when the bucket is empty
+	q := `INSERT INTO rate_limits (key, tokens, updated_at) VALUES ($1, $2 - 1, $3)
+	ON CONFLICT (key) DO UPDATE SET
+		tokens = MIN($2, tokens + ($3 - updated_at) * $4) - 1,
+		updated_at = $3
+	WHERE MIN($2, tokens + ($3 - updated_at) * $4) >= 1
+	RETURNING tokens`
+	var tokens float64
+	err := s.db.QueryRowContext(ctx, q, key, burst, now, rate).Scan(&tokens)
+	if err == nil {
+		return true, 0, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return false, 0, err
+	}
+	var updatedAt float64
+	q = "SELECT tokens, updated_at FROM rate_limits WHERE key = ?"
+	if err := s.db.QueryRowContext(ctx, q, key).Scan(&tokens, &updatedAt); err != nil {
+		return false, 0, err
+	}
+	tokens = math.Min(burst, tokens+(now-updatedAt)*rate)
+	return false, time.Duration((1 - tokens) / rate * float64(time.Second)), nil
+}
+
+type rateLimitConfig struct {
+	Store          string   `json:"store"`
+	TrustedProxies []string `json:"trusted_proxies"`
+}
+
+// readRateLimitConfig reads ratelimit.json, an empty config is returned
+// when the file does not exist.
+func readRateLimitConfig() rateLimitConfig {
+	var cfg rateLimitConfig
+	b, err := os.ReadFile("ratelimit.json")
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg
+	}
+	if err != nil {
+		log.Fatalf("Unable to read rate limit config file: %v", err)
+	}
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		log.Fatalf("Unable to parse rate limit config file: %v", err)
+	}
+	return cfg
+}
+
+// GetRateLimitStore builds the store configured in ratelimit.json,
+// falling back to the in-memory store when the file does not exist.
+func GetRateLimitStore(db *sql.DB) IRateLimitStore {
+	cfg := readRateLimitConfig()
+	switch cfg.Store {
+	case "sqlite":
+		return NewSQLRateLimitStore(db)
+	case "memory", "":
+		return NewMemoryRateLimitStore()
+	default:
+		log.Fatalf("Unknown rate limit store: %s", cfg.Store)
+		return nil
+	}
+}
+
+// GetTrustedProxies returns the proxies of ratelimit.json whose
+// X-Forwarded-For is believed for the client address, none by default
+// so clients can not pick their own address to escape the limits.
+func GetTrustedProxies() []string {
+	return readRateLimitConfig().TrustedProxies
+}
@@ -0,0 +1,148 @@
+package hof
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	_ "github.com/glebarez/go-sqlite"
+)
+
+// newTestDB opens an empty sqlite database with schema, waiting on
+// locks held by other connections instead of failing.
+func newTestDB(t *testing.T, schema string) *sql.DB {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "db.sqlite3")
+	db, err := sql.Open("sqlite", path+"?_pragma=busy_timeout(5000)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	if _, err := db.Exec(schema); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+const testRateLimitsSchema = `CREATE TABLE rate_limits
+(
+    key        VARCHAR(255) NOT NULL PRIMARY KEY,
+    tokens     REAL NOT NULL,
+    updated_at REAL NOT NULL
+)`
+
+func TestRateLimitStoreRefills(t *testing.T) {
+	stores := map[string]IRateLimitStore{
+		"memory": NewMemoryRateLimitStore(),
+		"sqlite": NewSQLRateLimitStore(newTestDB(t, testRateLimitsSchema)),
+	}
+	limit := RateLimit{Name: "test", Burst: 2, Per: 400 * time.Millisecond}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			for i := 0; i < limit.Burst; i++ {
+				if ok, _, err := store.Take(ctx, "key", limit); err != nil || !ok {
+					t.Fatalf("Take() %d of the burst = %v, %v", i+1, ok, err)
+				}
+			}
+			ok, wait, err := store.Take(ctx, "key", limit)
+			if err != nil || ok {
+				t.Fatalf("Take() past the burst = %v, %v, want it refused", ok, err)
+			}
+			// one token comes back every Per / Burst
+			if wait <= 0 || wait > 200*time.Millisecond {
+				t.Errorf("wait = %s, want up to 200ms", wait)
+			}
+			if ok, _, _ := store.Take(ctx, "other", limit); !ok {
+				t.Error("Take() of another key is refused")
+			}
+			time.Sleep(wait + 20*time.Millisecond)
+			if ok, _, err := store.Take(ctx, "key", limit); err != nil || !ok {
+				t.Fatalf("Take() after the refill = %v, %v", ok, err)
+			}
+			if ok, _, _ := store.Take(ctx, "key", limit); ok {
+				t.Error("Take() took more than the token refilled")
+			}
+		})
+	}
+}
+
+func TestSQLRateLimitStoreConcurrentTakes(t *testing.T) {
+	store := NewSQLRateLimitStore(newTestDB(t, testRateLimitsSchema))
+	limit := RateLimit{Name: "test", Burst: 5, Per: time.Hour}
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	allowed := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ok, _, err := store.Take(context.Background(), "key", limit)
+			if err != nil {
+				t.Errorf("Take() error = %v", err)
+				return
+			}
+			if ok {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if allowed != limit.Burst {
+		t.Errorf("%d concurrent requests allowed, want %d", allowed, limit.Burst)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	if err := engine.SetTrustedProxies(nil); err != nil {
+		t.Fatal(err)
+	}
+	limit := RateLimit{Name: "login", Burst: 1, Per: time.Minute}
+	engine.POST("/login", RateLimiter(NewMemoryRateLimitStore(), limit, RateLimitByIP),
+		func(ctx *gin.Context) { ctx.Status(http.StatusOK) })
+	tests := []struct {
+		name           string
+		remoteAddr     string
+		forwardedFor   string
+		wantStatus     int
+		wantRetryAfter string
+	}{
+		{name: "first request", remoteAddr: "203.0.113.7:1000", wantStatus: http.StatusOK},
+		{name: "past the burst", remoteAddr: "203.0.113.7:1001",
+			wantStatus: http.StatusTooManyRequests, wantRetryAfter: "60"},
+		{name: "X-Forwarded-For of an untrusted client", remoteAddr: "203.0.113.7:1002",
+			forwardedFor: "198.51.100.1", wantStatus: http.StatusTooManyRequests, wantRetryAfter: "60"},
+		{name: "another client", remoteAddr: "203.0.113.8:1000", wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/login", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.forwardedFor != "" {
+				req.Header.Set("X-Forwarded-For", tt.forwardedFor)
+			}
+			rec := httptest.NewRecorder()
+			engine.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Header().Get("Retry-After"); got != tt.wantRetryAfter {
+				t.Errorf("Retry-After = %q, want %q", got, tt.wantRetryAfter)
+			}
+			if tt.wantStatus == http.StatusTooManyRequests &&
+				rec.Body.String() != `{"error":"TOO_MANY_REQUESTS"}` {
+				t.Errorf("body = %s", rec.Body)
+			}
+		})
+	}
+}
@@ -18,3 +18,15 @@ func TimeToInt(t time.Time) int {
 	timeInt := hour*100 + minute
 	return timeInt
 }
+
+// BusyTime is a period a connected calendar reports as not free.
+type BusyTime struct {
+	Start time.Time
+	End   time.Time
+}
+
+// WeeklyRule returns the iCalendar recurrence rule of count meetings
+// repeating every interval weeks, e.g. FREQ=WEEKLY;INTERVAL=2;COUNT=4.
+func WeeklyRule(interval, count int) string {
+	return fmt.Sprintf("FREQ=WEEKLY;INTERVAL=%d;COUNT=%d", interval, count)
+}
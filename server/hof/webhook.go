@@ -0,0 +1,134 @@
+package hof
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// ErrWebhookAddress is returned for webhook urls pointing at the server
+// itself or its private network, e.g. the cloud metadata address.
+var ErrWebhookAddress = errors.New("webhook url must not point to a private, loopback or link-local address")
+
+// sharedAddressSpace is 100.64.0.0/10, carrier-grade NAT and on some
+// clouds the metadata service.
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// webhookAddressAllowed reports whether webhooks may be sent to ip, tests
+// swap it to reach their local receiver.
+var webhookAddressAllowed = func(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() &&
+		!ip.IsLinkLocalMulticast() && !ip.IsInterfaceLocalMulticast() &&
+		!ip.IsMulticast() && !ip.IsUnspecified() && !sharedAddressSpace.Contains(ip)
+}
+
+// CheckWebhookURL validates a webhook url, it has to be http or https and
+// every address its host resolves to has to be public.
+func CheckWebhookURL(ctx context.Context, rawURL string) error {
+	target, err := url.Parse(rawURL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return errors.New("url must be an http or https url")
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, target.Hostname())
+	if err != nil {
+		return fmt.Errorf("unable to resolve webhook host: %v", err)
+	}
+	for _, addr := range addrs {
+		if !webhookAddressAllowed(addr.IP) {
+			return ErrWebhookAddress
+		}
+	}
+	return nil
+}
+
+// webhookClient only connects to public addresses, the address is checked
+// when dialing so a host resolving differently later is caught as well.
+var webhookClient = &http.Client{
+	Transport: &http.Transport{
+		// a proxy would be the address checked instead of the receiver
+		Proxy: nil,
+		DialContext: (&net.Dialer{
+			Timeout: time.Second * 5,
+			Control: func(_, address string, _ syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				if ip := net.ParseIP(host); ip == nil || !webhookAddressAllowed(ip) {
+					return ErrWebhookAddress
+				}
+				return nil
+			},
+		}).DialContext,
+		TLSHandshakeTimeout:   time.Second * 5,
+		ResponseHeaderTimeout: time.Second * 10,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       time.Minute,
+	},
+	// a redirect would resend the body somewhere not configured
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// WebhookResponse is what a receiver answered to a delivery, the body
+// is cut to the first kilobyte.
+type WebhookResponse struct {
+	Status int
+	Body   string
+}
+
+// SignWebhook returns the signature of a webhook body sent at timestamp,
+// receivers compute the HMAC-SHA256 of "<timestamp>.<body>" with their
+// secret and compare it with the X-Goca-Signature header.
+func SignWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = fmt.Fprintf(mac, "%d.", timestamp)
+	_, _ = mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// SendWebhook posts the json body of event to url signed with secret,
+// a response other than 2xx is an error returned along with the response.
+func SendWebhook(
+	ctx context.Context,
+	url, secret, event string,
+	deliveryID int,
+	body []byte,
+) (*WebhookResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("error creating http request: %s", err.Error())
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Goca-Webhooks/1.0")
+	req.Header.Set("X-Goca-Event", event)
+	req.Header.Set("X-Goca-Delivery", strconv.Itoa(deliveryID))
+	req.Header.Set("X-Goca-Timestamp", strconv.FormatInt(timestamp, 10))
+	req.Header.Set("X-Goca-Signature", SignWebhook(secret, timestamp, body))
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error making http request: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	response := &WebhookResponse{Status: resp.StatusCode, Body: string(respBody)}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return response, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return response, nil
+}
@@ -0,0 +1,124 @@
+package hof
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// allowLocalWebhooks lets the tests deliver to their httptest receiver.
+func allowLocalWebhooks(t *testing.T) {
+	t.Helper()
+	allowed := webhookAddressAllowed
+	webhookAddressAllowed = func(net.IP) bool { return true }
+	t.Cleanup(func() { webhookAddressAllowed = allowed })
+}
+
+func TestSendWebhookSignature(t *testing.T) {
+	allowLocalWebhooks(t)
+	body := []byte(`{"event":"booking.created"}`)
+	var got *http.Request
+	var gotBody []byte
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		gotBody, _ = io.ReadAll(r.Body)
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer receiver.Close()
+	resp, err := SendWebhook(context.Background(), receiver.URL, "s3cret", "booking.created", 42, body)
+	if err != nil {
+		t.Fatalf("SendWebhook() error = %v", err)
+	}
+	if resp.Status != http.StatusOK || resp.Body != "ok" {
+		t.Errorf("response = %+v", resp)
+	}
+	if got.Header.Get("X-Goca-Event") != "booking.created" || got.Header.Get("X-Goca-Delivery") != "42" {
+		t.Errorf("event headers = %v", got.Header)
+	}
+	timestamp, err := strconv.ParseInt(got.Header.Get("X-Goca-Timestamp"), 10, 64)
+	if err != nil {
+		t.Fatalf("X-Goca-Timestamp = %q", got.Header.Get("X-Goca-Timestamp"))
+	}
+	// what a receiver computes from the request alone
+	if want := SignWebhook("s3cret", timestamp, gotBody); got.Header.Get("X-Goca-Signature") != want {
+		t.Errorf("X-Goca-Signature = %q, want %q", got.Header.Get("X-Goca-Signature"), want)
+	}
+	if SignWebhook("other", timestamp, gotBody) == got.Header.Get("X-Goca-Signature") {
+		t.Error("signature does not depend on the secret")
+	}
+}
+
+func TestSendWebhookErrorStatus(t *testing.T) {
+	allowLocalWebhooks(t)
+	tests := []struct {
+		name   string
+		status int
+	}{
+		{"server error", http.StatusInternalServerError},
+		{"client error", http.StatusGone},
+		{"redirect is not followed", http.StatusFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Location", "http://169.254.169.254/")
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(strings.Repeat("x", 4096)))
+			}))
+			defer receiver.Close()
+			resp, err := SendWebhook(context.Background(), receiver.URL, "s3cret", "booking.created", 1, []byte("{}"))
+			if err == nil {
+				t.Fatal("SendWebhook() error = nil")
+			}
+			if resp == nil || resp.Status != tt.status {
+				t.Fatalf("response = %+v, want status %d", resp, tt.status)
+			}
+			if len(resp.Body) != 1024 {
+				t.Errorf("response body of %d bytes, want it cut to 1024", len(resp.Body))
+			}
+		})
+	}
+}
+
+func TestSendWebhookRefusesPrivateAddresses(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("webhook reached a loopback receiver")
+	}))
+	defer receiver.Close()
+	_, err := SendWebhook(context.Background(), receiver.URL, "s3cret", "booking.created", 1, []byte("{}"))
+	if !errors.Is(err, ErrWebhookAddress) {
+		t.Fatalf("SendWebhook() error = %v, want ErrWebhookAddress", err)
+	}
+}
+
+func TestCheckWebhookURL(t *testing.T) {
+	tests := []struct {
+		url     string
+		wantErr bool
+	}{
+		{"https://93.184.216.34/hooks", false},
+		{"ftp://93.184.216.34/hooks", true},
+		{"http://127.0.0.1:8000/api", true},
+		{"http://localhost/api", true},
+		{"http://169.254.169.254/latest/meta-data/", true},
+		{"http://10.0.0.8/", true},
+		{"http://192.168.1.1/", true},
+		{"http://100.100.100.200/", true},
+		{"http://[::1]/", true},
+		{"http://[fd00::1]/", true},
+		{"http://[::ffff:127.0.0.1]/", true},
+		{"http://0.0.0.0/", true},
+	}
+	for _, tt := range tests {
+		err := CheckWebhookURL(context.Background(), tt.url)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("CheckWebhookURL(%q) error = %v, want error %v", tt.url, err, tt.wantErr)
+		}
+	}
+}
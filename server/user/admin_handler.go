@@ -0,0 +1,123 @@
+package user
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/0xForked/goca/server/hof"
+	"github.com/gin-gonic/gin"
+)
+
+type adminHandler struct {
+	service IUserService
+}
+
+func (h adminHandler) users(ctx *gin.Context) {
+	data, err := h.service.Users(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"data": data})
+}
+
+func (h adminHandler) newUser(ctx *gin.Context) {
+	var body NewUserForm
+	if err := ctx.ShouldBind(&body); err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err.Error()})
+		return
+	}
+	if err := body.Validate(); err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err})
+		return
+	}
+	data, err := h.service.NewUser(ctx, &body)
+	if err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusCreated,
+		gin.H{"data": data})
+}
+
+func (h adminHandler) updateUser(ctx *gin.Context) {
+	var adminID int
+	if id, ok := ctx.MustGet("uid").(float64); ok {
+		adminID = int(id)
+	}
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err.Error()})
+		return
+	}
+	var body UpdateUserForm
+	if err := ctx.ShouldBind(&body); err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err.Error()})
+		return
+	}
+	if err := body.Validate(); err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err})
+		return
+	}
+	data, err := h.service.UpdateUser(ctx, adminID, id, &body)
+	if err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"data": data})
+}
+
+func (h adminHandler) impersonate(ctx *gin.Context) {
+	var adminID int
+	if id, ok := ctx.MustGet("uid").(float64); ok {
+		adminID = int(id)
+	}
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err.Error()})
+		return
+	}
+	data, err := h.service.Impersonate(ctx, adminID, id,
+		ctx.Request.UserAgent(), ctx.ClientIP())
+	if err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err.Error()})
+		return
+	}
+	// no cookies, they would replace the admin's own session
+	ctx.JSON(http.StatusOK, gin.H{"data": data})
+}
+
+func (h adminHandler) bookings(ctx *gin.Context) {
+	data, err := h.service.AllBookings(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"data": data})
+}
+
+func newAdminHandler(
+	service IUserService,
+	router *gin.RouterGroup,
+) {
+	h := &adminHandler{service: service}
+	admin := router.Group("/admin",
+		hof.Auth(service), hof.SessionOnly, hof.NotImpersonating,
+		hof.RequireRole(RoleAdmin))
+	admin.GET("/users", h.users)
+	admin.POST("/users", h.newUser)
+	admin.PATCH("/users/:id", h.updateUser)
+	admin.POST("/users/:id/impersonate", h.impersonate)
+	admin.GET("/bookings", h.bookings)
+}
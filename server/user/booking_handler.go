@@ -5,10 +5,10 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/0xForked/goca/server/hof"
 	"github.com/gin-gonic/gin"
-	"golang.org/x/oauth2"
 )
 
 type bookingHandler struct {
@@ -23,6 +23,11 @@ func (h bookingHandler) host(ctx *gin.Context) {
 			gin.H{"error": err.Error()})
 		return
 	}
+	if user.DisabledAt > 0 {
+		ctx.JSON(http.StatusNotFound,
+			gin.H{"error": "host is not available"})
+		return
+	}
 	eventTypes, err := h.service.EventType(ctx, user.ID, user.Username)
 	if err != nil {
 		ctx.JSON(http.StatusUnprocessableEntity,
@@ -33,6 +38,38 @@ func (h bookingHandler) host(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, user)
 }
 
+func (h bookingHandler) team(ctx *gin.Context) {
+	team, err := h.service.PublicTeam(ctx, ctx.Param("slug"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound,
+			gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, team)
+}
+
+func (h bookingHandler) slots(ctx *gin.Context) {
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err.Error()})
+		return
+	}
+	date, err := strconv.ParseInt(ctx.Query("date"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": "date must be a unix timestamp"})
+		return
+	}
+	slots, err := h.service.Slots(ctx, id, date)
+	if err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"data": slots})
+}
+
 func (h bookingHandler) schedule(ctx *gin.Context) {
 	bid := ctx.Param("id")
 	num, err := strconv.Atoi(bid)
@@ -62,100 +99,92 @@ func (h bookingHandler) add(ctx *gin.Context) {
 			gin.H{"error": err})
 		return
 	}
-	// get user data
-	user, err := h.service.Profile(ctx, body.Username, false)
+	// resolve the host, for team event types one of the free hosts
+	target, err := h.service.BookingTarget(ctx, &body)
 	if err != nil {
 		ctx.JSON(http.StatusUnprocessableEntity,
 			gin.H{"error": err.Error()})
 		return
 	}
-	eventTypes, err := h.service.EventType(ctx, user.ID, user.Username)
-	if err != nil {
+	if err := body.ValidateAnswers(target.EventType.Questions); err != nil {
 		ctx.JSON(http.StatusUnprocessableEntity,
-			gin.H{"error": err.Error()})
+			gin.H{"error": err})
 		return
 	}
-	// booking
-	var summary string
-	var event interface{}
-	var timezone string
-	var title string
-	var duration int
-	for _, et := range eventTypes {
-		if et.ID == body.EventTypeID {
-			title = et.Title
-			timezone = et.Availability.Timezone
-			duration = et.Duration
-			break
-		}
+	if target.SeatOf != nil {
+		h.joinSeat(ctx, target, &body)
+		return
 	}
-	if body.MeetingLocation == "google" && user.GoogleToken.Valid {
-		cfg := hof.GetGoogleOAuthConfig()
-		tok := &oauth2.Token{}
-		if err = json.Unmarshal([]byte(user.GoogleToken.String), tok); err != nil {
-			ctx.JSON(http.StatusUnprocessableEntity,
-				gin.H{"error": err.Error()})
-			return
-		}
-		calendarService := hof.GetGoogleCalendarService(ctx, tok, cfg)
-		_, email, err := hof.GetGoogleUserData(ctx, tok, cfg)
-		if err != nil {
-			ctx.JSON(http.StatusUnprocessableEntity,
-				gin.H{"error": err.Error()})
-			return
-		}
-		summary = fmt.Sprintf("%s between %s and %s", title, user.Username, body.Name)
-		description := fmt.Sprintf("maybe notes? %s", body.Notes)
-		event, err = hof.SetGoogleNewMeeting(calendarService, summary, description, timezone,
-			email, body.Email, body.Date, body.Time, duration)
+	// the calendar event is created once the host accepts
+	if target.EventType.RequiresConfirmation == 1 {
+		summary := fmt.Sprintf("%s between %s and %s",
+			target.EventType.Title, target.Host.Username, body.Name)
+		id, err := h.service.NewBooking(ctx, target, summary, &body, nil)
 		if err != nil {
 			ctx.JSON(http.StatusBadRequest, err.Error())
 			return
 		}
+		ctx.JSON(http.StatusCreated, gin.H{"id": id, "status": BookingPending})
+		return
 	}
-	if body.MeetingLocation == "microsoft" && user.MicrosoftToken.Valid {
-		tok := &oauth2.Token{}
-		if err = json.Unmarshal([]byte(user.MicrosoftToken.String), tok); err != nil {
-			ctx.JSON(http.StatusUnprocessableEntity,
-				gin.H{"error": err.Error()})
-			return
-		}
-		summary = fmt.Sprintf("%s between %s and %s", title, user.Username, body.Name)
-		eventData := hof.ComposeMSMeetingData(timezone, summary, body.Date, body.Time,
-			duration, body.Name, body.Email)
-		// limitation: Only Work for Business Account (personal account not supported)
-		//meeting, err := hof.SetMicrosoftNewMeeting(eventData.Start.DateTime, eventData.End.DateTime,
-		//	eventData.Subject, tok.AccessToken)
-		//if err != nil {
-		//	ctx.JSON(http.StatusBadRequest, err.Error())
-		//	return
-		//}
-		//meetingURL := meeting["joinWebUrl"].(string)
-		//eventData.Body = hof.MSBody{
-		//	ContentType: "HTML",
-		//	Content:     fmt.Sprintf("Does next month work for you? <br><a href=\"%s\">Join the meeting</a>", meetingURL),
-		//}
-		event, err = hof.SetMicrosoftNewCalendarEvent(eventData, tok.AccessToken)
-		if err != nil {
-			ctx.JSON(http.StatusBadRequest, err.Error())
-			return
-		}
+	// the calendar event is created in the background once the booking
+	// is stored, its progress is the sync_status of the booking
+	id, err := h.service.NewBooking(ctx, target, "", &body, nil)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, err.Error())
+		return
+	}
+	if hasCalendarEvent(target.Host, body.MeetingLocation) {
+		ctx.JSON(http.StatusCreated, gin.H{"id": id, "sync_status": SyncPending})
 		return
 	}
-	// insert booking data
-	id, err := h.service.NewBooking(ctx, user.ID, summary, &body, event)
+	ctx.JSON(http.StatusCreated, gin.H{"id": id})
+}
+
+// joinSeat books a seat of a slot that already has a booking, a job adds
+// the invitee to the calendar event of the first invitee once it exists.
+func (h bookingHandler) joinSeat(
+	ctx *gin.Context,
+	target *BookingTarget,
+	body *BookingForm,
+) {
+	seat := target.SeatOf
+	body.MeetingLocation = seat.Location
+	var event interface{}
+	if !hasCalendarEvent(target.Host, seat.Location) && len(seat.Event) > 0 {
+		event = json.RawMessage(seat.Event)
+	}
+	id, err := h.service.NewBooking(ctx, target, seat.Title, body, event)
 	if err != nil {
 		ctx.JSON(http.StatusBadRequest, err.Error())
+		return
+	}
+	if event == nil && hasCalendarEvent(target.Host, seat.Location) {
+		ctx.JSON(http.StatusCreated, gin.H{"id": id, "sync_status": SyncPending})
+		return
 	}
 	ctx.JSON(http.StatusCreated, gin.H{"id": id})
 }
 
+var (
+	bookingIPLimit = hof.RateLimit{
+		Name: "booking-ip", Burst: 10, Per: time.Minute}
+	// every slot lookup reads the calendars of the hosts
+	slotsIPLimit = hof.RateLimit{
+		Name: "slots-ip", Burst: 60, Per: time.Minute}
+)
+
 func newBookingHandler(
 	service IUserService,
 	router *gin.RouterGroup,
+	limiter hof.IRateLimitStore,
 ) {
 	h := &bookingHandler{service: service}
 	router.GET("/booking/:username", h.host)
-	router.POST("/booking", h.add)
+	router.POST("/booking",
+		hof.RateLimiter(limiter, bookingIPLimit, hof.RateLimitByIP), h.add)
 	router.GET("/schedule/:id", h.schedule)
+	router.GET("/team/:slug", h.team)
+	router.GET("/event-types/:id/slots",
+		hof.RateLimiter(limiter, slotsIPLimit, hof.RateLimitByIP), h.slots)
 }
@@ -0,0 +1,436 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/0xForked/goca/server/hof"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/calendar/v3"
+)
+
+// jobCalendarMirror is the job kind syncing a connected calendar into
+// external_events, every run schedules the next one.
+const jobCalendarMirror = "calendar.mirror"
+
+const (
+	mirrorInterval = time.Minute * 10
+	// a mirror not synced for mirrorStale is only read when the provider
+	// can not be reached
+	mirrorStale = time.Hour
+	// events are kept from mirrorPast ago to mirrorHorizon ahead, the
+	// window moves along with a full sync every mirrorRefresh
+	mirrorPast    = time.Hour * 24
+	mirrorHorizon = time.Hour * 24 * 180
+	mirrorRefresh = time.Hour * 24 * 7
+)
+
+// mirrorJob is the payload of a mirror job.
+type mirrorJob struct {
+	MirrorID int `json:"mirror_id"`
+}
+
+// startMirror replaces the mirror of the calendar uid connected with
+// provider by an empty one synced right away, the job of the replaced
+// mirror stops once it finds it gone.
+func (s service) startMirror(ctx context.Context, uid int, provider string) error {
+	id, err := s.repository.InsertCalendarMirror(ctx, &CalendarMirror{
+		UserID:    uid,
+		Provider:  provider,
+		CreatedAt: time.Now().Unix(),
+	})
+	if err != nil {
+		return err
+	}
+	return s.jobs.Schedule(ctx, jobCalendarMirror, &mirrorJob{MirrorID: id}, time.Now())
+}
+
+// MirrorCalendar runs a mirror job. A failed sync is kept on the mirror
+// and tried again with the next run, the events synced before stay.
+func (s service) MirrorCalendar(ctx context.Context, payload []byte) error {
+	var job mirrorJob
+	if err := json.Unmarshal(payload, &job); err != nil {
+		return err
+	}
+	mirror, err := s.repository.FindCalendarMirror(ctx, job.MirrorID)
+	if err != nil || mirror == nil {
+		return err
+	}
+	user, err := s.repository.FindUserByID(ctx, mirror.UserID)
+	if err != nil {
+		return err
+	}
+	token := calendarToken(user, mirror.Provider)
+	if !token.Valid {
+		return s.repository.DeleteCalendarMirror(ctx, mirror.ID)
+	}
+	if err := s.syncMirror(ctx, mirror, user); err != nil {
+		log.Printf("unable to sync %s calendar of user %d: %s", mirror.Provider, user.ID, err)
+		if err := s.repository.UpdateCalendarMirrorError(ctx, mirror.ID, err.Error()); err != nil {
+			return err
+		}
+	}
+	return s.jobs.Schedule(ctx, jobCalendarMirror, &job, time.Now().Add(mirrorInterval))
+}
+
+func calendarToken(user *User, provider string) sql.NullString {
+	if provider == providerMicrosoft {
+		return user.MicrosoftToken
+	}
+	return user.GoogleToken
+}
+
+// syncMirror stores the changes of the calendar of mirror since its
+// cursor, or every event of a new window when it has none or the window
+// is due to move.
+func (s service) syncMirror(ctx context.Context, mirror *CalendarMirror, user *User) error {
+	tok := &oauth2.Token{}
+	if err := json.Unmarshal([]byte(calendarToken(user, mirror.Provider).String), tok); err != nil {
+		return err
+	}
+	now := time.Now()
+	full := mirror.Cursor == "" ||
+		now.Sub(time.Unix(mirror.WindowStart, 0)) > mirrorPast+mirrorRefresh
+	if full {
+		mirror.Cursor = ""
+		mirror.WindowStart = now.Add(-mirrorPast).Unix()
+		mirror.WindowEnd = now.Add(mirrorHorizon).Unix()
+	}
+	loc := s.mirrorLocation(ctx, mirror.UserID)
+	events, removed, cursor, err := s.mirrorChanges(ctx, mirror, user, tok, loc)
+	if errors.Is(err, hof.ErrGoogleSyncTokenExpired) || errors.Is(err, hof.ErrMicrosoftDeltaExpired) {
+		full = true
+		mirror.Cursor = ""
+		mirror.WindowStart = now.Add(-mirrorPast).Unix()
+		mirror.WindowEnd = now.Add(mirrorHorizon).Unix()
+		events, removed, cursor, err = s.mirrorChanges(ctx, mirror, user, tok, loc)
+	}
+	if err != nil {
+		return err
+	}
+	var kept []*ExternalEvent
+	for _, event := range events {
+		// incremental changes are not limited to the window
+		if event.EndAt <= mirror.WindowStart || event.StartAt >= mirror.WindowEnd {
+			removed = append(removed, event.ID)
+			continue
+		}
+		kept = append(kept, event)
+	}
+	mirror.Cursor = cursor
+	mirror.SyncedAt = now.Unix()
+	return s.repository.SaveExternalEvents(ctx, mirror, full, kept, removed)
+}
+
+// mirrorChanges lists the changes of the calendar of mirror since its
+// cursor, events that are gone are returned by id in removed.
+func (s service) mirrorChanges(
+	ctx context.Context,
+	mirror *CalendarMirror,
+	user *User,
+	tok *oauth2.Token,
+	loc *time.Location,
+) (events []*ExternalEvent, removed []string, cursor string, err error) {
+	switch mirror.Provider {
+	case providerGoogle:
+		calendarService, err := s.googleCalendar(ctx, tok)
+		if err != nil {
+			return nil, nil, "", err
+		}
+		var changes []*calendar.Event
+		changes, cursor, err = hof.ListGoogleOccurrenceChanges(calendarService,
+			mirror.Cursor, time.Unix(mirror.WindowStart, 0))
+		if err != nil {
+			return nil, nil, "", err
+		}
+		for _, change := range changes {
+			if event := googleExternalEvent(change, loc); event != nil {
+				events = append(events, event)
+			} else {
+				removed = append(removed, change.Id)
+			}
+		}
+	case providerMicrosoft:
+		var changes []hof.MSDeltaEvent
+		changes, cursor, err = s.microsoftGraph(ctx, user, tok).EventDelta(ctx, mirror.Cursor,
+			time.Unix(mirror.WindowStart, 0), time.Unix(mirror.WindowEnd, 0))
+		if err != nil {
+			return nil, nil, "", err
+		}
+		for i := range changes {
+			var event *ExternalEvent
+			if changes[i].Removed == nil {
+				event = microsoftExternalEvent(&changes[i].MSCalendarEvent, loc)
+			}
+			if event != nil {
+				events = append(events, event)
+			} else {
+				removed = append(removed, changes[i].ID)
+			}
+		}
+	default:
+		return nil, nil, "", fmt.Errorf("unknown calendar provider %s", mirror.Provider)
+	}
+	return events, removed, cursor, nil
+}
+
+// mirrorLocation is where all-day events of uid start and end, the
+// timezone of the availability of uid.
+func (s service) mirrorLocation(ctx context.Context, uid int) *time.Location {
+	availability, err := s.repository.FindUserAvailability(ctx, uid)
+	if err != nil {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(availability.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// googleExternalEvent returns the event to keep of a Google event, nil
+// when it was cancelled or has no time.
+func googleExternalEvent(event *calendar.Event, loc *time.Location) *ExternalEvent {
+	if event.Status == "cancelled" || event.Start == nil || event.End == nil {
+		return nil
+	}
+	external := &ExternalEvent{
+		ID:         event.Id,
+		Provider:   providerGoogle,
+		Title:      event.Summary,
+		Busy:       event.Transparency != "transparent",
+		Link:       event.HtmlLink,
+		MeetingURL: event.HangoutLink,
+		UpdatedAt:  time.Now().Unix(),
+	}
+	var start, end time.Time
+	var err error
+	if event.Start.DateTime == "" {
+		external.AllDay = true
+		if start, err = time.ParseInLocation(time.DateOnly, event.Start.Date, loc); err != nil {
+			return nil
+		}
+		if end, err = time.ParseInLocation(time.DateOnly, event.End.Date, loc); err != nil {
+			return nil
+		}
+	} else {
+		if start, err = time.Parse(time.RFC3339, event.Start.DateTime); err != nil {
+			return nil
+		}
+		if end, err = time.Parse(time.RFC3339, event.End.DateTime); err != nil {
+			return nil
+		}
+	}
+	external.StartAt, external.EndAt = start.Unix(), end.Unix()
+	return external
+}
+
+// microsoftExternalEvent returns the event to keep of an occurrence of
+// the Microsoft calendar view, nil when it was cancelled.
+func microsoftExternalEvent(event *hof.MSCalendarEvent, loc *time.Location) *ExternalEvent {
+	if event.IsCancelled {
+		return nil
+	}
+	start, end, err := event.StartEnd()
+	if err != nil {
+		return nil
+	}
+	if event.IsAllDay {
+		// all-day events run from midnight to midnight wherever the host is
+		start = time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, loc)
+		end = time.Date(end.Year(), end.Month(), end.Day(), 0, 0, 0, 0, loc)
+	}
+	external := &ExternalEvent{
+		ID:        event.ID,
+		Provider:  providerMicrosoft,
+		Title:     event.Subject,
+		StartAt:   start.Unix(),
+		EndAt:     end.Unix(),
+		AllDay:    event.IsAllDay,
+		Busy:      event.ShowAs != "free",
+		Link:      event.WebLink,
+		UpdatedAt: time.Now().Unix(),
+	}
+	if event.OnlineMeeting != nil {
+		external.MeetingURL = event.OnlineMeeting.JoinURL
+	}
+	return external
+}
+
+// calendarBusyTimes returns the busy periods of the calendar user
+// connected with provider between from and to. They are read from its
+// mirror while it is fresh, from the provider otherwise and from a mirror
+// that is not fresh when the provider can not be reached.
+func (s service) calendarBusyTimes(
+	ctx context.Context,
+	user *User,
+	provider string,
+	from, to time.Time,
+) ([]hof.BusyTime, error) {
+	mirror, err := s.userMirror(ctx, user.ID, provider)
+	if err != nil {
+		return nil, err
+	}
+	covered := mirror.covers(from, to)
+	if covered && time.Since(time.Unix(mirror.SyncedAt, 0)) < mirrorStale {
+		return s.repository.FindExternalBusyTimes(ctx, user.ID, provider, from.Unix(), to.Unix())
+	}
+	busy, err := s.liveBusyTimes(ctx, user, provider, from, to)
+	if err != nil && covered {
+		log.Printf("reading mirrored %s calendar of user %d synced at %d: %s",
+			provider, user.ID, mirror.SyncedAt, err)
+		return s.repository.FindExternalBusyTimes(ctx, user.ID, provider, from.Unix(), to.Unix())
+	}
+	return busy, err
+}
+
+func (s service) liveBusyTimes(
+	ctx context.Context,
+	user *User,
+	provider string,
+	from, to time.Time,
+) ([]hof.BusyTime, error) {
+	tok := &oauth2.Token{}
+	if err := json.Unmarshal([]byte(calendarToken(user, provider).String), tok); err != nil {
+		return nil, err
+	}
+	if provider == providerMicrosoft {
+		return s.microsoftGraph(ctx, user, tok).BusyTimes(ctx, from, to)
+	}
+	calendarService, err := s.googleCalendar(ctx, tok)
+	if err != nil {
+		return nil, err
+	}
+	return hof.GetGoogleBusyTimes(calendarService, from, to)
+}
+
+// userMirror returns the mirror of the calendar uid connected with
+// provider, calendars connected before mirrors existed get one started.
+func (s service) userMirror(
+	ctx context.Context,
+	uid int,
+	provider string,
+) (*CalendarMirror, error) {
+	mirror, err := s.repository.FindUserCalendarMirror(ctx, uid, provider)
+	if err != nil {
+		return nil, err
+	}
+	if mirror == nil {
+		if err := s.startMirror(ctx, uid, provider); err != nil {
+			log.Printf("unable to mirror %s calendar of user %d: %s", provider, uid, err)
+		}
+	}
+	return mirror, nil
+}
+
+// calendarEvents returns up to limit events of the calendar user connected
+// with provider between from and to, read like calendarBusyTimes.
+func (s service) calendarEvents(
+	ctx context.Context,
+	user *User,
+	provider string,
+	from, to time.Time,
+	limit int,
+) ([]*ExternalEvent, error) {
+	mirror, err := s.userMirror(ctx, user.ID, provider)
+	if err != nil {
+		return nil, err
+	}
+	covered := mirror.covers(from, to)
+	if covered && time.Since(time.Unix(mirror.SyncedAt, 0)) < mirrorStale {
+		return s.repository.FindExternalEvents(ctx, user.ID, provider,
+			from.Unix(), to.Unix(), limit)
+	}
+	events, err := s.liveEvents(ctx, user, provider, from, to, limit)
+	if err != nil && covered {
+		log.Printf("reading mirrored %s calendar of user %d synced at %d: %s",
+			provider, user.ID, mirror.SyncedAt, err)
+		return s.repository.FindExternalEvents(ctx, user.ID, provider,
+			from.Unix(), to.Unix(), limit)
+	}
+	return events, err
+}
+
+func (s service) liveEvents(
+	ctx context.Context,
+	user *User,
+	provider string,
+	from, to time.Time,
+	limit int,
+) ([]*ExternalEvent, error) {
+	tok := &oauth2.Token{}
+	if err := json.Unmarshal([]byte(calendarToken(user, provider).String), tok); err != nil {
+		return nil, err
+	}
+	loc := s.mirrorLocation(ctx, user.ID)
+	var events []*ExternalEvent
+	if provider == providerMicrosoft {
+		items, err := s.microsoftGraph(ctx, user, tok).CalendarView(ctx, from, to, limit)
+		if err != nil {
+			return nil, err
+		}
+		for i := range items {
+			if event := microsoftExternalEvent(&items[i], loc); event != nil {
+				events = append(events, event)
+			}
+		}
+		return events, nil
+	}
+	calendarService, err := s.googleCalendar(ctx, tok)
+	if err != nil {
+		return nil, err
+	}
+	items, err := hof.GetGoogleCalendarData(calendarService, from, to, limit)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range items {
+		if event := googleExternalEvent(item, loc); event != nil {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+// Events returns the first limit events between from and to of every
+// calendar user connected, ordered by start. A calendar that can not be
+// read is left out, failed tells why by provider.
+func (s service) Events(
+	ctx context.Context,
+	user *User,
+	from, to time.Time,
+	limit int,
+) (events []*ExternalEvent, failed map[string]string) {
+	events = []*ExternalEvent{}
+	for _, provider := range []string{providerGoogle, providerMicrosoft} {
+		if !calendarToken(user, provider).Valid {
+			continue
+		}
+		calendarEvents, err := s.calendarEvents(ctx, user, provider, from, to, limit)
+		if err != nil {
+			log.Printf("unable to read %s calendar of user %d: %s", provider, user.ID, err)
+			if failed == nil {
+				failed = map[string]string{}
+			}
+			failed[provider] = fmt.Sprintf("unable to read %s calendar: %s", provider, err)
+			continue
+		}
+		events = append(events, calendarEvents...)
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		if events[i].StartAt != events[j].StartAt {
+			return events[i].StartAt < events[j].StartAt
+		}
+		return events[i].EndAt < events[j].EndAt
+	})
+	if len(events) > limit {
+		events = events[:limit]
+	}
+	return events, failed
+}
@@ -0,0 +1,440 @@
+package user
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/0xForked/goca/server/hof"
+	"golang.org/x/oauth2"
+)
+
+// jobBookingEvent is the job kind creating the calendar event of a new
+// booking, it is stored in the same transaction as the booking.
+const jobBookingEvent = "booking.calendar_event"
+
+// bookingSyncMaxAttempts is how often creating the calendar event of a
+// booking is tried before the booking is cancelled.
+const bookingSyncMaxAttempts = 5
+
+// seatWaitRetry is how long a seat waits before checking again whether the
+// booking holding its slot has its calendar event.
+const seatWaitRetry = time.Minute
+
+// errors of notifications that do not come from a channel of ours
+var (
+	errUnknownChannel = errors.New("unknown notification channel")
+	errChannelToken   = errors.New("invalid notification channel token")
+)
+
+// channelJob is the payload of the jobs of a notification channel.
+type channelJob struct {
+	ChannelID string `json:"channel_id"`
+}
+
+// bookingEventJob is the payload of a calendar event job, the id of the
+// first booking of a series creates the event of the whole series.
+type bookingEventJob struct {
+	BookingID int `json:"booking_id"`
+}
+
+// hasCalendarEvent reports whether bookings at location get an event on
+// a calendar host connected.
+func hasCalendarEvent(host *User, location string) bool {
+	return (location == "google" && host.GoogleToken.Valid) ||
+		(location == "microsoft" && host.MicrosoftToken.Valid)
+}
+
+// SyncBookingEvent runs a calendar event job, creating the event of a
+// booking on the calendar of its host. Failed attempts are retried until
+// bookingSyncMaxAttempts, then the booking is cancelled so the invitee
+// does not show up to a meeting the host does not know about. The booking
+// is cancelled as well when the job itself runs out of attempts.
+func (s service) SyncBookingEvent(ctx context.Context, payload []byte) error {
+	var job bookingEventJob
+	if err := json.Unmarshal(payload, &job); err != nil {
+		return err
+	}
+	err := s.syncBookingEvent(ctx, &job)
+	if err != nil && hof.LastJobAttempt(ctx) {
+		// nothing runs the job again, a booking left pending would hold
+		// its slot without ever getting an event
+		if abandonErr := s.abandonPendingBooking(ctx, job.BookingID, err); abandonErr != nil {
+			log.Printf("unable to cancel booking %d: %s", job.BookingID, abandonErr)
+		}
+	}
+	return err
+}
+
+func (s service) syncBookingEvent(ctx context.Context, job *bookingEventJob) error {
+	booking, err := s.repository.FindBooking(ctx, job.BookingID)
+	if err != nil {
+		return err
+	}
+	if booking.SyncStatus != SyncPending {
+		return nil
+	}
+	if booking.CancelledAt > 0 {
+		booking.SyncStatus = SyncFailed
+		booking.SyncError = "booking was cancelled before its calendar event was created"
+		return s.repository.UpdateBookingSync(ctx, booking)
+	}
+	target, err := s.bookedTarget(ctx, booking)
+	if err != nil {
+		return err
+	}
+	var summary string
+	var event interface{}
+	if booking.SeatOf > 0 {
+		var seat *Booking
+		if seat, err = s.repository.FindBooking(ctx, booking.SeatOf); err != nil {
+			return err
+		}
+		// waiting is not a failed attempt, the job is scheduled again
+		// until the holder has its event or gave up
+		if seat.SyncStatus == SyncPending && seat.CancelledAt == 0 {
+			return s.jobs.Schedule(ctx, jobBookingEvent, job, time.Now().Add(seatWaitRetry))
+		}
+		booking.SyncAttempts++
+		if seat.CancelledAt > 0 || seat.SyncStatus == SyncFailed {
+			// the meeting to join is gone, there is nothing to retry
+			booking.SyncAttempts = bookingSyncMaxAttempts
+			err = fmt.Errorf("booking %s holding the slot was cancelled", seat.ID)
+		} else {
+			event, err = s.addSeatAttendee(ctx, target.Host, seat, booking)
+		}
+	} else {
+		// the event starts with the first occurrence of a series
+		form := &BookingForm{
+			Name:            booking.Name,
+			Email:           booking.Email,
+			Date:            target.StartAt.Unix(),
+			Time:            hof.TimeToInt(target.StartAt),
+			MeetingLocation: booking.Location,
+		}
+		booking.SyncAttempts++
+		summary, event, err = s.createCalendarEvent(ctx, target, form,
+			bookingDescription(booking.Notes, booking.Answers))
+	}
+	if err == nil && event == nil {
+		err = errors.New("calendar of the host is not connected")
+	}
+	if err == nil {
+		booking.Event, err = json.Marshal(event)
+	}
+	if err != nil {
+		booking.SyncError = err.Error()
+		if booking.SyncAttempts >= bookingSyncMaxAttempts {
+			return s.abandonBooking(ctx, target, booking)
+		}
+		if err := s.repository.UpdateBookingSync(ctx, booking); err != nil {
+			return err
+		}
+		return fmt.Errorf("calendar event of booking %s attempt %d: %s",
+			booking.ID, booking.SyncAttempts, booking.SyncError)
+	}
+	booking.SyncStatus, booking.SyncError = SyncSynced, ""
+	if summary != "" {
+		booking.Title = summary
+	}
+	if err := s.repository.UpdateBookingSync(ctx, booking); err != nil {
+		// an event the booking does not know about could never be cancelled,
+		// the next attempt creates it again
+		if cancelErr := s.cancelCalendarEvent(ctx, booking, true, time.Time{}); cancelErr != nil {
+			log.Printf("unable to remove calendar event of booking %s: %s",
+				booking.ID, cancelErr)
+		}
+		return err
+	}
+	s.notifyBooking(ctx, target, booking, &bookingNotice{Kind: noticeConfirmed})
+	return nil
+}
+
+// abandonBooking cancels a booking, with its whole series, whose calendar
+// event could not be created and lets the invitee know.
+func (s service) abandonBooking(
+	ctx context.Context,
+	target *BookingTarget,
+	booking *Booking,
+) error {
+	booking.SyncStatus = SyncFailed
+	if err := s.repository.UpdateBookingSync(ctx, booking); err != nil {
+		return err
+	}
+	if err := s.repository.CancelBookings(ctx, booking, true); err != nil {
+		return err
+	}
+	booking.Sequence++
+	booking.CancelledAt = time.Now().Unix()
+	s.notifyBooking(ctx, target, booking, &bookingNotice{
+		Kind:   noticeCancelled,
+		Reason: "The meeting could not be added to the calendar of the host.",
+	})
+	s.fireWebhooks(ctx, WebhookBookingCancelled, target, booking)
+	return nil
+}
+
+// abandonPendingBooking cancels the booking with id when it still waits
+// for its calendar event, cause is kept as its sync error.
+func (s service) abandonPendingBooking(ctx context.Context, id int, cause error) error {
+	booking, err := s.repository.FindBooking(ctx, id)
+	if err != nil {
+		return err
+	}
+	if booking.SyncStatus != SyncPending {
+		return nil
+	}
+	booking.SyncError = cause.Error()
+	target, err := s.bookedTarget(ctx, booking)
+	if err != nil {
+		// there is nobody to tell, the slot is still given back
+		booking.SyncStatus = SyncFailed
+		if err := s.repository.UpdateBookingSync(ctx, booking); err != nil {
+			return err
+		}
+		return s.repository.CancelBookings(ctx, booking, true)
+	}
+	return s.abandonBooking(ctx, target, booking)
+}
+
+// addSeatAttendee adds the invitee of booking to the calendar event of the
+// booking seat holding its slot, returning the event to store with it.
+func (s service) addSeatAttendee(
+	ctx context.Context,
+	host *User,
+	seat, booking *Booking,
+) (interface{}, error) {
+	var eventRef struct {
+		ID string `json:"id"`
+	}
+	_ = json.Unmarshal(seat.Event, &eventRef)
+	if eventRef.ID == "" {
+		return nil, fmt.Errorf("booking %s holding the slot has no calendar event", seat.ID)
+	}
+	if seat.Location == "google" && host.GoogleToken.Valid {
+		tok := &oauth2.Token{}
+		if err := json.Unmarshal([]byte(host.GoogleToken.String), tok); err != nil {
+			return nil, err
+		}
+		calendarService, err := s.googleCalendar(ctx, tok)
+		if err != nil {
+			return nil, err
+		}
+		return hof.AddGoogleEventAttendee(calendarService, eventRef.ID, booking.Email)
+	}
+	if seat.Location == "microsoft" && host.MicrosoftToken.Valid {
+		tok := &oauth2.Token{}
+		if err := json.Unmarshal([]byte(host.MicrosoftToken.String), tok); err != nil {
+			return nil, err
+		}
+		event, err := s.microsoftGraph(ctx, host, tok).AddEventAttendee(ctx, eventRef.ID,
+			hof.MSAttendee{
+				EmailAddress: hof.MSEmailAddress{Address: booking.Email, Name: booking.Name},
+				Type:         "required",
+			})
+		if err != nil {
+			return nil, err
+		}
+		// already invited, the event did not change
+		if event == nil {
+			return json.RawMessage(seat.Event), nil
+		}
+		keepJoinURL(event, seat.Event)
+		return event, nil
+	}
+	return nil, nil
+}
+
+// createCalendarEvent creates the event of a booking on the calendar of the
+// host chosen as meeting location, nothing is created for other locations.
+func (s service) createCalendarEvent(
+	ctx context.Context,
+	target *BookingTarget,
+	body *BookingForm,
+	description string,
+) (summary string, event interface{}, err error) {
+	user := target.Host
+	title := target.EventType.Title
+	timezone := target.Timezone
+	duration := target.EventType.Duration
+	if body.MeetingLocation == "google" && user.GoogleToken.Valid {
+		cfg := hof.GetGoogleOAuthConfig()
+		tok := &oauth2.Token{}
+		if err = json.Unmarshal([]byte(user.GoogleToken.String), tok); err != nil {
+			return "", nil, err
+		}
+		calendarService, err := s.googleCalendar(ctx, tok)
+		if err != nil {
+			return "", nil, err
+		}
+		_, email, err := hof.GetGoogleUserData(ctx, tok, cfg)
+		if err != nil {
+			return "", nil, err
+		}
+		summary = fmt.Sprintf("%s between %s and %s", title, user.Username, body.Name)
+		var coHostEmails []string
+		for _, coHost := range target.CoHosts {
+			coHostEmails = append(coHostEmails, coHost.Email)
+		}
+		recurrence := hof.GoogleRecurrence(target.Interval, len(target.Occurrences))
+		event, err = hof.SetGoogleNewMeeting(calendarService, summary, description, timezone,
+			email, body.Email, body.Date, body.Time, duration, recurrence, coHostEmails...)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+	if body.MeetingLocation == "microsoft" && user.MicrosoftToken.Valid {
+		tok := &oauth2.Token{}
+		if err = json.Unmarshal([]byte(user.MicrosoftToken.String), tok); err != nil {
+			return "", nil, err
+		}
+		summary = fmt.Sprintf("%s between %s and %s", title, user.Username, body.Name)
+		eventData, err := hof.ComposeMSMeetingData(timezone, summary, body.Date, body.Time,
+			duration, body.Name, body.Email)
+		if err != nil {
+			return "", nil, err
+		}
+		eventData.Recurrence = hof.ComposeMSRecurrence(target.StartAt,
+			target.Interval, len(target.Occurrences))
+		eventData.Body = hof.MSBody{ContentType: "text", Content: description}
+		// collective event types invite every other host
+		for _, coHost := range target.CoHosts {
+			eventData.Attendees = append(eventData.Attendees, hof.MSAttendee{
+				EmailAddress: hof.MSEmailAddress{
+					Address: coHost.Email,
+					Name:    coHost.Username,
+				},
+				Type: "required",
+			})
+		}
+		graph := s.microsoftGraph(ctx, user, tok)
+		start := target.StartAt
+		meetingURL, err := microsoftOnlineMeeting(ctx, graph, eventData,
+			start, start.Add(time.Duration(duration)*time.Minute))
+		if err != nil {
+			return "", nil, err
+		}
+		msEvent, err := graph.CreateEvent(ctx, eventData)
+		if err != nil {
+			return "", nil, err
+		}
+		// the standalone meeting is kept with the event like one of its own
+		if msEvent.OnlineMeeting == nil && meetingURL != "" {
+			msEvent.OnlineMeeting = &hof.MSOnlineMeeting{JoinURL: meetingURL}
+		}
+		event = msEvent
+	}
+	return summary, event, nil
+}
+
+// microsoftOnlineMeeting gives the event of a booking the online meeting
+// the account of the host supports: the provider of its calendar, else a
+// standalone Teams meeting for work or school accounts whose link goes in
+// the description and is returned. Without either, or when the calendar
+// can not be read, the event goes out without a meeting.
+func microsoftOnlineMeeting(
+	ctx context.Context,
+	graph *hof.GraphClient,
+	eventData *hof.MSEvent,
+	start, end time.Time,
+) (string, error) {
+	calendar, err := graph.Calendar(ctx)
+	if err != nil {
+		// the booking does not depend on its meeting
+		log.Printf("unable to find the meeting provider for %q: %s", eventData.Subject, err)
+		return "", nil
+	}
+	if provider := calendar.OnlineMeetingProvider(); provider != "" {
+		eventData.IsOnlineMeeting = true
+		eventData.OnlineMeetingProvider = provider
+		return "", nil
+	}
+	accountType, err := graph.AccountType()
+	if err != nil {
+		return "", err
+	}
+	if accountType != hof.MSAccountWork {
+		return "", nil
+	}
+	meeting, err := graph.CreateMeeting(ctx, eventData.Subject, start, end)
+	if err != nil {
+		// not every tenant licenses teams, the booking does not depend on it
+		log.Printf("unable to create teams meeting for %q: %s", eventData.Subject, err)
+		return "", nil
+	}
+	eventData.Body.Content = strings.TrimSpace(eventData.Body.Content +
+		"\n\nJoin the meeting: " + meeting.JoinWebURL)
+	return meeting.JoinWebURL, nil
+}
+
+// keepJoinURL carries the join link of a standalone Teams meeting over
+// from the stored event, graph only returns meetings of its own.
+func keepJoinURL(event *hof.MSEventResult, stored []byte) {
+	if event.OnlineMeeting != nil {
+		return
+	}
+	if url := joinURL(stored); url != "" {
+		event.OnlineMeeting = &hof.MSOnlineMeeting{JoinURL: url}
+	}
+}
+
+// cancelExternally cancels a booking, with series its whole series, whose
+// calendar event the host deleted on the calendar itself.
+func (s service) cancelExternally(
+	ctx context.Context,
+	booking *Booking,
+	series bool,
+) error {
+	target, err := s.bookedTarget(ctx, booking)
+	if err != nil {
+		return err
+	}
+	if err := s.repository.CancelBookings(ctx, booking, series); err != nil {
+		return err
+	}
+	booking.Sequence++
+	booking.CancelledAt = time.Now().Unix()
+	s.notifyBooking(ctx, target, booking, &bookingNotice{
+		Kind:       noticeCancelled,
+		Occurrence: booking.SeriesID > 0 && !series,
+		Reason:     "The host removed the meeting from their calendar.",
+	})
+	s.fireWebhooks(ctx, WebhookBookingCancelled, target, booking)
+	return nil
+}
+
+// moveExternally moves a booking to the time the host moved its calendar
+// event to on the calendar itself, the event moved already.
+func (s service) moveExternally(
+	ctx context.Context,
+	booking *Booking,
+	start, end time.Time,
+) error {
+	target, err := s.bookedTarget(ctx, booking)
+	if err != nil {
+		return err
+	}
+	if loc, err := time.LoadLocation(target.Timezone); err == nil {
+		start, end = start.In(loc), end.In(loc)
+	}
+	previous := target.StartAt
+	booking.Date, booking.Time = start.Unix(), hof.TimeToInt(start)
+	booking.StartAt, booking.EndAt = start.Unix(), end.Unix()
+	if err := s.repository.RescheduleBooking(ctx, booking); err != nil {
+		return err
+	}
+	booking.Sequence++
+	target.StartAt, target.EndAt = start, end
+	target.Occurrences = []time.Time{start}
+	s.notifyBooking(ctx, target, booking, &bookingNotice{
+		Kind:     noticeRescheduled,
+		Previous: previous,
+	})
+	s.scheduleReminders(ctx, target.EventType, []*Booking{booking})
+	s.fireWebhooks(ctx, WebhookBookingRescheduled, target, booking)
+	return nil
+}
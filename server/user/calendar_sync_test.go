@@ -0,0 +1,146 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/0xForked/goca/server/hof"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+)
+
+func TestMicrosoftOnlineMeeting(t *testing.T) {
+	issued := func(tid string) string {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256,
+			jwt.MapClaims{"tid": tid}).SignedString([]byte("key"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	work, personal := issued("72f988bf-86f1-41af-91ab-2d7cd011db47"), issued("9188040d-6c67-4c5b-b112-36a304b66dad")
+	const joinURL = "https://teams.microsoft.com/l/meetup-join/1"
+	tests := []struct {
+		name         string
+		accessToken  string
+		calendar     *hof.MSCalendar
+		wantProvider string
+		wantJoinURL  string
+	}{
+		{name: "calendar that can not be read", accessToken: work},
+		{name: "provider of the calendar", accessToken: personal,
+			calendar: &hof.MSCalendar{AllowedOnlineMeetingProviders: []string{"teamsForBusiness"},
+				DefaultOnlineMeetingProvider: "teamsForBusiness"},
+			wantProvider: "teamsForBusiness"},
+		{name: "work account without a provider", accessToken: work,
+			calendar:    &hof.MSCalendar{AllowedOnlineMeetingProviders: []string{"unknown"}},
+			wantJoinURL: joinURL},
+		{name: "personal account without a provider", accessToken: personal,
+			calendar: &hof.MSCalendar{AllowedOnlineMeetingProviders: []string{"unknown"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _, _ := newTestService(t)
+			g := newGraphStandIn(t, s)
+			g.accessToken = tt.accessToken
+			if tt.calendar != nil {
+				g.routes["GET /me/calendar"] = tt.calendar
+			}
+			g.routes["POST /me/onlineMeetings"] = &hof.MSMeeting{JoinWebURL: joinURL}
+			graph := s.graphClient(oauth2.StaticTokenSource(&oauth2.Token{AccessToken: tt.accessToken}))
+			eventData := &hof.MSEvent{Subject: "Intro", Body: hof.MSBody{ContentType: "text"}}
+			start := time.Now().Add(time.Hour)
+			got, err := microsoftOnlineMeeting(context.Background(), graph, eventData,
+				start, start.Add(30*time.Minute))
+			if err != nil {
+				t.Fatalf("microsoftOnlineMeeting() error = %v", err)
+			}
+			if got != tt.wantJoinURL {
+				t.Errorf("join url = %q, want %q", got, tt.wantJoinURL)
+			}
+			if eventData.IsOnlineMeeting != (tt.wantProvider != "") ||
+				eventData.OnlineMeetingProvider != tt.wantProvider {
+				t.Errorf("event meeting = %v %q, want %q", eventData.IsOnlineMeeting,
+					eventData.OnlineMeetingProvider, tt.wantProvider)
+			}
+			if tt.wantJoinURL != "" && !strings.Contains(eventData.Body.Content, tt.wantJoinURL) {
+				t.Errorf("description %q has no join url", eventData.Body.Content)
+			}
+		})
+	}
+}
+
+// insertTestSeat stores a booking taking a seat of the booking holder,
+// both wait for their calendar event.
+func insertTestSeat(t *testing.T, db *sql.DB, holder int) (int, []byte) {
+	t.Helper()
+	start := time.Now().Add(48 * time.Hour).Truncate(time.Minute)
+	id := insertTestBookings(t, db, providerMicrosoft, "", start)[0]
+	if _, err := db.Exec("UPDATE bookings SET event = 'null', sync_status = ? WHERE id IN (?, ?)",
+		SyncPending, holder, id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("UPDATE bookings SET seat_of = ? WHERE id = ?", holder, id); err != nil {
+		t.Fatal(err)
+	}
+	payload, _ := json.Marshal(&bookingEventJob{BookingID: id})
+	return id, payload
+}
+
+func TestSyncBookingEventSeatWaitsForHolder(t *testing.T) {
+	s, db, jobs := newTestService(t)
+	holder := insertTestBookings(t, db, providerMicrosoft, "", time.Now().Add(48*time.Hour))[0]
+	id, payload := insertTestSeat(t, db, holder)
+	// even the last attempt of the job only waits
+	ctx := hof.WithJobAttempt(context.Background(), 8, 8)
+	if err := s.SyncBookingEvent(ctx, payload); err != nil {
+		t.Fatalf("SyncBookingEvent() error = %v", err)
+	}
+	if jobs.count(jobBookingEvent) != 1 {
+		t.Errorf("%d calendar event jobs scheduled, want 1", jobs.count(jobBookingEvent))
+	}
+	booking, err := s.repository.FindBooking(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if booking.SyncStatus != SyncPending || booking.SyncAttempts != 0 || booking.CancelledAt > 0 {
+		t.Errorf("booking %s with %d attempts, cancelled at %d, want it pending",
+			booking.SyncStatus, booking.SyncAttempts, booking.CancelledAt)
+	}
+}
+
+func TestSyncBookingEventLastJobAttempt(t *testing.T) {
+	tests := []struct {
+		name          string
+		attempt       int
+		wantStatus    string
+		wantCancelled bool
+	}{
+		{name: "attempts left", attempt: 3, wantStatus: SyncPending},
+		{name: "last attempt", attempt: 8, wantStatus: SyncFailed, wantCancelled: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, db, _ := newTestService(t)
+			// the booking holding the slot is gone, finding it fails
+			// before an attempt of the booking is counted
+			id, payload := insertTestSeat(t, db, 999)
+			ctx := hof.WithJobAttempt(context.Background(), tt.attempt, 8)
+			if err := s.SyncBookingEvent(ctx, payload); err == nil {
+				t.Fatal("SyncBookingEvent() error = nil, want the missing booking")
+			}
+			booking, err := s.repository.FindBooking(context.Background(), id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if booking.SyncStatus != tt.wantStatus || (booking.CancelledAt > 0) != tt.wantCancelled {
+				t.Errorf("booking %s, cancelled at %d, want %s cancelled %v",
+					booking.SyncStatus, booking.CancelledAt, tt.wantStatus, tt.wantCancelled)
+			}
+		})
+	}
+}
@@ -0,0 +1,251 @@
+package user
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/0xForked/goca/server/hof"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/calendar/v3"
+)
+
+// job kinds keeping bookings in line with the Google calendars of their
+// hosts, a sync runs for every notification and a channel is renewed
+// before it expires
+const (
+	jobGoogleSync  = "google.sync"
+	jobGoogleRenew = "google.renew"
+)
+
+const (
+	providerGoogle = "google"
+	// googleChannelTTL is how long a channel is asked for, Google may
+	// grant less, it is renewed googleChannelRenewal before it expires
+	googleChannelTTL     = time.Hour * 24 * 7
+	googleChannelRenewal = time.Hour * 12
+	googleNotifyPath     = "/api/v1/google/notifications"
+)
+
+// watchGoogleCalendar replaces the notification channels of the Google
+// calendar of user with a new one, starting from syncToken or from now.
+func (s service) watchGoogleCalendar(
+	ctx context.Context,
+	user *User,
+	syncToken string,
+) error {
+	tok := &oauth2.Token{}
+	if err := json.Unmarshal([]byte(user.GoogleToken.String), tok); err != nil {
+		return err
+	}
+	calendarService, err := s.googleCalendar(ctx, tok)
+	if err != nil {
+		return err
+	}
+	channelID, err := hof.GenerateRandomToken(16)
+	if err != nil {
+		return err
+	}
+	token, err := hof.GenerateRandomToken(24)
+	if err != nil {
+		return err
+	}
+	watched, err := hof.WatchGoogleEvents(calendarService, channelID, token,
+		appURL+googleNotifyPath, googleChannelTTL)
+	if err != nil {
+		return err
+	}
+	if syncToken == "" {
+		// changes made before the channel are not of interest
+		if _, syncToken, err = hof.ListGoogleEventChanges(calendarService, ""); err != nil {
+			return err
+		}
+	}
+	channel := &CalendarChannel{
+		UserID:     user.ID,
+		Provider:   providerGoogle,
+		ChannelID:  channelID,
+		ResourceID: watched.ResourceId,
+		Token:      token,
+		SyncToken:  syncToken,
+		ExpiresAt:  time.UnixMilli(watched.Expiration).Unix(),
+		CreatedAt:  time.Now().Unix(),
+	}
+	previous, err := s.repository.FindUserCalendarChannels(ctx, user.ID, providerGoogle)
+	if err != nil {
+		return err
+	}
+	if channel.ID, err = s.repository.InsertCalendarChannel(ctx, channel); err != nil {
+		return err
+	}
+	for _, old := range previous {
+		if err := hof.StopGoogleChannel(calendarService, old.ChannelID, old.ResourceID); err != nil {
+			log.Printf("unable to stop google channel %s: %s", old.ChannelID, err)
+		}
+		if err := s.repository.DeleteCalendarChannel(ctx, old.ID); err != nil {
+			return err
+		}
+	}
+	renewAt := time.Unix(channel.ExpiresAt, 0).Add(-googleChannelRenewal)
+	return s.jobs.Schedule(ctx, jobGoogleRenew, &channelJob{ChannelID: channelID}, renewAt)
+}
+
+// RenewGoogleChannel runs a renewal job, the channel is replaced by a new
+// one continuing from its sync token. Channels replaced since are left.
+func (s service) RenewGoogleChannel(ctx context.Context, payload []byte) error {
+	channel, user, err := s.googleChannel(ctx, payload)
+	if err != nil || channel == nil {
+		return err
+	}
+	return s.watchGoogleCalendar(ctx, user, channel.SyncToken)
+}
+
+// GoogleNotification checks a notification of a channel came from Google
+// and queues a sync of the calendar it watches.
+func (s service) GoogleNotification(
+	ctx context.Context,
+	channelID, token, resourceID, state string,
+) error {
+	channel, err := s.repository.FindCalendarChannel(ctx, channelID)
+	if err != nil {
+		return err
+	}
+	if channel == nil || channel.Provider != providerGoogle {
+		return errUnknownChannel
+	}
+	if subtle.ConstantTimeCompare([]byte(channel.Token), []byte(token)) != 1 ||
+		channel.ResourceID != resourceID {
+		return errChannelToken
+	}
+	// the first notification of a channel only confirms it works
+	if state == "sync" {
+		return nil
+	}
+	return s.jobs.Schedule(ctx, jobGoogleSync, &channelJob{ChannelID: channelID}, time.Now())
+}
+
+// SyncGoogleChannel runs a sync job, bookings whose events changed on the
+// Google calendar of their host are cancelled or moved along.
+func (s service) SyncGoogleChannel(ctx context.Context, payload []byte) error {
+	channel, user, err := s.googleChannel(ctx, payload)
+	if err != nil || channel == nil {
+		return err
+	}
+	tok := &oauth2.Token{}
+	if err := json.Unmarshal([]byte(user.GoogleToken.String), tok); err != nil {
+		return err
+	}
+	calendarService, err := s.googleCalendar(ctx, tok)
+	if err != nil {
+		return err
+	}
+	events, syncToken, err := hof.ListGoogleEventChanges(calendarService, channel.SyncToken)
+	if errors.Is(err, hof.ErrGoogleSyncTokenExpired) {
+		// every event is compared again, bookings already in line are left
+		events, syncToken, err = hof.ListGoogleEventChanges(calendarService, "")
+	}
+	if err != nil {
+		return err
+	}
+	for _, event := range events {
+		if err := s.reconcileGoogleEvent(ctx, user, event); err != nil {
+			return fmt.Errorf("unable to reconcile event %s: %s", event.Id, err)
+		}
+	}
+	return s.repository.UpdateCalendarChannelSyncToken(ctx, channel.ID, syncToken)
+}
+
+// googleChannel returns the channel of a channel job with its user, nil
+// when the channel is gone or the user disconnected Google since.
+func (s service) googleChannel(
+	ctx context.Context,
+	payload []byte,
+) (*CalendarChannel, *User, error) {
+	var job channelJob
+	if err := json.Unmarshal(payload, &job); err != nil {
+		return nil, nil, err
+	}
+	channel, err := s.repository.FindCalendarChannel(ctx, job.ChannelID)
+	if err != nil || channel == nil {
+		return nil, nil, err
+	}
+	user, err := s.repository.FindUserByID(ctx, channel.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !user.GoogleToken.Valid {
+		return nil, nil, s.repository.DeleteCalendarChannel(ctx, channel.ID)
+	}
+	return channel, user, nil
+}
+
+// reconcileGoogleEvent applies a change of a Google event to the bookings
+// of user it belongs to. A deleted event cancels its bookings, a deleted
+// occurrence of a recurring event the booking of that occurrence and a
+// moved event moves a single booking, moved series are not followed.
+func (s service) reconcileGoogleEvent(
+	ctx context.Context,
+	user *User,
+	event *calendar.Event,
+) error {
+	eventID := event.Id
+	var original time.Time
+	if event.RecurringEventId != "" {
+		eventID = event.RecurringEventId
+		if event.OriginalStartTime == nil || event.OriginalStartTime.DateTime == "" {
+			return nil
+		}
+		var err error
+		if original, err = time.Parse(time.RFC3339, event.OriginalStartTime.DateTime); err != nil {
+			return err
+		}
+	}
+	ids, err := s.repository.FindEventBookingIDs(ctx, user.ID, providerGoogle, eventID)
+	if err != nil {
+		return err
+	}
+	cancelledSeries := map[int]bool{}
+	for _, id := range ids {
+		booking, err := s.repository.FindBooking(ctx, id)
+		if err != nil {
+			return err
+		}
+		if booking.CancelledAt > 0 || booking.Status != BookingConfirmed ||
+			cancelledSeries[booking.SeriesID] ||
+			(!original.IsZero() && booking.StartAt != original.Unix()) {
+			continue
+		}
+		if event.Status == "cancelled" {
+			series := booking.SeriesID > 0 && original.IsZero()
+			if series {
+				cancelledSeries[booking.SeriesID] = true
+			}
+			if err := s.cancelExternally(ctx, booking, series); err != nil {
+				return err
+			}
+			continue
+		}
+		if booking.SeriesID > 0 || event.Start == nil || event.End == nil {
+			continue
+		}
+		start, err := time.Parse(time.RFC3339, event.Start.DateTime)
+		if err != nil {
+			continue // all-day events have no time to move to
+		}
+		end, err := time.Parse(time.RFC3339, event.End.DateTime)
+		if err != nil {
+			continue
+		}
+		if start.Unix() == booking.StartAt && end.Unix() == booking.EndAt {
+			continue
+		}
+		if err := s.moveExternally(ctx, booking, start, end); err != nil {
+			return err
+		}
+	}
+	return nil
+}
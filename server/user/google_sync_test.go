@@ -0,0 +1,321 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/0xForked/goca/server/hof"
+	_ "github.com/glebarez/go-sqlite"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/option"
+)
+
+// testJobs keeps the kinds of the jobs scheduled instead of running them.
+type testJobs struct {
+	kinds []string
+}
+
+func (j *testJobs) Schedule(_ context.Context, kind string, _ interface{}, _ time.Time) error {
+	j.kinds = append(j.kinds, kind)
+	return nil
+}
+
+func (j *testJobs) Handle(string, hof.JobHandler) {}
+
+func (j *testJobs) count(kind string) int {
+	n := 0
+	for _, k := range j.kinds {
+		if k == kind {
+			n++
+		}
+	}
+	return n
+}
+
+// newTestService returns a service on a copy of the example database, the
+// mentor (user 1) is connected to Google and Microsoft with fake tokens.
+func newTestService(t *testing.T) (*service, *sql.DB, *testJobs) {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("..", "..", "db.sqlite3.example"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "db.sqlite3")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+	tok := `{"access_token":"fake","token_type":"Bearer","expiry":"2099-01-01T00:00:00Z"}`
+	if _, err := db.Exec("UPDATE users SET google_token = ?, microsoft_token = ? WHERE id = 1",
+		tok, tok); err != nil {
+		t.Fatal(err)
+	}
+	jobs := &testJobs{}
+	return &service{repository: newSQLRepository(db), jobs: jobs}, db, jobs
+}
+
+// insertTestBookings stores confirmed bookings of event type 2 of the
+// mentor on calendar event eventID of provider, more than one start makes
+// them a series.
+func insertTestBookings(
+	t *testing.T,
+	db *sql.DB,
+	provider, eventID string,
+	starts ...time.Time,
+) []int {
+	t.Helper()
+	event, _ := json.Marshal(map[string]string{"id": eventID})
+	var ids []int
+	for _, start := range starts {
+		var id int
+		err := db.QueryRow("INSERT INTO bookings (user_id, event_type_id, title, notes, name, "+
+			"email, date, time, event, created_at, location, start_at, end_at) VALUES "+
+			"(1, 2, '', '', 'Ann', 'ann@example.com', ?, ?, ?, ?, ?, ?, ?) RETURNING id",
+			start.Unix(), hof.TimeToInt(start), string(event), time.Now().Unix(), provider,
+			start.Unix(), start.Add(45*time.Minute).Unix()).Scan(&id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) > 1 {
+		if _, err := db.Exec("UPDATE bookings SET series_id = ?, recurrence = ? WHERE id >= ?",
+			ids[0], hof.WeeklyRule(1, len(ids)), ids[0]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return ids
+}
+
+func insertTestChannel(t *testing.T, s *service, provider, syncToken string) *CalendarChannel {
+	t.Helper()
+	channel := &CalendarChannel{
+		UserID:     1,
+		Provider:   provider,
+		ChannelID:  "channel-1",
+		ResourceID: "resource-1",
+		Token:      "secret-1",
+		SyncToken:  syncToken,
+		ExpiresAt:  time.Now().Add(time.Hour).Unix(),
+		CreatedAt:  time.Now().Unix(),
+	}
+	var err error
+	if channel.ID, err = s.repository.InsertCalendarChannel(context.Background(), channel); err != nil {
+		t.Fatal(err)
+	}
+	return channel
+}
+
+// googleStandIn serves the event list of the Calendar API, pages are
+// answered by the sync token asked for, an unknown token with 410 Gone.
+type googleStandIn struct {
+	*httptest.Server
+	pages  map[string]*calendar.Events
+	listed []string
+}
+
+func newGoogleStandIn(t *testing.T, s *service) *googleStandIn {
+	t.Helper()
+	g := &googleStandIn{pages: map[string]*calendar.Events{}}
+	g.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/calendars/primary/events" {
+			http.NotFound(w, r)
+			return
+		}
+		syncToken := r.URL.Query().Get("syncToken")
+		g.listed = append(g.listed, syncToken)
+		w.Header().Set("Content-Type", "application/json")
+		page, ok := g.pages[syncToken]
+		if !ok {
+			w.WriteHeader(http.StatusGone)
+			_, _ = w.Write([]byte(`{"error":{"code":410,"message":"Sync token is no longer valid, ` +
+				`a full sync is required.","errors":[{"reason":"fullSyncRequired"}]}}`))
+			return
+		}
+		_ = json.NewEncoder(w).Encode(page)
+	}))
+	t.Cleanup(g.Close)
+	s.googleCalendar = func(ctx context.Context, _ *oauth2.Token) (*calendar.Service, error) {
+		return calendar.NewService(ctx, option.WithEndpoint(g.URL+"/"),
+			option.WithHTTPClient(g.Client()))
+	}
+	return g
+}
+
+func TestGoogleNotification(t *testing.T) {
+	s, _, jobs := newTestService(t)
+	insertTestChannel(t, s, providerGoogle, "sync-1")
+	tests := []struct {
+		name       string
+		channelID  string
+		token      string
+		resourceID string
+		state      string
+		wantErr    error
+		wantSyncs  int
+	}{
+		{"unknown channel", "channel-2", "secret-1", "resource-1", "exists", errUnknownChannel, 0},
+		{"wrong token", "channel-1", "secret-2", "resource-1", "exists", errChannelToken, 0},
+		{"other resource", "channel-1", "secret-1", "resource-2", "exists", errChannelToken, 0},
+		{"sync state only confirms", "channel-1", "secret-1", "resource-1", "sync", nil, 0},
+		{"change", "channel-1", "secret-1", "resource-1", "exists", nil, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jobs.kinds = nil
+			err := s.GoogleNotification(context.Background(), tt.channelID, tt.token,
+				tt.resourceID, tt.state)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("GoogleNotification() error = %v, want %v", err, tt.wantErr)
+			}
+			if got := jobs.count(jobGoogleSync); got != tt.wantSyncs {
+				t.Errorf("%d sync jobs scheduled, want %d", got, tt.wantSyncs)
+			}
+		})
+	}
+}
+
+func TestSyncGoogleChannelResetsExpiredSyncToken(t *testing.T) {
+	s, db, _ := newTestService(t)
+	g := newGoogleStandIn(t, s)
+	channel := insertTestChannel(t, s, providerGoogle, "stale")
+	start := time.Now().Add(48 * time.Hour).Truncate(time.Minute)
+	ids := insertTestBookings(t, db, providerGoogle, "event-1", start)
+	// the full list every event is compared against again
+	g.pages[""] = &calendar.Events{
+		Items:         []*calendar.Event{{Id: "event-1", Status: "cancelled"}},
+		NextSyncToken: "fresh",
+	}
+	payload, _ := json.Marshal(&channelJob{ChannelID: channel.ChannelID})
+	if err := s.SyncGoogleChannel(context.Background(), payload); err != nil {
+		t.Fatalf("SyncGoogleChannel() error = %v", err)
+	}
+	if len(g.listed) != 2 || g.listed[0] != "stale" || g.listed[1] != "" {
+		t.Errorf("listed with sync tokens %q, want the stale one then none", g.listed)
+	}
+	stored, err := s.repository.FindCalendarChannel(context.Background(), channel.ChannelID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.SyncToken != "fresh" {
+		t.Errorf("sync token = %q, want fresh", stored.SyncToken)
+	}
+	booking, err := s.repository.FindBooking(context.Background(), ids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if booking.CancelledAt == 0 {
+		t.Error("booking of the deleted event is not cancelled")
+	}
+}
+
+func TestReconcileGoogleEvent(t *testing.T) {
+	first := time.Now().Add(48 * time.Hour).Truncate(time.Minute).UTC()
+	second, third := first.AddDate(0, 0, 7), first.AddDate(0, 0, 14)
+	moved := first.Add(2 * time.Hour)
+	dateTime := func(t time.Time) *calendar.EventDateTime {
+		return &calendar.EventDateTime{DateTime: t.Format(time.RFC3339)}
+	}
+	tests := []struct {
+		name          string
+		starts        []time.Time
+		event         *calendar.Event
+		wantCancelled []bool
+		wantStarts    []time.Time
+		wantMails     int
+	}{
+		{
+			name:          "deleted event cancels the booking",
+			starts:        []time.Time{first},
+			event:         &calendar.Event{Id: "event-1", Status: "cancelled"},
+			wantCancelled: []bool{true},
+			wantStarts:    []time.Time{first},
+			wantMails:     1,
+		},
+		{
+			name:   "moved event moves the booking",
+			starts: []time.Time{first},
+			event: &calendar.Event{Id: "event-1", Status: "confirmed",
+				Start: dateTime(moved), End: dateTime(moved.Add(45 * time.Minute))},
+			wantCancelled: []bool{false},
+			wantStarts:    []time.Time{moved},
+			wantMails:     1,
+		},
+		{
+			name:   "unchanged event is left",
+			starts: []time.Time{first},
+			event: &calendar.Event{Id: "event-1", Status: "confirmed",
+				Start: dateTime(first), End: dateTime(first.Add(45 * time.Minute))},
+			wantCancelled: []bool{false},
+			wantStarts:    []time.Time{first},
+		},
+		{
+			name:          "deleted series cancels every meeting",
+			starts:        []time.Time{first, second, third},
+			event:         &calendar.Event{Id: "event-1", Status: "cancelled"},
+			wantCancelled: []bool{true, true, true},
+			wantStarts:    []time.Time{first, second, third},
+			wantMails:     1,
+		},
+		{
+			name:   "deleted occurrence cancels its meeting",
+			starts: []time.Time{first, second, third},
+			event: &calendar.Event{Id: "event-1_occurrence", RecurringEventId: "event-1",
+				Status: "cancelled", OriginalStartTime: dateTime(second)},
+			wantCancelled: []bool{false, true, false},
+			wantStarts:    []time.Time{first, second, third},
+			wantMails:     1,
+		},
+		{
+			name:   "moved occurrence is not followed",
+			starts: []time.Time{first, second, third},
+			event: &calendar.Event{Id: "event-1_occurrence", RecurringEventId: "event-1",
+				Status: "confirmed", OriginalStartTime: dateTime(second),
+				Start: dateTime(moved), End: dateTime(moved.Add(45 * time.Minute))},
+			wantCancelled: []bool{false, false, false},
+			wantStarts:    []time.Time{first, second, third},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, db, jobs := newTestService(t)
+			ids := insertTestBookings(t, db, providerGoogle, "event-1", tt.starts...)
+			user, err := s.repository.FindUserByID(context.Background(), 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := s.reconcileGoogleEvent(context.Background(), user, tt.event); err != nil {
+				t.Fatalf("reconcileGoogleEvent() error = %v", err)
+			}
+			for i, id := range ids {
+				booking, err := s.repository.FindBooking(context.Background(), id)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if cancelled := booking.CancelledAt > 0; cancelled != tt.wantCancelled[i] {
+					t.Errorf("booking %d cancelled = %v, want %v", i, cancelled, tt.wantCancelled[i])
+				}
+				if booking.StartAt != tt.wantStarts[i].Unix() {
+					t.Errorf("booking %d starts at %s, want %s", i,
+						time.Unix(booking.StartAt, 0).UTC(), tt.wantStarts[i])
+				}
+			}
+			if got := jobs.count(jobBookingMail); got != tt.wantMails {
+				t.Errorf("%d emails sent, want %d", got, tt.wantMails)
+			}
+		})
+	}
+}
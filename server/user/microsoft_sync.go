@@ -0,0 +1,307 @@
+package user
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/0xForked/goca/server/hof"
+	"golang.org/x/oauth2"
+)
+
+// job kinds keeping bookings in line with the Microsoft calendars of their
+// hosts, a sync runs for every changed event and a subscription is renewed
+// before it expires
+const (
+	jobMicrosoftSync  = "microsoft.sync"
+	jobMicrosoftRenew = "microsoft.renew"
+)
+
+const (
+	providerMicrosoft = "microsoft"
+	// graph keeps subscriptions to events for at most 4230 minutes
+	msSubscriptionTTL     = time.Minute * 4200
+	msSubscriptionRenewal = time.Hour * 12
+	msNotifyPath          = "/api/v1/microsoft/notifications"
+)
+
+// microsoftSyncJob is the payload of a sync job, one changed event.
+type microsoftSyncJob struct {
+	ChannelID  string `json:"channel_id"`
+	ChangeType string `json:"change_type"`
+	EventID    string `json:"event_id"`
+}
+
+// watchMicrosoftCalendar replaces the subscriptions to the Microsoft
+// calendar of user with a new one.
+func (s service) watchMicrosoftCalendar(ctx context.Context, user *User) error {
+	tok := &oauth2.Token{}
+	if err := json.Unmarshal([]byte(user.MicrosoftToken.String), tok); err != nil {
+		return err
+	}
+	clientState, err := hof.GenerateRandomToken(24)
+	if err != nil {
+		return err
+	}
+	graph := s.microsoftGraph(ctx, user, tok)
+	subscription, err := graph.CreateSubscription(ctx,
+		appURL+msNotifyPath, clientState, time.Now().Add(msSubscriptionTTL))
+	if err != nil {
+		return err
+	}
+	expiresAt, err := time.Parse(time.RFC3339, subscription.ExpirationDateTime)
+	if err != nil {
+		return err
+	}
+	channel := &CalendarChannel{
+		UserID:     user.ID,
+		Provider:   providerMicrosoft,
+		ChannelID:  subscription.ID,
+		ResourceID: subscription.Resource,
+		Token:      clientState,
+		ExpiresAt:  expiresAt.Unix(),
+		CreatedAt:  time.Now().Unix(),
+	}
+	previous, err := s.repository.FindUserCalendarChannels(ctx, user.ID, providerMicrosoft)
+	if err != nil {
+		return err
+	}
+	if channel.ID, err = s.repository.InsertCalendarChannel(ctx, channel); err != nil {
+		return err
+	}
+	for _, old := range previous {
+		if err := graph.DeleteSubscription(ctx, old.ChannelID); err != nil {
+			log.Printf("unable to delete microsoft subscription %s: %s", old.ChannelID, err)
+		}
+		if err := s.repository.DeleteCalendarChannel(ctx, old.ID); err != nil {
+			return err
+		}
+	}
+	return s.scheduleMicrosoftRenewal(ctx, channel)
+}
+
+func (s service) scheduleMicrosoftRenewal(ctx context.Context, channel *CalendarChannel) error {
+	renewAt := time.Unix(channel.ExpiresAt, 0).Add(-msSubscriptionRenewal)
+	return s.jobs.Schedule(ctx, jobMicrosoftRenew,
+		&channelJob{ChannelID: channel.ChannelID}, renewAt)
+}
+
+// RenewMicrosoftChannel runs a renewal job, a subscription graph no longer
+// knows is replaced by a new one.
+func (s service) RenewMicrosoftChannel(ctx context.Context, payload []byte) error {
+	var job channelJob
+	if err := json.Unmarshal(payload, &job); err != nil {
+		return err
+	}
+	channel, user, err := s.microsoftChannel(ctx, job.ChannelID)
+	if err != nil || channel == nil {
+		return err
+	}
+	tok := &oauth2.Token{}
+	if err := json.Unmarshal([]byte(user.MicrosoftToken.String), tok); err != nil {
+		return err
+	}
+	subscription, err := s.microsoftGraph(ctx, user, tok).RenewSubscription(ctx,
+		channel.ChannelID, time.Now().Add(msSubscriptionTTL))
+	if err != nil {
+		log.Printf("unable to renew microsoft subscription %s: %s", channel.ChannelID, err)
+		return s.watchMicrosoftCalendar(ctx, user)
+	}
+	expiresAt, err := time.Parse(time.RFC3339, subscription.ExpirationDateTime)
+	if err != nil {
+		return err
+	}
+	channel.ExpiresAt = expiresAt.Unix()
+	if err := s.repository.UpdateCalendarChannelExpiry(ctx, channel.ID, channel.ExpiresAt); err != nil {
+		return err
+	}
+	return s.scheduleMicrosoftRenewal(ctx, channel)
+}
+
+// MicrosoftNotifications queues a sync of every changed event notified by
+// graph, notifications without the client state of their subscription
+// did not come from graph and are dropped.
+func (s service) MicrosoftNotifications(
+	ctx context.Context,
+	notifications []hof.MSNotification,
+) error {
+	for _, notification := range notifications {
+		channel, err := s.repository.FindCalendarChannel(ctx, notification.SubscriptionID)
+		if err != nil {
+			return err
+		}
+		if channel == nil || channel.Provider != providerMicrosoft {
+			log.Printf("notification of unknown subscription %s", notification.SubscriptionID)
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(channel.Token), []byte(notification.ClientState)) != 1 {
+			log.Printf("notification of subscription %s with invalid client state",
+				notification.SubscriptionID)
+			continue
+		}
+		if err := s.jobs.Schedule(ctx, jobMicrosoftSync, &microsoftSyncJob{
+			ChannelID:  channel.ChannelID,
+			ChangeType: notification.ChangeType,
+			EventID:    notification.ResourceData.ID,
+		}, time.Now()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SyncMicrosoftEvent runs a sync job, the bookings of a changed event are
+// cancelled or moved along. A deleted event cancels its bookings, an
+// occurrence of a recurring event that is gone the booking of that
+// occurrence and a moved event moves a single booking.
+func (s service) SyncMicrosoftEvent(ctx context.Context, payload []byte) error {
+	var job microsoftSyncJob
+	if err := json.Unmarshal(payload, &job); err != nil {
+		return err
+	}
+	channel, user, err := s.microsoftChannel(ctx, job.ChannelID)
+	if err != nil || channel == nil {
+		return err
+	}
+	tok := &oauth2.Token{}
+	if err := json.Unmarshal([]byte(user.MicrosoftToken.String), tok); err != nil {
+		return err
+	}
+	graph := s.microsoftGraph(ctx, user, tok)
+	var state *hof.MSEventState
+	if job.ChangeType != "deleted" {
+		if state, err = graph.EventState(ctx, job.EventID); err != nil {
+			return err
+		}
+	}
+	eventID := job.EventID
+	var original time.Time
+	if state != nil && state.SeriesMasterID != "" {
+		eventID = state.SeriesMasterID
+		if original, err = time.Parse(time.RFC3339, state.OriginalStart); err != nil {
+			return err
+		}
+	}
+	ids, err := s.repository.FindEventBookingIDs(ctx, user.ID, providerMicrosoft, eventID)
+	if err != nil {
+		return err
+	}
+	cancelledSeries := map[int]bool{}
+	for _, id := range ids {
+		booking, err := s.repository.FindBooking(ctx, id)
+		if err != nil {
+			return err
+		}
+		if booking.CancelledAt > 0 || booking.Status != BookingConfirmed ||
+			cancelledSeries[booking.SeriesID] ||
+			(!original.IsZero() && booking.StartAt != original.Unix()) {
+			continue
+		}
+		switch {
+		case state == nil || state.IsCancelled:
+			series := booking.SeriesID > 0 && original.IsZero()
+			if series {
+				cancelledSeries[booking.SeriesID] = true
+			}
+			err = s.cancelExternally(ctx, booking, series)
+		case state.Type == "seriesMaster":
+			// graph tells a series changed, not which occurrence is gone
+			var starts []time.Time
+			starts, err = graph.EventOccurrences(ctx, eventID, time.Unix(booking.StartAt, 0),
+				time.Unix(booking.EndAt, 0))
+			if err == nil && !hasStart(starts, booking.StartAt) {
+				err = s.cancelExternally(ctx, booking, false)
+			}
+		case booking.SeriesID == 0:
+			start, end, parseErr := state.StartEnd()
+			if parseErr != nil || (start.Unix() == booking.StartAt && end.Unix() == booking.EndAt) {
+				continue
+			}
+			err = s.moveExternally(ctx, booking, start, end)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func hasStart(starts []time.Time, startAt int64) bool {
+	for _, start := range starts {
+		if start.Unix() == startAt {
+			return true
+		}
+	}
+	return false
+}
+
+// microsoftGraph returns a graph client acting with tok of user, an
+// expired access token is refreshed and the new token stored with user.
+func (s service) microsoftGraph(ctx context.Context, user *User, tok *oauth2.Token) *hof.GraphClient {
+	return s.graphClient(&storedTokenSource{
+		ctx:         ctx,
+		repository:  s.repository,
+		username:    user.Username,
+		column:      "microsoft_token",
+		base:        s.microsoftOAuth().TokenSource(ctx, tok),
+		accessToken: tok.AccessToken,
+	})
+}
+
+// storedTokenSource takes the tokens of a user from base and stores them
+// with the user whenever base refreshed the access token.
+type storedTokenSource struct {
+	ctx         context.Context
+	repository  ISQLRepository
+	username    string
+	column      string
+	base        oauth2.TokenSource
+	mu          sync.Mutex
+	accessToken string
+}
+
+func (ts *storedTokenSource) Token() (*oauth2.Token, error) {
+	tok, err := ts.base.Token()
+	if err != nil {
+		return nil, err
+	}
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if tok.AccessToken == ts.accessToken {
+		return tok, nil
+	}
+	data, err := json.Marshal(tok)
+	if err != nil {
+		return nil, err
+	}
+	// a failed store refreshes again next time, the request goes ahead
+	if err := ts.repository.UpdateUser(ts.ctx, ts.username, ts.column, data); err != nil {
+		log.Printf("unable to store refreshed token of %s: %s", ts.username, err)
+		return tok, nil
+	}
+	ts.accessToken = tok.AccessToken
+	return tok, nil
+}
+
+// microsoftChannel returns the subscription channelID with its user, nil
+// when it is gone or the user disconnected Microsoft since.
+func (s service) microsoftChannel(
+	ctx context.Context,
+	channelID string,
+) (*CalendarChannel, *User, error) {
+	channel, err := s.repository.FindCalendarChannel(ctx, channelID)
+	if err != nil || channel == nil {
+		return nil, nil, err
+	}
+	user, err := s.repository.FindUserByID(ctx, channel.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !user.MicrosoftToken.Valid {
+		return nil, nil, s.repository.DeleteCalendarChannel(ctx, channel.ID)
+	}
+	return channel, user, nil
+}
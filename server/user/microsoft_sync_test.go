@@ -0,0 +1,182 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/0xForked/goca/server/hof"
+	"golang.org/x/oauth2"
+)
+
+// graphStandIn serves routes of graph and the token endpoint refreshing
+// access tokens to "fresh", requests without accessToken answer 401.
+type graphStandIn struct {
+	*httptest.Server
+	routes      map[string]interface{}
+	accessToken string
+	refreshes   int
+	unexpected  []string
+}
+
+func newGraphStandIn(t *testing.T, s *service) *graphStandIn {
+	t.Helper()
+	g := &graphStandIn{routes: map[string]interface{}{}, accessToken: "fresh"}
+	g.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/token" {
+			if r.FormValue("grant_type") != "refresh_token" || r.FormValue("refresh_token") != "refresh-1" {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(`{"error":"invalid_grant"}`))
+				return
+			}
+			g.refreshes++
+			_, _ = w.Write([]byte(`{"access_token":"fresh","token_type":"Bearer","expires_in":3600}`))
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer "+g.accessToken {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error":{"code":"InvalidAuthenticationToken","message":"expired"}}`))
+			return
+		}
+		route, ok := g.routes[r.Method+" "+r.URL.Path]
+		if !ok {
+			g.unexpected = append(g.unexpected, r.Method+" "+r.URL.Path)
+			http.NotFound(w, r)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(route)
+	}))
+	t.Cleanup(g.Close)
+	s.microsoftOAuth = func() *oauth2.Config {
+		return &oauth2.Config{ClientID: "goca", Endpoint: oauth2.Endpoint{
+			TokenURL:  g.URL + "/token",
+			AuthStyle: oauth2.AuthStyleInParams,
+		}}
+	}
+	s.graphClient = func(ts oauth2.TokenSource) *hof.GraphClient {
+		client := hof.NewGraphClient(ts)
+		client.BaseURL, client.HTTPClient = g.URL, g.Client()
+		return client
+	}
+	return g
+}
+
+// expireMicrosoftToken leaves the mentor an expired access token with a
+// refresh token.
+func expireMicrosoftToken(t *testing.T, db *sql.DB) {
+	t.Helper()
+	if _, err := db.Exec("UPDATE users SET microsoft_token = ? WHERE id = 1",
+		`{"access_token":"stale","token_type":"Bearer","refresh_token":"refresh-1",`+
+			`"expiry":"2000-01-01T00:00:00Z"}`); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func storedMicrosoftToken(t *testing.T, s *service) *oauth2.Token {
+	t.Helper()
+	user, err := s.repository.FindUserByID(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tok := &oauth2.Token{}
+	if err := json.Unmarshal([]byte(user.MicrosoftToken.String), tok); err != nil {
+		t.Fatal(err)
+	}
+	return tok
+}
+
+func TestRenewMicrosoftChannelToken(t *testing.T) {
+	tests := []struct {
+		name          string
+		expired       bool
+		wantRefreshes int
+		wantToken     string
+	}{
+		{name: "expired token is refreshed and stored", expired: true, wantRefreshes: 1,
+			wantToken: "fresh"},
+		{name: "valid token is used as is", wantToken: "fake"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, db, jobs := newTestService(t)
+			g := newGraphStandIn(t, s)
+			g.accessToken = tt.wantToken
+			if tt.expired {
+				expireMicrosoftToken(t, db)
+			}
+			channel := insertTestChannel(t, s, providerMicrosoft, "")
+			expiresAt := time.Now().Add(msSubscriptionTTL).Truncate(time.Second).UTC()
+			g.routes["PATCH /subscriptions/"+channel.ChannelID] = &hof.MSSubscription{
+				ID:                 channel.ChannelID,
+				ExpirationDateTime: expiresAt.Format(time.RFC3339),
+			}
+			payload, _ := json.Marshal(&channelJob{ChannelID: channel.ChannelID})
+			if err := s.RenewMicrosoftChannel(context.Background(), payload); err != nil {
+				t.Fatalf("RenewMicrosoftChannel() error = %v", err)
+			}
+			if len(g.unexpected) > 0 {
+				t.Errorf("unexpected graph requests %q", g.unexpected)
+			}
+			if g.refreshes != tt.wantRefreshes {
+				t.Errorf("token refreshed %d times, want %d", g.refreshes, tt.wantRefreshes)
+			}
+			tok := storedMicrosoftToken(t, s)
+			if tok.AccessToken != tt.wantToken {
+				t.Errorf("stored access token = %q, want %q", tok.AccessToken, tt.wantToken)
+			}
+			if tt.expired && tok.RefreshToken != "refresh-1" {
+				t.Errorf("stored refresh token = %q, want it kept", tok.RefreshToken)
+			}
+			stored, err := s.repository.FindCalendarChannel(context.Background(), channel.ChannelID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stored.ExpiresAt != expiresAt.Unix() {
+				t.Errorf("channel expires at %d, want %d", stored.ExpiresAt, expiresAt.Unix())
+			}
+			if jobs.count(jobMicrosoftRenew) != 1 {
+				t.Errorf("%d renewals scheduled, want 1", jobs.count(jobMicrosoftRenew))
+			}
+		})
+	}
+}
+
+func TestSyncMicrosoftEventRefreshesToken(t *testing.T) {
+	s, db, _ := newTestService(t)
+	g := newGraphStandIn(t, s)
+	expireMicrosoftToken(t, db)
+	channel := insertTestChannel(t, s, providerMicrosoft, "")
+	ids := insertTestBookings(t, db, providerMicrosoft, "event-1",
+		time.Now().Add(48*time.Hour).Truncate(time.Minute))
+	g.routes["GET /me/events/event-1"] = &hof.MSEventState{
+		ID:          "event-1",
+		Type:        "singleInstance",
+		IsCancelled: true,
+	}
+	payload, _ := json.Marshal(&microsoftSyncJob{
+		ChannelID:  channel.ChannelID,
+		ChangeType: "updated",
+		EventID:    "event-1",
+	})
+	if err := s.SyncMicrosoftEvent(context.Background(), payload); err != nil {
+		t.Fatalf("SyncMicrosoftEvent() error = %v", err)
+	}
+	if g.refreshes != 1 {
+		t.Errorf("token refreshed %d times, want 1", g.refreshes)
+	}
+	if tok := storedMicrosoftToken(t, s); tok.AccessToken != "fresh" {
+		t.Errorf("stored access token = %q, want fresh", tok.AccessToken)
+	}
+	booking, err := s.repository.FindBooking(context.Background(), ids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if booking.CancelledAt == 0 {
+		t.Error("booking of the cancelled event is not cancelled")
+	}
+}
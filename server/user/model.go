@@ -2,6 +2,8 @@ package user
 
 import (
 	"database/sql"
+	"encoding/json"
+	"time"
 
 	"github.com/golodash/galidator"
 )
@@ -9,7 +11,16 @@ import (
 type User struct {
 	ID             int            `json:"id"`
 	Username       string         `json:"username"`
+	Email          string         `json:"email,omitempty"`
 	Password       string         `json:"password,omitempty"`
+	Role           string         `json:"role"`
+	TOTPSecret     sql.NullString `json:"-"`
+	TOTPEnabled    bool           `json:"totp_enabled"`
+	TOTPLastStep   int64          `json:"-"`
+	FailedLogins   int            `json:"-"`
+	LockedUntil    int64          `json:"-"`
+	DisabledAt     int64          `json:"disabled_at,omitempty"`
+	CreatedAt      int64          `json:"created_at,omitempty"`
 	GoogleToken    sql.NullString `json:"-"`
 	MicrosoftToken sql.NullString `json:"-"`
 	Availability   *Availability  `json:"availability,omitempty"`
@@ -35,36 +46,466 @@ type AvailabilityDay struct {
 }
 
 type EventType struct {
+	ID                   int              `json:"id"`
+	UserID               int              `json:"-"`
+	TeamID               int              `json:"team_id,omitempty"`
+	AvailabilityID       int              `json:"-"`
+	Enable               int              `json:"enable"`
+	Title                string           `json:"title"`
+	Description          string           `json:"description"`
+	Duration             int              `json:"duration"` // duration in minute
+	SchedulingType       string           `json:"scheduling_type,omitempty"`
+	Seats                int              `json:"seats,omitempty"`         // invitees per slot, 0 is one-on-one
+	RecurringMax         int              `json:"recurring_max,omitempty"` // occurrences per booking, 0 is not recurring
+	RequiresConfirmation int              `json:"requires_confirmation"`   // 1 keeps bookings pending until the host accepts
+	Reminders            []int            `json:"reminders"`               // minutes before a booking its reminders are sent
+	Hosts                []*EventTypeHost `json:"hosts,omitempty"`
+	Questions            []*Question      `json:"questions,omitempty"`
+	Availability         *Availability    `json:"availability,omitempty"`
+	IsGoogleAvailable    bool             `json:"is_google_available"`
+	IsMicrosoftAvailable bool             `json:"is_microsoft_available"`
+}
+
+type Booking struct {
+	ID           string      `json:"id"`
+	UserID       int         `json:"-"`
+	EventTypeID  int         `json:"-"`
+	Title        string      `json:"title"`
+	Notes        string      `json:"notes"`
+	Name         string      `json:"name"`
+	Email        string      `json:"email"`
+	Date         int64       `json:"date"`
+	Time         int         `json:"time"`
+	Location     string      `json:"location"`
+	StartAt      int64       `json:"start_at,omitempty"`
+	EndAt        int64       `json:"end_at,omitempty"`
+	Host         string      `json:"host,omitempty"`
+	CoHostIDs    []int       `json:"-"`
+	SeatOf       int         `json:"-"`
+	SeatsTaken   int         `json:"-"`
+	SeriesID     int         `json:"series_id,omitempty"`
+	Recurrence   string      `json:"recurrence,omitempty"`
+	CancelledAt  int64       `json:"cancelled_at,omitempty"`
+	Status       string      `json:"status,omitempty"`
+	ExpiresAt    int64       `json:"expires_at,omitempty"` // pending bookings expire unanswered
+	Answers      []*Answer   `json:"answers,omitempty"`
+	Sequence     int         `json:"-"`                     // version of the calendar invite
+	SyncStatus   string      `json:"sync_status,omitempty"` // calendar event created yet
+	SyncError    string      `json:"sync_error,omitempty"`
+	SyncAttempts int         `json:"-"`
+	Event        []byte      `json:"-"`
+	EventDetail  interface{} `json:"event_detail"`
+	JoinURL      string      `json:"join_url,omitempty"` // online meeting of the calendar event
+}
+
+// Team groups users that share event types, its timezone is the one
+// slots of team event types are expressed in.
+type Team struct {
+	ID         int           `json:"id"`
+	Name       string        `json:"name"`
+	Slug       string        `json:"slug"`
+	Timezone   string        `json:"timezone"`
+	CreatedAt  int64         `json:"created_at"`
+	Members    []*TeamMember `json:"members,omitempty"`
+	EventTypes []*EventType  `json:"event_types,omitempty"`
+}
+
+type TeamMember struct {
+	ID        int    `json:"id"`
+	TeamID    int    `json:"-"`
+	UserID    int    `json:"user_id"`
+	Username  string `json:"username"`
+	Role      string `json:"role"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+// EventTypeHost is a team member taking bookings of a team event type,
+// a higher weight gets a larger share of round-robin bookings.
+type EventTypeHost struct {
+	UserID   int    `json:"user_id"`
+	Username string `json:"username"`
+	Weight   int    `json:"weight"`
+}
+
+// Question is an extra question of an event type asked on the
+// booking page, the answer is sent under Name.
+type Question struct {
+	Name     string   `json:"name"`
+	Label    string   `json:"label"`
+	Type     string   `json:"type"`
+	Required bool     `json:"required"`
+	Options  []string `json:"options,omitempty"` // choices of select questions
+}
+
+// Answer is the answer of the invitee to a question, a string or for
+// checkbox questions a bool.
+type Answer struct {
+	Name  string      `json:"name"`
+	Label string      `json:"label"`
+	Value interface{} `json:"value"`
+}
+
+// Slot is a bookable start time, Time is HHMM in the event type timezone.
+type Slot struct {
+	Time      int   `json:"time"`
+	StartAt   int64 `json:"start_at"`
+	SeatsLeft int   `json:"seats_left,omitempty"`
+}
+
+// BookingTarget is what a booking request resolves to, the host
+// whose calendar receives the event and the slot in absolute time.
+type BookingTarget struct {
+	Host    *User
+	CoHosts []*User
+	// SeatOf is the booking of the slot the invitee joins, its
+	// calendar event gets the invitee as a new attendee
+	SeatOf    *Booking
+	EventType *EventType
+	Timezone  string
+	StartAt   time.Time
+	EndAt     time.Time
+	// Occurrences are the starts of every meeting of the booking, more
+	// than one for recurring bookings repeating every Interval weeks
+	Occurrences []time.Time
+	Interval    int
+}
+
+type Session struct {
 	ID                   int           `json:"id"`
 	UserID               int           `json:"-"`
-	AvailabilityID       int           `json:"-"`
-	Enable               int           `json:"enable"`
-	Title                string        `json:"title"`
-	Description          string        `json:"description"`
-	Duration             int           `json:"duration"` // duration in minute
-	Availability         *Availability `json:"availability,omitempty"`
-	IsGoogleAvailable    bool          `json:"is_google_available"`
-	IsMicrosoftAvailable bool          `json:"is_microsoft_available"`
+	JTI                  string        `json:"-"`
+	RefreshToken         string        `json:"-"`
+	PreviousRefreshToken string        `json:"-"`
+	UserAgent            string        `json:"user_agent"`
+	IPAddress            string        `json:"ip_address"`
+	CreatedAt            int64         `json:"created_at"`
+	LastUsedAt           int64         `json:"last_used_at"`
+	ExpiresAt            int64         `json:"expires_at"`
+	RevokedAt            sql.NullInt64 `json:"-"`
+	ImpersonatorID       int           `json:"-"`
 }
 
-type Booking struct {
-	ID          string      `json:"id"`
-	UserID      int         `json:"-"`
-	EventTypeID int         `json:"-"`
-	Title       string      `json:"title"`
-	Notes       string      `json:"notes"`
-	Name        string      `json:"name"`
-	Email       string      `json:"email"`
-	Date        int64       `json:"date"`
-	Time        int         `json:"time"`
-	Location    string      `json:"location"`
-	Event       []byte      `json:"-"`
-	EventDetail interface{} `json:"event_detail"`
+type UserIdentity struct {
+	ID        int
+	UserID    int
+	Provider  string
+	Subject   string
+	Email     string
+	CreatedAt int64
 }
 
-type LoginForm struct {
+type OIDCLoginForm struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	UserAgent     string
+	IPAddress     string
+}
+
+type LoginChallenge struct {
+	ID        int
+	UserID    int
+	Token     string
+	Attempts  int
+	CreatedAt int64
+	ExpiresAt int64
+	UsedAt    sql.NullInt64
+}
+
+type RecoveryCode struct {
+	ID     int
+	UserID int
+	Code   string
+}
+
+type PasswordReset struct {
+	ID        int
+	UserID    int
+	Token     string
+	CreatedAt int64
+	ExpiresAt int64
+	UsedAt    sql.NullInt64
+}
+
+const (
+	ScopeProfileRead      = "profile:read"
+	ScopeAvailabilityRead = "availability:read"
+	ScopeEventTypesRead   = "event_types:read"
+	ScopeEventTypesWrite  = "event_types:write"
+	ScopeBookingsRead     = "bookings:read"
+	ScopeCalendarRead     = "calendar:read"
+)
+
+var apiKeyScopes = []interface{}{
+	ScopeProfileRead, ScopeAvailabilityRead, ScopeEventTypesRead,
+	ScopeEventTypesWrite, ScopeBookingsRead, ScopeCalendarRead,
+}
+
+type APIKey struct {
+	ID         int      `json:"id"`
+	UserID     int      `json:"-"`
+	Name       string   `json:"name"`
+	Prefix     string   `json:"prefix"`
+	Key        string   `json:"key,omitempty"`
+	Scopes     []string `json:"scopes"`
+	CreatedAt  int64    `json:"created_at"`
+	LastUsedAt int64    `json:"last_used_at"`
+	ExpiresAt  int64    `json:"expires_at"`
+	RevokedAt  int64    `json:"revoked_at"`
+}
+
+type APIKeyForm struct {
+	Name          string   `json:"name" form:"name"`
+	Scopes        []string `json:"scopes" form:"scopes"`
+	ExpiresInDays int      `json:"expires_in_days" form:"expires_in_days"`
+}
+
+func (f *APIKeyForm) Validate() interface{} {
+	g := galidator.New()
+	return g.ComplexValidator(galidator.Rules{
+		"Name":          g.R("name").Required().Max(255),
+		"Scopes":        g.R("scopes").Required().Children(g.R().Choices(apiKeyScopes...)),
+		"ExpiresInDays": g.R("expires_in_days").Min(0).Max(365),
+	}).Validate(f)
+}
+
+// Webhook posts the booking events it subscribes to, signed with
+// its secret, to URL.
+type Webhook struct {
+	ID        int      `json:"id"`
+	UserID    int      `json:"-"`
+	URL       string   `json:"url"`
+	Secret    string   `json:"secret,omitempty"`
+	Events    []string `json:"events"`
+	CreatedAt int64    `json:"created_at"`
+}
+
+// WebhookDelivery is one event sent to a webhook, with the outcome
+// of its latest attempt.
+type WebhookDelivery struct {
+	ID             int             `json:"id"`
+	WebhookID      int             `json:"webhook_id"`
+	Webhook        *Webhook        `json:"-"`
+	Event          string          `json:"event"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	ResponseStatus int             `json:"response_status,omitempty"`
+	ResponseBody   string          `json:"response_body,omitempty"`
+	Error          string          `json:"error,omitempty"`
+	CreatedAt      int64           `json:"created_at"`
+	DeliveredAt    int64           `json:"delivered_at,omitempty"`
+}
+
+// Events webhooks subscribe to, webhook.test is only sent on request.
+const (
+	WebhookBookingCreated     = "booking.created"
+	WebhookBookingRescheduled = "booking.rescheduled"
+	WebhookBookingCancelled   = "booking.cancelled"
+	WebhookTest               = "webhook.test"
+)
+
+var webhookEvents = []interface{}{
+	WebhookBookingCreated, WebhookBookingRescheduled, WebhookBookingCancelled,
+}
+
+// Statuses of webhook deliveries, a delivery is failed once every
+// attempt went unanswered.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+type WebhookForm struct {
+	URL string `json:"url" form:"url"`
+	// Secret signs the deliveries, one is generated when left out
+	Secret string   `json:"secret" form:"secret"`
+	Events []string `json:"events" form:"events"`
+}
+
+func (f *WebhookForm) Validate() interface{} {
+	g := galidator.New()
+	return g.ComplexValidator(galidator.Rules{
+		"URL":    g.R("url").Required().Max(2048),
+		"Secret": g.R("secret").Max(255),
+		"Events": g.R("events").Required().Children(g.R().Choices(webhookEvents...)),
+	}).Validate(f)
+}
+
+// CalendarChannel delivers change notifications of the calendar a user
+// connected with Provider, Token proves a notification comes from it and
+// SyncToken is where the next sync continues from.
+type CalendarChannel struct {
+	ID         int    `json:"id"`
+	UserID     int    `json:"-"`
+	Provider   string `json:"provider"`
+	ChannelID  string `json:"channel_id"`
+	ResourceID string `json:"-"`
+	Token      string `json:"-"`
+	SyncToken  string `json:"-"`
+	ExpiresAt  int64  `json:"expires_at"`
+	CreatedAt  int64  `json:"created_at"`
+}
+
+// CalendarMirror keeps the events of the calendar a user connected with
+// Provider between WindowStart and WindowEnd in external_events, Cursor
+// is the sync token or delta link the next sync continues from.
+type CalendarMirror struct {
+	ID          int
+	UserID      int
+	Provider    string
+	Cursor      string
+	WindowStart int64
+	WindowEnd   int64
+	SyncedAt    int64
+	Error       string
+	CreatedAt   int64
+}
+
+// covers tells whether the mirror was synced and holds every event
+// between from and to.
+func (m *CalendarMirror) covers(from, to time.Time) bool {
+	return m != nil && m.SyncedAt > 0 &&
+		from.Unix() >= m.WindowStart && to.Unix() <= m.WindowEnd
+}
+
+// ExternalEvent is an event of a connected calendar as last synced.
+type ExternalEvent struct {
+	ID         string `json:"id"`
+	UserID     int    `json:"-"`
+	Provider   string `json:"provider"`
+	Title      string `json:"title"`
+	StartAt    int64  `json:"start_at"`
+	EndAt      int64  `json:"end_at"`
+	AllDay     bool   `json:"all_day"`
+	Busy       bool   `json:"busy"`
+	Link       string `json:"link,omitempty"`
+	MeetingURL string `json:"meeting_url,omitempty"`
+	UpdatedAt  int64  `json:"updated_at"`
+}
+
+type EventTypeForm struct {
+	Enable               int    `json:"enable" form:"enable"`
+	Title                string `json:"title" form:"title"`
+	Description          string `json:"description" form:"description"`
+	Duration             int    `json:"duration" form:"duration"`
+	Seats                int    `json:"seats" form:"seats"`
+	RecurringMax         int    `json:"recurring_max" form:"recurring_max"`
+	RequiresConfirmation int    `json:"requires_confirmation" form:"requires_confirmation"`
+	// Reminders are minutes before a booking, left out they are kept
+	Reminders []int `json:"reminders" form:"-"`
+}
+
+func (f *EventTypeForm) Validate() interface{} {
+	g := galidator.New()
+	return g.ComplexValidator(galidator.Rules{
+		"Enable":               g.R("enable").Choices(0, 1),
+		"Title":                g.R("title").Required().Max(255),
+		"Description":          g.R("description").Required().Max(255),
+		"Duration":             g.R("duration").Required().Min(5).Max(720),
+		"Seats":                g.R("seats").Min(0).Max(1000),
+		"RecurringMax":         g.R("recurring_max").Min(0).Max(maxOccurrences),
+		"RequiresConfirmation": g.R("requires_confirmation").Choices(0, 1),
+		"Reminders": g.R("reminders").Max(maxReminders).
+			Children(g.R().Min(minReminder).Max(maxReminder).SpecificMessages(reminderMessages)),
+	}).Validate(f)
+}
+
+type QuestionsForm struct {
+	Questions []*Question `json:"questions"`
+}
+
+func (f *QuestionsForm) Validate() interface{} {
+	g := galidator.New()
+	return g.ComplexValidator(galidator.Rules{
+		"Questions": g.R("questions").Max(maxQuestions).Children(g.R().Complex(galidator.Rules{
+			"Name": g.R("name").Required().Regex(questionNamePattern).
+				SpecificMessages(galidator.Messages{
+					"regex": "$field must be 1-32 characters, start with a letter " +
+						"and contain only lowercase letters, numbers or _",
+				}),
+			"Label":   g.R("label").Required().Max(255),
+			"Type":    g.R("type").Required().Choices(questionTypes...),
+			"Options": g.R("options").Max(50).Children(g.R().Required().Max(255)),
+		})),
+	}).Validate(f)
+}
+
+type TeamForm struct {
+	Name     string `json:"name" form:"name"`
+	Slug     string `json:"slug" form:"slug"`
+	Timezone string `json:"timezone" form:"timezone"`
+}
+
+func (f *TeamForm) Validate() interface{} {
+	g := galidator.New()
+	return g.ComplexValidator(galidator.Rules{
+		"Name": g.R("name").Required().Max(255),
+		"Slug": g.R("slug").Required().Regex(usernamePattern).
+			SpecificMessages(galidator.Messages{
+				"regex": "$field must be 3-32 characters, start with a letter " +
+					"and contain only lowercase letters, numbers, - or _",
+			}),
+		"Timezone": g.R("timezone").Required(),
+	}).Validate(f)
+}
+
+type TeamMemberForm struct {
 	Username string `json:"username" form:"username"`
-	Password string `json:"password" form:"password"`
+	Role     string `json:"role" form:"role"`
+}
+
+func (f *TeamMemberForm) Validate() interface{} {
+	g := galidator.New()
+	return g.ComplexValidator(galidator.Rules{
+		"Username": g.R("username").Required(),
+		"Role":     g.R("role").Choices(TeamRoleOwner, TeamRoleMember),
+	}).Validate(f)
+}
+
+type TeamEventTypeHostForm struct {
+	Username string `json:"username"`
+	Weight   int    `json:"weight"`
+}
+
+type TeamEventTypeForm struct {
+	Title          string `json:"title" form:"title"`
+	Description    string `json:"description" form:"description"`
+	Duration       int    `json:"duration" form:"duration"`
+	SchedulingType string `json:"scheduling_type" form:"scheduling_type"`
+	Seats          int    `json:"seats" form:"seats"`
+	RecurringMax   int    `json:"recurring_max" form:"recurring_max"`
+	// 1 keeps bookings pending until the host accepts
+	RequiresConfirmation int                      `json:"requires_confirmation" form:"requires_confirmation"`
+	Hosts                []*TeamEventTypeHostForm `json:"hosts" form:"-"`
+	Questions            []*Question              `json:"questions" form:"-"`
+	Reminders            []int                    `json:"reminders" form:"-"`
+}
+
+func (f *TeamEventTypeForm) Validate() interface{} {
+	g := galidator.New()
+	return g.ComplexValidator(galidator.Rules{
+		"Title":       g.R("title").Required().Max(255),
+		"Description": g.R("description").Required().Max(255),
+		"Duration":    g.R("duration").Required().Min(5).Max(720),
+		"SchedulingType": g.R("scheduling_type").Required().
+			Choices(SchedulingRoundRobin, SchedulingCollective),
+		"Seats":                g.R("seats").Min(0).Max(1000),
+		"RecurringMax":         g.R("recurring_max").Min(0).Max(maxOccurrences),
+		"RequiresConfirmation": g.R("requires_confirmation").Choices(0, 1),
+		"Reminders": g.R("reminders").Max(maxReminders).
+			Children(g.R().Min(minReminder).Max(maxReminder).SpecificMessages(reminderMessages)),
+	}).Validate(f)
+}
+
+type LoginForm struct {
+	Username  string `json:"username" form:"username"`
+	Password  string `json:"password" form:"password"`
+	UserAgent string `json:"-" form:"-"`
+	IPAddress string `json:"-" form:"-"`
 }
 
 func (f *LoginForm) Validate() interface{} {
@@ -75,8 +516,264 @@ func (f *LoginForm) Validate() interface{} {
 	}).Validate(f)
 }
 
+// Team roles, owners manage members and event types of the team.
+const (
+	TeamRoleOwner  = "owner"
+	TeamRoleMember = "member"
+)
+
+// Scheduling types of team event types, round-robin assigns each booking
+// to one free host favouring the least booked, collective needs every
+// host free and invites all of them.
+const (
+	SchedulingRoundRobin = "round_robin"
+	SchedulingCollective = "collective"
+)
+
+// Roles, admins manage every account, hosts own event types and take
+// bookings, members can only manage their own account.
+const (
+	RoleAdmin  = "admin"
+	RoleHost   = "host"
+	RoleMember = "member"
+)
+
+// Booking statuses, bookings of event types requiring confirmation are
+// pending until the host accepts or rejects them or they expire.
+const (
+	BookingConfirmed = "confirmed"
+	BookingPending   = "pending"
+	BookingRejected  = "rejected"
+	BookingExpired   = "expired"
+)
+
+// Sync statuses of the calendar event of a booking, created in the
+// background after the booking is stored.
+const (
+	SyncPending = "pending"
+	SyncSynced  = "synced"
+	SyncFailed  = "failed"
+)
+
+// Intervals of recurring bookings in weeks, a recurring booking
+// has at most maxOccurrences meetings.
+const (
+	RecurWeekly    = 1
+	RecurBiWeekly  = 2
+	maxOccurrences = 52
+)
+
+// Reminders are sent between minReminder minutes and maxReminder minutes
+// (a week) before a booking, new event types remind a day and an hour before.
+const (
+	maxReminders = 5
+	minReminder  = 5
+	maxReminder  = 10080
+)
+
+var (
+	defaultReminders = []int{1440, 60}
+	reminderMessages = galidator.Messages{
+		"min": "reminders are sent at least $min minutes before",
+		"max": "reminders are sent at most $max minutes before",
+	}
+)
+
+// Types of event type questions, checkbox answers are bools and
+// every other answer a string.
+const (
+	QuestionText     = "text"
+	QuestionLongText = "long_text"
+	QuestionSelect   = "select"
+	QuestionCheckbox = "checkbox"
+	QuestionPhone    = "phone"
+	maxQuestions     = 20
+)
+
+var questionTypes = []interface{}{
+	QuestionText, QuestionLongText, QuestionSelect, QuestionCheckbox, QuestionPhone,
+}
+
+const (
+	questionNamePattern = `^[a-z][a-z0-9_]{0,31}$`
+	phonePattern        = `^\+[1-9][0-9]{6,14}$`
+)
+
+const usernamePattern = `^[a-z][a-z0-9_-]{2,31}$`
+
+type LoginTOTPForm struct {
+	ChallengeToken string `json:"challenge_token" form:"challenge_token"`
+	Code           string `json:"code" form:"code"`
+	RecoveryCode   string `json:"recovery_code" form:"recovery_code"`
+	UserAgent      string `json:"-" form:"-"`
+	IPAddress      string `json:"-" form:"-"`
+}
+
+func (f *LoginTOTPForm) Validate() interface{} {
+	g := galidator.New()
+	return g.ComplexValidator(galidator.Rules{
+		"ChallengeToken": g.R("challenge_token").Required(),
+		"Code":           g.R("code").WhenNotExistAll("RecoveryCode"),
+		"RecoveryCode":   g.R("recovery_code").WhenNotExistAll("Code"),
+	}).Validate(f)
+}
+
+type TOTPCodeForm struct {
+	Code string `json:"code" form:"code"`
+}
+
+func (f *TOTPCodeForm) Validate() interface{} {
+	g := galidator.New()
+	return g.ComplexValidator(galidator.Rules{
+		"Code": g.R("code").Required().Len(6),
+	}).Validate(f)
+}
+
+type DisableTOTPForm struct {
+	Password string `json:"password" form:"password"`
+	Code     string `json:"code" form:"code"`
+}
+
+func (f *DisableTOTPForm) Validate() interface{} {
+	g := galidator.New()
+	return g.ComplexValidator(galidator.Rules{
+		"Code": g.R("code").Required().Len(6),
+	}).Validate(f)
+}
+
+type RefreshTokenForm struct {
+	RefreshToken string `json:"refresh_token" form:"refresh_token"`
+	UserAgent    string `json:"-" form:"-"`
+	IPAddress    string `json:"-" form:"-"`
+}
+
+func (f *RefreshTokenForm) Validate() interface{} {
+	g := galidator.New()
+	return g.ComplexValidator(galidator.Rules{
+		"RefreshToken": g.R("refresh_token").Required(),
+	}).Validate(f)
+}
+
+type RegisterForm struct {
+	Username             string `json:"username" form:"username"`
+	Email                string `json:"email" form:"email"`
+	Password             string `json:"password" form:"password"`
+	PasswordConfirmation string `json:"password_confirmation" form:"password_confirmation"`
+}
+
+func (f *RegisterForm) Validate() interface{} {
+	g := galidator.New()
+	return g.ComplexValidator(galidator.Rules{
+		"Username": g.R("username").Required().Regex(usernamePattern).
+			SpecificMessages(galidator.Messages{
+				"regex": "$field must be 3-32 characters, start with a letter " +
+					"and contain only lowercase letters, numbers, - or _",
+			}),
+		"Email":                g.R("email").Required().Email(),
+		"Password":             g.R("password").Required().Password(),
+		"PasswordConfirmation": g.R("password_confirmation").Required(),
+	}).Validate(f)
+}
+
+type NewUserForm struct {
+	Username string `json:"username" form:"username"`
+	Email    string `json:"email" form:"email"`
+	Password string `json:"password" form:"password"`
+	Role     string `json:"role" form:"role"`
+}
+
+func (f *NewUserForm) Validate() interface{} {
+	g := galidator.New()
+	return g.ComplexValidator(galidator.Rules{
+		"Username": g.R("username").Required().Regex(usernamePattern).
+			SpecificMessages(galidator.Messages{
+				"regex": "$field must be 3-32 characters, start with a letter " +
+					"and contain only lowercase letters, numbers, - or _",
+			}),
+		"Email":    g.R("email").Email(),
+		"Password": g.R("password").Required().Password(),
+		"Role":     g.R("role").Choices(RoleAdmin, RoleHost, RoleMember),
+	}).Validate(f)
+}
+
+type UpdateUserForm struct {
+	Role     string `json:"role" form:"role"`
+	Disabled *bool  `json:"disabled" form:"disabled"`
+}
+
+func (f *UpdateUserForm) Validate() interface{} {
+	g := galidator.New()
+	return g.ComplexValidator(galidator.Rules{
+		"Role": g.R("role").Choices(RoleAdmin, RoleHost, RoleMember),
+	}).Validate(f)
+}
+
+type ChangeEmailForm struct {
+	Email    string `json:"email" form:"email"`
+	Password string `json:"password" form:"password"`
+}
+
+func (f *ChangeEmailForm) Validate() interface{} {
+	g := galidator.New()
+	return g.ComplexValidator(galidator.Rules{
+		"Email": g.R("email").Required().Email(),
+	}).Validate(f)
+}
+
+type ChangePasswordForm struct {
+	CurrentPassword      string `json:"current_password" form:"current_password"`
+	NewPassword          string `json:"new_password" form:"new_password"`
+	PasswordConfirmation string `json:"password_confirmation" form:"password_confirmation"`
+	JTI                  string `json:"-" form:"-"` // session kept signed in
+}
+
+func (f *ChangePasswordForm) Validate() interface{} {
+	g := galidator.New()
+	return g.ComplexValidator(galidator.Rules{
+		"NewPassword":          g.R("new_password").Required().Password(),
+		"PasswordConfirmation": g.R("password_confirmation").Required(),
+	}).Validate(f)
+}
+
+type ForgotPasswordForm struct {
+	Email string `json:"email" form:"email"`
+}
+
+func (f *ForgotPasswordForm) Validate() interface{} {
+	g := galidator.New()
+	return g.ComplexValidator(galidator.Rules{
+		"Email": g.R("email").Required().Email(),
+	}).Validate(f)
+}
+
+type ResetPasswordForm struct {
+	Token                string `json:"token" form:"token"`
+	Password             string `json:"password" form:"password"`
+	PasswordConfirmation string `json:"password_confirmation" form:"password_confirmation"`
+}
+
+func (f *ResetPasswordForm) Validate() interface{} {
+	g := galidator.New()
+	return g.ComplexValidator(galidator.Rules{
+		"Token":                g.R("token").Required(),
+		"Password":             g.R("password").Required().Password(),
+		"PasswordConfirmation": g.R("password_confirmation").Required(),
+	}).Validate(f)
+}
+
+type DeleteAccountForm struct {
+	Password string `json:"password" form:"password"`
+}
+
+// Validate accepts an empty password, accounts created by sign in have
+// none to confirm with.
+func (f *DeleteAccountForm) Validate() interface{} {
+	return nil
+}
+
 type BookingForm struct {
 	Username        string `json:"username" form:"username"`
+	Team            string `json:"team" form:"team"`
 	EventTypeID     int    `json:"event_type_id" form:"event_type_id"`
 	Date            int64  `json:"date" form:"date"`
 	Time            int    `json:"time" form:"time"`
@@ -84,16 +781,86 @@ type BookingForm struct {
 	Email           string `json:"email" form:"email"`
 	Notes           string `json:"notes" form:"notes"`
 	MeetingLocation string `json:"meeting_location" form:"meeting_location"`
+	// RecurringCount meetings are booked every RecurringInterval weeks
+	RecurringInterval int `json:"recurring_interval" form:"recurring_interval"`
+	RecurringCount    int `json:"recurring_count" form:"recurring_count"`
+	// Answers to the questions of the event type keyed by question name
+	Answers map[string]interface{} `json:"answers" form:"-"`
 }
 
 func (f *BookingForm) Validate() interface{} {
 	g := galidator.New()
 	return g.ComplexValidator(galidator.Rules{
-		"Username":    g.R("username").Required(),
-		"EventTypeID": g.R("event_type_id").Required(),
-		"Date":        g.R("date").Required(),
-		"Time":        g.R("time").Required(),
-		"Name":        g.R("name").Required(),
-		"Email":       g.R("email").Required(),
+		"Username":          g.R("username").WhenNotExistAll("Team"),
+		"Team":              g.R("team").WhenNotExistAll("Username"),
+		"EventTypeID":       g.R("event_type_id").Required(),
+		"Date":              g.R("date").Required(),
+		"Time":              g.R("time").Required(),
+		"Name":              g.R("name").Required(),
+		"Email":             g.R("email").Required().Email(),
+		"RecurringInterval": g.R("recurring_interval").Choices(0, RecurWeekly, RecurBiWeekly),
+		"RecurringCount":    g.R("recurring_count").Min(0).Max(maxOccurrences),
+	}).Validate(f)
+}
+
+// ValidateAnswers checks the answers against questions, every question
+// is one galidator rule on the answer under its name.
+func (f *BookingForm) ValidateAnswers(questions []*Question) interface{} {
+	if len(questions) == 0 {
+		return nil
+	}
+	g := galidator.New()
+	rules := galidator.Rules{}
+	// galidator needs every validated key present in the map
+	answers := map[string]interface{}{}
+	for _, q := range questions {
+		answers[q.Name] = f.Answers[q.Name]
+		rule := g.R(q.Name)
+		if q.Required {
+			rule = rule.Required()
+		}
+		// a missing answer would fail every rule, only report it missing
+		if answers[q.Name] == nil {
+			rules[q.Name] = rule
+			continue
+		}
+		switch q.Type {
+		case QuestionText:
+			rule = rule.String().Max(255)
+		case QuestionLongText:
+			rule = rule.String().Max(5000)
+		case QuestionSelect:
+			choices := make([]interface{}, len(q.Options))
+			for i, option := range q.Options {
+				choices[i] = option
+			}
+			rule = rule.Choices(choices...)
+		case QuestionCheckbox:
+			rule = rule.Type(true)
+		case QuestionPhone:
+			rule = rule.Regex(phonePattern).SpecificMessages(galidator.Messages{
+				"regex": "$field must be a phone number in international format, e.g. +6281234567890",
+			})
+		}
+		rules[q.Name] = rule
+	}
+	return g.ComplexValidator(rules).Validate(answers)
+}
+
+type CancelBookingForm struct {
+	// Series cancels every remaining occurrence of a recurring booking
+	Series bool `json:"series" form:"series"`
+}
+
+type RescheduleBookingForm struct {
+	Date int64 `json:"date" form:"date"`
+	Time int   `json:"time" form:"time"`
+}
+
+func (f *RescheduleBookingForm) Validate() interface{} {
+	g := galidator.New()
+	return g.ComplexValidator(galidator.Rules{
+		"Date": g.R("date").Required(),
+		"Time": g.R("time").Required(),
 	}).Validate(f)
 }
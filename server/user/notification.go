@@ -0,0 +1,326 @@
+package user
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"text/template"
+	"time"
+
+	"github.com/0xForked/goca/server/hof"
+)
+
+// kinds of booking emails, each one has a subject and a text template
+const (
+	noticeConfirmed   = "confirmed"
+	noticeRescheduled = "rescheduled"
+	noticeCancelled   = "cancelled"
+	noticeReminder    = "reminder"
+)
+
+// jobBookingReminder is the job kind sending one reminder of a booking.
+const jobBookingReminder = "booking.reminder"
+
+// jobBookingMail is the job kind delivering one rendered booking email,
+// so a slow mail server never holds up the request changing the booking.
+const jobBookingMail = "booking.mail"
+
+const bookingMailTemplates = `
+{{- define "confirmed.subject"}}Confirmed: {{.Title}}{{end}}
+{{- define "confirmed.text"}}{{.Title}} is confirmed.
+
+{{template "details" .}}
+The attached invite adds the meeting to your calendar.
+{{end}}
+{{- define "rescheduled.subject"}}Rescheduled: {{.Title}}{{end}}
+{{- define "rescheduled.text"}}{{.Title}} has been moved, it was planned for {{.Previous.Format "Monday, 2 January 2006 15:04"}}.
+
+{{template "details" .}}
+The attached invite updates the meeting in your calendar.
+{{end}}
+{{- define "cancelled.subject"}}Cancelled: {{.Title}}{{end}}
+{{- define "cancelled.text"}}
+{{- if .Occurrence}}The meeting of {{.Title}} on {{.When}} has been cancelled.
+{{- else if not .Until.IsZero}}The meetings of {{.Title}} after {{.Until.Format "Monday, 2 January 2006 15:04"}} have been cancelled.
+{{- else}}{{.Title}} has been cancelled.{{end}}
+{{- if .Reason}} {{.Reason}}{{end}}
+
+{{template "details" .}}
+The attached update removes the meeting from your calendar.
+{{end}}
+{{- define "reminder.subject"}}Reminder: {{.Title}} starts in {{.StartsIn}}{{end}}
+{{- define "reminder.text"}}{{.Title}} starts in {{.StartsIn}}.
+
+{{template "details" .}}{{end}}
+{{- define "details"}}When: {{.When}} ({{.Timezone}})
+{{if and .Repeats (not .Occurrence)}}Repeats: {{.Repeats}}
+{{end}}Host: {{.Host}}
+Invitee: {{.Invitee}}
+{{if .JoinURL}}Join: {{.JoinURL}}
+{{end}}{{if .Details}}
+{{.Details}}
+{{end}}{{end}}`
+
+var bookingMail = template.Must(template.New("booking").Parse(bookingMailTemplates))
+
+// bookingNotice is what a booking email is rendered from, callers set
+// the kind of change, the rest is filled from the booking.
+type bookingNotice struct {
+	Kind string
+	// Occurrence limits a cancellation to one meeting of a series
+	Occurrence bool
+	// Until is the start of the last meeting a cancelled series keeps
+	Until time.Time
+	// Previous is the start of a rescheduled booking before it moved
+	Previous time.Time
+	Reason   string
+	// StartsIn is how long before the meeting a reminder is sent
+	StartsIn string
+
+	Title    string
+	When     string
+	Timezone string
+	Repeats  string
+	Host     string
+	Invitee  string
+	JoinURL  string
+	Details  string
+}
+
+// noticeTimeLayout is how meeting times read in emails, the templates
+// use the same layout.
+const noticeTimeLayout = "Monday, 2 January 2006 15:04"
+
+// notifyBooking emails the invitee and every host of booking about the
+// change in notice, the email is delivered by a job. The booking already
+// changed, so failures are only logged.
+func (s service) notifyBooking(
+	ctx context.Context,
+	target *BookingTarget,
+	booking *Booking,
+	notice *bookingNotice,
+) {
+	msg, err := bookingMessage(target, booking, notice)
+	if err == nil {
+		err = s.jobs.Schedule(ctx, jobBookingMail, msg, time.Now())
+	}
+	if err != nil {
+		log.Printf("unable to send booking %s email for booking %s: %s",
+			notice.Kind, booking.ID, err)
+	}
+}
+
+// SendBookingMail runs a booking email job.
+func (s service) SendBookingMail(ctx context.Context, payload []byte) error {
+	var msg hof.MailMessage
+	if err := json.Unmarshal(payload, &msg); err != nil {
+		return err
+	}
+	return s.mailer.Send(ctx, &msg)
+}
+
+// bookingMessage renders notice into an email to the invitee and every
+// host of booking, changes of the booking carry a calendar invite.
+func bookingMessage(
+	target *BookingTarget,
+	booking *Booking,
+	notice *bookingNotice,
+) (*hof.MailMessage, error) {
+	loc, err := time.LoadLocation(target.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	invite := bookingInvite(target, booking, notice.Occurrence)
+	notice.Title = invite.Summary
+	notice.When = fmt.Sprintf("%s - %s", invite.Start.In(loc).Format(noticeTimeLayout),
+		invite.End.In(loc).Format("15:04"))
+	notice.Timezone = loc.String()
+	if !notice.Previous.IsZero() {
+		notice.Previous = notice.Previous.In(loc)
+	}
+	if !notice.Until.IsZero() {
+		notice.Until = notice.Until.In(loc)
+	}
+	if booking.Recurrence != "" && len(target.Occurrences) > 1 {
+		notice.Repeats = recurrenceText(target.Interval, len(target.Occurrences))
+	}
+	notice.Host = target.Host.Username
+	notice.Invitee = booking.Name
+	notice.JoinURL = joinURL(booking.Event)
+	notice.Details = bookingDescription(booking.Notes, booking.Answers)
+	var subject, text bytes.Buffer
+	if err := bookingMail.ExecuteTemplate(&subject, notice.Kind+".subject", notice); err != nil {
+		return nil, err
+	}
+	if err := bookingMail.ExecuteTemplate(&text, notice.Kind+".text", notice); err != nil {
+		return nil, err
+	}
+	to := []string{booking.Email}
+	for _, host := range append([]*User{target.Host}, target.CoHosts...) {
+		if host.Email != "" {
+			to = append(to, host.Email)
+		}
+	}
+	msg := &hof.MailMessage{To: to, Subject: subject.String(), Text: text.String()}
+	if notice.Kind != noticeReminder {
+		method := hof.ICSMethodRequest
+		// a series ending early is updated with its shorter recurrence
+		if notice.Kind == noticeCancelled && notice.Until.IsZero() {
+			method = hof.ICSMethodCancel
+		}
+		msg.Attachments = []hof.MailAttachment{{
+			Name:        "invite.ics",
+			ContentType: "text/calendar; charset=utf-8; method=" + method,
+			Data:        hof.ComposeICS(method, invite),
+		}}
+	}
+	return msg, nil
+}
+
+// reminderJob is the payload of a reminder job, a booking that no longer
+// starts at StartAt moved and has reminders of its own.
+type reminderJob struct {
+	BookingID int   `json:"booking_id"`
+	StartAt   int64 `json:"start_at"`
+	Minutes   int   `json:"minutes"`
+}
+
+// scheduleReminders schedules the reminders of eventType for every booking,
+// reminders due already are left out. Failures are only logged.
+func (s service) scheduleReminders(
+	ctx context.Context,
+	eventType *EventType,
+	bookings []*Booking,
+) {
+	now := time.Now()
+	for _, booking := range bookings {
+		id, err := strconv.Atoi(booking.ID)
+		if err != nil || booking.CancelledAt > 0 {
+			continue
+		}
+		for _, minutes := range eventType.Reminders {
+			runAt := time.Unix(booking.StartAt, 0).Add(-time.Duration(minutes) * time.Minute)
+			if !runAt.After(now) {
+				continue
+			}
+			if err := s.jobs.Schedule(ctx, jobBookingReminder, &reminderJob{
+				BookingID: id,
+				StartAt:   booking.StartAt,
+				Minutes:   minutes,
+			}, runAt); err != nil {
+				log.Printf("unable to schedule reminder of booking %d: %s", id, err)
+			}
+		}
+	}
+}
+
+// SendBookingReminder runs a reminder job, reminders of bookings that
+// were cancelled, moved or are not confirmed are dropped.
+func (s service) SendBookingReminder(ctx context.Context, payload []byte) error {
+	var job reminderJob
+	if err := json.Unmarshal(payload, &job); err != nil {
+		return err
+	}
+	booking, err := s.repository.FindBooking(ctx, job.BookingID)
+	if err != nil {
+		return err
+	}
+	if booking.CancelledAt > 0 || booking.Status != BookingConfirmed ||
+		booking.StartAt != job.StartAt || booking.StartAt <= time.Now().Unix() {
+		return nil
+	}
+	target, err := s.bookedTarget(ctx, booking)
+	if err != nil {
+		return err
+	}
+	msg, err := bookingMessage(target, booking, &bookingNotice{
+		Kind:       noticeReminder,
+		Occurrence: true,
+		StartsIn:   reminderText(job.Minutes),
+	})
+	if err != nil {
+		return err
+	}
+	return s.mailer.Send(ctx, msg)
+}
+
+// reminderText describes minutes as the largest whole unit, e.g. 2 days.
+func reminderText(minutes int) string {
+	n, unit := minutes, "minute"
+	switch {
+	case minutes%1440 == 0 && minutes > 1440:
+		n, unit = minutes/1440, "day"
+	case minutes%60 == 0:
+		n, unit = minutes/60, "hour"
+	}
+	if n == 1 {
+		return "1 " + unit
+	}
+	return fmt.Sprintf("%d %ss", n, unit)
+}
+
+// bookingInvite returns the calendar event of booking, the uid stays the
+// same for every version of it so calendar clients update it in place. A
+// series is one event repeating, unless only occurrence is meant.
+func bookingInvite(target *BookingTarget, booking *Booking, occurrence bool) *hof.ICSEvent {
+	uid := booking.ID
+	if booking.SeriesID > 0 {
+		uid = fmt.Sprint(booking.SeriesID)
+	}
+	title := booking.Title
+	if title == "" {
+		title = fmt.Sprintf("%s between %s and %s",
+			target.EventType.Title, target.Host.Username, booking.Name)
+	}
+	invite := &hof.ICSEvent{
+		UID:            fmt.Sprintf("booking-%s@goca", uid),
+		Sequence:       booking.Sequence,
+		Summary:        title,
+		Description:    bookingDescription(booking.Notes, booking.Answers),
+		Location:       joinURL(booking.Event),
+		Start:          time.Unix(booking.StartAt, 0),
+		End:            time.Unix(booking.EndAt, 0),
+		OrganizerName:  target.Host.Username,
+		OrganizerEmail: target.Host.Email,
+		Attendees:      []hof.ICSAttendee{{Name: booking.Name, Email: booking.Email}},
+	}
+	for _, coHost := range target.CoHosts {
+		invite.Attendees = append(invite.Attendees,
+			hof.ICSAttendee{Name: coHost.Username, Email: coHost.Email})
+	}
+	if booking.SeriesID > 0 && occurrence {
+		invite.RecurrenceID = invite.Start
+	} else if booking.SeriesID > 0 {
+		invite.Start, invite.End = target.StartAt, target.EndAt
+		invite.RRule = booking.Recurrence
+	}
+	return invite
+}
+
+// recurrenceText describes count meetings every interval weeks.
+func recurrenceText(interval, count int) string {
+	every := "every week"
+	if interval > 1 {
+		every = fmt.Sprintf("every %d weeks", interval)
+	}
+	return fmt.Sprintf("%s, %d times", every, count)
+}
+
+// joinURL returns the link to join the online meeting of the calendar
+// event of a booking, empty when it has none.
+func joinURL(event []byte) string {
+	var link struct {
+		HangoutLink   string `json:"hangoutLink"`
+		OnlineMeeting *struct {
+			JoinURL string `json:"joinUrl"`
+		} `json:"onlineMeeting"`
+	}
+	_ = json.Unmarshal(event, &link)
+	if link.OnlineMeeting != nil && link.OnlineMeeting.JoinURL != "" {
+		return link.OnlineMeeting.JoinURL
+	}
+	return link.HangoutLink
+}
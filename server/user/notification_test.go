@@ -0,0 +1,60 @@
+package user
+
+import (
+	"testing"
+	"time"
+
+	"github.com/0xForked/goca/server/hof"
+)
+
+func TestBookingInviteKeepsUID(t *testing.T) {
+	start := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	target := &BookingTarget{
+		Host:      &User{Username: "mentor", Email: "mentor@example.com"},
+		EventType: &EventType{Title: "Intro"},
+		StartAt:   start,
+		EndAt:     start.Add(30 * time.Minute),
+	}
+	single := &Booking{ID: "7", Name: "Ann", Email: "ann@example.com",
+		StartAt: start.Unix(), EndAt: start.Add(30 * time.Minute).Unix()}
+	second := start.AddDate(0, 0, 7)
+	occurrence := &Booking{ID: "9", SeriesID: 8, Sequence: 2, Name: "Ann", Email: "ann@example.com",
+		StartAt: second.Unix(), EndAt: second.Add(30 * time.Minute).Unix(),
+		Recurrence: hof.WeeklyRule(1, 3)}
+	tests := []struct {
+		name         string
+		booking      *Booking
+		occurrence   bool
+		wantUID      string
+		wantStart    time.Time
+		wantRRule    string
+		wantRecurID  time.Time
+		wantSequence int
+	}{
+		{name: "single booking", booking: single, wantUID: "booking-7@goca", wantStart: start},
+		{name: "whole series", booking: occurrence, wantUID: "booking-8@goca", wantStart: start,
+			wantRRule: "FREQ=WEEKLY;INTERVAL=1;COUNT=3", wantSequence: 2},
+		{name: "one occurrence", booking: occurrence, occurrence: true, wantUID: "booking-8@goca",
+			wantStart: second, wantRecurID: second, wantSequence: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			invite := bookingInvite(target, tt.booking, tt.occurrence)
+			if invite.UID != tt.wantUID {
+				t.Errorf("UID = %q, want %q", invite.UID, tt.wantUID)
+			}
+			if !invite.Start.Equal(tt.wantStart) {
+				t.Errorf("Start = %s, want %s", invite.Start, tt.wantStart)
+			}
+			if invite.RRule != tt.wantRRule {
+				t.Errorf("RRule = %q, want %q", invite.RRule, tt.wantRRule)
+			}
+			if !invite.RecurrenceID.Equal(tt.wantRecurID) {
+				t.Errorf("RecurrenceID = %s, want %s", invite.RecurrenceID, tt.wantRecurID)
+			}
+			if invite.Sequence != tt.wantSequence {
+				t.Errorf("Sequence = %d, want %d", invite.Sequence, tt.wantSequence)
+			}
+		})
+	}
+}
@@ -3,15 +3,29 @@ package user
 import (
 	"database/sql"
 
+	"github.com/0xForked/goca/server/hof"
 	"github.com/gin-gonic/gin"
 )
 
 func NewUserModuleProvider(
 	rg *gin.RouterGroup,
 	db *sql.DB,
+	jobs hof.IJobScheduler,
 ) {
 	repo := newSQLRepository(db)
-	svc := newUserService(repo)
-	newUserHandler(svc, rg)
-	newBookingHandler(svc, rg)
+	svc := newUserService(repo, hof.GetMailSender(), jobs)
+	jobs.Handle(jobBookingReminder, svc.SendBookingReminder)
+	jobs.Handle(jobBookingMail, svc.SendBookingMail)
+	jobs.Handle(jobWebhookDelivery, svc.DeliverWebhook)
+	jobs.Handle(jobBookingEvent, svc.SyncBookingEvent)
+	jobs.Handle(jobGoogleSync, svc.SyncGoogleChannel)
+	jobs.Handle(jobGoogleRenew, svc.RenewGoogleChannel)
+	jobs.Handle(jobMicrosoftSync, svc.SyncMicrosoftEvent)
+	jobs.Handle(jobMicrosoftRenew, svc.RenewMicrosoftChannel)
+	jobs.Handle(jobCalendarMirror, svc.MirrorCalendar)
+	limiter := hof.GetRateLimitStore(db)
+	newUserHandler(svc, rg, limiter)
+	newBookingHandler(svc, rg, limiter)
+	newTeamHandler(svc, rg)
+	newAdminHandler(svc, rg)
 }
@@ -0,0 +1,60 @@
+package user
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// checkQuestions reports what galidator rules can not express, names
+// must be unique and select questions need options to choose from.
+func checkQuestions(questions []*Question) error {
+	names := map[string]bool{}
+	for _, q := range questions {
+		if names[q.Name] {
+			return fmt.Errorf("question name %s is used twice", q.Name)
+		}
+		names[q.Name] = true
+		if q.Type == QuestionSelect && len(q.Options) == 0 {
+			return fmt.Errorf("select question %s has no options", q.Name)
+		}
+		if q.Type != QuestionSelect && len(q.Options) > 0 {
+			return errors.New("only select questions have options")
+		}
+	}
+	return nil
+}
+
+// bookingAnswers returns the answers of the questions in question order,
+// unanswered questions and answers to unknown questions are left out.
+func bookingAnswers(questions []*Question, values map[string]interface{}) []*Answer {
+	var answers []*Answer
+	for _, q := range questions {
+		value, ok := values[q.Name]
+		if !ok || value == nil || value == "" {
+			continue
+		}
+		answers = append(answers, &Answer{Name: q.Name, Label: q.Label, Value: value})
+	}
+	return answers
+}
+
+// bookingDescription is the description of the calendar event of a
+// booking, the notes of the invitee followed by every answer.
+func bookingDescription(notes string, answers []*Answer) string {
+	var lines []string
+	if notes != "" {
+		lines = append(lines, notes, "")
+	}
+	for _, answer := range answers {
+		value := fmt.Sprint(answer.Value)
+		if checked, ok := answer.Value.(bool); ok {
+			value = "No"
+			if checked {
+				value = "Yes"
+			}
+		}
+		lines = append(lines, fmt.Sprintf("%s: %s", answer.Label, value))
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
@@ -7,14 +7,210 @@ import (
 	"errors"
 	"fmt"
 	"sort"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/0xForked/goca/server/hof"
 )
 
+// errNotFound is wrapped by the lookups whose callers tell a missing row
+// from a failing query.
+var errNotFound = errors.New("not found")
+
+// errSlotTaken is returned when another booking of a host took the
+// slot between the availability check and the insert.
+var errSlotTaken = errors.New("the selected time is already booked")
+
+// errNoSeatsLeft is returned when the seats of a slot were taken between
+// the seat lookup and the insert.
+var errNoSeatsLeft = errors.New("no seats left at the selected time")
+
+// errNotPending is returned when a pending booking was answered, or
+// expired, before the change to it was stored.
+var errNotPending = errors.New("booking is no longer pending")
+
 type ISQLRepository interface {
 	FindUserProfile(
 		ctx context.Context,
 		username string,
 	) (*User, error)
+	FindUserByID(
+		ctx context.Context,
+		uid int,
+	) (*User, error)
+	FindUserByEmail(
+		ctx context.Context,
+		email string,
+	) (*User, error)
+	IsUsernameExist(
+		ctx context.Context,
+		username string,
+	) (bool, error)
+	IsEmailExist(
+		ctx context.Context,
+		email string,
+	) (bool, error)
+	InsertUser(
+		ctx context.Context,
+		user *User,
+	) (int, error)
+	UpdateUserEmail(
+		ctx context.Context,
+		uid int,
+		email string,
+	) error
+	UpdateUserPassword(
+		ctx context.Context,
+		uid int,
+		password, keepJTI string,
+	) error
+	UpdateUserLoginFailures(
+		ctx context.Context,
+		uid int,
+		attempts int,
+		lockedUntil int64,
+	) error
+	IncrementUserLoginFailures(
+		ctx context.Context,
+		uid int,
+	) (int, error)
+	LockUser(
+		ctx context.Context,
+		uid int,
+		lockedUntil int64,
+	) error
+	DeleteUser(
+		ctx context.Context,
+		uid int,
+	) error
+	FindUsers(
+		ctx context.Context,
+	) ([]*User, error)
+	UpdateUserRole(
+		ctx context.Context,
+		uid int,
+		role string,
+	) error
+	UpdateUserDisabled(
+		ctx context.Context,
+		uid int,
+		disabledAt int64,
+	) error
+	InsertSession(
+		ctx context.Context,
+		session *Session,
+	) (int, error)
+	FindSessionByJTI(
+		ctx context.Context,
+		jti string,
+	) (*Session, error)
+	FindSessionByRefreshToken(
+		ctx context.Context,
+		refreshToken string,
+	) (*Session, error)
+	RotateSession(
+		ctx context.Context,
+		session *Session,
+	) error
+	RevokeSession(
+		ctx context.Context,
+		sid int,
+	) error
+	RevokeUserSessions(
+		ctx context.Context,
+		uid int,
+	) error
+	FindUserIdentity(
+		ctx context.Context,
+		provider, subject string,
+	) (*UserIdentity, error)
+	InsertUserIdentity(
+		ctx context.Context,
+		identity *UserIdentity,
+	) (int, error)
+	SetUserTOTPSecret(
+		ctx context.Context,
+		uid int,
+		secret string,
+	) error
+	EnableUserTOTP(
+		ctx context.Context,
+		uid int,
+		step int64,
+		recoveryCodes []string,
+	) error
+	DisableUserTOTP(
+		ctx context.Context,
+		uid int,
+	) error
+	UpdateUserTOTPStep(
+		ctx context.Context,
+		uid int,
+		step int64,
+	) error
+	ReplaceRecoveryCodes(
+		ctx context.Context,
+		uid int,
+		recoveryCodes []string,
+	) error
+	FindUnusedRecoveryCodes(
+		ctx context.Context,
+		uid int,
+	) ([]*RecoveryCode, error)
+	UseRecoveryCode(
+		ctx context.Context,
+		id int,
+	) error
+	InsertLoginChallenge(
+		ctx context.Context,
+		challenge *LoginChallenge,
+	) (int, error)
+	FindLoginChallenge(
+		ctx context.Context,
+		token string,
+	) (*LoginChallenge, error)
+	IncrementLoginChallengeAttempts(
+		ctx context.Context,
+		id int,
+	) error
+	UseLoginChallenge(
+		ctx context.Context,
+		id int,
+	) error
+	InsertAPIKey(
+		ctx context.Context,
+		key *APIKey,
+	) (int, error)
+	FindUserAPIKeys(
+		ctx context.Context,
+		uid int,
+	) ([]*APIKey, error)
+	FindAPIKey(
+		ctx context.Context,
+		key string,
+	) (*APIKey, error)
+	RevokeAPIKey(
+		ctx context.Context,
+		uid, id int,
+	) error
+	TouchAPIKey(
+		ctx context.Context,
+		id int,
+	) error
+	InsertPasswordReset(
+		ctx context.Context,
+		reset *PasswordReset,
+	) (int, error)
+	FindPasswordReset(
+		ctx context.Context,
+		token string,
+	) (*PasswordReset, error)
+	ResetPassword(
+		ctx context.Context,
+		reset *PasswordReset,
+		password string,
+	) error
 	FindUserAvailability(
 		ctx context.Context,
 		uid int,
@@ -23,213 +219,2562 @@ type ISQLRepository interface {
 		ctx context.Context,
 		uid int,
 	) ([]*EventType, error)
+	FindEventType(
+		ctx context.Context,
+		id int,
+	) (*EventType, error)
+	FindTeamEventTypes(
+		ctx context.Context,
+		teamID int,
+	) ([]*EventType, error)
+	FindEventTypeHosts(
+		ctx context.Context,
+		eventTypeID int,
+	) ([]*EventTypeHost, error)
+	InsertTeamEventType(
+		ctx context.Context,
+		eventType *EventType,
+	) (int, error)
+	FindHostBookingStats(
+		ctx context.Context,
+		eventTypeID int,
+	) (map[int]*hostStat, error)
+	FindUserBusyBookings(
+		ctx context.Context,
+		uid int,
+		from, to int64,
+	) ([]hof.BusyTime, error)
+	InsertTeam(
+		ctx context.Context,
+		team *Team,
+		ownerID int,
+	) (int, error)
+	FindTeam(
+		ctx context.Context,
+		id int,
+	) (*Team, error)
+	FindTeamBySlug(
+		ctx context.Context,
+		slug string,
+	) (*Team, error)
+	IsTeamSlugExist(
+		ctx context.Context,
+		slug string,
+	) (bool, error)
+	FindUserTeams(
+		ctx context.Context,
+		uid int,
+	) ([]*Team, error)
+	FindTeamMembers(
+		ctx context.Context,
+		teamID int,
+	) ([]*TeamMember, error)
+	InsertTeamMember(
+		ctx context.Context,
+		member *TeamMember,
+	) (int, error)
+	DeleteTeamMember(
+		ctx context.Context,
+		teamID, uid int,
+	) error
 	UpdateUser(
 		ctx context.Context,
 		username string,
 		tokenType string,
 		tokenValue []byte,
 	) error
+	UpdateEventType(
+		ctx context.Context,
+		eventType *EventType,
+	) error
+	UpdateEventTypeQuestions(
+		ctx context.Context,
+		eventType *EventType,
+	) error
 	FindBooking(
 		ctx context.Context,
 		bookingID int,
 	) (*Booking, error)
+	FindUserBookings(
+		ctx context.Context,
+		uid int,
+	) ([]*Booking, error)
+	FindAllBookings(
+		ctx context.Context,
+	) ([]*Booking, error)
 	InsertBooking(
 		ctx context.Context,
 		booking *Booking,
 	) (int, error)
+	InsertBookingSeries(
+		ctx context.Context,
+		bookings []*Booking,
+	) (int, error)
+	CancelBookings(
+		ctx context.Context,
+		booking *Booking,
+		series bool,
+	) error
+	FindBookingCoHosts(
+		ctx context.Context,
+		bookingID int,
+	) ([]int, error)
+	FindSeriesBookings(
+		ctx context.Context,
+		seriesID int,
+	) ([]*Booking, error)
+	ConfirmBookings(
+		ctx context.Context,
+		booking *Booking,
+	) error
+	UpdatePendingBookingsStatus(
+		ctx context.Context,
+		booking *Booking,
+		status string,
+	) error
+	ExpireBookings(
+		ctx context.Context,
+		now int64,
+	) error
+	RescheduleBooking(
+		ctx context.Context,
+		booking *Booking,
+	) error
+	UpdateBookingSync(
+		ctx context.Context,
+		booking *Booking,
+	) error
+	FindEventBookingIDs(
+		ctx context.Context,
+		uid int,
+		location, eventID string,
+	) ([]int, error)
+	InsertCalendarChannel(
+		ctx context.Context,
+		channel *CalendarChannel,
+	) (int, error)
+	FindCalendarChannel(
+		ctx context.Context,
+		channelID string,
+	) (*CalendarChannel, error)
+	FindUserCalendarChannels(
+		ctx context.Context,
+		uid int,
+		provider string,
+	) ([]*CalendarChannel, error)
+	UpdateCalendarChannelSyncToken(
+		ctx context.Context,
+		id int,
+		syncToken string,
+	) error
+	UpdateCalendarChannelExpiry(
+		ctx context.Context,
+		id int,
+		expiresAt int64,
+	) error
+	DeleteCalendarChannel(
+		ctx context.Context,
+		id int,
+	) error
+	InsertCalendarMirror(
+		ctx context.Context,
+		mirror *CalendarMirror,
+	) (int, error)
+	FindCalendarMirror(
+		ctx context.Context,
+		id int,
+	) (*CalendarMirror, error)
+	FindUserCalendarMirror(
+		ctx context.Context,
+		uid int,
+		provider string,
+	) (*CalendarMirror, error)
+	SaveExternalEvents(
+		ctx context.Context,
+		mirror *CalendarMirror,
+		full bool,
+		events []*ExternalEvent,
+		removed []string,
+	) error
+	UpdateCalendarMirrorError(
+		ctx context.Context,
+		id int,
+		syncErr string,
+	) error
+	DeleteCalendarMirror(
+		ctx context.Context,
+		id int,
+	) error
+	FindExternalEvents(
+		ctx context.Context,
+		uid int,
+		provider string,
+		from, to int64,
+		limit int,
+	) ([]*ExternalEvent, error)
+	FindExternalBusyTimes(
+		ctx context.Context,
+		uid int,
+		provider string,
+		from, to int64,
+	) ([]hof.BusyTime, error)
+	InsertWebhook(
+		ctx context.Context,
+		webhook *Webhook,
+	) (int, error)
+	FindUserWebhooks(
+		ctx context.Context,
+		uid int,
+	) ([]*Webhook, error)
+	FindUserWebhook(
+		ctx context.Context,
+		uid, id int,
+	) (*Webhook, error)
+	DeleteWebhook(
+		ctx context.Context,
+		uid, id int,
+	) error
+	InsertWebhookDelivery(
+		ctx context.Context,
+		delivery *WebhookDelivery,
+	) (int, error)
+	FindWebhookDelivery(
+		ctx context.Context,
+		id int,
+	) (*WebhookDelivery, error)
+	FindWebhookDeliveries(
+		ctx context.Context,
+		webhookID int,
+	) ([]*WebhookDelivery, error)
+	UpdateWebhookDelivery(
+		ctx context.Context,
+		delivery *WebhookDelivery,
+	) error
+	FindSeatBooking(
+		ctx context.Context,
+		eventTypeID int,
+		startAt int64,
+	) (*Booking, error)
+	FindSeatBookings(
+		ctx context.Context,
+		eventTypeID int,
+		from, to int64,
+	) ([]*Booking, error)
+}
+
+type sqlRepository struct {
+	db *sql.DB
+}
+
+const userColumns = "id, username, COALESCE(email, ''), password, " +
+	"google_token, microsoft_token, role, totp_secret, " +
+	"totp_enabled_at IS NOT NULL, COALESCE(totp_last_step, 0), " +
+	"failed_login_attempts, COALESCE(locked_until, 0), COALESCE(disabled_at, 0), " +
+	"created_at"
+
+func scanUser(row *sql.Row, notFound error) (*User, error) {
+	var user User
+	err := row.Scan(&user.ID, &user.Username, &user.Email, &user.Password,
+		&user.GoogleToken, &user.MicrosoftToken, &user.Role,
+		&user.TOTPSecret, &user.TOTPEnabled, &user.TOTPLastStep,
+		&user.FailedLogins, &user.LockedUntil, &user.DisabledAt, &user.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, notFound
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) FindUserProfile(
+	ctx context.Context,
+	username string,
+) (*User, error) {
+	q := "SELECT " + userColumns + " FROM users WHERE username = ?"
+	return scanUser(s.db.QueryRowContext(ctx, q, username), fmt.Errorf(
+		"account with username %s not found", username))
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) FindUserByID(
+	ctx context.Context,
+	uid int,
+) (*User, error) {
+	q := "SELECT " + userColumns + " FROM users WHERE id = ?"
+	return scanUser(s.db.QueryRowContext(ctx, q, uid), fmt.Errorf(
+		"account with id %d not found", uid))
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) FindUserByEmail(
+	ctx context.Context,
+	email string,
+) (*User, error) {
+	q := "SELECT " + userColumns + " FROM users WHERE email = ?"
+	return scanUser(s.db.QueryRowContext(ctx, q, email), fmt.Errorf(
+		"account with email %s %w", email, errNotFound))
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) IsUsernameExist(
+	ctx context.Context,
+	username string,
+) (bool, error) {
+	q := "SELECT EXISTS (SELECT 1 FROM users WHERE username = ?)"
+	row := s.db.QueryRowContext(ctx, q, username)
+	var exist bool
+	if err := row.Scan(&exist); err != nil {
+		return false, err
+	}
+	return exist, nil
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) IsEmailExist(
+	ctx context.Context,
+	email string,
+) (bool, error) {
+	q := "SELECT EXISTS (SELECT 1 FROM users WHERE email = ?)"
+	row := s.db.QueryRowContext(ctx, q, email)
+	var exist bool
+	if err := row.Scan(&exist); err != nil {
+		return false, err
+	}
+	return exist, nil
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) InsertUser(
+	ctx context.Context,
+	user *User,
+) (int, error) {
+	q := "INSERT INTO users (username, email, password, role, created_at) "
+	q += "VALUES ($1, NULLIF($2, ''), $3, $4, $5) RETURNING id"
+	row := s.db.QueryRowContext(ctx, q, user.Username, user.Email,
+		user.Password, user.Role, time.Now().Unix())
+	var id int
+	if err := row.Scan(&id); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) UpdateUserEmail(
+	ctx context.Context,
+	uid int,
+	email string,
+) error {
+	q := "UPDATE users SET email = ? WHERE id = ?"
+	_, err := s.db.ExecContext(ctx, q, email, uid)
+	return err
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) UpdateUserPassword(
+	ctx context.Context,
+	uid int,
+	password, keepJTI string,
+) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	q := "UPDATE users SET password = ? WHERE id = ?"
+	if _, err := tx.ExecContext(ctx, q, password, uid); err != nil {
+		return err
+	}
+	q = "UPDATE sessions SET revoked_at = ? WHERE user_id = ? AND jti != ? "
+	q += "AND revoked_at IS NULL"
+	if _, err := tx.ExecContext(ctx, q, time.Now().Unix(), uid, keepJTI); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) UpdateUserLoginFailures(
+	ctx context.Context,
+	uid int,
+	attempts int,
+	lockedUntil int64,
+) error {
+	q := "UPDATE users SET failed_login_attempts = ?, locked_until = NULLIF(?, 0) WHERE id = ?"
+	_, err := s.db.ExecContext(ctx, q, attempts, lockedUntil, uid)
+	return err
+}
+
+// IncrementUserLoginFailures counts a failed login in the database so
+// parallel attempts each count, returning the failures counted so far.
+//
+//goland:noinspection ALL
+func (s sqlRepository) IncrementUserLoginFailures(
+	ctx context.Context,
+	uid int,
+) (int, error) {
+	q := "UPDATE users SET failed_login_attempts = failed_login_attempts + 1 "
+	q += "WHERE id = ? RETURNING failed_login_attempts"
+	var attempts int
+	if err := s.db.QueryRowContext(ctx, q, uid).Scan(&attempts); err != nil {
+		return 0, err
+	}
+	return attempts, nil
+}
+
+// LockUser locks an account until lockedUntil, a longer lock set by a
+// parallel attempt is kept.
+//
+//goland:noinspection ALL
+func (s sqlRepository) LockUser(
+	ctx context.Context,
+	uid int,
+	lockedUntil int64,
+) error {
+	q := "UPDATE users SET locked_until = MAX(COALESCE(locked_until, 0), ?) WHERE id = ?"
+	_, err := s.db.ExecContext(ctx, q, lockedUntil, uid)
+	return err
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) DeleteUser(
+	ctx context.Context,
+	uid int,
+) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	for _, q := range []string{
+		"DELETE FROM sessions WHERE user_id = ?",
+		"DELETE FROM password_resets WHERE user_id = ?",
+		"DELETE FROM user_identities WHERE user_id = ?",
+		"DELETE FROM api_keys WHERE user_id = ?",
+		"DELETE FROM webhook_deliveries WHERE webhook_id IN (SELECT id FROM webhooks WHERE user_id = ?)",
+		"DELETE FROM webhooks WHERE user_id = ?",
+		"DELETE FROM calendar_channels WHERE user_id = ?",
+		"DELETE FROM calendar_mirrors WHERE user_id = ?",
+		"DELETE FROM external_events WHERE user_id = ?",
+		"DELETE FROM recovery_codes WHERE user_id = ?",
+		"DELETE FROM login_challenges WHERE user_id = ?",
+		"DELETE FROM team_members WHERE user_id = ?",
+		"DELETE FROM event_type_hosts WHERE user_id = ?",
+		"DELETE FROM booking_hosts WHERE user_id = ?",
+		"DELETE FROM bookings WHERE user_id = ?",
+		"DELETE FROM event_types WHERE user_id = ?",
+		"DELETE FROM availability_days WHERE user_id = ?",
+		"DELETE FROM availabilities WHERE user_id = ?",
+		"DELETE FROM users WHERE id = ?",
+	} {
+		if _, err := tx.ExecContext(ctx, q, uid); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) FindUsers(
+	ctx context.Context,
+) ([]*User, error) {
+	q := "SELECT id, username, COALESCE(email, ''), role, "
+	q += "totp_enabled_at IS NOT NULL, COALESCE(disabled_at, 0), created_at "
+	q += "FROM users ORDER BY id"
+	rows, err := s.db.QueryContext(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	users := []*User{}
+	for rows.Next() {
+		var user User
+		if err := rows.Scan(&user.ID, &user.Username, &user.Email, &user.Role,
+			&user.TOTPEnabled, &user.DisabledAt, &user.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		users = append(users, &user)
+	}
+	return users, rows.Err()
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) UpdateUserRole(
+	ctx context.Context,
+	uid int,
+	role string,
+) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	q := "UPDATE users SET role = ? WHERE id = ?"
+	result, err := tx.ExecContext(ctx, q, role, uid)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return fmt.Errorf("account with id %d not found", uid)
+	}
+	// tokens carry the role, sign the user out so they pick up the new one
+	q = "UPDATE sessions SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL"
+	if _, err := tx.ExecContext(ctx, q, time.Now().Unix(), uid); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) UpdateUserDisabled(
+	ctx context.Context,
+	uid int,
+	disabledAt int64,
+) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	q := "UPDATE users SET disabled_at = NULLIF(?, 0) WHERE id = ?"
+	result, err := tx.ExecContext(ctx, q, disabledAt, uid)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return fmt.Errorf("account with id %d not found", uid)
+	}
+	if disabledAt > 0 {
+		q = "UPDATE sessions SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL"
+		if _, err := tx.ExecContext(ctx, q, disabledAt, uid); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) InsertSession(
+	ctx context.Context,
+	session *Session,
+) (int, error) {
+	q := "INSERT INTO sessions (user_id, jti, refresh_token, user_agent, ip_address, "
+	q += "created_at, last_used_at, expires_at, impersonator_id) "
+	q += "VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NULLIF($9, 0)) RETURNING id"
+	row := s.db.QueryRowContext(ctx, q, session.UserID, session.JTI,
+		session.RefreshToken, session.UserAgent, session.IPAddress,
+		session.CreatedAt, session.LastUsedAt, session.ExpiresAt,
+		session.ImpersonatorID)
+	var id int
+	if err := row.Scan(&id); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+const sessionColumns = "id, user_id, jti, refresh_token, " +
+	"COALESCE(previous_refresh_token, ''), COALESCE(user_agent, ''), " +
+	"COALESCE(ip_address, ''), created_at, last_used_at, expires_at, revoked_at, " +
+	"COALESCE(impersonator_id, 0)"
+
+func scanSession(row *sql.Row) (*Session, error) {
+	var session Session
+	if err := row.Scan(&session.ID, &session.UserID, &session.JTI,
+		&session.RefreshToken, &session.PreviousRefreshToken,
+		&session.UserAgent, &session.IPAddress, &session.CreatedAt,
+		&session.LastUsedAt, &session.ExpiresAt, &session.RevokedAt,
+		&session.ImpersonatorID,
+	); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errors.New("session not found")
+		}
+		return nil, err
+	}
+	return &session, nil
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) FindSessionByJTI(
+	ctx context.Context,
+	jti string,
+) (*Session, error) {
+	q := "SELECT " + sessionColumns + " FROM sessions WHERE jti = ?"
+	return scanSession(s.db.QueryRowContext(ctx, q, jti))
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) FindSessionByRefreshToken(
+	ctx context.Context,
+	refreshToken string,
+) (*Session, error) {
+	q := "SELECT " + sessionColumns + " FROM sessions "
+	q += "WHERE refresh_token = $1 OR previous_refresh_token = $1"
+	return scanSession(s.db.QueryRowContext(ctx, q, refreshToken))
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) RotateSession(
+	ctx context.Context,
+	session *Session,
+) error {
+	q := "UPDATE sessions SET jti = ?, previous_refresh_token = refresh_token, "
+	q += "refresh_token = ?, user_agent = ?, ip_address = ?, "
+	q += "last_used_at = ?, expires_at = ? WHERE id = ? AND revoked_at IS NULL "
+	q += "AND refresh_token = ?"
+	res, err := s.db.ExecContext(ctx, q, session.JTI, session.RefreshToken,
+		session.UserAgent, session.IPAddress, session.LastUsedAt,
+		session.ExpiresAt, session.ID, session.PreviousRefreshToken)
+	if err != nil {
+		return err
+	}
+	// revoked or rotated by another request since it was read
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return errors.New("refresh token has already been used")
+	}
+	return nil
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) RevokeSession(
+	ctx context.Context,
+	sid int,
+) error {
+	q := "UPDATE sessions SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL"
+	_, err := s.db.ExecContext(ctx, q, time.Now().Unix(), sid)
+	return err
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) RevokeUserSessions(
+	ctx context.Context,
+	uid int,
+) error {
+	q := "UPDATE sessions SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL"
+	_, err := s.db.ExecContext(ctx, q, time.Now().Unix(), uid)
+	return err
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) FindUserIdentity(
+	ctx context.Context,
+	provider, subject string,
+) (*UserIdentity, error) {
+	q := "SELECT id, user_id, provider, subject, COALESCE(email, ''), created_at "
+	q += "FROM user_identities WHERE provider = ? AND subject = ?"
+	row := s.db.QueryRowContext(ctx, q, provider, subject)
+	var identity UserIdentity
+	if err := row.Scan(&identity.ID, &identity.UserID, &identity.Provider,
+		&identity.Subject, &identity.Email, &identity.CreatedAt,
+	); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf(
+				"%s identity %s %w",
+				provider, subject, errNotFound)
+		}
+		return nil, err
+	}
+	return &identity, nil
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) InsertUserIdentity(
+	ctx context.Context,
+	identity *UserIdentity,
+) (int, error) {
+	q := "INSERT INTO user_identities (user_id, provider, subject, email, created_at) "
+	q += "VALUES ($1, $2, $3, NULLIF($4, ''), $5) RETURNING id"
+	row := s.db.QueryRowContext(ctx, q, identity.UserID, identity.Provider,
+		identity.Subject, identity.Email, time.Now().Unix())
+	var id int
+	if err := row.Scan(&id); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) SetUserTOTPSecret(
+	ctx context.Context,
+	uid int,
+	secret string,
+) error {
+	q := "UPDATE users SET totp_secret = ?, totp_enabled_at = NULL, "
+	q += "totp_last_step = NULL WHERE id = ?"
+	_, err := s.db.ExecContext(ctx, q, secret, uid)
+	return err
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) EnableUserTOTP(
+	ctx context.Context,
+	uid int,
+	step int64,
+	recoveryCodes []string,
+) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	q := "UPDATE users SET totp_enabled_at = ?, totp_last_step = ? WHERE id = ?"
+	if _, err := tx.ExecContext(ctx, q, time.Now().Unix(), step, uid); err != nil {
+		return err
+	}
+	if err := replaceRecoveryCodes(ctx, tx, uid, recoveryCodes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) DisableUserTOTP(
+	ctx context.Context,
+	uid int,
+) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	q := "UPDATE users SET totp_secret = NULL, totp_enabled_at = NULL, "
+	q += "totp_last_step = NULL WHERE id = ?"
+	if _, err := tx.ExecContext(ctx, q, uid); err != nil {
+		return err
+	}
+	if err := replaceRecoveryCodes(ctx, tx, uid, nil); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) UpdateUserTOTPStep(
+	ctx context.Context,
+	uid int,
+	step int64,
+) error {
+	q := "UPDATE users SET totp_last_step = ? "
+	q += "WHERE id = ? AND COALESCE(totp_last_step, 0) < ?"
+	res, err := s.db.ExecContext(ctx, q, step, uid, step)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return errors.New("verification code has already been used")
+	}
+	return nil
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) ReplaceRecoveryCodes(
+	ctx context.Context,
+	uid int,
+	recoveryCodes []string,
+) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	if err := replaceRecoveryCodes(ctx, tx, uid, recoveryCodes); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func replaceRecoveryCodes(
+	ctx context.Context,
+	tx *sql.Tx,
+	uid int,
+	recoveryCodes []string,
+) error {
+	q := "DELETE FROM recovery_codes WHERE user_id = ?"
+	if _, err := tx.ExecContext(ctx, q, uid); err != nil {
+		return err
+	}
+	q = "INSERT INTO recovery_codes (user_id, code, created_at) VALUES (?, ?, ?)"
+	for _, code := range recoveryCodes {
+		if _, err := tx.ExecContext(ctx, q, uid, code, time.Now().Unix()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) FindUnusedRecoveryCodes(
+	ctx context.Context,
+	uid int,
+) ([]*RecoveryCode, error) {
+	q := "SELECT id, user_id, code FROM recovery_codes "
+	q += "WHERE user_id = ? AND used_at IS NULL"
+	rows, err := s.db.QueryContext(ctx, q, uid)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	var codes []*RecoveryCode
+	for rows.Next() {
+		var code RecoveryCode
+		if err := rows.Scan(&code.ID, &code.UserID, &code.Code); err != nil {
+			return nil, err
+		}
+		codes = append(codes, &code)
+	}
+	return codes, rows.Err()
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) UseRecoveryCode(
+	ctx context.Context,
+	id int,
+) error {
+	q := "UPDATE recovery_codes SET used_at = ? WHERE id = ? AND used_at IS NULL"
+	res, err := s.db.ExecContext(ctx, q, time.Now().Unix(), id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return errors.New("recovery code has already been used")
+	}
+	return nil
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) InsertLoginChallenge(
+	ctx context.Context,
+	challenge *LoginChallenge,
+) (int, error) {
+	q := "INSERT INTO login_challenges (user_id, token, created_at, expires_at) "
+	q += "VALUES ($1, $2, $3, $4) RETURNING id"
+	row := s.db.QueryRowContext(ctx, q, challenge.UserID, challenge.Token,
+		challenge.CreatedAt, challenge.ExpiresAt)
+	var id int
+	if err := row.Scan(&id); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) FindLoginChallenge(
+	ctx context.Context,
+	token string,
+) (*LoginChallenge, error) {
+	q := "SELECT id, user_id, token, attempts, created_at, expires_at, used_at "
+	q += "FROM login_challenges WHERE token = ?"
+	row := s.db.QueryRowContext(ctx, q, token)
+	var challenge LoginChallenge
+	if err := row.Scan(&challenge.ID, &challenge.UserID, &challenge.Token,
+		&challenge.Attempts, &challenge.CreatedAt, &challenge.ExpiresAt,
+		&challenge.UsedAt,
+	); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errors.New("login challenge not found")
+		}
+		return nil, err
+	}
+	return &challenge, nil
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) IncrementLoginChallengeAttempts(
+	ctx context.Context,
+	id int,
+) error {
+	q := "UPDATE login_challenges SET attempts = attempts + 1 WHERE id = ?"
+	_, err := s.db.ExecContext(ctx, q, id)
+	return err
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) UseLoginChallenge(
+	ctx context.Context,
+	id int,
+) error {
+	q := "UPDATE login_challenges SET used_at = ? WHERE id = ? AND used_at IS NULL"
+	res, err := s.db.ExecContext(ctx, q, time.Now().Unix(), id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return errors.New("login challenge has already been used")
+	}
+	return nil
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) InsertAPIKey(
+	ctx context.Context,
+	key *APIKey,
+) (int, error) {
+	q := "INSERT INTO api_keys (user_id, name, prefix, key, scopes, created_at, expires_at) "
+	q += "VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, 0)) RETURNING id"
+	row := s.db.QueryRowContext(ctx, q, key.UserID, key.Name, key.Prefix,
+		key.Key, strings.Join(key.Scopes, " "), key.CreatedAt, key.ExpiresAt)
+	var id int
+	if err := row.Scan(&id); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+const apiKeyColumns = "id, user_id, name, prefix, key, scopes, created_at, " +
+	"COALESCE(last_used_at, 0), COALESCE(expires_at, 0), COALESCE(revoked_at, 0)"
+
+func scanAPIKey(scan func(dest ...any) error) (*APIKey, error) {
+	var key APIKey
+	var scopes string
+	if err := scan(&key.ID, &key.UserID, &key.Name, &key.Prefix, &key.Key,
+		&scopes, &key.CreatedAt, &key.LastUsedAt, &key.ExpiresAt, &key.RevokedAt,
+	); err != nil {
+		return nil, err
+	}
+	key.Scopes = strings.Fields(scopes)
+	return &key, nil
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) FindUserAPIKeys(
+	ctx context.Context,
+	uid int,
+) ([]*APIKey, error) {
+	q := "SELECT " + apiKeyColumns + " FROM api_keys WHERE user_id = ? ORDER BY id DESC"
+	rows, err := s.db.QueryContext(ctx, q, uid)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	keys := []*APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		key.Key = ""
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) FindAPIKey(
+	ctx context.Context,
+	key string,
+) (*APIKey, error) {
+	q := "SELECT " + apiKeyColumns + " FROM api_keys WHERE key = ?"
+	apiKey, err := scanAPIKey(s.db.QueryRowContext(ctx, q, key).Scan)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errors.New("api key not found")
+	}
+	return apiKey, err
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) RevokeAPIKey(
+	ctx context.Context,
+	uid, id int,
+) error {
+	q := "UPDATE api_keys SET revoked_at = ? "
+	q += "WHERE id = ? AND user_id = ? AND revoked_at IS NULL"
+	res, err := s.db.ExecContext(ctx, q, time.Now().Unix(), id, uid)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return fmt.Errorf("active api key with id %d not found", id)
+	}
+	return nil
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) TouchAPIKey(
+	ctx context.Context,
+	id int,
+) error {
+	// only write once a minute, keys used by scripts can be very chatty
+	now := time.Now().Unix()
+	q := "UPDATE api_keys SET last_used_at = ? "
+	q += "WHERE id = ? AND COALESCE(last_used_at, 0) < ?"
+	_, err := s.db.ExecContext(ctx, q, now, id, now-60)
+	return err
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) InsertPasswordReset(
+	ctx context.Context,
+	reset *PasswordReset,
+) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+	// only the latest requested token stays usable
+	q := "UPDATE password_resets SET used_at = ? WHERE user_id = ? AND used_at IS NULL"
+	if _, err := tx.ExecContext(ctx, q, reset.CreatedAt, reset.UserID); err != nil {
+		return 0, err
+	}
+	q = "INSERT INTO password_resets (user_id, token, created_at, expires_at) "
+	q += "VALUES ($1, $2, $3, $4) RETURNING id"
+	row := tx.QueryRowContext(ctx, q, reset.UserID, reset.Token,
+		reset.CreatedAt, reset.ExpiresAt)
+	var id int
+	if err := row.Scan(&id); err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) FindPasswordReset(
+	ctx context.Context,
+	token string,
+) (*PasswordReset, error) {
+	q := "SELECT id, user_id, token, created_at, expires_at, used_at "
+	q += "FROM password_resets WHERE token = ?"
+	row := s.db.QueryRowContext(ctx, q, token)
+	var reset PasswordReset
+	if err := row.Scan(&reset.ID, &reset.UserID, &reset.Token,
+		&reset.CreatedAt, &reset.ExpiresAt, &reset.UsedAt,
+	); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errors.New("password reset token not found")
+		}
+		return nil, err
+	}
+	return &reset, nil
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) ResetPassword(
+	ctx context.Context,
+	reset *PasswordReset,
+	password string,
+) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	now := time.Now().Unix()
+	q := "UPDATE password_resets SET used_at = ? WHERE id = ? AND used_at IS NULL"
+	res, err := tx.ExecContext(ctx, q, now, reset.ID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return errors.New("password reset token has already been used")
+	}
+	q = "UPDATE users SET password = ?, failed_login_attempts = 0, "
+	q += "locked_until = NULL WHERE id = ?"
+	if _, err := tx.ExecContext(ctx, q, password, reset.UserID); err != nil {
+		return err
+	}
+	q = "UPDATE sessions SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL"
+	if _, err := tx.ExecContext(ctx, q, now, reset.UserID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) FindUserAvailability(
+	ctx context.Context,
+	uid int,
+) (*Availability, error) {
+	q := `SELECT
+	    a.id,
+	    a.user_id,
+	    a.label,
+	    a.timezone,
+	    (SELECT json_group_array(
+			json_object(
+				'id', ad.id,
+				'user_id', ad.user_id,
+				'availability_id', ad.availability_id,
+				'Enable', ad.Enable,
+				'day', ad.day,
+				'start_time', ad.start_time,
+				'end_time', ad.end_time
+			))
+	        FROM availability_days AS ad
+	        WHERE ad.availability_id = a.id
+	    ) AS availability_days
+	FROM availabilities AS a WHERE a.user_id = ? ORDER BY a.id LIMIT 1`
+	row := s.db.QueryRowContext(ctx, q, uid)
+	var av Availability
+	var availabilityDaysJSON []byte
+	if err := row.Scan(&av.ID, &av.UserID,
+		&av.Label, &av.Timezone, &availabilityDaysJSON); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf(
+				"user availabililty with id %d not found",
+				uid)
+		}
+		return nil, err
+	}
+	var availabilityDays []*AvailabilityDay
+	if err := json.Unmarshal(availabilityDaysJSON, &availabilityDays); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal availability_days: %v", err)
+	}
+	av.Days = availabilityDays
+	return &av, nil
+}
+
+// eventTypeQuery selects event types with their availability,
+// team event types have none and use the availability of each host.
+const eventTypeQuery = `SELECT
+	    et.id,
+	    COALESCE(et.user_id, 0),
+	    COALESCE(et.team_id, 0),
+	    COALESCE(et.availability_id, 0),
+	    et.enable,
+	    et.title,
+	    et.description,
+	    et.duration,
+	    COALESCE(et.scheduling_type, ''),
+	    et.seats,
+	    et.recurring_max,
+	    COALESCE(et.questions, ''),
+	    et.requires_confirmation,
+	    et.reminders,
+	    COALESCE(a.id, 0) as av_id,
+	    COALESCE(a.label, '') as av_label,
+	    COALESCE(a.timezone, '') as av_timezone,
+        (
+            SELECT json_group_array(
+                json_object(
+                    'id', ad.id,
+                    'user_id', ad.user_id,
+                    'availability_id', ad.availability_id,
+                    'Enable', ad.Enable,
+                    'day', ad.day,
+                    'start_time', ad.start_time,
+                    'end_time', ad.end_time
+                )
+            )
+            FROM availability_days AS ad
+            WHERE ad.availability_id = a.id
+        ) AS availability_days
+	FROM event_types AS et
+	LEFT JOIN availabilities AS a ON et.availability_id = a.id `
+
+func scanEventType(scan func(dest ...any) error) (*EventType, error) {
+	var et EventType
+	var av Availability
+	var availabilityDaysJSON []byte
+	var questionsJSON, remindersJSON string
+	if err := scan(
+		&et.ID, &et.UserID, &et.TeamID, &et.AvailabilityID,
+		&et.Enable, &et.Title, &et.Description,
+		&et.Duration, &et.SchedulingType, &et.Seats, &et.RecurringMax,
+		&questionsJSON, &et.RequiresConfirmation, &remindersJSON,
+		&av.ID, &av.Label, &av.Timezone, &availabilityDaysJSON,
+	); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(remindersJSON), &et.Reminders); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal reminders: %v", err)
+	}
+	if questionsJSON != "" {
+		if err := json.Unmarshal([]byte(questionsJSON), &et.Questions); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal questions: %v", err)
+		}
+	}
+	if av.ID == 0 {
+		return &et, nil
+	}
+	var availabilityDays []*AvailabilityDay
+	if err := json.Unmarshal(availabilityDaysJSON, &availabilityDays); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal availability_days: %v", err)
+	}
+	sort.Slice(availabilityDays, func(i, j int) bool {
+		return availabilityDays[i].Enable > availabilityDays[j].Enable
+	})
+	et.Availability = &Availability{
+		ID:       av.ID,
+		Label:    av.Label,
+		Timezone: av.Timezone,
+		Days:     availabilityDays,
+	}
+	return &et, nil
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) FindUserEventType(
+	ctx context.Context,
+	uid int,
+) ([]*EventType, error) {
+	q := eventTypeQuery + "WHERE et.user_id = ?"
+	rows, err := s.db.QueryContext(ctx, q, uid)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	var eventTypes []*EventType
+	for rows.Next() {
+		et, err := scanEventType(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		eventTypes = append(eventTypes, et)
+	}
+	return eventTypes, rows.Err()
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) FindEventType(
+	ctx context.Context,
+	id int,
+) (*EventType, error) {
+	q := eventTypeQuery + "WHERE et.id = ?"
+	et, err := scanEventType(s.db.QueryRowContext(ctx, q, id).Scan)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("event type with id %d not found", id)
+		}
+		return nil, err
+	}
+	return et, nil
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) FindTeamEventTypes(
+	ctx context.Context,
+	teamID int,
+) ([]*EventType, error) {
+	q := eventTypeQuery + "WHERE et.team_id = ?"
+	rows, err := s.db.QueryContext(ctx, q, teamID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	eventTypes := []*EventType{}
+	for rows.Next() {
+		et, err := scanEventType(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		eventTypes = append(eventTypes, et)
+	}
+	return eventTypes, rows.Err()
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) FindEventTypeHosts(
+	ctx context.Context,
+	eventTypeID int,
+) ([]*EventTypeHost, error) {
+	q := "SELECT h.user_id, u.username, h.weight FROM event_type_hosts h "
+	q += "JOIN users u ON u.id = h.user_id WHERE h.event_type_id = ? ORDER BY h.id"
+	rows, err := s.db.QueryContext(ctx, q, eventTypeID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	hosts := []*EventTypeHost{}
+	for rows.Next() {
+		var host EventTypeHost
+		if err := rows.Scan(&host.UserID, &host.Username, &host.Weight); err != nil {
+			return nil, err
+		}
+		hosts = append(hosts, &host)
+	}
+	return hosts, rows.Err()
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) InsertTeamEventType(
+	ctx context.Context,
+	eventType *EventType,
+) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+	questions, err := nullableJSON(eventType.Questions)
+	if err != nil {
+		return 0, err
+	}
+	reminders, err := json.Marshal(eventType.Reminders)
+	if err != nil {
+		return 0, err
+	}
+	q := "INSERT INTO event_types (team_id, enable, title, description, duration, scheduling_type, "
+	q += "seats, recurring_max, questions, requires_confirmation, reminders) "
+	q += "VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) RETURNING id"
+	row := tx.QueryRowContext(ctx, q, eventType.TeamID, eventType.Enable,
+		eventType.Title, eventType.Description, eventType.Duration,
+		eventType.SchedulingType, eventType.Seats, eventType.RecurringMax, questions,
+		eventType.RequiresConfirmation, string(reminders))
+	var id int
+	if err := row.Scan(&id); err != nil {
+		return 0, err
+	}
+	q = "INSERT INTO event_type_hosts (event_type_id, user_id, weight) VALUES (?, ?, ?)"
+	for _, host := range eventType.Hosts {
+		if _, err := tx.ExecContext(ctx, q, id, host.UserID, host.Weight); err != nil {
+			return 0, err
+		}
+	}
+	return id, tx.Commit()
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) FindHostBookingStats(
+	ctx context.Context,
+	eventTypeID int,
+) (map[int]*hostStat, error) {
+	q := "SELECT user_id, COUNT(*), MAX(created_at) FROM bookings "
+	q += "WHERE event_type_id = ? AND cancelled_at IS NULL AND status IN ('confirmed', 'pending') "
+	q += "GROUP BY user_id"
+	rows, err := s.db.QueryContext(ctx, q, eventTypeID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	stats := map[int]*hostStat{}
+	for rows.Next() {
+		var uid int
+		var stat hostStat
+		if err := rows.Scan(&uid, &stat.Bookings, &stat.LastBookedAt); err != nil {
+			return nil, err
+		}
+		stats[uid] = &stat
+	}
+	return stats, rows.Err()
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) FindUserBusyBookings(
+	ctx context.Context,
+	uid int,
+	from, to int64,
+) ([]hof.BusyTime, error) {
+	q := "SELECT start_at, end_at FROM bookings WHERE start_at < $2 AND end_at > $3 "
+	q += "AND cancelled_at IS NULL "
+	// pending bookings hold the slot until they expire
+	q += "AND (status = 'confirmed' OR (status = 'pending' AND expires_at > $4)) "
+	q += "AND (user_id = $1 OR id IN (SELECT booking_id FROM booking_hosts WHERE user_id = $1))"
+	rows, err := s.db.QueryContext(ctx, q, uid, to, from, time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	var busy []hof.BusyTime
+	for rows.Next() {
+		var start, end int64
+		if err := rows.Scan(&start, &end); err != nil {
+			return nil, err
+		}
+		busy = append(busy, hof.BusyTime{
+			Start: time.Unix(start, 0), End: time.Unix(end, 0)})
+	}
+	return busy, rows.Err()
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) InsertTeam(
+	ctx context.Context,
+	team *Team,
+	ownerID int,
+) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+	now := time.Now().Unix()
+	q := "INSERT INTO teams (name, slug, timezone, created_at) "
+	q += "VALUES ($1, $2, $3, $4) RETURNING id"
+	row := tx.QueryRowContext(ctx, q, team.Name, team.Slug, team.Timezone, now)
+	var id int
+	if err := row.Scan(&id); err != nil {
+		return 0, err
+	}
+	q = "INSERT INTO team_members (team_id, user_id, role, created_at) VALUES (?, ?, ?, ?)"
+	if _, err := tx.ExecContext(ctx, q, id, ownerID, TeamRoleOwner, now); err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+const teamColumns = "t.id, t.name, t.slug, t.timezone, t.created_at"
+
+func scanTeam(scan func(dest ...any) error) (*Team, error) {
+	var team Team
+	if err := scan(&team.ID, &team.Name, &team.Slug,
+		&team.Timezone, &team.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &team, nil
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) FindTeam(
+	ctx context.Context,
+	id int,
+) (*Team, error) {
+	q := "SELECT " + teamColumns + " FROM teams t WHERE t.id = ?"
+	team, err := scanTeam(s.db.QueryRowContext(ctx, q, id).Scan)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("team with id %d not found", id)
+		}
+		return nil, err
+	}
+	return team, nil
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) FindTeamBySlug(
+	ctx context.Context,
+	slug string,
+) (*Team, error) {
+	q := "SELECT " + teamColumns + " FROM teams t WHERE t.slug = ?"
+	team, err := scanTeam(s.db.QueryRowContext(ctx, q, slug).Scan)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("team %s not found", slug)
+		}
+		return nil, err
+	}
+	return team, nil
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) IsTeamSlugExist(
+	ctx context.Context,
+	slug string,
+) (bool, error) {
+	q := "SELECT EXISTS (SELECT 1 FROM teams WHERE slug = ?)"
+	row := s.db.QueryRowContext(ctx, q, slug)
+	var exist bool
+	if err := row.Scan(&exist); err != nil {
+		return false, err
+	}
+	return exist, nil
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) FindUserTeams(
+	ctx context.Context,
+	uid int,
+) ([]*Team, error) {
+	q := "SELECT " + teamColumns + " FROM teams t "
+	q += "JOIN team_members m ON m.team_id = t.id WHERE m.user_id = ? ORDER BY t.id"
+	rows, err := s.db.QueryContext(ctx, q, uid)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	teams := []*Team{}
+	for rows.Next() {
+		team, err := scanTeam(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		teams = append(teams, team)
+	}
+	return teams, rows.Err()
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) FindTeamMembers(
+	ctx context.Context,
+	teamID int,
+) ([]*TeamMember, error) {
+	q := "SELECT m.id, m.team_id, m.user_id, u.username, m.role, m.created_at "
+	q += "FROM team_members m JOIN users u ON u.id = m.user_id "
+	q += "WHERE m.team_id = ? ORDER BY m.id"
+	rows, err := s.db.QueryContext(ctx, q, teamID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	members := []*TeamMember{}
+	for rows.Next() {
+		var member TeamMember
+		if err := rows.Scan(&member.ID, &member.TeamID, &member.UserID,
+			&member.Username, &member.Role, &member.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		members = append(members, &member)
+	}
+	return members, rows.Err()
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) InsertTeamMember(
+	ctx context.Context,
+	member *TeamMember,
+) (int, error) {
+	q := "INSERT INTO team_members (team_id, user_id, role, created_at) "
+	q += "VALUES ($1, $2, $3, $4) RETURNING id"
+	row := s.db.QueryRowContext(ctx, q, member.TeamID, member.UserID,
+		member.Role, member.CreatedAt)
+	var id int
+	if err := row.Scan(&id); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) DeleteTeamMember(
+	ctx context.Context,
+	teamID, uid int,
+) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	q := "DELETE FROM team_members WHERE team_id = ? AND user_id = ?"
+	result, err := tx.ExecContext(ctx, q, teamID, uid)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return fmt.Errorf("user with id %d is not a member of the team", uid)
+	}
+	// a former member no longer hosts the event types of the team
+	q = "DELETE FROM event_type_hosts WHERE user_id = ? AND event_type_id IN "
+	q += "(SELECT id FROM event_types WHERE team_id = ?)"
+	if _, err := tx.ExecContext(ctx, q, uid, teamID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) UpdateUser(
+	ctx context.Context,
+	username string,
+	tokenType string,
+	tokenValue []byte,
+) error {
+	// google_token || microsoft_token
+	q := "UPDATE users SET "
+	q += fmt.Sprintf("%s = ? WHERE username = ?", tokenType)
+	_, err := s.db.ExecContext(ctx, q, tokenValue, username)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) UpdateEventType(
+	ctx context.Context,
+	eventType *EventType,
+) error {
+	// without reminders the current ones are kept
+	var reminders sql.NullString
+	if eventType.Reminders != nil {
+		data, err := json.Marshal(eventType.Reminders)
+		if err != nil {
+			return err
+		}
+		reminders = sql.NullString{String: string(data), Valid: true}
+	}
+	q := "UPDATE event_types SET enable = ?, title = ?, description = ?, duration = ?, seats = ?, "
+	q += "recurring_max = ?, requires_confirmation = ?, reminders = COALESCE(?, reminders) "
+	q += "WHERE id = ? AND user_id = ?"
+	res, err := s.db.ExecContext(ctx, q, eventType.Enable, eventType.Title,
+		eventType.Description, eventType.Duration, eventType.Seats,
+		eventType.RecurringMax, eventType.RequiresConfirmation, reminders,
+		eventType.ID, eventType.UserID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return fmt.Errorf("event type with id %d not found", eventType.ID)
+	}
+	return nil
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) UpdateEventTypeQuestions(
+	ctx context.Context,
+	eventType *EventType,
+) error {
+	questions, err := nullableJSON(eventType.Questions)
+	if err != nil {
+		return err
+	}
+	q := "UPDATE event_types SET questions = ? WHERE id = ? AND user_id = ?"
+	res, err := s.db.ExecContext(ctx, q, questions, eventType.ID, eventType.UserID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return fmt.Errorf("event type with id %d not found", eventType.ID)
+	}
+	return nil
+}
+
+// nullableJSON encodes list as json, an empty list is stored as NULL.
+func nullableJSON(list interface{}) (sql.NullString, error) {
+	data, err := json.Marshal(list)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+	if text := string(data); text == "null" || text == "[]" {
+		return sql.NullString{}, nil
+	}
+	return sql.NullString{String: string(data), Valid: true}, nil
+}
+
+// unmarshalAnswers decodes the answers column of a booking.
+func unmarshalAnswers(data string, booking *Booking) error {
+	if data == "" {
+		return nil
+	}
+	if err := json.Unmarshal([]byte(data), &booking.Answers); err != nil {
+		return fmt.Errorf("failed to unmarshal answers: %v", err)
+	}
+	return nil
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) FindBooking(
+	ctx context.Context,
+	bookingID int,
+) (*Booking, error) {
+	q := "SELECT id, user_id, event_type_id, title, notes, name, email, date, time, event, "
+	q += "COALESCE(location, ''), COALESCE(start_at, 0), COALESCE(end_at, 0), COALESCE(seat_of, 0), "
+	q += "COALESCE(series_id, 0), COALESCE(recurrence, ''), COALESCE(cancelled_at, 0), "
+	q += "COALESCE(answers, ''), status, COALESCE(expires_at, 0), sequence, COALESCE(sync_status, ''), "
+	q += "COALESCE(sync_error, ''), sync_attempts FROM bookings WHERE id = ?"
+	row := s.db.QueryRowContext(ctx, q, bookingID)
+	var booking Booking
+	var bookingJSON []byte
+	var answers string
+	err := row.Scan(&booking.ID, &booking.UserID, &booking.EventTypeID, &booking.Title, &booking.Notes,
+		&booking.Name, &booking.Email, &booking.Date, &booking.Time, &bookingJSON,
+		&booking.Location, &booking.StartAt, &booking.EndAt, &booking.SeatOf, &booking.SeriesID,
+		&booking.Recurrence, &booking.CancelledAt, &answers, &booking.Status,
+		&booking.ExpiresAt, &booking.Sequence, &booking.SyncStatus, &booking.SyncError,
+		&booking.SyncAttempts)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf(
+				"booking with id %d not found",
+				bookingID)
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(bookingJSON, &booking.EventDetail); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal availability_days: %v", err)
+	}
+	booking.Event = bookingJSON
+	booking.JoinURL = joinURL(bookingJSON)
+	if err := unmarshalAnswers(answers, &booking); err != nil {
+		return nil, err
+	}
+	return &booking, nil
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) FindUserBookings(
+	ctx context.Context,
+	uid int,
+) ([]*Booking, error) {
+	q := "SELECT id, event_type_id, title, notes, name, email, date, time, "
+	q += "COALESCE(location, ''), COALESCE(series_id, 0), COALESCE(recurrence, ''), "
+	q += "COALESCE(cancelled_at, 0), COALESCE(answers, ''), status, COALESCE(expires_at, 0), "
+	q += "COALESCE(sync_status, ''), COALESCE(sync_error, ''), COALESCE(event, '') "
+	q += "FROM bookings WHERE user_id = $1 "
+	q += "OR id IN (SELECT booking_id FROM booking_hosts WHERE user_id = $1) "
+	q += "ORDER BY date DESC, time DESC"
+	rows, err := s.db.QueryContext(ctx, q, uid)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	bookings := []*Booking{}
+	for rows.Next() {
+		var booking Booking
+		var answers, event string
+		if err := rows.Scan(&booking.ID, &booking.EventTypeID, &booking.Title,
+			&booking.Notes, &booking.Name, &booking.Email, &booking.Date,
+			&booking.Time, &booking.Location, &booking.SeriesID,
+			&booking.Recurrence, &booking.CancelledAt, &answers,
+			&booking.Status, &booking.ExpiresAt, &booking.SyncStatus,
+			&booking.SyncError, &event,
+		); err != nil {
+			return nil, err
+		}
+		booking.JoinURL = joinURL([]byte(event))
+		if err := unmarshalAnswers(answers, &booking); err != nil {
+			return nil, err
+		}
+		bookings = append(bookings, &booking)
+	}
+	return bookings, rows.Err()
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) FindAllBookings(
+	ctx context.Context,
+) ([]*Booking, error) {
+	q := "SELECT b.id, b.event_type_id, u.username, b.title, b.notes, b.name, "
+	q += "b.email, b.date, b.time, COALESCE(b.location, ''), COALESCE(b.series_id, 0), "
+	q += "COALESCE(b.recurrence, ''), COALESCE(b.cancelled_at, 0), COALESCE(b.answers, ''), "
+	q += "b.status, COALESCE(b.expires_at, 0), COALESCE(b.sync_status, ''), "
+	q += "COALESCE(b.sync_error, ''), COALESCE(b.event, '') FROM bookings b "
+	q += "JOIN users u ON u.id = b.user_id ORDER BY b.date DESC, b.time DESC"
+	rows, err := s.db.QueryContext(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	bookings := []*Booking{}
+	for rows.Next() {
+		var booking Booking
+		var answers, event string
+		if err := rows.Scan(&booking.ID, &booking.EventTypeID, &booking.Host,
+			&booking.Title, &booking.Notes, &booking.Name, &booking.Email,
+			&booking.Date, &booking.Time, &booking.Location, &booking.SeriesID,
+			&booking.Recurrence, &booking.CancelledAt, &answers,
+			&booking.Status, &booking.ExpiresAt, &booking.SyncStatus,
+			&booking.SyncError, &event,
+		); err != nil {
+			return nil, err
+		}
+		booking.JoinURL = joinURL([]byte(event))
+		if err := unmarshalAnswers(answers, &booking); err != nil {
+			return nil, err
+		}
+		bookings = append(bookings, &booking)
+	}
+	return bookings, rows.Err()
+}
+
+// seatBookingColumns selects the booking that created the calendar event
+// of a slot and how many seats of the slot are taken.
+const seatBookingColumns = "b.id, b.user_id, b.event_type_id, b.title, " +
+	"COALESCE(b.location, ''), b.event, b.start_at, b.end_at, " +
+	"(SELECT COUNT(*) FROM bookings s WHERE (s.id = b.id OR s.seat_of = b.id) " +
+	"AND s.cancelled_at IS NULL)"
+
+func scanSeatBooking(scan func(dest ...any) error) (*Booking, error) {
+	var booking Booking
+	var event sql.NullString
+	if err := scan(&booking.ID, &booking.UserID, &booking.EventTypeID,
+		&booking.Title, &booking.Location, &event, &booking.StartAt,
+		&booking.EndAt, &booking.SeatsTaken,
+	); err != nil {
+		return nil, err
+	}
+	booking.Event = []byte(event.String)
+	return &booking, nil
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) FindSeatBooking(
+	ctx context.Context,
+	eventTypeID int,
+	startAt int64,
+) (*Booking, error) {
+	q := "SELECT " + seatBookingColumns + " FROM bookings b "
+	q += "WHERE b.event_type_id = ? AND b.start_at = ? AND b.seat_of IS NULL "
+	q += "ORDER BY b.id LIMIT 1"
+	booking, err := scanSeatBooking(s.db.QueryRowContext(ctx, q, eventTypeID, startAt).Scan)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return booking, nil
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) FindSeatBookings(
+	ctx context.Context,
+	eventTypeID int,
+	from, to int64,
+) ([]*Booking, error) {
+	q := "SELECT " + seatBookingColumns + " FROM bookings b "
+	q += "WHERE b.event_type_id = ? AND b.start_at >= ? AND b.start_at < ? "
+	q += "AND b.seat_of IS NULL"
+	rows, err := s.db.QueryContext(ctx, q, eventTypeID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	var bookings []*Booking
+	for rows.Next() {
+		booking, err := scanSeatBooking(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		bookings = append(bookings, booking)
+	}
+	return bookings, rows.Err()
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) InsertBooking(
+	ctx context.Context,
+	booking *Booking,
+) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+	id, err := insertBooking(ctx, tx, booking)
+	if err != nil {
+		return 0, err
+	}
+	if err := scheduleBookingEvent(ctx, tx, booking, id); err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+// InsertBookingSeries stores every occurrence of a recurring booking,
+// the id of the first occurrence is the series id of all of them.
+//
+//goland:noinspection ALL
+func (s sqlRepository) InsertBookingSeries(
+	ctx context.Context,
+	bookings []*Booking,
+) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+	var seriesID int
+	for _, booking := range bookings {
+		booking.SeriesID = seriesID
+		id, err := insertBooking(ctx, tx, booking)
+		if err != nil {
+			return 0, err
+		}
+		booking.ID = strconv.Itoa(id)
+		if seriesID == 0 {
+			seriesID = id
+			q := "UPDATE bookings SET series_id = ? WHERE id = ?"
+			if _, err := tx.ExecContext(ctx, q, seriesID, id); err != nil {
+				return 0, err
+			}
+		}
+	}
+	// the series shares one calendar event
+	if err := scheduleBookingEvent(ctx, tx, bookings[0], seriesID); err != nil {
+		return 0, err
+	}
+	return seriesID, tx.Commit()
+}
+
+// scheduleBookingEvent stores the job creating the calendar event of the
+// booking id along with it, when the event is still to be created.
+func scheduleBookingEvent(
+	ctx context.Context,
+	tx *sql.Tx,
+	booking *Booking,
+	id int,
+) error {
+	if booking.SyncStatus != SyncPending {
+		return nil
+	}
+	return hof.ScheduleJob(ctx, tx, jobBookingEvent,
+		&bookingEventJob{BookingID: id}, time.Now())
+}
+
+//goland:noinspection ALL
+func insertBooking(
+	ctx context.Context,
+	tx *sql.Tx,
+	booking *Booking,
+) (int, error) {
+	answers, err := nullableJSON(booking.Answers)
+	if err != nil {
+		return 0, err
+	}
+	hosts, err := json.Marshal(append([]int{booking.UserID}, booking.CoHostIDs...))
+	if err != nil {
+		return 0, err
+	}
+	q := "INSERT INTO bookings (user_id, event_type_id, title, notes, name, email, date, time, event, location, "
+	q += "start_at, end_at, seat_of, series_id, recurrence, answers, status, expires_at, sync_status, "
+	q += "created_at) SELECT $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, NULLIF($13, 0), "
+	q += "NULLIF($14, 0), NULLIF($15, ''), $16, $17, NULLIF($18, 0), NULLIF($19, ''), $20 "
+	// the insert only happens while no booking of the hosts holds the slot,
+	// seats join the booking holding it while it has seats left
+	q += "WHERE ($13 > 0 AND (SELECT COUNT(*) FROM bookings WHERE (id = $13 OR seat_of = $13) "
+	q += "AND cancelled_at IS NULL) < (SELECT seats FROM event_types WHERE id = $2)) "
+	q += "OR ($13 = 0 AND NOT EXISTS (SELECT 1 FROM bookings WHERE start_at < $12 AND end_at > $11 "
+	q += "AND cancelled_at IS NULL AND (status = 'confirmed' OR (status = 'pending' AND expires_at > $20)) "
+	q += "AND (user_id IN (SELECT value FROM json_each($21)) OR id IN (SELECT booking_id FROM booking_hosts "
+	q += "WHERE user_id IN (SELECT value FROM json_each($21)))))) RETURNING id"
+	row := tx.QueryRowContext(ctx, q, booking.UserID, booking.EventTypeID, booking.Title,
+		booking.Notes, booking.Name, booking.Email, booking.Date, booking.Time,
+		booking.Event, booking.Location, booking.StartAt, booking.EndAt,
+		booking.SeatOf, booking.SeriesID, booking.Recurrence, answers,
+		booking.Status, booking.ExpiresAt, booking.SyncStatus, time.Now().Unix(), string(hosts))
+	var id int
+	if err := row.Scan(&id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) && booking.SeatOf > 0 {
+			return 0, errNoSeatsLeft
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, errSlotTaken
+		}
+		return 0, err
+	}
+	q = "INSERT INTO booking_hosts (booking_id, user_id) VALUES (?, ?)"
+	for _, uid := range booking.CoHostIDs {
+		if _, err := tx.ExecContext(ctx, q, id, uid); err != nil {
+			return 0, err
+		}
+	}
+	return id, nil
+}
+
+// CancelBookings marks the booking, or with series every occurrence of its
+// series that has not started nor been cancelled yet, as cancelled. The
+// series keeps the recurrence of booking, shortened when it ends early. The
+// sequence of the whole series moves on as its calendar invite changes
+// either way.
+//
+//goland:noinspection ALL
+func (s sqlRepository) CancelBookings(
+	ctx context.Context,
+	booking *Booking,
+	series bool,
+) error {
+	q := "UPDATE bookings SET sequence = sequence + 1, cancelled_at = CASE "
+	q += "WHEN cancelled_at IS NULL AND ((id = $2 AND NOT $4) OR ($4 AND start_at > $1)) THEN $1 "
+	q += "ELSE cancelled_at END, recurrence = CASE WHEN $4 THEN NULLIF($5, '') ELSE recurrence END "
+	q += "WHERE id = $2 OR (series_id = $3 AND $3 > 0)"
+	_, err := s.db.ExecContext(ctx, q, time.Now().Unix(), booking.ID, booking.SeriesID, series,
+		booking.Recurrence)
+	return err
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) FindBookingCoHosts(
+	ctx context.Context,
+	bookingID int,
+) ([]int, error) {
+	q := "SELECT user_id FROM booking_hosts WHERE booking_id = ? ORDER BY id"
+	rows, err := s.db.QueryContext(ctx, q, bookingID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// FindSeriesBookings returns every occurrence of a series in order,
+// with only the columns telling them apart.
+//
+//goland:noinspection ALL
+func (s sqlRepository) FindSeriesBookings(
+	ctx context.Context,
+	seriesID int,
+) ([]*Booking, error) {
+	q := "SELECT id, start_at, end_at, COALESCE(cancelled_at, 0) "
+	q += "FROM bookings WHERE series_id = ? ORDER BY start_at"
+	rows, err := s.db.QueryContext(ctx, q, seriesID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	var bookings []*Booking
+	for rows.Next() {
+		var booking Booking
+		if err := rows.Scan(&booking.ID, &booking.StartAt, &booking.EndAt,
+			&booking.CancelledAt); err != nil {
+			return nil, err
+		}
+		bookings = append(bookings, &booking)
+	}
+	return bookings, rows.Err()
+}
+
+// ConfirmBookings confirms the pending booking with its whole series, along
+// with the job creating its calendar event when that is pending, and
+// returns errNotPending when it is no longer pending.
+//
+//goland:noinspection ALL
+func (s sqlRepository) ConfirmBookings(
+	ctx context.Context,
+	booking *Booking,
+) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	q := "UPDATE bookings SET status = 'confirmed', expires_at = NULL, sync_status = NULLIF($1, '') "
+	q += "WHERE (id = $2 OR (series_id = $3 AND $3 > 0)) AND status = 'pending' AND expires_at > $4"
+	res, err := tx.ExecContext(ctx, q, booking.SyncStatus, booking.ID, booking.SeriesID, time.Now().Unix())
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		if err == nil {
+			err = errNotPending
+		}
+		return err
+	}
+	// the first occurrence creates the event of a series
+	id := booking.SeriesID
+	if id == 0 {
+		if id, err = strconv.Atoi(booking.ID); err != nil {
+			return err
+		}
+	}
+	if err := scheduleBookingEvent(ctx, tx, booking, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UpdatePendingBookingsStatus moves the pending booking with its whole
+// series to status.
+//
+//goland:noinspection ALL
+func (s sqlRepository) UpdatePendingBookingsStatus(
+	ctx context.Context,
+	booking *Booking,
+	status string,
+) error {
+	q := "UPDATE bookings SET status = $1 "
+	q += "WHERE (id = $2 OR (series_id = $3 AND $3 > 0)) AND status = 'pending'"
+	_, err := s.db.ExecContext(ctx, q, status, booking.ID, booking.SeriesID)
+	return err
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) ExpireBookings(
+	ctx context.Context,
+	now int64,
+) error {
+	q := "UPDATE bookings SET status = 'expired' WHERE status = 'pending' AND expires_at <= ?"
+	_, err := s.db.ExecContext(ctx, q, now)
+	return err
+}
+
+// RescheduleBooking moves the booking to its new date, time and calendar
+// event, the next version of its calendar invite.
+//
+//goland:noinspection ALL
+func (s sqlRepository) RescheduleBooking(
+	ctx context.Context,
+	booking *Booking,
+) error {
+	q := "UPDATE bookings SET date = $1, time = $2, start_at = $3, end_at = $4, "
+	q += "event = $5, expires_at = NULLIF($6, 0), sequence = sequence + 1 WHERE id = $7"
+	_, err := s.db.ExecContext(ctx, q, booking.Date, booking.Time, booking.StartAt,
+		booking.EndAt, booking.Event, booking.ExpiresAt, booking.ID)
+	return err
+}
+
+// UpdateBookingSync stores the outcome of creating the calendar event of
+// the booking for its whole series, the event and title once created.
+//
+//goland:noinspection ALL
+func (s sqlRepository) UpdateBookingSync(
+	ctx context.Context,
+	booking *Booking,
+) error {
+	q := "UPDATE bookings SET sync_status = $1, sync_error = NULLIF($2, ''), sync_attempts = $3, "
+	q += "event = COALESCE($4, event), title = COALESCE(NULLIF($5, ''), title) "
+	q += "WHERE id = $6 OR (series_id = $7 AND $7 > 0)"
+	var event interface{}
+	if booking.SyncStatus == SyncSynced {
+		event = booking.Event
+	}
+	_, err := s.db.ExecContext(ctx, q, booking.SyncStatus, booking.SyncError,
+		booking.SyncAttempts, event, booking.Title, booking.ID, booking.SeriesID)
+	return err
+}
+
+// FindEventBookingIDs returns the bookings of the host uid whose calendar
+// event at location is eventID, every occurrence of a series and every
+// seat of a slot share one event.
+//
+//goland:noinspection ALL
+func (s sqlRepository) FindEventBookingIDs(
+	ctx context.Context,
+	uid int,
+	location, eventID string,
+) ([]int, error) {
+	q := "SELECT id FROM bookings WHERE user_id = ? AND location = ? "
+	q += "AND json_valid(event) AND json_extract(event, '$.id') = ? ORDER BY start_at"
+	rows, err := s.db.QueryContext(ctx, q, uid, location, eventID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) InsertCalendarChannel(
+	ctx context.Context,
+	channel *CalendarChannel,
+) (int, error) {
+	q := "INSERT INTO calendar_channels (user_id, provider, channel_id, resource_id, token, "
+	q += "sync_token, expires_at, created_at) VALUES ($1, $2, $3, NULLIF($4, ''), $5, "
+	q += "NULLIF($6, ''), $7, $8) RETURNING id"
+	row := s.db.QueryRowContext(ctx, q, channel.UserID, channel.Provider, channel.ChannelID,
+		channel.ResourceID, channel.Token, channel.SyncToken, channel.ExpiresAt, channel.CreatedAt)
+	var id int
+	if err := row.Scan(&id); err != nil {
+		return 0, err
+	}
+	return id, nil
 }
 
-type sqlRepository struct {
-	db *sql.DB
+const calendarChannelColumns = "id, user_id, provider, channel_id, COALESCE(resource_id, ''), " +
+	"token, COALESCE(sync_token, ''), expires_at, created_at"
+
+func scanCalendarChannel(scan func(dest ...any) error) (*CalendarChannel, error) {
+	var channel CalendarChannel
+	if err := scan(&channel.ID, &channel.UserID, &channel.Provider, &channel.ChannelID,
+		&channel.ResourceID, &channel.Token, &channel.SyncToken, &channel.ExpiresAt,
+		&channel.CreatedAt,
+	); err != nil {
+		return nil, err
+	}
+	return &channel, nil
 }
 
+// FindCalendarChannel returns the channel notifications name, nil when
+// it is unknown, e.g. replaced by a renewed one.
+//
 //goland:noinspection ALL
-func (s sqlRepository) FindUserProfile(
+func (s sqlRepository) FindCalendarChannel(
 	ctx context.Context,
-	username string,
-) (*User, error) {
-	q := "SELECT id, username, password, google_token, microsoft_token "
-	q += "FROM users WHERE username = ?"
-	row := s.db.QueryRowContext(ctx, q, username)
-	var user User
-	err := row.Scan(&user.ID, &user.Username,
-		&user.Password, &user.GoogleToken, &user.MicrosoftToken)
+	channelID string,
+) (*CalendarChannel, error) {
+	q := "SELECT " + calendarChannelColumns + " FROM calendar_channels WHERE channel_id = ?"
+	channel, err := scanCalendarChannel(s.db.QueryRowContext(ctx, q, channelID).Scan)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return nil, fmt.Errorf(
-				"account with username %s not found",
-				username)
+			return nil, nil
 		}
 		return nil, err
 	}
-	return &user, nil
+	return channel, nil
 }
 
 //goland:noinspection ALL
-func (s sqlRepository) FindUserAvailability(
+func (s sqlRepository) FindUserCalendarChannels(
 	ctx context.Context,
 	uid int,
-) (*Availability, error) {
-	q := `SELECT
-	    a.id,
-	    a.user_id,
-	    a.label,
-	    a.timezone,
-	    (SELECT json_group_array(
-			json_object(
-				'id', ad.id,
-				'user_id', ad.user_id,
-				'availability_id', ad.availability_id,
-				'Enable', ad.Enable,
-				'day', ad.day,
-				'start_time', ad.start_time,
-				'end_time', ad.end_time
-			))
-	        FROM availability_days AS ad
-	        WHERE ad.availability_id = a.id
-	    ) AS availability_days
-	FROM availabilities AS a WHERE a.user_id = 1`
-	row := s.db.QueryRowContext(ctx, q, uid)
-	var av Availability
-	var availabilityDaysJSON []byte
-	if err := row.Scan(&av.ID, &av.UserID,
-		&av.Label, &av.Timezone, &availabilityDaysJSON); err != nil {
+	provider string,
+) ([]*CalendarChannel, error) {
+	q := "SELECT " + calendarChannelColumns + " FROM calendar_channels "
+	q += "WHERE user_id = ? AND provider = ? ORDER BY id"
+	rows, err := s.db.QueryContext(ctx, q, uid, provider)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	var channels []*CalendarChannel
+	for rows.Next() {
+		channel, err := scanCalendarChannel(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		channels = append(channels, channel)
+	}
+	return channels, rows.Err()
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) UpdateCalendarChannelSyncToken(
+	ctx context.Context,
+	id int,
+	syncToken string,
+) error {
+	q := "UPDATE calendar_channels SET sync_token = NULLIF(?, '') WHERE id = ?"
+	_, err := s.db.ExecContext(ctx, q, syncToken, id)
+	return err
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) UpdateCalendarChannelExpiry(
+	ctx context.Context,
+	id int,
+	expiresAt int64,
+) error {
+	q := "UPDATE calendar_channels SET expires_at = ? WHERE id = ?"
+	_, err := s.db.ExecContext(ctx, q, expiresAt, id)
+	return err
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) DeleteCalendarChannel(
+	ctx context.Context,
+	id int,
+) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM calendar_channels WHERE id = ?", id)
+	return err
+}
+
+// InsertCalendarMirror stores mirror in place of the previous mirror of
+// the same calendar, the events of the previous one are deleted with it.
+//
+//goland:noinspection ALL
+func (s sqlRepository) InsertCalendarMirror(
+	ctx context.Context,
+	mirror *CalendarMirror,
+) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+	for _, q := range []string{
+		"DELETE FROM external_events WHERE user_id = ? AND provider = ?",
+		"DELETE FROM calendar_mirrors WHERE user_id = ? AND provider = ?",
+	} {
+		if _, err := tx.ExecContext(ctx, q, mirror.UserID, mirror.Provider); err != nil {
+			return 0, err
+		}
+	}
+	q := "INSERT INTO calendar_mirrors (user_id, provider, created_at) "
+	q += "VALUES ($1, $2, $3) RETURNING id"
+	var id int
+	if err := tx.QueryRowContext(ctx, q, mirror.UserID, mirror.Provider,
+		mirror.CreatedAt).Scan(&id); err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+const calendarMirrorColumns = "id, user_id, provider, COALESCE(cursor, ''), window_start, " +
+	"window_end, synced_at, COALESCE(error, ''), created_at"
+
+func scanCalendarMirror(scan func(dest ...any) error) (*CalendarMirror, error) {
+	var mirror CalendarMirror
+	if err := scan(&mirror.ID, &mirror.UserID, &mirror.Provider, &mirror.Cursor,
+		&mirror.WindowStart, &mirror.WindowEnd, &mirror.SyncedAt, &mirror.Error,
+		&mirror.CreatedAt,
+	); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return nil, fmt.Errorf(
-				"user availabililty with id %d not found",
-				uid)
+			return nil, nil
 		}
 		return nil, err
 	}
-	var availabilityDays []*AvailabilityDay
-	if err := json.Unmarshal(availabilityDaysJSON, &availabilityDays); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal availability_days: %v", err)
-	}
-	av.Days = availabilityDays
-	return &av, nil
+	return &mirror, nil
 }
 
+// FindCalendarMirror returns the mirror id, nil when it was replaced or
+// deleted since.
+//
 //goland:noinspection ALL
-func (s sqlRepository) FindUserEventType(
+func (s sqlRepository) FindCalendarMirror(
+	ctx context.Context,
+	id int,
+) (*CalendarMirror, error) {
+	q := "SELECT " + calendarMirrorColumns + " FROM calendar_mirrors WHERE id = ?"
+	return scanCalendarMirror(s.db.QueryRowContext(ctx, q, id).Scan)
+}
+
+// FindUserCalendarMirror returns the mirror of the calendar uid connected
+// with provider, nil when there is none yet.
+//
+//goland:noinspection ALL
+func (s sqlRepository) FindUserCalendarMirror(
 	ctx context.Context,
 	uid int,
-) ([]*EventType, error) {
-	q := `SELECT
-	    et.id,
-	    et.user_id,
-	    et.availability_id,
-	    et.enable,
-	    et.title,
-	    et.description,
-	    et.duration,
-	    a.id as av_id,
-	    a.label as av_label,
-	    a.timezone as av_timezone,
-        (
-            SELECT json_group_array(
-                json_object(
-                    'id', ad.id,
-                    'user_id', ad.user_id,
-                    'availability_id', ad.availability_id,
-                    'Enable', ad.Enable,
-                    'day', ad.day,
-                    'start_time', ad.start_time,
-                    'end_time', ad.end_time
-                )
-            )
-            FROM availability_days AS ad
-            WHERE ad.availability_id = a.id
-        ) AS availability_days
-	FROM event_types AS et
-	LEFT JOIN availabilities AS a ON et.availability_id = a.id
-	WHERE et.user_id = ?`
-	rows, err := s.db.QueryContext(ctx, q, uid)
+	provider string,
+) (*CalendarMirror, error) {
+	q := "SELECT " + calendarMirrorColumns + " FROM calendar_mirrors "
+	q += "WHERE user_id = ? AND provider = ?"
+	return scanCalendarMirror(s.db.QueryRowContext(ctx, q, uid, provider).Scan)
+}
+
+// SaveExternalEvents stores the events of a sync of mirror and deletes the
+// removed ones, a full sync replaces every event. The cursor and window of
+// mirror are stored along so a failed sync is repeated as a whole.
+//
+//goland:noinspection ALL
+func (s sqlRepository) SaveExternalEvents(
+	ctx context.Context,
+	mirror *CalendarMirror,
+	full bool,
+	events []*ExternalEvent,
+	removed []string,
+) error {
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	var eventTypes []*EventType
-	for rows.Next() {
-		var et EventType
-		var av Availability
-		var availabilityDaysJSON []byte
-		if err := rows.Scan(
-			&et.ID, &et.UserID, &et.AvailabilityID,
-			&et.Enable, &et.Title, &et.Description,
-			&et.Duration, &av.ID, &av.Label, &av.Timezone,
-			&availabilityDaysJSON,
-		); err != nil {
-			return nil, err
+	defer func() { _ = tx.Rollback() }()
+	if full {
+		q := "DELETE FROM external_events WHERE user_id = ? AND provider = ?"
+		if _, err := tx.ExecContext(ctx, q, mirror.UserID, mirror.Provider); err != nil {
+			return err
 		}
-		var availabilityDays []*AvailabilityDay
-		if err := json.Unmarshal(availabilityDaysJSON, &availabilityDays); err != nil {
-			return nil, fmt.Errorf("failed to unmarshal availability_days: %v", err)
+	}
+	q := "INSERT INTO external_events (user_id, provider, event_id, title, start_at, end_at, "
+	q += "all_day, busy, link, meeting_url, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7, "
+	q += "$8, NULLIF($9, ''), NULLIF($10, ''), $11) ON CONFLICT (user_id, provider, event_id) "
+	q += "DO UPDATE SET title = excluded.title, start_at = excluded.start_at, "
+	q += "end_at = excluded.end_at, all_day = excluded.all_day, busy = excluded.busy, "
+	q += "link = excluded.link, meeting_url = excluded.meeting_url, updated_at = excluded.updated_at"
+	for _, event := range events {
+		if _, err := tx.ExecContext(ctx, q, mirror.UserID, mirror.Provider, event.ID,
+			event.Title, event.StartAt, event.EndAt, event.AllDay, event.Busy, event.Link,
+			event.MeetingURL, event.UpdatedAt); err != nil {
+			return err
 		}
-		sort.Slice(availabilityDays, func(i, j int) bool {
-			return availabilityDays[i].Enable > availabilityDays[j].Enable
-		})
-		et.Availability = &Availability{
-			ID:       av.ID,
-			Label:    av.Label,
-			Timezone: av.Timezone,
-			Days:     availabilityDays,
+	}
+	// occurrences of Google are named after their recurring event
+	q = "DELETE FROM external_events WHERE user_id = $1 AND provider = $2 AND "
+	q += "(event_id = $3 OR substr(event_id, 1, length($3) + 1) = $3 || '_')"
+	for _, eventID := range removed {
+		if _, err := tx.ExecContext(ctx, q, mirror.UserID, mirror.Provider, eventID); err != nil {
+			return err
 		}
-		eventTypes = append(eventTypes, &et)
 	}
-	return eventTypes, nil
+	q = "UPDATE calendar_mirrors SET cursor = NULLIF($1, ''), window_start = $2, window_end = $3, "
+	q += "synced_at = $4, error = NULL WHERE id = $5"
+	if _, err := tx.ExecContext(ctx, q, mirror.Cursor, mirror.WindowStart, mirror.WindowEnd,
+		mirror.SyncedAt, mirror.ID); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 //goland:noinspection ALL
-func (s sqlRepository) UpdateUser(
+func (s sqlRepository) UpdateCalendarMirrorError(
 	ctx context.Context,
-	username string,
-	tokenType string,
-	tokenValue []byte,
+	id int,
+	syncErr string,
 ) error {
-	// google_token || microsoft_token
-	q := "UPDATE users SET "
-	q += fmt.Sprintf("%s = ? WHERE username = ?", tokenType)
-	_, err := s.db.ExecContext(ctx, q, tokenValue, username)
+	q := "UPDATE calendar_mirrors SET error = NULLIF(?, '') WHERE id = ?"
+	_, err := s.db.ExecContext(ctx, q, syncErr, id)
+	return err
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) DeleteCalendarMirror(
+	ctx context.Context,
+	id int,
+) error {
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
-	return nil
+	defer func() { _ = tx.Rollback() }()
+	q := "DELETE FROM external_events WHERE (user_id, provider) = "
+	q += "(SELECT user_id, provider FROM calendar_mirrors WHERE id = ?)"
+	if _, err := tx.ExecContext(ctx, q, id); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM calendar_mirrors WHERE id = ?", id); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
+// FindExternalEvents returns up to limit events of uid overlapping from
+// to to ordered by start, of every provider when provider is empty.
+//
 //goland:noinspection ALL
-func (s sqlRepository) FindBooking(
+func (s sqlRepository) FindExternalEvents(
 	ctx context.Context,
-	bookingID int,
-) (*Booking, error) {
-	q := "SELECT id, title, notes, name, email, date, time, event FROM bookings WHERE id = ?"
-	row := s.db.QueryRowContext(ctx, q, bookingID)
-	var booking Booking
-	var bookingJSON []byte
-	err := row.Scan(&booking.ID, &booking.Title, &booking.Notes,
-		&booking.Name, &booking.Email, &booking.Date, &booking.Time, &bookingJSON)
+	uid int,
+	provider string,
+	from, to int64,
+	limit int,
+) ([]*ExternalEvent, error) {
+	q := "SELECT event_id, user_id, provider, title, start_at, end_at, all_day, busy, "
+	q += "COALESCE(link, ''), COALESCE(meeting_url, ''), updated_at FROM external_events "
+	q += "WHERE user_id = $1 AND start_at < $2 AND end_at > $3 "
+	args := []interface{}{uid, to, from}
+	if provider != "" {
+		q += "AND provider = $4 "
+		args = append(args, provider)
+	}
+	q += fmt.Sprintf("ORDER BY start_at, id LIMIT %d", limit)
+	rows, err := s.db.QueryContext(ctx, q, args...)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, fmt.Errorf(
-				"booking with id %d not found",
-				bookingID)
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	events := []*ExternalEvent{}
+	for rows.Next() {
+		var event ExternalEvent
+		if err := rows.Scan(&event.ID, &event.UserID, &event.Provider, &event.Title,
+			&event.StartAt, &event.EndAt, &event.AllDay, &event.Busy, &event.Link,
+			&event.MeetingURL, &event.UpdatedAt,
+		); err != nil {
+			return nil, err
 		}
+		events = append(events, &event)
+	}
+	return events, rows.Err()
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) FindExternalBusyTimes(
+	ctx context.Context,
+	uid int,
+	provider string,
+	from, to int64,
+) ([]hof.BusyTime, error) {
+	q := "SELECT start_at, end_at FROM external_events WHERE user_id = $1 AND provider = $2 "
+	q += "AND busy = 1 AND start_at < $3 AND end_at > $4"
+	rows, err := s.db.QueryContext(ctx, q, uid, provider, to, from)
+	if err != nil {
 		return nil, err
 	}
-	if err := json.Unmarshal(bookingJSON, &booking.EventDetail); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal availability_days: %v", err)
+	defer func() { _ = rows.Close() }()
+	var busy []hof.BusyTime
+	for rows.Next() {
+		var start, end int64
+		if err := rows.Scan(&start, &end); err != nil {
+			return nil, err
+		}
+		busy = append(busy, hof.BusyTime{
+			Start: time.Unix(start, 0), End: time.Unix(end, 0)})
 	}
-	return &booking, nil
+	return busy, rows.Err()
 }
 
 //goland:noinspection ALL
-func (s sqlRepository) InsertBooking(
+func (s sqlRepository) InsertWebhook(
 	ctx context.Context,
-	booking *Booking,
+	webhook *Webhook,
 ) (int, error) {
-	q := "INSERT INTO bookings (user_id, event_type_id, title, notes, name, email, date, time, event, location, created_at) "
-	q += "values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) RETURNING id"
-	row := s.db.QueryRowContext(ctx, q, booking.UserID, booking.EventTypeID, booking.Title,
-		booking.Notes, booking.Name, booking.Email, booking.Date, booking.Time,
-		booking.Event, booking.Location, time.Now().Unix())
+	q := "INSERT INTO webhooks (user_id, url, secret, events, created_at) "
+	q += "VALUES ($1, $2, $3, $4, $5) RETURNING id"
+	row := s.db.QueryRowContext(ctx, q, webhook.UserID, webhook.URL, webhook.Secret,
+		strings.Join(webhook.Events, " "), webhook.CreatedAt)
+	var id int
+	if err := row.Scan(&id); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+const webhookColumns = "id, user_id, url, secret, events, created_at"
+
+func scanWebhook(scan func(dest ...any) error) (*Webhook, error) {
+	var webhook Webhook
+	var events string
+	if err := scan(&webhook.ID, &webhook.UserID, &webhook.URL,
+		&webhook.Secret, &events, &webhook.CreatedAt,
+	); err != nil {
+		return nil, err
+	}
+	webhook.Events = strings.Fields(events)
+	return &webhook, nil
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) FindUserWebhooks(
+	ctx context.Context,
+	uid int,
+) ([]*Webhook, error) {
+	q := "SELECT " + webhookColumns + " FROM webhooks WHERE user_id = ? ORDER BY id"
+	rows, err := s.db.QueryContext(ctx, q, uid)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	webhooks := []*Webhook{}
+	for rows.Next() {
+		webhook, err := scanWebhook(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks, rows.Err()
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) FindUserWebhook(
+	ctx context.Context,
+	uid, id int,
+) (*Webhook, error) {
+	q := "SELECT " + webhookColumns + " FROM webhooks WHERE id = ? AND user_id = ?"
+	webhook, err := scanWebhook(s.db.QueryRowContext(ctx, q, id, uid).Scan)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("webhook with id %d not found", id)
+	}
+	return webhook, err
+}
+
+// DeleteWebhook deletes the webhook of the user uid with its deliveries.
+//
+//goland:noinspection ALL
+func (s sqlRepository) DeleteWebhook(
+	ctx context.Context,
+	uid, id int,
+) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	res, err := tx.ExecContext(ctx, "DELETE FROM webhooks WHERE id = ? AND user_id = ?", id, uid)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return fmt.Errorf("webhook with id %d not found", id)
+	}
+	q := "DELETE FROM webhook_deliveries WHERE webhook_id = ?"
+	if _, err := tx.ExecContext(ctx, q, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) InsertWebhookDelivery(
+	ctx context.Context,
+	delivery *WebhookDelivery,
+) (int, error) {
+	q := "INSERT INTO webhook_deliveries (webhook_id, event, payload, status, created_at) "
+	q += "VALUES ($1, $2, $3, $4, $5) RETURNING id"
+	row := s.db.QueryRowContext(ctx, q, delivery.WebhookID, delivery.Event,
+		string(delivery.Payload), delivery.Status, delivery.CreatedAt)
 	var id int
 	if err := row.Scan(&id); err != nil {
 		return 0, err
@@ -237,6 +2782,84 @@ func (s sqlRepository) InsertBooking(
 	return id, nil
 }
 
+const webhookDeliveryColumns = "d.id, d.webhook_id, d.event, d.payload, d.status, d.attempts, " +
+	"COALESCE(d.response_status, 0), COALESCE(d.response_body, ''), COALESCE(d.error, ''), " +
+	"d.created_at, COALESCE(d.delivered_at, 0)"
+
+func scanWebhookDelivery(scan func(dest ...any) error, extra ...any) (*WebhookDelivery, error) {
+	var delivery WebhookDelivery
+	var payload string
+	dest := []any{&delivery.ID, &delivery.WebhookID, &delivery.Event, &payload,
+		&delivery.Status, &delivery.Attempts, &delivery.ResponseStatus,
+		&delivery.ResponseBody, &delivery.Error, &delivery.CreatedAt, &delivery.DeliveredAt}
+	if err := scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+	delivery.Payload = json.RawMessage(payload)
+	return &delivery, nil
+}
+
+// FindWebhookDelivery returns the delivery with the webhook it goes to,
+// nil when the webhook was deleted since.
+//
+//goland:noinspection ALL
+func (s sqlRepository) FindWebhookDelivery(
+	ctx context.Context,
+	id int,
+) (*WebhookDelivery, error) {
+	q := "SELECT " + webhookDeliveryColumns + ", w.url, w.secret FROM webhook_deliveries d "
+	q += "JOIN webhooks w ON w.id = d.webhook_id WHERE d.id = ?"
+	var webhook Webhook
+	delivery, err := scanWebhookDelivery(s.db.QueryRowContext(ctx, q, id).Scan,
+		&webhook.URL, &webhook.Secret)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	webhook.ID = delivery.WebhookID
+	delivery.Webhook = &webhook
+	return delivery, nil
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) FindWebhookDeliveries(
+	ctx context.Context,
+	webhookID int,
+) ([]*WebhookDelivery, error) {
+	q := "SELECT " + webhookDeliveryColumns + " FROM webhook_deliveries d "
+	q += "WHERE d.webhook_id = ? ORDER BY d.id DESC LIMIT 100"
+	rows, err := s.db.QueryContext(ctx, q, webhookID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	deliveries := []*WebhookDelivery{}
+	for rows.Next() {
+		delivery, err := scanWebhookDelivery(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, rows.Err()
+}
+
+//goland:noinspection ALL
+func (s sqlRepository) UpdateWebhookDelivery(
+	ctx context.Context,
+	delivery *WebhookDelivery,
+) error {
+	q := "UPDATE webhook_deliveries SET status = $1, attempts = $2, response_status = NULLIF($3, 0), "
+	q += "response_body = NULLIF($4, ''), error = NULLIF($5, ''), delivered_at = NULLIF($6, 0) "
+	q += "WHERE id = $7"
+	_, err := s.db.ExecContext(ctx, q, delivery.Status, delivery.Attempts,
+		delivery.ResponseStatus, delivery.ResponseBody, delivery.Error,
+		delivery.DeliveredAt, delivery.ID)
+	return err
+}
+
 func newSQLRepository(db *sql.DB) ISQLRepository {
 	return sqlRepository{db: db}
 }
@@ -4,10 +4,15 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/0xForked/goca/server/hof"
 	"golang.org/x/oauth2"
+	"google.golang.org/api/calendar/v3"
 )
 
 type IUserService interface {
@@ -16,13 +21,111 @@ type IUserService interface {
 	EventType(ctx context.Context, uid int, uname string) ([]*EventType, error)
 	SaveGoogleToken(ctx context.Context, username string, googleToken *oauth2.Token) error
 	SaveMicrosoftToken(ctx context.Context, username string, microsoftToken *oauth2.Token) error
+	MicrosoftProfile(ctx context.Context, microsoftToken *oauth2.Token) (*hof.MSUserProfile, error)
 	Login(ctx context.Context, form *LoginForm) (map[string]interface{}, error)
+	OIDCLogin(ctx context.Context, form *OIDCLoginForm) (map[string]interface{}, error)
+	LoginTOTP(ctx context.Context, form *LoginTOTPForm) (map[string]interface{}, error)
+	SetupTOTP(ctx context.Context, username string) (map[string]interface{}, error)
+	EnableTOTP(ctx context.Context, username string, form *TOTPCodeForm) ([]string, error)
+	DisableTOTP(ctx context.Context, username string, form *DisableTOTPForm) error
+	RegenerateRecoveryCodes(ctx context.Context, username string, form *TOTPCodeForm) ([]string, error)
+	RefreshToken(ctx context.Context, form *RefreshTokenForm) (map[string]interface{}, error)
+	Logout(ctx context.Context, jti string) error
+	LogoutAll(ctx context.Context, uid int) error
+	IsSessionRevoked(ctx context.Context, jti string) (bool, error)
+	Register(ctx context.Context, form *RegisterForm) (*User, error)
+	NewUser(ctx context.Context, form *NewUserForm) (*User, error)
+	ForgotPassword(ctx context.Context, form *ForgotPasswordForm)
+	ResetPassword(ctx context.Context, form *ResetPasswordForm) error
+	ChangeEmail(ctx context.Context, username string, form *ChangeEmailForm) error
+	ChangePassword(ctx context.Context, username string, form *ChangePasswordForm) error
+	DeleteAccount(ctx context.Context, username string, form *DeleteAccountForm) error
+	UpdateEventType(ctx context.Context, uid, id int, form *EventTypeForm) error
+	UpdateEventTypeQuestions(ctx context.Context, uid, id int, form *QuestionsForm) error
 	Booking(ctx context.Context, uid int) (*Booking, error)
-	NewBooking(ctx context.Context, userID int, title string, form *BookingForm, event interface{}) (int, error)
+	Bookings(ctx context.Context, uid int) ([]*Booking, error)
+	APIKeys(ctx context.Context, uid int) ([]*APIKey, error)
+	NewAPIKey(ctx context.Context, uid int, form *APIKeyForm) (*APIKey, error)
+	RevokeAPIKey(ctx context.Context, uid, id int) error
+	AuthenticateAPIKey(ctx context.Context, key string) (*hof.APIKeyPrincipal, error)
+	NewBooking(ctx context.Context, target *BookingTarget, title string, form *BookingForm, event interface{}) (int, error)
+	CancelBooking(ctx context.Context, uid, bookingID int, form *CancelBookingForm) error
+	PendingBooking(ctx context.Context, uid, bookingID int) (*BookingTarget, *Booking, error)
+	ConfirmBooking(ctx context.Context, target *BookingTarget, booking *Booking) error
+	RejectBooking(ctx context.Context, uid, bookingID int) error
+	RescheduleBooking(ctx context.Context, uid, bookingID int, form *RescheduleBookingForm) error
+	SendBookingReminder(ctx context.Context, payload []byte) error
+	SendBookingMail(ctx context.Context, payload []byte) error
+	SyncBookingEvent(ctx context.Context, payload []byte) error
+	GoogleNotification(ctx context.Context, channelID, token, resourceID, state string) error
+	SyncGoogleChannel(ctx context.Context, payload []byte) error
+	RenewGoogleChannel(ctx context.Context, payload []byte) error
+	MicrosoftNotifications(ctx context.Context, notifications []hof.MSNotification) error
+	SyncMicrosoftEvent(ctx context.Context, payload []byte) error
+	RenewMicrosoftChannel(ctx context.Context, payload []byte) error
+	MirrorCalendar(ctx context.Context, payload []byte) error
+	Events(ctx context.Context, user *User, from, to time.Time, limit int) ([]*ExternalEvent, map[string]string)
+	Webhooks(ctx context.Context, uid int) ([]*Webhook, error)
+	NewWebhook(ctx context.Context, uid int, form *WebhookForm) (*Webhook, error)
+	DeleteWebhook(ctx context.Context, uid, id int) error
+	WebhookDeliveries(ctx context.Context, uid, id int) ([]*WebhookDelivery, error)
+	TestWebhook(ctx context.Context, uid, id int) (*WebhookDelivery, error)
+	DeliverWebhook(ctx context.Context, payload []byte) error
+	BookingTarget(ctx context.Context, form *BookingForm) (*BookingTarget, error)
+	Slots(ctx context.Context, eventTypeID int, date int64) ([]*Slot, error)
+	Teams(ctx context.Context, uid int) ([]*Team, error)
+	NewTeam(ctx context.Context, uid int, form *TeamForm) (*Team, error)
+	Team(ctx context.Context, uid, id int) (*Team, error)
+	PublicTeam(ctx context.Context, slug string) (*Team, error)
+	AddTeamMember(ctx context.Context, uid, teamID int, form *TeamMemberForm) (*TeamMember, error)
+	RemoveTeamMember(ctx context.Context, uid, teamID, memberID int) error
+	NewTeamEventType(ctx context.Context, uid, teamID int, form *TeamEventTypeForm) (*EventType, error)
+	Users(ctx context.Context) ([]*User, error)
+	UpdateUser(ctx context.Context, adminID, uid int, form *UpdateUserForm) (*User, error)
+	Impersonate(ctx context.Context, adminID, uid int, userAgent, ipAddress string) (map[string]interface{}, error)
+	AllBookings(ctx context.Context) ([]*Booking, error)
 }
 
+const (
+	appURL               = "http://localhost:8000"
+	accessTokenLifetime  = time.Minute * 30
+	refreshTokenLifetime = time.Hour * 24 * 30
+	passwordResetTTL     = time.Hour
+	totpIssuer           = "Goca"
+	loginChallengeTTL    = time.Minute * 5
+	loginChallengeTries  = 5
+	recoveryCodeCount    = 10
+	// failed logins allowed before the account is locked,
+	// every further failure doubles the lock up to lockoutMax
+	lockoutThreshold = 5
+	lockoutBase      = time.Second * 30
+	lockoutMax       = time.Hour
+	// impersonation sessions cannot be refreshed
+	impersonationLifetime = time.Hour
+	// unanswered booking requests release their slot after this
+	// long, or when the slot starts if that is sooner
+	confirmationTTL = time.Hour * 24
+)
+
 type service struct {
 	repository ISQLRepository
+	mailer     hof.IMailSender
+	jobs       hof.IJobScheduler
+	// googleCalendar returns the Calendar API acting with the token of a
+	// user, tests point it at a stand-in
+	googleCalendar func(ctx context.Context, tok *oauth2.Token) (*calendar.Service, error)
+	// microsoftOAuth refreshes the Microsoft tokens of users and graphClient
+	// calls graph with them, tests point both at a stand-in
+	microsoftOAuth func() *oauth2.Config
+	graphClient    func(ts oauth2.TokenSource) *hof.GraphClient
+	// sendWebhook posts a delivery to its webhook, tests replace it with a
+	// receiver of their own
+	sendWebhook func(
+		ctx context.Context,
+		url, secret, event string,
+		deliveryID int,
+		body []byte,
+	) (*hof.WebhookResponse, error)
 }
 
 func (s service) Profile(
@@ -76,8 +179,22 @@ func (s service) SaveGoogleToken(
 	if err != nil {
 		return err
 	}
-	return s.repository.UpdateUser(
-		ctx, username, "google_token", data)
+	if err := s.repository.UpdateUser(
+		ctx, username, "google_token", data); err != nil {
+		return err
+	}
+	// without notifications only changes made in Google itself are missed
+	user, err := s.repository.FindUserProfile(ctx, username)
+	if err != nil {
+		return err
+	}
+	if err := s.watchGoogleCalendar(ctx, user, ""); err != nil {
+		log.Printf("unable to watch google calendar of %s: %s", username, err)
+	}
+	if err := s.startMirror(ctx, user.ID, providerGoogle); err != nil {
+		log.Printf("unable to mirror google calendar of %s: %s", username, err)
+	}
+	return nil
 }
 
 func (s service) SaveMicrosoftToken(
@@ -89,8 +206,31 @@ func (s service) SaveMicrosoftToken(
 	if err != nil {
 		return err
 	}
-	return s.repository.UpdateUser(
-		ctx, username, "microsoft_token", data)
+	if err := s.repository.UpdateUser(
+		ctx, username, "microsoft_token", data); err != nil {
+		return err
+	}
+	// without notifications only changes made in Outlook itself are missed
+	user, err := s.repository.FindUserProfile(ctx, username)
+	if err != nil {
+		return err
+	}
+	if err := s.watchMicrosoftCalendar(ctx, user); err != nil {
+		log.Printf("unable to watch microsoft calendar of %s: %s", username, err)
+	}
+	if err := s.startMirror(ctx, user.ID, providerMicrosoft); err != nil {
+		log.Printf("unable to mirror microsoft calendar of %s: %s", username, err)
+	}
+	return nil
+}
+
+// MicrosoftProfile reads the graph profile of the account microsoftToken
+// belongs to.
+func (s service) MicrosoftProfile(
+	ctx context.Context,
+	microsoftToken *oauth2.Token,
+) (*hof.MSUserProfile, error) {
+	return s.graphClient(s.microsoftOAuth().TokenSource(ctx, microsoftToken)).UserProfile(ctx)
 }
 
 func (s service) Login(
@@ -101,81 +241,2044 @@ func (s service) Login(
 	if err != nil {
 		return nil, err
 	}
+	if user.Password == "" {
+		return nil, errors.New("account has no password, sign in with google or microsoft")
+	}
+	// checked before hashing so a locked account costs no scrypt round
+	if wait := time.Until(time.Unix(user.LockedUntil, 0)); wait > 0 {
+		return nil, &hof.RetryAfterError{
+			Message:    "account is locked after too many failed login attempts",
+			RetryAfter: wait,
+		}
+	}
 	if err := s.validatePassword(user.Password, form.Password); err != nil {
+		if err := s.recordFailedLogin(ctx, user); err != nil {
+			return nil, err
+		}
+		return nil, err
+	}
+	if user.FailedLogins > 0 {
+		if err := s.repository.UpdateUserLoginFailures(ctx, user.ID, 0, 0); err != nil {
+			return nil, err
+		}
+	}
+	return s.completeLogin(ctx, user, form.UserAgent, form.IPAddress)
+}
+
+func (s service) recordFailedLogin(ctx context.Context, user *User) error {
+	attempts, err := s.repository.IncrementUserLoginFailures(ctx, user.ID)
+	if err != nil || attempts < lockoutThreshold {
+		return err
+	}
+	lockout := lockoutBase << (attempts - lockoutThreshold)
+	if lockout > lockoutMax || lockout <= 0 {
+		lockout = lockoutMax
+	}
+	return s.repository.LockUser(ctx, user.ID, time.Now().Add(lockout).Unix())
+}
+
+func (s service) LoginTOTP(
+	ctx context.Context,
+	form *LoginTOTPForm,
+) (map[string]interface{}, error) {
+	challenge, err := s.repository.FindLoginChallenge(ctx, hof.HashToken(form.ChallengeToken))
+	if err != nil {
+		return nil, errors.New("login challenge is not valid")
+	}
+	if challenge.UsedAt.Valid || challenge.ExpiresAt < time.Now().Unix() ||
+		challenge.Attempts >= loginChallengeTries {
+		return nil, errors.New("login challenge is expired, please sign in again")
+	}
+	user, err := s.repository.FindUserByID(ctx, challenge.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if form.RecoveryCode != "" {
+		err = s.useRecoveryCode(ctx, user, form.RecoveryCode)
+	} else {
+		err = s.verifyTOTP(ctx, user, form.Code)
+	}
+	if err != nil {
+		if err := s.repository.IncrementLoginChallengeAttempts(ctx, challenge.ID); err != nil {
+			return nil, err
+		}
+		return nil, err
+	}
+	if err := s.repository.UseLoginChallenge(ctx, challenge.ID); err != nil {
 		return nil, err
 	}
-	at, ate, err := s.generateToken(user)
+	return s.startSession(ctx, user, form.UserAgent, form.IPAddress)
+}
+
+func (s service) SetupTOTP(
+	ctx context.Context,
+	username string,
+) (map[string]interface{}, error) {
+	user, err := s.Profile(ctx, username, false)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabled {
+		return nil, errors.New("two-factor authentication is already enabled")
+	}
+	secret, err := hof.GenerateTOTPSecret()
 	if err != nil {
 		return nil, err
 	}
+	if err := s.repository.SetUserTOTPSecret(ctx, user.ID, secret); err != nil {
+		return nil, err
+	}
 	return map[string]interface{}{
-		"type":       "Bearer",
-		"token":      at,
-		"expires_in": ate,
+		"secret": secret,
+		"uri":    hof.TOTPProvisioningURI(totpIssuer, user.Username, secret),
 	}, nil
 }
 
-func (s service) validatePassword(hash, userPwd string) error {
-	h := hof.PasswordHash{Stored: hash, Supplied: userPwd}
-	isValid, err := h.ComparePassword(hof.Parallelization)
+func (s service) EnableTOTP(
+	ctx context.Context,
+	username string,
+	form *TOTPCodeForm,
+) ([]string, error) {
+	user, err := s.Profile(ctx, username, false)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabled {
+		return nil, errors.New("two-factor authentication is already enabled")
+	}
+	if !user.TOTPSecret.Valid {
+		return nil, errors.New("two-factor authentication has not been set up")
+	}
+	step, ok := hof.ValidateTOTP(user.TOTPSecret.String, form.Code, time.Now())
+	if !ok {
+		return nil, errors.New("verification code is not valid")
+	}
+	codes, hashes, err := s.generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repository.EnableUserTOTP(ctx, user.ID, step, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func (s service) DisableTOTP(
+	ctx context.Context,
+	username string,
+	form *DisableTOTPForm,
+) error {
+	user, err := s.Profile(ctx, username, true)
 	if err != nil {
 		return err
 	}
-	if !isValid {
-		return errors.New("password is not valid")
+	if !user.TOTPEnabled {
+		return errors.New("two-factor authentication is not enabled")
 	}
-	return nil
+	if user.Password != "" {
+		if err := s.validatePassword(user.Password, form.Password); err != nil {
+			return err
+		}
+	}
+	if err := s.verifyTOTP(ctx, user, form.Code); err != nil {
+		return err
+	}
+	return s.repository.DisableUserTOTP(ctx, user.ID)
 }
 
-func (s service) generateToken(
+func (s service) RegenerateRecoveryCodes(
+	ctx context.Context,
+	username string,
+	form *TOTPCodeForm,
+) ([]string, error) {
+	user, err := s.Profile(ctx, username, false)
+	if err != nil {
+		return nil, err
+	}
+	if !user.TOTPEnabled {
+		return nil, errors.New("two-factor authentication is not enabled")
+	}
+	if err := s.verifyTOTP(ctx, user, form.Code); err != nil {
+		return nil, err
+	}
+	codes, hashes, err := s.generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repository.ReplaceRecoveryCodes(ctx, user.ID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func (s service) verifyTOTP(ctx context.Context, user *User, code string) error {
+	step, ok := hof.ValidateTOTP(user.TOTPSecret.String, code, time.Now())
+	if !ok || step <= user.TOTPLastStep {
+		return errors.New("verification code is not valid")
+	}
+	return s.repository.UpdateUserTOTPStep(ctx, user.ID, step)
+}
+
+func (s service) useRecoveryCode(ctx context.Context, user *User, code string) error {
+	codes, err := s.repository.FindUnusedRecoveryCodes(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	code = normalizeRecoveryCode(code)
+	for _, rc := range codes {
+		h := hof.PasswordHash{Stored: rc.Code, Supplied: code}
+		if ok, err := h.ComparePassword(hof.Parallelization); err == nil && ok {
+			return s.repository.UseRecoveryCode(ctx, rc.ID)
+		}
+	}
+	return errors.New("recovery code is not valid")
+}
+
+// generateRecoveryCodes returns the codes shown once to the user
+// and their scrypt hashes to store.
+func (s service) generateRecoveryCodes() (codes, hashes []string, err error) {
+	for i := 0; i < recoveryCodeCount; i++ {
+		raw, err := hof.GenerateRandomToken(5)
+		if err != nil {
+			return nil, nil, err
+		}
+		hash, err := s.hashPassword(raw)
+		if err != nil {
+			return nil, nil, err
+		}
+		codes = append(codes, raw[:5]+"-"+raw[5:])
+		hashes = append(hashes, hash)
+	}
+	return codes, hashes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// completeLogin starts a session, or when two-factor authentication is
+// enabled returns a short-lived challenge to be completed with LoginTOTP.
+func (s service) completeLogin(
+	ctx context.Context,
 	user *User,
-) (at string, ate *time.Time, err error) {
-	jwtToken := hof.JSONWebToken{}
-	jwtToken.IssuedAt = time.Now()
-	tokenExpiredIn := jwtToken.IssuedAt.Add(time.Minute * 30)
-	jwtToken.ExpiredAt = tokenExpiredIn
-	accessToken, err := jwtToken.Claim(map[string]interface{}{
-		"id":       user.ID,
-		"username": user.Username,
-	})
+	userAgent, ipAddress string,
+) (map[string]interface{}, error) {
+	if user.DisabledAt > 0 {
+		return nil, errors.New("account is disabled")
+	}
+	if !user.TOTPEnabled {
+		return s.startSession(ctx, user, userAgent, ipAddress)
+	}
+	token, err := hof.GenerateRandomToken(32)
 	if err != nil {
-		return "", nil, err
+		return nil, err
 	}
-	return accessToken, &tokenExpiredIn, nil
+	now := time.Now()
+	challenge := &LoginChallenge{
+		UserID:    user.ID,
+		Token:     hof.HashToken(token),
+		CreatedAt: now.Unix(),
+		ExpiresAt: now.Add(loginChallengeTTL).Unix(),
+	}
+	if challenge.ID, err = s.repository.InsertLoginChallenge(ctx, challenge); err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"type":            "totp",
+		"challenge_token": token,
+		"expires_in":      time.Unix(challenge.ExpiresAt, 0),
+	}, nil
 }
 
-func (s service) Booking(ctx context.Context, uid int) (*Booking, error) {
-	return s.repository.FindBooking(ctx, uid)
+func (s service) OIDCLogin(
+	ctx context.Context,
+	form *OIDCLoginForm,
+) (map[string]interface{}, error) {
+	user, err := s.findOrCreateOIDCUser(ctx, form)
+	if err != nil {
+		return nil, err
+	}
+	return s.completeLogin(ctx, user, form.UserAgent, form.IPAddress)
 }
 
-func (s service) NewBooking(
+// findOrCreateOIDCUser resolves the account behind an identity, linking it
+// to an existing account only when the provider verified the email.
+func (s service) findOrCreateOIDCUser(
 	ctx context.Context,
-	userID int,
-	title string,
-	form *BookingForm,
-	event interface{},
-) (int, error) {
-	newEvent, err := json.Marshal(event)
+	form *OIDCLoginForm,
+) (*User, error) {
+	identity, err := s.repository.FindUserIdentity(ctx, form.Provider, form.Subject)
+	if err == nil {
+		return s.repository.FindUserByID(ctx, identity.UserID)
+	}
+	if !errors.Is(err, errNotFound) {
+		return nil, err
+	}
+	var user *User
+	if form.Email != "" {
+		user, err = s.repository.FindUserByEmail(ctx, form.Email)
+		switch {
+		case errors.Is(err, errNotFound):
+			user = nil
+		case err != nil:
+			return nil, err
+		case !form.EmailVerified:
+			return nil, fmt.Errorf(
+				"%s did not verify %s, sign in with your password first",
+				form.Provider, form.Email)
+		}
+	}
+	if user == nil {
+		email := ""
+		if form.EmailVerified {
+			email = form.Email
+		}
+		username, err := s.availableUsername(ctx, form.Email, form.Name)
+		if err != nil {
+			return nil, err
+		}
+		// accounts created by sign in have no password until they reset it
+		if user, err = s.createUser(ctx, username, email, "", RoleMember); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := s.repository.InsertUserIdentity(ctx, &UserIdentity{
+		UserID:   user.ID,
+		Provider: form.Provider,
+		Subject:  form.Subject,
+		Email:    form.Email,
+	}); err != nil {
+		return nil, err
+	}
+	return s.repository.FindUserByID(ctx, user.ID)
+}
+
+// availableUsername derives a username matching usernamePattern
+// from the email or name of an identity.
+func (s service) availableUsername(
+	ctx context.Context,
+	email, name string,
+) (string, error) {
+	base := name
+	if at := strings.Index(email, "@"); at > 0 {
+		base = email[:at]
+	}
+	var b strings.Builder
+	for _, r := range strings.ToLower(base) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '_', r == '-':
+			b.WriteRune(r)
+		case r == '.' || r == ' ':
+			b.WriteRune('-')
+		}
+	}
+	username := strings.TrimLeft(b.String(), "0123456789_-")
+	if len(username) < 3 {
+		username = "user-" + username
+	}
+	if len(username) > 26 {
+		username = username[:26]
+	}
+	candidate := username
+	for i := 0; i < 5; i++ {
+		exist, err := s.repository.IsUsernameExist(ctx, candidate)
+		if err != nil {
+			return "", err
+		}
+		if !exist {
+			return candidate, nil
+		}
+		suffix, err := hof.GenerateRandomToken(2)
+		if err != nil {
+			return "", err
+		}
+		candidate = username + "-" + suffix
+	}
+	return "", errors.New("unable to find an available username")
+}
+
+func (s service) startSession(
+	ctx context.Context,
+	user *User,
+	userAgent, ipAddress string,
+) (map[string]interface{}, error) {
+	if user.DisabledAt > 0 {
+		return nil, errors.New("account is disabled")
+	}
+	session := &Session{
+		UserID:    user.ID,
+		UserAgent: userAgent,
+		IPAddress: ipAddress,
+		CreatedAt: time.Now().Unix(),
+	}
+	refreshToken, err := s.renewSession(session)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-	newBooking := Booking{
-		UserID:      userID,
-		EventTypeID: form.EventTypeID,
-		Title:       title,
-		Notes:       form.Notes,
-		Name:        form.Name,
-		Email:       form.Email,
-		Date:        form.Date,
-		Time:        form.Time,
-		Event:       newEvent,
-		Location:    form.MeetingLocation,
+	if session.ID, err = s.repository.InsertSession(ctx, session); err != nil {
+		return nil, err
 	}
-	return s.repository.InsertBooking(ctx, &newBooking)
+	return s.generateTokenPair(user, session, refreshToken)
 }
 
-func newUserService(
-	repository ISQLRepository,
-) IUserService {
-	return &service{repository: repository}
+func (s service) RefreshToken(
+	ctx context.Context,
+	form *RefreshTokenForm,
+) (map[string]interface{}, error) {
+	hash := hof.HashToken(form.RefreshToken)
+	session, err := s.repository.FindSessionByRefreshToken(ctx, hash)
+	if err != nil {
+		return nil, errors.New("refresh token is not valid")
+	}
+	if session.RevokedAt.Valid || session.ExpiresAt < time.Now().Unix() {
+		return nil, errors.New("refresh token is expired or revoked")
+	}
+	// a rotated token presented again means it was leaked,
+	// kill the whole session so neither party can use it
+	if session.RefreshToken != hash {
+		if err := s.repository.RevokeSession(ctx, session.ID); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("refresh token has already been used")
+	}
+	if session.ImpersonatorID > 0 {
+		return nil, errors.New("impersonation sessions cannot be refreshed")
+	}
+	user, err := s.repository.FindUserByID(ctx, session.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user.DisabledAt > 0 {
+		return nil, errors.New("account is disabled")
+	}
+	session.UserAgent = form.UserAgent
+	session.IPAddress = form.IPAddress
+	// rotating only succeeds while the presented token is still current
+	session.PreviousRefreshToken = session.RefreshToken
+	refreshToken, err := s.renewSession(session)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repository.RotateSession(ctx, session); err != nil {
+		return nil, err
+	}
+	return s.generateTokenPair(user, session, refreshToken)
+}
+
+func (s service) Logout(ctx context.Context, jti string) error {
+	session, err := s.repository.FindSessionByJTI(ctx, jti)
+	if err != nil {
+		return err
+	}
+	return s.repository.RevokeSession(ctx, session.ID)
+}
+
+func (s service) LogoutAll(ctx context.Context, uid int) error {
+	return s.repository.RevokeUserSessions(ctx, uid)
+}
+
+func (s service) IsSessionRevoked(ctx context.Context, jti string) (bool, error) {
+	if jti == "" {
+		return true, nil
+	}
+	session, err := s.repository.FindSessionByJTI(ctx, jti)
+	if err != nil {
+		return true, err
+	}
+	return session.RevokedAt.Valid || session.ExpiresAt < time.Now().Unix(), nil
+}
+
+// renewSession assigns a fresh jti and refresh token to the session
+// and returns the plain refresh token, only its hash is stored.
+func (s service) renewSession(session *Session) (string, error) {
+	jti, err := hof.GenerateRandomToken(16)
+	if err != nil {
+		return "", err
+	}
+	refreshToken, err := hof.GenerateRandomToken(32)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	session.JTI = jti
+	session.RefreshToken = hof.HashToken(refreshToken)
+	session.LastUsedAt = now.Unix()
+	session.ExpiresAt = now.Add(refreshTokenLifetime).Unix()
+	return refreshToken, nil
+}
+
+func (s service) generateTokenPair(
+	user *User,
+	session *Session,
+	refreshToken string,
+) (map[string]interface{}, error) {
+	at, ate, err := s.generateToken(user, session)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"type":               "Bearer",
+		"token":              at,
+		"expires_in":         ate,
+		"refresh_token":      refreshToken,
+		"refresh_expires_in": time.Unix(session.ExpiresAt, 0),
+	}, nil
+}
+
+func (s service) Register(
+	ctx context.Context,
+	form *RegisterForm,
+) (*User, error) {
+	if form.Password != form.PasswordConfirmation {
+		return nil, errors.New("password confirmation does not match")
+	}
+	return s.createUser(ctx, form.Username, form.Email, form.Password, RoleMember)
+}
+
+func (s service) NewUser(
+	ctx context.Context,
+	form *NewUserForm,
+) (*User, error) {
+	role := form.Role
+	if role == "" {
+		role = RoleMember
+	}
+	return s.createUser(ctx, form.Username, form.Email, form.Password, role)
+}
+
+// ForgotPassword mails a password reset link to the account with the
+// email of form. The caller learns nothing about whether it exists: the
+// link is sent in the background and failures are only logged.
+func (s service) ForgotPassword(
+	ctx context.Context,
+	form *ForgotPasswordForm,
+) {
+	user, err := s.repository.FindUserByEmail(ctx, form.Email)
+	if err != nil {
+		log.Printf("password reset requested for unknown email: %s", err)
+		return
+	}
+	// the request context ends with the response
+	go func() {
+		if err := s.sendPasswordReset(context.Background(), user); err != nil {
+			log.Printf("unable to send password reset to user %d: %s", user.ID, err)
+		}
+	}()
+}
+
+func (s service) sendPasswordReset(ctx context.Context, user *User) error {
+	token, err := hof.GenerateRandomToken(32)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	reset := &PasswordReset{
+		UserID:    user.ID,
+		Token:     hof.HashToken(token),
+		CreatedAt: now.Unix(),
+		ExpiresAt: now.Add(passwordResetTTL).Unix(),
+	}
+	if reset.ID, err = s.repository.InsertPasswordReset(ctx, reset); err != nil {
+		return err
+	}
+	return s.mailer.Send(ctx, &hof.MailMessage{
+		To:      []string{user.Email},
+		Subject: "Reset your password",
+		Text: fmt.Sprintf("Hi %s,\n\nUse the link below to choose a new password, "+
+			"it expires in %s and can only be used once:\n\n%s/fe/reset-password?token=%s\n\n"+
+			"If you did not request a password reset you can ignore this email.\n",
+			user.Username, passwordResetTTL, appURL, token),
+	})
+}
+
+func (s service) ResetPassword(
+	ctx context.Context,
+	form *ResetPasswordForm,
+) error {
+	if form.Password != form.PasswordConfirmation {
+		return errors.New("password confirmation does not match")
+	}
+	reset, err := s.repository.FindPasswordReset(ctx, hof.HashToken(form.Token))
+	if err != nil {
+		return errors.New("password reset token is not valid")
+	}
+	if reset.UsedAt.Valid || reset.ExpiresAt < time.Now().Unix() {
+		return errors.New("password reset token is expired or already used")
+	}
+	hash, err := s.hashPassword(form.Password)
+	if err != nil {
+		return err
+	}
+	return s.repository.ResetPassword(ctx, reset, hash)
+}
+
+// ChangeEmail sets the email password resets and notifications go to,
+// accounts created before emails were required have none.
+func (s service) ChangeEmail(
+	ctx context.Context,
+	username string,
+	form *ChangeEmailForm,
+) error {
+	user, err := s.Profile(ctx, username, true)
+	if err != nil {
+		return err
+	}
+	if err := s.confirmPassword(user, form.Password); err != nil {
+		return err
+	}
+	if other, err := s.repository.FindUserByEmail(ctx, form.Email); err == nil && other.ID != user.ID {
+		return errors.New("email is already taken")
+	}
+	return s.repository.UpdateUserEmail(ctx, user.ID, form.Email)
+}
+
+func (s service) ChangePassword(
+	ctx context.Context,
+	username string,
+	form *ChangePasswordForm,
+) error {
+	if form.NewPassword != form.PasswordConfirmation {
+		return errors.New("password confirmation does not match")
+	}
+	user, err := s.Profile(ctx, username, true)
+	if err != nil {
+		return err
+	}
+	if err := s.confirmPassword(user, form.CurrentPassword); err != nil {
+		return err
+	}
+	hash, err := s.hashPassword(form.NewPassword)
+	if err != nil {
+		return err
+	}
+	// every other session may belong to whoever knew the old password
+	return s.repository.UpdateUserPassword(ctx, user.ID, hash, form.JTI)
+}
+
+func (s service) DeleteAccount(
+	ctx context.Context,
+	username string,
+	form *DeleteAccountForm,
+) error {
+	user, err := s.Profile(ctx, username, true)
+	if err != nil {
+		return err
+	}
+	if err := s.confirmPassword(user, form.Password); err != nil {
+		return err
+	}
+	return s.repository.DeleteUser(ctx, user.ID)
+}
+
+func (s service) createUser(
+	ctx context.Context,
+	username, email, password, role string,
+) (*User, error) {
+	exist, err := s.repository.IsUsernameExist(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	if exist {
+		return nil, fmt.Errorf(
+			"account with username %s already exist",
+			username)
+	}
+	if email != "" {
+		if exist, err = s.repository.IsEmailExist(ctx, email); err != nil {
+			return nil, err
+		}
+		if exist {
+			return nil, fmt.Errorf(
+				"account with email %s already exist",
+				email)
+		}
+	}
+	var hash string
+	if password != "" {
+		if hash, err = s.hashPassword(password); err != nil {
+			return nil, err
+		}
+	}
+	user := &User{Username: username, Email: email, Password: hash, Role: role}
+	if user.ID, err = s.repository.InsertUser(ctx, user); err != nil {
+		return nil, err
+	}
+	user.Password = ""
+	return user, nil
+}
+
+func (s service) hashPassword(password string) (string, error) {
+	h := hof.PasswordHash{Supplied: password}
+	return h.MakePassword(hof.Parallelization)
+}
+
+// confirmPassword checks the password a signed in user confirms a change
+// with, accounts created by sign in have none until they set one.
+func (s service) confirmPassword(user *User, password string) error {
+	if user.Password == "" {
+		return nil
+	}
+	if password == "" {
+		return errors.New("password is required")
+	}
+	return s.validatePassword(user.Password, password)
+}
+
+func (s service) validatePassword(hash, userPwd string) error {
+	h := hof.PasswordHash{Stored: hash, Supplied: userPwd}
+	isValid, err := h.ComparePassword(hof.Parallelization)
+	if err != nil {
+		return err
+	}
+	if !isValid {
+		return errors.New("password is not valid")
+	}
+	return nil
+}
+
+func (s service) generateToken(
+	user *User,
+	session *Session,
+) (at string, ate *time.Time, err error) {
+	jwtToken := hof.JSONWebToken{ID: session.JTI}
+	jwtToken.IssuedAt = time.Now()
+	tokenExpiredIn := jwtToken.IssuedAt.Add(accessTokenLifetime)
+	jwtToken.ExpiredAt = tokenExpiredIn
+	payload := map[string]interface{}{
+		"id":       user.ID,
+		"username": user.Username,
+		"role":     user.Role,
+	}
+	if session.ImpersonatorID > 0 {
+		payload["impersonator"] = session.ImpersonatorID
+	}
+	accessToken, err := jwtToken.Claim(payload)
+	if err != nil {
+		return "", nil, err
+	}
+	return accessToken, &tokenExpiredIn, nil
+}
+
+func (s service) UpdateEventType(
+	ctx context.Context,
+	uid, id int,
+	form *EventTypeForm,
+) error {
+	if form.Seats > 0 && form.RequiresConfirmation == 1 {
+		return errors.New("event types with seats can not require confirmation")
+	}
+	return s.repository.UpdateEventType(ctx, &EventType{
+		ID:                   id,
+		UserID:               uid,
+		Enable:               form.Enable,
+		Title:                form.Title,
+		Description:          form.Description,
+		Duration:             form.Duration,
+		Seats:                form.Seats,
+		RecurringMax:         form.RecurringMax,
+		RequiresConfirmation: form.RequiresConfirmation,
+		Reminders:            form.Reminders,
+	})
+}
+
+func (s service) UpdateEventTypeQuestions(
+	ctx context.Context,
+	uid, id int,
+	form *QuestionsForm,
+) error {
+	if err := checkQuestions(form.Questions); err != nil {
+		return err
+	}
+	return s.repository.UpdateEventTypeQuestions(ctx, &EventType{
+		ID:        id,
+		UserID:    uid,
+		Questions: form.Questions,
+	})
+}
+
+func (s service) Booking(ctx context.Context, uid int) (*Booking, error) {
+	return s.repository.FindBooking(ctx, uid)
+}
+
+func (s service) Bookings(ctx context.Context, uid int) ([]*Booking, error) {
+	if err := s.repository.ExpireBookings(ctx, time.Now().Unix()); err != nil {
+		return nil, err
+	}
+	return s.repository.FindUserBookings(ctx, uid)
+}
+
+func (s service) APIKeys(ctx context.Context, uid int) ([]*APIKey, error) {
+	return s.repository.FindUserAPIKeys(ctx, uid)
+}
+
+func (s service) NewAPIKey(
+	ctx context.Context,
+	uid int,
+	form *APIKeyForm,
+) (*APIKey, error) {
+	secret, err := hof.GenerateRandomToken(20)
+	if err != nil {
+		return nil, err
+	}
+	plain := hof.APIKeyPrefix + secret
+	now := time.Now()
+	key := &APIKey{
+		UserID:    uid,
+		Name:      form.Name,
+		Prefix:    plain[:len(hof.APIKeyPrefix)+6],
+		Key:       hof.HashToken(plain),
+		Scopes:    form.Scopes,
+		CreatedAt: now.Unix(),
+	}
+	if form.ExpiresInDays > 0 {
+		key.ExpiresAt = now.AddDate(0, 0, form.ExpiresInDays).Unix()
+	}
+	if key.ID, err = s.repository.InsertAPIKey(ctx, key); err != nil {
+		return nil, err
+	}
+	// the only time the plain key is shown
+	key.Key = plain
+	return key, nil
+}
+
+func (s service) RevokeAPIKey(ctx context.Context, uid, id int) error {
+	return s.repository.RevokeAPIKey(ctx, uid, id)
+}
+
+func (s service) AuthenticateAPIKey(
+	ctx context.Context,
+	key string,
+) (*hof.APIKeyPrincipal, error) {
+	apiKey, err := s.repository.FindAPIKey(ctx, hof.HashToken(key))
+	if err != nil {
+		return nil, err
+	}
+	if apiKey.RevokedAt > 0 ||
+		(apiKey.ExpiresAt > 0 && apiKey.ExpiresAt < time.Now().Unix()) {
+		return nil, errors.New("api key is expired or revoked")
+	}
+	user, err := s.repository.FindUserByID(ctx, apiKey.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if user.DisabledAt > 0 {
+		return nil, errors.New("account is disabled")
+	}
+	if err := s.repository.TouchAPIKey(ctx, apiKey.ID); err != nil {
+		log.Printf("unable to update api key last use: %s", err)
+	}
+	return &hof.APIKeyPrincipal{
+		KeyID:    apiKey.ID,
+		UserID:   user.ID,
+		Username: user.Username,
+		Role:     user.Role,
+		Scopes:   apiKey.Scopes,
+	}, nil
+}
+
+func (s service) NewBooking(
+	ctx context.Context,
+	target *BookingTarget,
+	title string,
+	form *BookingForm,
+	event interface{},
+) (int, error) {
+	newEvent, err := json.Marshal(event)
+	if err != nil {
+		return 0, err
+	}
+	newBooking := Booking{
+		UserID:      target.Host.ID,
+		EventTypeID: target.EventType.ID,
+		Title:       title,
+		Notes:       form.Notes,
+		Name:        form.Name,
+		Email:       form.Email,
+		Date:        form.Date,
+		Time:        form.Time,
+		Event:       newEvent,
+		Location:    form.MeetingLocation,
+		StartAt:     target.StartAt.Unix(),
+		EndAt:       target.EndAt.Unix(),
+		Answers:     bookingAnswers(target.EventType.Questions, form.Answers),
+		Status:      BookingConfirmed,
+	}
+	if target.EventType.RequiresConfirmation == 1 {
+		newBooking.Status = BookingPending
+		expiresAt := time.Now().Add(confirmationTTL)
+		if target.StartAt.Before(expiresAt) {
+			expiresAt = target.StartAt
+		}
+		newBooking.ExpiresAt = expiresAt.Unix()
+	}
+	// the calendar event is created, or for a seat joined, by a job stored
+	// along with the booking
+	if event == nil && newBooking.Status == BookingConfirmed &&
+		hasCalendarEvent(target.Host, form.MeetingLocation) {
+		newBooking.SyncStatus = SyncPending
+	}
+	for _, coHost := range target.CoHosts {
+		newBooking.CoHostIDs = append(newBooking.CoHostIDs, coHost.ID)
+	}
+	if target.SeatOf != nil {
+		if newBooking.SeatOf, err = strconv.Atoi(target.SeatOf.ID); err != nil {
+			return 0, err
+		}
+	}
+	var id int
+	bookings := []*Booking{&newBooking}
+	if len(target.Occurrences) < 2 {
+		if id, err = s.repository.InsertBooking(ctx, &newBooking); err != nil {
+			return 0, err
+		}
+	} else {
+		// one booking per occurrence so each one blocks its own time
+		newBooking.Recurrence = hof.WeeklyRule(target.Interval, len(target.Occurrences))
+		duration := target.EndAt.Sub(target.StartAt)
+		bookings = make([]*Booking, 0, len(target.Occurrences))
+		for _, start := range target.Occurrences {
+			occurrence := newBooking
+			occurrence.Date = start.Unix()
+			occurrence.StartAt = start.Unix()
+			occurrence.EndAt = start.Add(duration).Unix()
+			bookings = append(bookings, &occurrence)
+		}
+		if id, err = s.repository.InsertBookingSeries(ctx, bookings); err != nil {
+			return 0, err
+		}
+		newBooking.SeriesID = id
+	}
+	newBooking.ID = strconv.Itoa(id)
+	if newBooking.Status == BookingConfirmed {
+		// with the calendar event pending the job confirms the booking
+		if newBooking.SyncStatus != SyncPending {
+			s.notifyBooking(ctx, target, &newBooking, &bookingNotice{Kind: noticeConfirmed})
+		}
+		s.scheduleReminders(ctx, target.EventType, bookings)
+	}
+	s.fireWebhooks(ctx, WebhookBookingCreated, target, &newBooking)
+	return id, nil
+}
+
+// CancelBooking cancels a booking of the host uid and its calendar event,
+// with form.Series every remaining occurrence of a recurring booking.
+func (s service) CancelBooking(
+	ctx context.Context,
+	uid, bookingID int,
+	form *CancelBookingForm,
+) error {
+	booking, err := s.repository.FindBooking(ctx, bookingID)
+	if err != nil {
+		return err
+	}
+	if booking.UserID != uid {
+		return fmt.Errorf("booking with id %d not found", bookingID)
+	}
+	if booking.CancelledAt > 0 {
+		return errors.New("booking is already cancelled")
+	}
+	if form.Series && booking.SeriesID == 0 {
+		return errors.New("booking is not recurring")
+	}
+	target, err := s.bookedTarget(ctx, booking)
+	if err != nil {
+		return err
+	}
+	// a series keeps the meetings that already started, it ends
+	// with the last of them
+	var until time.Time
+	if form.Series {
+		now, kept := time.Now(), 0
+		for kept < len(target.Occurrences) && !target.Occurrences[kept].After(now) {
+			kept++
+		}
+		if kept == len(target.Occurrences) {
+			return errors.New("every meeting of the series has already started")
+		}
+		if kept > 0 {
+			until = target.Occurrences[kept-1]
+			target.Occurrences = target.Occurrences[:kept]
+			booking.Recurrence = hof.WeeklyRule(target.Interval, kept)
+		}
+	}
+	if err := s.cancelCalendarEvent(ctx, booking, form.Series, until); err != nil {
+		return err
+	}
+	if err := s.repository.CancelBookings(ctx, booking, form.Series); err != nil {
+		return err
+	}
+	booking.Sequence++
+	booking.CancelledAt = time.Now().Unix()
+	s.notifyBooking(ctx, target, booking, &bookingNotice{
+		Kind:       noticeCancelled,
+		Occurrence: booking.SeriesID > 0 && !form.Series,
+		Until:      until,
+	})
+	s.fireWebhooks(ctx, WebhookBookingCancelled, target, booking)
+	return nil
+}
+
+// PendingBooking returns the pending booking of the host uid with the
+// target it was requested for, a booking of a series returns the target
+// of the whole series.
+func (s service) PendingBooking(
+	ctx context.Context,
+	uid, bookingID int,
+) (*BookingTarget, *Booking, error) {
+	booking, err := s.pendingBooking(ctx, uid, bookingID)
+	if err != nil {
+		return nil, nil, err
+	}
+	target, err := s.bookedTarget(ctx, booking)
+	if err != nil {
+		return nil, nil, err
+	}
+	return target, booking, nil
+}
+
+// bookedTarget rebuilds the target a stored booking was made for, for a
+// booking of a series the target of the whole series.
+func (s service) bookedTarget(
+	ctx context.Context,
+	booking *Booking,
+) (*BookingTarget, error) {
+	eventType, err := s.repository.FindEventType(ctx, booking.EventTypeID)
+	if err != nil {
+		return nil, err
+	}
+	host, err := s.repository.FindUserByID(ctx, booking.UserID)
+	if err != nil {
+		return nil, err
+	}
+	target := &BookingTarget{Host: host, EventType: eventType}
+	if eventType.TeamID > 0 {
+		team, err := s.repository.FindTeam(ctx, eventType.TeamID)
+		if err != nil {
+			return nil, err
+		}
+		target.Timezone = team.Timezone
+	} else if eventType.Availability != nil {
+		target.Timezone = eventType.Availability.Timezone
+	}
+	loc, err := time.LoadLocation(target.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("unknown timezone %s", target.Timezone)
+	}
+	occurrences := []*Booking{booking}
+	if booking.SeriesID > 0 {
+		if occurrences, err = s.repository.FindSeriesBookings(ctx, booking.SeriesID); err != nil {
+			return nil, err
+		}
+		_, _ = fmt.Sscanf(booking.Recurrence, "FREQ=WEEKLY;INTERVAL=%d", &target.Interval)
+	}
+	for _, occurrence := range occurrences {
+		target.Occurrences = append(target.Occurrences, time.Unix(occurrence.StartAt, 0).In(loc))
+	}
+	target.StartAt = target.Occurrences[0]
+	target.EndAt = target.StartAt.Add(time.Duration(booking.EndAt-booking.StartAt) * time.Second)
+	bookingID, err := strconv.Atoi(booking.ID)
+	if err != nil {
+		return nil, err
+	}
+	coHostIDs, err := s.repository.FindBookingCoHosts(ctx, bookingID)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range coHostIDs {
+		coHost, err := s.repository.FindUserByID(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		target.CoHosts = append(target.CoHosts, coHost)
+	}
+	return target, nil
+}
+
+// ConfirmBooking confirms a pending booking, its calendar event is
+// created by a job stored along with the confirmation like for a new
+// booking.
+func (s service) ConfirmBooking(
+	ctx context.Context,
+	target *BookingTarget,
+	booking *Booking,
+) error {
+	if hasCalendarEvent(target.Host, booking.Location) {
+		booking.SyncStatus = SyncPending
+	}
+	if err := s.repository.ConfirmBookings(ctx, booking); err != nil {
+		return err
+	}
+	booking.Status = BookingConfirmed
+	booking.ExpiresAt = 0
+	// with the calendar event pending the job confirms the booking
+	if booking.SyncStatus != SyncPending {
+		s.notifyBooking(ctx, target, booking, &bookingNotice{Kind: noticeConfirmed})
+	}
+	occurrences := []*Booking{booking}
+	if booking.SeriesID > 0 {
+		var err error
+		if occurrences, err = s.repository.FindSeriesBookings(ctx, booking.SeriesID); err != nil {
+			return err
+		}
+	}
+	s.scheduleReminders(ctx, target.EventType, occurrences)
+	return nil
+}
+
+// RejectBooking rejects a pending booking of the host uid, releasing
+// the slot it held.
+func (s service) RejectBooking(ctx context.Context, uid, bookingID int) error {
+	booking, err := s.pendingBooking(ctx, uid, bookingID)
+	if err != nil {
+		return err
+	}
+	target, err := s.bookedTarget(ctx, booking)
+	if err != nil {
+		return err
+	}
+	if err := s.repository.UpdatePendingBookingsStatus(ctx, booking, BookingRejected); err != nil {
+		return err
+	}
+	booking.Status = BookingRejected
+	s.notifyBooking(ctx, target, booking, &bookingNotice{
+		Kind:   noticeCancelled,
+		Reason: "The host declined the request.",
+	})
+	s.fireWebhooks(ctx, WebhookBookingCancelled, target, booking)
+	return nil
+}
+
+// RescheduleBooking moves a single booking of the host uid to another time
+// its hosts are free at, the calendar event moves along.
+func (s service) RescheduleBooking(
+	ctx context.Context,
+	uid, bookingID int,
+	form *RescheduleBookingForm,
+) error {
+	booking, err := s.repository.FindBooking(ctx, bookingID)
+	if err != nil {
+		return err
+	}
+	if booking.UserID != uid {
+		return fmt.Errorf("booking with id %d not found", bookingID)
+	}
+	if booking.CancelledAt > 0 {
+		return errors.New("booking is cancelled")
+	}
+	if booking.Status != BookingConfirmed && booking.Status != BookingPending {
+		return fmt.Errorf("booking is %s", booking.Status)
+	}
+	if booking.SeriesID > 0 {
+		return errors.New("recurring bookings can not be rescheduled")
+	}
+	target, err := s.bookedTarget(ctx, booking)
+	if err != nil {
+		return err
+	}
+	if target.EventType.Seats > 0 {
+		return errors.New("bookings of event types with seats can not be rescheduled")
+	}
+	start, err := slotTime(target.Timezone, form.Date, form.Time)
+	if err != nil {
+		return err
+	}
+	end := start.Add(target.EndAt.Sub(target.StartAt))
+	if !start.After(time.Now()) {
+		return errors.New("the selected time has already passed")
+	}
+	if err := s.checkReschedule(ctx, target, start, end); err != nil {
+		return err
+	}
+	if booking.Event, err = s.rescheduleCalendarEvent(ctx, target, booking, start, end); err != nil {
+		return err
+	}
+	previous := target.StartAt
+	booking.Date, booking.Time = start.Unix(), hof.TimeToInt(start)
+	booking.StartAt, booking.EndAt = start.Unix(), end.Unix()
+	if booking.Status == BookingPending && booking.ExpiresAt > booking.StartAt {
+		booking.ExpiresAt = booking.StartAt
+	}
+	if err := s.repository.RescheduleBooking(ctx, booking); err != nil {
+		return err
+	}
+	booking.Sequence++
+	target.StartAt, target.EndAt = start, end
+	target.Occurrences = []time.Time{start}
+	if booking.Status == BookingConfirmed {
+		s.notifyBooking(ctx, target, booking, &bookingNotice{
+			Kind:     noticeRescheduled,
+			Previous: previous,
+		})
+		// reminders of the previous time see the booking moved and stop
+		s.scheduleReminders(ctx, target.EventType, []*Booking{booking})
+	}
+	s.fireWebhooks(ctx, WebhookBookingRescheduled, target, booking)
+	return nil
+}
+
+// checkReschedule reports whether the host and every co-host of target
+// are free from start to end, the time the booking holds now aside.
+func (s service) checkReschedule(
+	ctx context.Context,
+	target *BookingTarget,
+	start, end time.Time,
+) error {
+	_, schedules, err := s.hostSchedules(ctx, target.EventType)
+	if err != nil {
+		return err
+	}
+	hosts := map[int]bool{target.Host.ID: true}
+	for _, coHost := range target.CoHosts {
+		hosts[coHost.ID] = true
+	}
+	var involved []*hostSchedule
+	for _, schedule := range schedules {
+		if hosts[schedule.user.ID] {
+			involved = append(involved, schedule)
+		}
+	}
+	involved = s.loadBusyTimes(ctx, involved, start, end)
+	for _, schedule := range involved {
+		schedule.busy = withoutBusy(schedule.busy, target.StartAt, target.EndAt)
+	}
+	if len(freeHosts(involved, []time.Time{start}, end.Sub(start))) < len(hosts) {
+		return errors.New("the host is not available at the selected time")
+	}
+	return nil
+}
+
+// pendingBooking returns the booking of the host uid waiting for an
+// answer, expiring it when it has not been answered in time.
+func (s service) pendingBooking(
+	ctx context.Context,
+	uid, bookingID int,
+) (*Booking, error) {
+	booking, err := s.repository.FindBooking(ctx, bookingID)
+	if err != nil {
+		return nil, err
+	}
+	if booking.UserID != uid {
+		return nil, fmt.Errorf("booking with id %d not found", bookingID)
+	}
+	if booking.CancelledAt > 0 {
+		return nil, errors.New("booking is cancelled")
+	}
+	if booking.Status == BookingPending && booking.ExpiresAt <= time.Now().Unix() {
+		if err := s.repository.UpdatePendingBookingsStatus(ctx, booking, BookingExpired); err != nil {
+			return nil, err
+		}
+		booking.Status = BookingExpired
+	}
+	if booking.Status != BookingPending {
+		return nil, fmt.Errorf("booking is %s", booking.Status)
+	}
+	return booking, nil
+}
+
+// cancelCalendarEvent removes the booking from the calendar of its host,
+// events of event types with seats are kept for the other invitees. A
+// series with until set ends with the occurrence starting at until.
+func (s service) cancelCalendarEvent(
+	ctx context.Context,
+	booking *Booking,
+	series bool,
+	until time.Time,
+) error {
+	var eventRef struct {
+		ID string `json:"id"`
+	}
+	_ = json.Unmarshal(booking.Event, &eventRef)
+	if eventRef.ID == "" {
+		return nil
+	}
+	eventType, err := s.repository.FindEventType(ctx, booking.EventTypeID)
+	if err != nil {
+		return err
+	}
+	if eventType.Seats > 0 {
+		return nil
+	}
+	user, err := s.repository.FindUserByID(ctx, booking.UserID)
+	if err != nil {
+		return err
+	}
+	occurrence := booking.SeriesID > 0 && !series
+	start := time.Unix(booking.StartAt, 0)
+	if booking.Location == "google" && user.GoogleToken.Valid {
+		tok := &oauth2.Token{}
+		if err := json.Unmarshal([]byte(user.GoogleToken.String), tok); err != nil {
+			return err
+		}
+		calendarService, err := s.googleCalendar(ctx, tok)
+		if err != nil {
+			return err
+		}
+		if occurrence {
+			return hof.CancelGoogleEventOccurrence(calendarService, eventRef.ID, start)
+		}
+		if series && !until.IsZero() {
+			return hof.TruncateGoogleEvent(calendarService, eventRef.ID, until)
+		}
+		return hof.CancelGoogleEvent(calendarService, eventRef.ID)
+	}
+	if booking.Location == "microsoft" && user.MicrosoftToken.Valid {
+		tok := &oauth2.Token{}
+		if err := json.Unmarshal([]byte(user.MicrosoftToken.String), tok); err != nil {
+			return err
+		}
+		graph := s.microsoftGraph(ctx, user, tok)
+		if occurrence {
+			return graph.CancelEventOccurrence(ctx, eventRef.ID, start)
+		}
+		if series && !until.IsZero() {
+			return graph.TruncateEventSeries(ctx, eventRef.ID, until)
+		}
+		return graph.CancelEvent(ctx, eventRef.ID)
+	}
+	return nil
+}
+
+// rescheduleCalendarEvent moves the calendar event of booking to start
+// and end, returning the event to store with the booking.
+func (s service) rescheduleCalendarEvent(
+	ctx context.Context,
+	target *BookingTarget,
+	booking *Booking,
+	start, end time.Time,
+) ([]byte, error) {
+	var eventRef struct {
+		ID string `json:"id"`
+	}
+	_ = json.Unmarshal(booking.Event, &eventRef)
+	if eventRef.ID == "" {
+		return booking.Event, nil
+	}
+	user := target.Host
+	var event interface{}
+	if booking.Location == "google" && user.GoogleToken.Valid {
+		tok := &oauth2.Token{}
+		if err := json.Unmarshal([]byte(user.GoogleToken.String), tok); err != nil {
+			return nil, err
+		}
+		calendarService, err := s.googleCalendar(ctx, tok)
+		if err != nil {
+			return nil, err
+		}
+		googleEvent, err := hof.RescheduleGoogleEvent(calendarService, eventRef.ID,
+			target.Timezone, start, end)
+		if err != nil {
+			return nil, err
+		}
+		event = googleEvent
+	}
+	if booking.Location == "microsoft" && user.MicrosoftToken.Valid {
+		tok := &oauth2.Token{}
+		if err := json.Unmarshal([]byte(user.MicrosoftToken.String), tok); err != nil {
+			return nil, err
+		}
+		microsoftEvent, err := s.microsoftGraph(ctx, user, tok).RescheduleEvent(ctx,
+			eventRef.ID, target.Timezone, start, end)
+		if err != nil {
+			return nil, err
+		}
+		keepJoinURL(microsoftEvent, booking.Event)
+		event = microsoftEvent
+	}
+	if event == nil {
+		return booking.Event, nil
+	}
+	return json.Marshal(event)
+}
+
+// BookingTarget resolves the host and slot of a booking request, for team
+// event types the host is picked among the hosts free at that time.
+func (s service) BookingTarget(
+	ctx context.Context,
+	form *BookingForm,
+) (*BookingTarget, error) {
+	if form.Team != "" {
+		return s.teamBookingTarget(ctx, form)
+	}
+	user, err := s.Profile(ctx, form.Username, false)
+	if err != nil {
+		return nil, err
+	}
+	if user.DisabledAt > 0 {
+		return nil, errors.New("host is not available")
+	}
+	eventType, err := s.repository.FindEventType(ctx, form.EventTypeID)
+	if err != nil || eventType.UserID != user.ID {
+		return nil, fmt.Errorf("event type with id %d not found", form.EventTypeID)
+	}
+	if eventType.Availability == nil {
+		return nil, errors.New("event type has no availability")
+	}
+	start, err := slotTime(eventType.Availability.Timezone, form.Date, form.Time)
+	if err != nil {
+		return nil, err
+	}
+	if !start.After(time.Now()) {
+		return nil, errors.New("the selected time has already passed")
+	}
+	target := &BookingTarget{
+		Host:      user,
+		EventType: eventType,
+		Timezone:  eventType.Availability.Timezone,
+		StartAt:   start,
+		EndAt:     start.Add(time.Duration(eventType.Duration) * time.Minute),
+	}
+	if err := s.recurrence(target, form); err != nil {
+		return nil, err
+	}
+	if err := s.takeSeat(ctx, target); err != nil || target.SeatOf != nil {
+		return target, err
+	}
+	// confirmed and pending bookings hold their slot
+	held, err := s.repository.FindUserBusyBookings(ctx, user.ID,
+		target.StartAt.Unix(), target.EndAt.Unix())
+	if err != nil {
+		return nil, err
+	}
+	if len(held) > 0 {
+		return nil, errSlotTaken
+	}
+	// the working hours and connected calendars of the host, as for the
+	// slots offered
+	_, schedules, err := s.hostSchedules(ctx, eventType)
+	if err != nil {
+		return nil, err
+	}
+	if len(s.occurrenceHosts(ctx, target, schedules)) == 0 {
+		if len(target.Occurrences) > 1 {
+			return nil, errors.New("the host is not available at every occurrence")
+		}
+		return nil, errors.New("the host is not available at the selected time")
+	}
+	return target, nil
+}
+
+// recurrence sets the occurrences of target, a single one unless the
+// form asks for a recurring booking the event type allows.
+func (s service) recurrence(target *BookingTarget, form *BookingForm) error {
+	target.Occurrences = []time.Time{target.StartAt}
+	if form.RecurringCount < 2 {
+		return nil
+	}
+	eventType := target.EventType
+	if eventType.RecurringMax < 2 {
+		return errors.New("event type does not allow recurring bookings")
+	}
+	if form.RecurringCount > eventType.RecurringMax {
+		return fmt.Errorf("event type allows at most %d occurrences", eventType.RecurringMax)
+	}
+	if eventType.Seats > 0 {
+		return errors.New("event types with seats can not be booked recurring")
+	}
+	target.Interval = form.RecurringInterval
+	if target.Interval == 0 {
+		target.Interval = RecurWeekly
+	}
+	target.Occurrences = occurrences(target.StartAt, target.Interval, form.RecurringCount)
+	return nil
+}
+
+// occurrenceHosts loads the busy times of the hosts over every occurrence
+// of target and returns the hosts it can be booked with.
+func (s service) occurrenceHosts(
+	ctx context.Context,
+	target *BookingTarget,
+	schedules []*hostSchedule,
+) []*hostSchedule {
+	duration := target.EndAt.Sub(target.StartAt)
+	last := target.Occurrences[len(target.Occurrences)-1]
+	schedules = s.loadBusyTimes(ctx, schedules, target.StartAt, last.Add(duration))
+	return availableHosts(target.EventType, schedules, target.Occurrences, duration)
+}
+
+// takeSeat points the target at the booking already holding the slot when
+// the event type has seats, the invitee joins it with the same host.
+func (s service) takeSeat(ctx context.Context, target *BookingTarget) error {
+	if target.EventType.Seats == 0 {
+		return nil
+	}
+	seat, err := s.repository.FindSeatBooking(ctx, target.EventType.ID, target.StartAt.Unix())
+	if err != nil || seat == nil {
+		return err
+	}
+	if seat.SeatsTaken >= target.EventType.Seats {
+		return errNoSeatsLeft
+	}
+	if target.Host, err = s.repository.FindUserByID(ctx, seat.UserID); err != nil {
+		return err
+	}
+	target.SeatOf = seat
+	return nil
+}
+
+func (s service) teamBookingTarget(
+	ctx context.Context,
+	form *BookingForm,
+) (*BookingTarget, error) {
+	team, err := s.repository.FindTeamBySlug(ctx, form.Team)
+	if err != nil {
+		return nil, err
+	}
+	eventType, err := s.repository.FindEventType(ctx, form.EventTypeID)
+	if err != nil || eventType.TeamID != team.ID || eventType.Enable != 1 {
+		return nil, fmt.Errorf("event type with id %d not found", form.EventTypeID)
+	}
+	start, err := slotTime(team.Timezone, form.Date, form.Time)
+	if err != nil {
+		return nil, err
+	}
+	end := start.Add(time.Duration(eventType.Duration) * time.Minute)
+	if !start.After(time.Now()) {
+		return nil, errors.New("the selected time has already passed")
+	}
+	target := &BookingTarget{
+		EventType: eventType,
+		Timezone:  team.Timezone,
+		StartAt:   start,
+		EndAt:     end,
+	}
+	if err := s.recurrence(target, form); err != nil {
+		return nil, err
+	}
+	if err := s.takeSeat(ctx, target); err != nil || target.SeatOf != nil {
+		return target, err
+	}
+	_, schedules, err := s.hostSchedules(ctx, eventType)
+	if err != nil {
+		return nil, err
+	}
+	free := s.occurrenceHosts(ctx, target, schedules)
+	if len(free) == 0 && len(target.Occurrences) > 1 {
+		return nil, errors.New("no host is available at every occurrence")
+	}
+	if len(free) == 0 {
+		return nil, errors.New("no host is available at the selected time")
+	}
+	if eventType.SchedulingType == SchedulingCollective {
+		organiser, err := pickOrganiser(free, form.MeetingLocation)
+		if err != nil {
+			return nil, err
+		}
+		target.Host = organiser.user
+		for _, schedule := range free {
+			if schedule == organiser {
+				continue
+			}
+			if schedule.user.Email == "" {
+				return nil, fmt.Errorf("host %s has no email address", schedule.user.Username)
+			}
+			target.CoHosts = append(target.CoHosts, schedule.user)
+		}
+		return target, nil
+	}
+	stats, err := s.repository.FindHostBookingStats(ctx, eventType.ID)
+	if err != nil {
+		return nil, err
+	}
+	// a host without the calendar could not create the meeting
+	if free = connectedHosts(free, form.MeetingLocation); len(free) == 0 {
+		return nil, fmt.Errorf("no available host has connected %s", form.MeetingLocation)
+	}
+	target.Host = pickRoundRobinHost(free, stats).user
+	return target, nil
+}
+
+// Slots returns the start times of the day of date that can be booked,
+// in the timezone of the event type.
+func (s service) Slots(
+	ctx context.Context,
+	eventTypeID int,
+	date int64,
+) ([]*Slot, error) {
+	eventType, err := s.repository.FindEventType(ctx, eventTypeID)
+	if err != nil {
+		return nil, err
+	}
+	if eventType.Enable != 1 {
+		return nil, fmt.Errorf("event type with id %d not found", eventTypeID)
+	}
+	timezone, schedules, err := s.hostSchedules(ctx, eventType)
+	if err != nil {
+		return nil, err
+	}
+	duration := time.Duration(eventType.Duration) * time.Minute
+	candidates, err := daySlots(timezone, date, eventType.Duration)
+	if err != nil {
+		return nil, err
+	}
+	slots := []*Slot{}
+	if len(candidates) == 0 || len(schedules) == 0 {
+		return slots, nil
+	}
+	from, to := candidates[0], candidates[len(candidates)-1].Add(duration)
+	schedules = s.loadBusyTimes(ctx, schedules, from, to)
+	// slots already holding a booking stay open while seats remain
+	seats := map[int64]*Booking{}
+	if eventType.Seats > 0 {
+		bookings, err := s.repository.FindSeatBookings(ctx, eventType.ID, from.Unix(), to.Unix())
+		if err != nil {
+			return nil, err
+		}
+		for _, booking := range bookings {
+			seats[booking.StartAt] = booking
+		}
+	}
+	now := time.Now()
+	for _, start := range candidates {
+		if !start.After(now) {
+			continue
+		}
+		slot := &Slot{Time: hof.TimeToInt(start), StartAt: start.Unix()}
+		if booking, ok := seats[start.Unix()]; ok {
+			if slot.SeatsLeft = eventType.Seats - booking.SeatsTaken; slot.SeatsLeft > 0 {
+				slots = append(slots, slot)
+			}
+			continue
+		}
+		if len(availableHosts(eventType, schedules, []time.Time{start}, duration)) > 0 {
+			slot.SeatsLeft = eventType.Seats
+			slots = append(slots, slot)
+		}
+	}
+	return slots, nil
+}
+
+// hostSchedules returns the timezone slots of the event type are expressed
+// in and the hosts able to take it, without their busy times yet.
+func (s service) hostSchedules(
+	ctx context.Context,
+	eventType *EventType,
+) (string, []*hostSchedule, error) {
+	if eventType.TeamID == 0 {
+		if eventType.Availability == nil {
+			return "", nil, errors.New("event type has no availability")
+		}
+		user, err := s.repository.FindUserByID(ctx, eventType.UserID)
+		if err != nil {
+			return "", nil, err
+		}
+		var schedules []*hostSchedule
+		if user.DisabledAt == 0 {
+			schedules = append(schedules, &hostSchedule{
+				host:         &EventTypeHost{UserID: user.ID, Username: user.Username, Weight: 1},
+				user:         user,
+				availability: eventType.Availability,
+			})
+		}
+		return eventType.Availability.Timezone, schedules, nil
+	}
+	team, err := s.repository.FindTeam(ctx, eventType.TeamID)
+	if err != nil {
+		return "", nil, err
+	}
+	if eventType.Hosts, err = s.repository.FindEventTypeHosts(ctx, eventType.ID); err != nil {
+		return "", nil, err
+	}
+	var schedules []*hostSchedule
+	for _, host := range eventType.Hosts {
+		user, err := s.repository.FindUserByID(ctx, host.UserID)
+		if err != nil || user.DisabledAt > 0 {
+			continue
+		}
+		// hosts without working hours can not take bookings
+		availability, err := s.repository.FindUserAvailability(ctx, host.UserID)
+		if err != nil {
+			continue
+		}
+		schedules = append(schedules, &hostSchedule{
+			host:         host,
+			user:         user,
+			availability: availability,
+		})
+	}
+	return team.Timezone, schedules, nil
+}
+
+// loadBusyTimes fills the busy times of every host between from and to,
+// hosts whose calendar can not be read are left out as their
+// availability is unknown.
+func (s service) loadBusyTimes(
+	ctx context.Context,
+	schedules []*hostSchedule,
+	from, to time.Time,
+) []*hostSchedule {
+	var loaded []*hostSchedule
+	for _, schedule := range schedules {
+		busy, err := s.busyTimes(ctx, schedule.user, from, to)
+		if err != nil {
+			log.Printf("unable to read busy times of user %d: %s", schedule.user.ID, err)
+			continue
+		}
+		schedule.busy = busy
+		loaded = append(loaded, schedule)
+	}
+	return loaded
+}
+
+// busyTimes merges the bookings of the user with the busy
+// periods of the calendars the user connected.
+func (s service) busyTimes(
+	ctx context.Context,
+	user *User,
+	from, to time.Time,
+) ([]hof.BusyTime, error) {
+	busy, err := s.repository.FindUserBusyBookings(ctx, user.ID, from.Unix(), to.Unix())
+	if err != nil {
+		return nil, err
+	}
+	for _, provider := range []string{providerGoogle, providerMicrosoft} {
+		if !calendarToken(user, provider).Valid {
+			continue
+		}
+		calendarBusy, err := s.calendarBusyTimes(ctx, user, provider, from, to)
+		if err != nil {
+			return nil, err
+		}
+		busy = append(busy, calendarBusy...)
+	}
+	return busy, nil
+}
+
+func (s service) Teams(ctx context.Context, uid int) ([]*Team, error) {
+	return s.repository.FindUserTeams(ctx, uid)
+}
+
+func (s service) NewTeam(
+	ctx context.Context,
+	uid int,
+	form *TeamForm,
+) (*Team, error) {
+	if _, err := time.LoadLocation(form.Timezone); err != nil {
+		return nil, fmt.Errorf("unknown timezone %s", form.Timezone)
+	}
+	exist, err := s.repository.IsTeamSlugExist(ctx, form.Slug)
+	if err != nil {
+		return nil, err
+	}
+	if exist {
+		return nil, fmt.Errorf("team %s already exists", form.Slug)
+	}
+	team := &Team{Name: form.Name, Slug: form.Slug, Timezone: form.Timezone}
+	if team.ID, err = s.repository.InsertTeam(ctx, team, uid); err != nil {
+		return nil, err
+	}
+	return s.repository.FindTeam(ctx, team.ID)
+}
+
+// Team returns the team with its members and event types,
+// only members of the team can see it.
+func (s service) Team(ctx context.Context, uid, id int) (*Team, error) {
+	team, err := s.repository.FindTeam(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if team.Members, err = s.repository.FindTeamMembers(ctx, id); err != nil {
+		return nil, err
+	}
+	if teamMember(team.Members, uid) == nil {
+		return nil, fmt.Errorf("team with id %d not found", id)
+	}
+	if team.EventTypes, err = s.teamEventTypes(ctx, id); err != nil {
+		return nil, err
+	}
+	return team, nil
+}
+
+// PublicTeam returns the team and its enabled event types for the booking page.
+func (s service) PublicTeam(ctx context.Context, slug string) (*Team, error) {
+	team, err := s.repository.FindTeamBySlug(ctx, slug)
+	if err != nil {
+		return nil, err
+	}
+	eventTypes, err := s.teamEventTypes(ctx, team.ID)
+	if err != nil {
+		return nil, err
+	}
+	team.EventTypes = []*EventType{}
+	for _, eventType := range eventTypes {
+		if eventType.Enable == 1 {
+			team.EventTypes = append(team.EventTypes, eventType)
+		}
+	}
+	return team, nil
+}
+
+func (s service) teamEventTypes(ctx context.Context, teamID int) ([]*EventType, error) {
+	eventTypes, err := s.repository.FindTeamEventTypes(ctx, teamID)
+	if err != nil {
+		return nil, err
+	}
+	for _, eventType := range eventTypes {
+		if eventType.Hosts, err = s.repository.FindEventTypeHosts(ctx, eventType.ID); err != nil {
+			return nil, err
+		}
+	}
+	return eventTypes, nil
+}
+
+func teamMember(members []*TeamMember, uid int) *TeamMember {
+	for _, member := range members {
+		if member.UserID == uid {
+			return member
+		}
+	}
+	return nil
+}
+
+// teamOwnerMembers returns the members of the team when uid owns it.
+func (s service) teamOwnerMembers(ctx context.Context, uid, teamID int) ([]*TeamMember, error) {
+	if _, err := s.repository.FindTeam(ctx, teamID); err != nil {
+		return nil, err
+	}
+	members, err := s.repository.FindTeamMembers(ctx, teamID)
+	if err != nil {
+		return nil, err
+	}
+	if member := teamMember(members, uid); member == nil || member.Role != TeamRoleOwner {
+		return nil, errors.New("only team owners can manage the team")
+	}
+	return members, nil
+}
+
+func (s service) AddTeamMember(
+	ctx context.Context,
+	uid, teamID int,
+	form *TeamMemberForm,
+) (*TeamMember, error) {
+	members, err := s.teamOwnerMembers(ctx, uid, teamID)
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.repository.FindUserProfile(ctx, form.Username)
+	if err != nil {
+		return nil, err
+	}
+	if teamMember(members, user.ID) != nil {
+		return nil, fmt.Errorf("%s is already a member of the team", user.Username)
+	}
+	member := &TeamMember{
+		TeamID:    teamID,
+		UserID:    user.ID,
+		Username:  user.Username,
+		Role:      form.Role,
+		CreatedAt: time.Now().Unix(),
+	}
+	if member.Role == "" {
+		member.Role = TeamRoleMember
+	}
+	if member.ID, err = s.repository.InsertTeamMember(ctx, member); err != nil {
+		return nil, err
+	}
+	return member, nil
+}
+
+// RemoveTeamMember lets owners remove members and members leave,
+// the last owner can not leave the team.
+func (s service) RemoveTeamMember(
+	ctx context.Context,
+	uid, teamID, memberID int,
+) error {
+	members, err := s.repository.FindTeamMembers(ctx, teamID)
+	if err != nil {
+		return err
+	}
+	actor := teamMember(members, uid)
+	if actor == nil || (actor.Role != TeamRoleOwner && uid != memberID) {
+		return errors.New("only team owners can manage the team")
+	}
+	if member := teamMember(members, memberID); member != nil && member.Role == TeamRoleOwner {
+		owners := 0
+		for _, m := range members {
+			if m.Role == TeamRoleOwner {
+				owners++
+			}
+		}
+		if owners == 1 {
+			return errors.New("the last owner can not leave the team")
+		}
+	}
+	return s.repository.DeleteTeamMember(ctx, teamID, memberID)
+}
+
+// NewTeamEventType creates an event type owned by the team, hosted by the
+// given members or by every member when no host is given.
+func (s service) NewTeamEventType(
+	ctx context.Context,
+	uid, teamID int,
+	form *TeamEventTypeForm,
+) (*EventType, error) {
+	members, err := s.teamOwnerMembers(ctx, uid, teamID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkQuestions(form.Questions); err != nil {
+		return nil, err
+	}
+	if form.Seats > 0 && form.RequiresConfirmation == 1 {
+		return nil, errors.New("event types with seats can not require confirmation")
+	}
+	eventType := &EventType{
+		TeamID:               teamID,
+		Enable:               1,
+		Title:                form.Title,
+		Description:          form.Description,
+		Duration:             form.Duration,
+		SchedulingType:       form.SchedulingType,
+		Seats:                form.Seats,
+		RecurringMax:         form.RecurringMax,
+		Questions:            form.Questions,
+		RequiresConfirmation: form.RequiresConfirmation,
+		Reminders:            form.Reminders,
+	}
+	if eventType.Reminders == nil {
+		eventType.Reminders = defaultReminders
+	}
+	if len(form.Hosts) == 0 {
+		for _, member := range members {
+			eventType.Hosts = append(eventType.Hosts, &EventTypeHost{
+				UserID: member.UserID, Username: member.Username, Weight: 1})
+		}
+	}
+	for _, host := range form.Hosts {
+		var member *TeamMember
+		for _, m := range members {
+			if m.Username == host.Username {
+				member = m
+			}
+		}
+		if member == nil {
+			return nil, fmt.Errorf("%s is not a member of the team", host.Username)
+		}
+		weight := host.Weight
+		if weight == 0 {
+			weight = 1
+		}
+		if weight < 1 || weight > 100 {
+			return nil, errors.New("host weight must be between 1 and 100")
+		}
+		eventType.Hosts = append(eventType.Hosts, &EventTypeHost{
+			UserID: member.UserID, Username: member.Username, Weight: weight})
+	}
+	if eventType.SchedulingType == SchedulingCollective && len(eventType.Hosts) < 2 {
+		return nil, errors.New("collective event types need at least two hosts")
+	}
+	if eventType.ID, err = s.repository.InsertTeamEventType(ctx, eventType); err != nil {
+		return nil, err
+	}
+	return eventType, nil
+}
+
+func (s service) Users(ctx context.Context) ([]*User, error) {
+	return s.repository.FindUsers(ctx)
+}
+
+func (s service) UpdateUser(
+	ctx context.Context,
+	adminID, uid int,
+	form *UpdateUserForm,
+) (*User, error) {
+	// an admin locking themselves out could leave nobody to undo it
+	if adminID == uid {
+		return nil, errors.New("you can not change your own account")
+	}
+	user, err := s.repository.FindUserByID(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	if form.Role != "" && form.Role != user.Role {
+		if err := s.repository.UpdateUserRole(ctx, uid, form.Role); err != nil {
+			return nil, err
+		}
+	}
+	if form.Disabled != nil && *form.Disabled != (user.DisabledAt > 0) {
+		var disabledAt int64
+		if *form.Disabled {
+			disabledAt = time.Now().Unix()
+		}
+		if err := s.repository.UpdateUserDisabled(ctx, uid, disabledAt); err != nil {
+			return nil, err
+		}
+	}
+	user, err = s.repository.FindUserByID(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	user.Password = ""
+	return user, nil
+}
+
+// Impersonate starts a short-lived session as another user for support,
+// the token carries the admin id so it can be told apart and audited.
+func (s service) Impersonate(
+	ctx context.Context,
+	adminID, uid int,
+	userAgent, ipAddress string,
+) (map[string]interface{}, error) {
+	if adminID == uid {
+		return nil, errors.New("you can not impersonate yourself")
+	}
+	user, err := s.repository.FindUserByID(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	if user.Role == RoleAdmin {
+		return nil, errors.New("admins can not be impersonated")
+	}
+	if user.DisabledAt > 0 {
+		return nil, errors.New("account is disabled")
+	}
+	session := &Session{
+		UserID:         user.ID,
+		UserAgent:      userAgent,
+		IPAddress:      ipAddress,
+		CreatedAt:      time.Now().Unix(),
+		ImpersonatorID: adminID,
+	}
+	if _, err := s.renewSession(session); err != nil {
+		return nil, err
+	}
+	session.ExpiresAt = time.Now().Add(impersonationLifetime).Unix()
+	if session.ID, err = s.repository.InsertSession(ctx, session); err != nil {
+		return nil, err
+	}
+	log.Printf("user %d impersonates user %d in session %d", adminID, uid, session.ID)
+	at, ate, err := s.generateToken(user, session)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"type":       "Bearer",
+		"token":      at,
+		"expires_in": ate,
+	}, nil
+}
+
+func (s service) AllBookings(ctx context.Context) ([]*Booking, error) {
+	if err := s.repository.ExpireBookings(ctx, time.Now().Unix()); err != nil {
+		return nil, err
+	}
+	return s.repository.FindAllBookings(ctx)
+}
+
+func newUserService(
+	repository ISQLRepository,
+	mailer hof.IMailSender,
+	jobs hof.IJobScheduler,
+) IUserService {
+	return &service{
+		repository: repository,
+		mailer:     mailer,
+		jobs:       jobs,
+		googleCalendar: func(ctx context.Context, tok *oauth2.Token) (*calendar.Service, error) {
+			return hof.GetGoogleCalendarService(ctx, tok, hof.GetGoogleOAuthConfig())
+		},
+		microsoftOAuth: hof.GetMicrosoftOAuthConfig,
+		graphClient:    hof.GetGraphClientFactory(),
+		sendWebhook:    hof.SendWebhook,
+	}
 }
@@ -0,0 +1,127 @@
+package user
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/0xForked/goca/server/hof"
+)
+
+// nextWorkday returns a weekday after tomorrow in loc at midnight, the
+// example availability of the mentor has working hours 09:00 to 16:00.
+func nextWorkday(loc *time.Location) time.Time {
+	day := time.Now().In(loc).AddDate(0, 0, 2)
+	for day.Weekday() == time.Saturday || day.Weekday() == time.Sunday {
+		day = day.AddDate(0, 0, 1)
+	}
+	return time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, loc)
+}
+
+func TestBookingTargetOfHost(t *testing.T) {
+	loc, err := time.LoadLocation("Asia/Singapore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := nextWorkday(loc)
+	busyFrom := day.Add(10 * time.Hour).UTC()
+	tests := []struct {
+		name    string
+		date    time.Time
+		time    int
+		wantErr string
+	}{
+		{name: "free", date: day, time: 1100},
+		{name: "past", date: day.AddDate(0, 0, -7), time: 1100, wantErr: "already passed"},
+		{name: "outside working hours", date: day, time: 2000, wantErr: "not available"},
+		{name: "busy on the calendar", date: day, time: 1015, wantErr: "not available"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, db, _ := newTestService(t)
+			if _, err := db.Exec("UPDATE users SET google_token = NULL WHERE id = 1"); err != nil {
+				t.Fatal(err)
+			}
+			g := newGraphStandIn(t, s)
+			g.accessToken = "fake"
+			g.routes["GET /me/calendarView"] = map[string]interface{}{
+				"value": []*hof.MSCalendarEvent{{
+					ID:     "event-1",
+					Start:  hof.MSEventStartEnd{DateTime: busyFrom.Format("2006-01-02T15:04:05"), TimeZone: "UTC"},
+					End:    hof.MSEventStartEnd{DateTime: busyFrom.Add(time.Hour).Format("2006-01-02T15:04:05"), TimeZone: "UTC"},
+					ShowAs: "busy",
+				}},
+			}
+			target, err := s.BookingTarget(context.Background(), &BookingForm{
+				Username:    "mentor",
+				EventTypeID: 1,
+				Date:        tt.date.Unix(),
+				Time:        tt.time,
+				Name:        "Ann",
+				Email:       "ann@example.com",
+			})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("BookingTarget() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("BookingTarget() error = %v", err)
+			}
+			if want := day.Add(11 * time.Hour); !target.StartAt.Equal(want) {
+				t.Errorf("StartAt = %s, want %s", target.StartAt, want)
+			}
+		})
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	s, _, _ := newTestService(t)
+	ctx := context.Background()
+	codes, hashes, err := s.generateRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != recoveryCodeCount || len(hashes) != recoveryCodeCount {
+		t.Fatalf("%d codes and %d hashes, want %d", len(codes), len(hashes), recoveryCodeCount)
+	}
+	for i, hash := range hashes {
+		if strings.Contains(hash, normalizeRecoveryCode(codes[i])) {
+			t.Errorf("hash %q holds the code %q", hash, codes[i])
+		}
+	}
+	if err := s.repository.EnableUserTOTP(ctx, 1, 0, hashes); err != nil {
+		t.Fatal(err)
+	}
+	user, err := s.repository.FindUserByID(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		code    string
+		wantErr bool
+	}{
+		{name: "typed in upper case with spaces", code: " " + strings.ToUpper(codes[0]) + " "},
+		{name: "used once only", code: codes[0], wantErr: true},
+		{name: "without the dash", code: strings.ReplaceAll(codes[1], "-", "")},
+		{name: "unknown code", code: "00000-00000", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.useRecoveryCode(ctx, user, tt.code)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("useRecoveryCode(%q) error = %v, want error %v", tt.code, err, tt.wantErr)
+			}
+		})
+	}
+	unused, err := s.repository.FindUnusedRecoveryCodes(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unused) != recoveryCodeCount-2 {
+		t.Errorf("%d unused codes, want %d", len(unused), recoveryCodeCount-2)
+	}
+}
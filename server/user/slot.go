@@ -0,0 +1,203 @@
+package user
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/0xForked/goca/server/hof"
+)
+
+// slotStep is the spacing of offered start times, it matches the
+// granularity of the time picker on the booking page.
+const slotStep = 15 * time.Minute
+
+// slotTime resolves the date and HHMM time of a booking in timezone,
+// the same way the calendar events are created.
+func slotTime(timezone string, date int64, timeInt int) (time.Time, error) {
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("unknown timezone %s", timezone)
+	}
+	day := time.Unix(date, 0).In(loc)
+	return time.Date(day.Year(), day.Month(), day.Day(),
+		timeInt/100, timeInt%100, 0, 0, loc), nil
+}
+
+// daySlots returns every candidate start of the day of date in timezone
+// that leaves room for duration before midnight.
+func daySlots(timezone string, date int64, duration int) ([]time.Time, error) {
+	start, err := slotTime(timezone, date, 0)
+	if err != nil {
+		return nil, err
+	}
+	end := start.AddDate(0, 0, 1)
+	var slots []time.Time
+	for t := start; !t.Add(time.Duration(duration) * time.Minute).After(end); t = t.Add(slotStep) {
+		slots = append(slots, t)
+	}
+	return slots, nil
+}
+
+// withinAvailability reports whether start to end falls inside one enabled
+// day of av, evaluated in the timezone of the availability itself.
+func withinAvailability(av *Availability, start, end time.Time) bool {
+	if av == nil {
+		return false
+	}
+	loc, err := time.LoadLocation(av.Timezone)
+	if err != nil {
+		return false
+	}
+	start, end = start.In(loc), end.In(loc)
+	// a slot crossing midnight would span two availability days
+	if end.YearDay() != start.YearDay() && !(end.Hour() == 0 && end.Minute() == 0) {
+		return false
+	}
+	from := hof.TimeToInt(start)
+	to := hof.TimeToInt(end)
+	if to == 0 {
+		to = 2400
+	}
+	for _, day := range av.Days {
+		if day.Enable == 1 && day.Day == int(start.Weekday()) &&
+			from >= day.StartTime && to <= day.EndTime {
+			return true
+		}
+	}
+	return false
+}
+
+// hostSchedule is what is known about a host while computing slots.
+type hostSchedule struct {
+	host         *EventTypeHost
+	user         *User
+	availability *Availability
+	busy         []hof.BusyTime
+}
+
+func (h *hostSchedule) isFree(start, end time.Time) bool {
+	if !withinAvailability(h.availability, start, end) {
+		return false
+	}
+	for _, b := range h.busy {
+		if b.Start.Before(end) && start.Before(b.End) {
+			return false
+		}
+	}
+	return true
+}
+
+// withoutBusy returns busy without the periods from start to end, the
+// time a booking being moved holds itself.
+func withoutBusy(busy []hof.BusyTime, start, end time.Time) []hof.BusyTime {
+	var kept []hof.BusyTime
+	for _, b := range busy {
+		if !b.Start.Equal(start) || !b.End.Equal(end) {
+			kept = append(kept, b)
+		}
+	}
+	return kept
+}
+
+// freeHosts returns the hosts free for the whole slot at every start.
+func freeHosts(
+	schedules []*hostSchedule,
+	starts []time.Time,
+	duration time.Duration,
+) []*hostSchedule {
+	var free []*hostSchedule
+	for _, schedule := range schedules {
+		isFree := true
+		for _, start := range starts {
+			if !schedule.isFree(start, start.Add(duration)) {
+				isFree = false
+				break
+			}
+		}
+		if isFree {
+			free = append(free, schedule)
+		}
+	}
+	return free
+}
+
+// availableHosts returns the hosts the slot can be booked with at every
+// start, collective event types need every one of their hosts free at once.
+func availableHosts(
+	eventType *EventType,
+	schedules []*hostSchedule,
+	starts []time.Time,
+	duration time.Duration,
+) []*hostSchedule {
+	free := freeHosts(schedules, starts, duration)
+	if eventType.SchedulingType == SchedulingCollective &&
+		len(free) < len(eventType.Hosts) {
+		return nil
+	}
+	return free
+}
+
+// occurrences returns the starts of count meetings repeating every interval
+// weeks from start, at the same wall clock time in its timezone.
+func occurrences(start time.Time, interval, count int) []time.Time {
+	starts := []time.Time{start}
+	for i := 1; i < count; i++ {
+		starts = append(starts, start.AddDate(0, 0, 7*interval*i))
+	}
+	return starts
+}
+
+// pickOrganiser returns the first free host with a calendar connected for
+// the meeting location, the event is created on that calendar.
+func pickOrganiser(free []*hostSchedule, meetingLocation string) (*hostSchedule, error) {
+	connected := connectedHosts(free, meetingLocation)
+	if len(connected) == 0 {
+		return nil, fmt.Errorf("no host has connected %s to host the meeting", meetingLocation)
+	}
+	return connected[0], nil
+}
+
+// connectedHosts returns the hosts of free that can create the event of
+// the meeting location, every host for locations without a calendar.
+func connectedHosts(free []*hostSchedule, meetingLocation string) []*hostSchedule {
+	if meetingLocation != "google" && meetingLocation != "microsoft" {
+		return free
+	}
+	var connected []*hostSchedule
+	for _, schedule := range free {
+		if hasCalendarEvent(schedule.user, meetingLocation) {
+			connected = append(connected, schedule)
+		}
+	}
+	return connected
+}
+
+// hostStat is the booking history of a host for one event type.
+type hostStat struct {
+	Bookings     int
+	LastBookedAt int64
+}
+
+// pickRoundRobinHost returns the free host with the fewest bookings
+// relative to its weight, the least recently booked wins a tie.
+func pickRoundRobinHost(free []*hostSchedule, stats map[int]*hostStat) *hostSchedule {
+	var picked *hostSchedule
+	var pickedScore float64
+	var pickedLast int64
+	for _, schedule := range free {
+		stat := stats[schedule.user.ID]
+		if stat == nil {
+			stat = &hostStat{}
+		}
+		weight := schedule.host.Weight
+		if weight < 1 {
+			weight = 1
+		}
+		score := float64(stat.Bookings) / float64(weight)
+		if picked == nil || score < pickedScore ||
+			(score == pickedScore && stat.LastBookedAt < pickedLast) {
+			picked, pickedScore, pickedLast = schedule, score, stat.LastBookedAt
+		}
+	}
+	return picked
+}
@@ -0,0 +1,180 @@
+package user
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/0xForked/goca/server/hof"
+	"github.com/gin-gonic/gin"
+)
+
+type teamHandler struct {
+	service IUserService
+}
+
+func (h teamHandler) teams(ctx *gin.Context) {
+	var uid int
+	if id, ok := ctx.MustGet("uid").(float64); ok {
+		uid = int(id)
+	}
+	data, err := h.service.Teams(ctx, uid)
+	if err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"data": data})
+}
+
+func (h teamHandler) newTeam(ctx *gin.Context) {
+	var uid int
+	if id, ok := ctx.MustGet("uid").(float64); ok {
+		uid = int(id)
+	}
+	var body TeamForm
+	if err := ctx.ShouldBind(&body); err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err.Error()})
+		return
+	}
+	if err := body.Validate(); err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err})
+		return
+	}
+	data, err := h.service.NewTeam(ctx, uid, &body)
+	if err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusCreated, gin.H{"data": data})
+}
+
+func (h teamHandler) team(ctx *gin.Context) {
+	var uid int
+	if id, ok := ctx.MustGet("uid").(float64); ok {
+		uid = int(id)
+	}
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err.Error()})
+		return
+	}
+	data, err := h.service.Team(ctx, uid, id)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound,
+			gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"data": data})
+}
+
+func (h teamHandler) addMember(ctx *gin.Context) {
+	var uid int
+	if id, ok := ctx.MustGet("uid").(float64); ok {
+		uid = int(id)
+	}
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err.Error()})
+		return
+	}
+	var body TeamMemberForm
+	if err := ctx.ShouldBind(&body); err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err.Error()})
+		return
+	}
+	if err := body.Validate(); err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err})
+		return
+	}
+	data, err := h.service.AddTeamMember(ctx, uid, id, &body)
+	if err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusCreated, gin.H{"data": data})
+}
+
+func (h teamHandler) removeMember(ctx *gin.Context) {
+	var uid int
+	if id, ok := ctx.MustGet("uid").(float64); ok {
+		uid = int(id)
+	}
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err.Error()})
+		return
+	}
+	memberID, err := strconv.Atoi(ctx.Param("uid"))
+	if err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.service.RemoveTeamMember(ctx, uid, id, memberID); err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"data": nil})
+}
+
+func (h teamHandler) newEventType(ctx *gin.Context) {
+	var uid int
+	if id, ok := ctx.MustGet("uid").(float64); ok {
+		uid = int(id)
+	}
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err.Error()})
+		return
+	}
+	var body TeamEventTypeForm
+	if err := ctx.ShouldBindJSON(&body); err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err.Error()})
+		return
+	}
+	if err := body.Validate(); err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err})
+		return
+	}
+	questions := QuestionsForm{Questions: body.Questions}
+	if err := questions.Validate(); err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err})
+		return
+	}
+	data, err := h.service.NewTeamEventType(ctx, uid, id, &body)
+	if err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusCreated, gin.H{"data": data})
+}
+
+func newTeamHandler(
+	service IUserService,
+	router *gin.RouterGroup,
+) {
+	h := &teamHandler{service: service}
+	session := []gin.HandlerFunc{hof.Auth(service), hof.SessionOnly}
+	router.GET("/teams", append(session, h.teams)...)
+	router.POST("/teams", append(session,
+		hof.RequireRole(RoleAdmin, RoleHost), h.newTeam)...)
+	router.GET("/teams/:id", append(session, h.team)...)
+	router.POST("/teams/:id/members", append(session, h.addMember)...)
+	router.DELETE("/teams/:id/members/:uid", append(session, h.removeMember)...)
+	router.POST("/teams/:id/event-types", append(session, h.newEventType)...)
+}
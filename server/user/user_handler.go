@@ -2,7 +2,11 @@ package user
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -29,52 +33,633 @@ func (h handler) login(ctx *gin.Context) {
 			gin.H{"error": err})
 		return
 	}
+	body.UserAgent = ctx.Request.UserAgent()
+	body.IPAddress = ctx.ClientIP()
 	// Call the service
 	data, err := h.service.Login(ctx, &body)
+	if err != nil {
+		var retryErr *hof.RetryAfterError
+		if errors.As(err, &retryErr) {
+			hof.SetRetryAfter(ctx, retryErr.RetryAfter)
+			ctx.JSON(http.StatusTooManyRequests,
+				gin.H{"error": err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusBadRequest,
+			gin.H{"error": err.Error()})
+		return
+	}
+	// two-factor authentication pending, no token yet
+	if data["type"] == "totp" {
+		ctx.JSON(http.StatusOK,
+			gin.H{"data": data})
+		return
+	}
+	setTokenCookies(ctx, data)
+	ctx.JSON(http.StatusOK,
+		gin.H{"data": data})
+}
+
+func (h handler) loginTOTP(ctx *gin.Context) {
+	var body LoginTOTPForm
+	if err := ctx.ShouldBind(&body); err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err.Error()})
+		return
+	}
+	if err := body.Validate(); err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err})
+		return
+	}
+	body.UserAgent = ctx.Request.UserAgent()
+	body.IPAddress = ctx.ClientIP()
+	data, err := h.service.LoginTOTP(ctx, &body)
 	if err != nil {
 		ctx.JSON(http.StatusBadRequest,
 			gin.H{"error": err.Error()})
 		return
 	}
-	ctx.SetCookie("ACCESS_TOKEN", data["token"].(string),
-		1800, "/", "", false, true)
-	ctx.JSON(http.StatusOK,
-		gin.H{"data": data})
+	setTokenCookies(ctx, data)
+	ctx.JSON(http.StatusOK,
+		gin.H{"data": data})
+}
+
+func (h handler) setupTOTP(ctx *gin.Context) {
+	var username string
+	if uname, ok := ctx.MustGet("uname").(string); ok {
+		username = uname
+	}
+	data, err := h.service.SetupTOTP(ctx, username)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest,
+			gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"data": data})
+}
+
+func (h handler) enableTOTP(ctx *gin.Context) {
+	var username string
+	if uname, ok := ctx.MustGet("uname").(string); ok {
+		username = uname
+	}
+	var body TOTPCodeForm
+	if err := ctx.ShouldBind(&body); err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err.Error()})
+		return
+	}
+	if err := body.Validate(); err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err})
+		return
+	}
+	codes, err := h.service.EnableTOTP(ctx, username, &body)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest,
+			gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"data": gin.H{"recovery_codes": codes}})
+}
+
+func (h handler) disableTOTP(ctx *gin.Context) {
+	var username string
+	if uname, ok := ctx.MustGet("uname").(string); ok {
+		username = uname
+	}
+	var body DisableTOTPForm
+	if err := ctx.ShouldBind(&body); err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err.Error()})
+		return
+	}
+	if err := body.Validate(); err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err})
+		return
+	}
+	if err := h.service.DisableTOTP(ctx, username, &body); err != nil {
+		ctx.JSON(http.StatusBadRequest,
+			gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"data": nil})
+}
+
+func (h handler) recoveryCodes(ctx *gin.Context) {
+	var username string
+	if uname, ok := ctx.MustGet("uname").(string); ok {
+		username = uname
+	}
+	var body TOTPCodeForm
+	if err := ctx.ShouldBind(&body); err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err.Error()})
+		return
+	}
+	if err := body.Validate(); err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err})
+		return
+	}
+	codes, err := h.service.RegenerateRecoveryCodes(ctx, username, &body)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest,
+			gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"data": gin.H{"recovery_codes": codes}})
+}
+
+func (h handler) oidcLogin(ctx *gin.Context) {
+	provider, err := hof.GetOIDCProvider(ctx.Param("provider"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound,
+			gin.H{"error": err.Error()})
+		return
+	}
+	state, err := hof.GenerateRandomToken(16)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError,
+			gin.H{"error": err.Error()})
+		return
+	}
+	nonce, err := hof.GenerateRandomToken(16)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError,
+			gin.H{"error": err.Error()})
+		return
+	}
+	ctx.SetCookie("OIDC_STATE", state+"."+nonce,
+		600, "/api/v1/oidc", "", false, true)
+	cfg := provider.OAuthConfig(oidcRedirectURL(provider.Name))
+	ctx.Redirect(http.StatusTemporaryRedirect, cfg.AuthCodeURL(state,
+		oauth2.SetAuthURLParam("nonce", nonce),
+		oauth2.SetAuthURLParam("prompt", "select_account")))
+}
+
+func (h handler) oidcCallback(ctx *gin.Context) {
+	provider, err := hof.GetOIDCProvider(ctx.Param("provider"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound,
+			gin.H{"error": err.Error()})
+		return
+	}
+	cookie, err := ctx.Request.Cookie("OIDC_STATE")
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest,
+			gin.H{"error": "sign in session expired, please try again"})
+		return
+	}
+	ctx.SetCookie("OIDC_STATE", "", -1, "/api/v1/oidc", "", false, true)
+	state, nonce, _ := strings.Cut(cookie.Value, ".")
+	if state == "" || state != ctx.Query("state") {
+		ctx.JSON(http.StatusBadRequest,
+			gin.H{"error": "sign in state does not match"})
+		return
+	}
+	if errMsg := ctx.Query("error"); errMsg != "" {
+		ctx.JSON(http.StatusUnauthorized,
+			gin.H{"error": errMsg})
+		return
+	}
+	cfg := provider.OAuthConfig(oidcRedirectURL(provider.Name))
+	tok, err := cfg.Exchange(ctx, ctx.Query("code"))
+	if err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err.Error()})
+		return
+	}
+	rawIDToken, ok := tok.Extra("id_token").(string)
+	if !ok {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": "provider did not return an id token"})
+		return
+	}
+	claims, err := provider.Verifier.Verify(ctx, rawIDToken, nonce)
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized,
+			gin.H{"error": err.Error()})
+		return
+	}
+	data, err := h.service.OIDCLogin(ctx, &OIDCLoginForm{
+		Provider:      provider.Name,
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: claims.IsEmailVerified(),
+		Name:          claims.Name,
+		UserAgent:     ctx.Request.UserAgent(),
+		IPAddress:     ctx.ClientIP(),
+	})
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest,
+			gin.H{"error": err.Error()})
+		return
+	}
+	if data["type"] == "totp" {
+		// a fragment is not sent on to servers or kept in their logs
+		ctx.Redirect(http.StatusTemporaryRedirect,
+			"/fe/login/totp#challenge_token="+data["challenge_token"].(string))
+		return
+	}
+	setTokenCookies(ctx, data)
+	ctx.Redirect(http.StatusTemporaryRedirect, "/fe/")
+}
+
+func oidcRedirectURL(provider string) string {
+	return fmt.Sprintf("%s/api/v1/oidc/%s/callback", appURL, provider)
+}
+
+func (h handler) refreshToken(ctx *gin.Context) {
+	var body RefreshTokenForm
+	if err := ctx.ShouldBind(&body); err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err.Error()})
+		return
+	}
+	if cookie, err := ctx.Request.Cookie("REFRESH_TOKEN"); err == nil &&
+		body.RefreshToken == "" {
+		body.RefreshToken = cookie.Value
+	}
+	if err := body.Validate(); err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err})
+		return
+	}
+	body.UserAgent = ctx.Request.UserAgent()
+	body.IPAddress = ctx.ClientIP()
+	data, err := h.service.RefreshToken(ctx, &body)
+	if err != nil {
+		hof.ClearCookie(ctx, "ACCESS_TOKEN")
+		hof.ClearCookie(ctx, "REFRESH_TOKEN")
+		ctx.JSON(http.StatusUnauthorized,
+			gin.H{"error": err.Error()})
+		return
+	}
+	setTokenCookies(ctx, data)
+	ctx.JSON(http.StatusOK,
+		gin.H{"data": data})
+}
+
+func setTokenCookies(ctx *gin.Context, data map[string]interface{}) {
+	ctx.SetCookie("ACCESS_TOKEN", data["token"].(string),
+		int(accessTokenLifetime.Seconds()), "/", "", false, true)
+	ctx.SetCookie("REFRESH_TOKEN", data["refresh_token"].(string),
+		int(refreshTokenLifetime.Seconds()), "/", "", false, true)
+}
+
+func (h handler) register(ctx *gin.Context) {
+	var body RegisterForm
+	if err := ctx.ShouldBind(&body); err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err.Error()})
+		return
+	}
+	if err := body.Validate(); err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err})
+		return
+	}
+	data, err := h.service.Register(ctx, &body)
+	if err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusCreated,
+		gin.H{"data": data})
+}
+
+func (h handler) forgotPassword(ctx *gin.Context) {
+	var body ForgotPasswordForm
+	if err := ctx.ShouldBind(&body); err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err.Error()})
+		return
+	}
+	if err := body.Validate(); err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err})
+		return
+	}
+	h.service.ForgotPassword(ctx, &body)
+	ctx.JSON(http.StatusAccepted, gin.H{"data": nil})
+}
+
+func (h handler) resetPassword(ctx *gin.Context) {
+	var body ResetPasswordForm
+	if err := ctx.ShouldBind(&body); err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err.Error()})
+		return
+	}
+	if err := body.Validate(); err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err})
+		return
+	}
+	if err := h.service.ResetPassword(ctx, &body); err != nil {
+		ctx.JSON(http.StatusBadRequest,
+			gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"data": nil})
+}
+
+func (h handler) changeEmail(ctx *gin.Context) {
+	var username string
+	if uname, ok := ctx.MustGet("uname").(string); ok {
+		username = uname
+	}
+	var body ChangeEmailForm
+	if err := ctx.ShouldBind(&body); err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err.Error()})
+		return
+	}
+	if err := body.Validate(); err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err})
+		return
+	}
+	if err := h.service.ChangeEmail(ctx, username, &body); err != nil {
+		ctx.JSON(http.StatusBadRequest,
+			gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"data": nil})
+}
+
+func (h handler) changePassword(ctx *gin.Context) {
+	var username string
+	if uname, ok := ctx.MustGet("uname").(string); ok {
+		username = uname
+	}
+	var body ChangePasswordForm
+	if err := ctx.ShouldBind(&body); err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err.Error()})
+		return
+	}
+	if err := body.Validate(); err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err})
+		return
+	}
+	if id, ok := ctx.MustGet("jti").(string); ok {
+		body.JTI = id
+	}
+	if err := h.service.ChangePassword(ctx, username, &body); err != nil {
+		ctx.JSON(http.StatusBadRequest,
+			gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"data": nil})
+}
+
+func (h handler) deleteAccount(ctx *gin.Context) {
+	var username string
+	if uname, ok := ctx.MustGet("uname").(string); ok {
+		username = uname
+	}
+	var body DeleteAccountForm
+	if err := ctx.ShouldBind(&body); err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err.Error()})
+		return
+	}
+	if err := body.Validate(); err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err})
+		return
+	}
+	if err := h.service.DeleteAccount(ctx, username, &body); err != nil {
+		ctx.JSON(http.StatusBadRequest,
+			gin.H{"error": err.Error()})
+		return
+	}
+	hof.ClearCookie(ctx, "ACCESS_TOKEN")
+	hof.ClearCookie(ctx, "REFRESH_TOKEN")
+	ctx.JSON(http.StatusOK, gin.H{"data": nil})
+}
+
+func (h handler) logout(ctx *gin.Context) {
+	var jti string
+	if id, ok := ctx.MustGet("jti").(string); ok {
+		jti = id
+	}
+	if err := h.service.Logout(ctx, jti); err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err.Error()})
+		return
+	}
+	hof.ClearCookie(ctx, "ACCESS_TOKEN")
+	hof.ClearCookie(ctx, "REFRESH_TOKEN")
+	ctx.JSON(http.StatusUnauthorized, nil)
+}
+
+func (h handler) logoutAll(ctx *gin.Context) {
+	var uid int
+	if id, ok := ctx.MustGet("uid").(float64); ok {
+		uid = int(id)
+	}
+	if err := h.service.LogoutAll(ctx, uid); err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err.Error()})
+		return
+	}
+	hof.ClearCookie(ctx, "ACCESS_TOKEN")
+	hof.ClearCookie(ctx, "REFRESH_TOKEN")
+	ctx.JSON(http.StatusUnauthorized, nil)
+}
+
+func (h handler) profile(ctx *gin.Context) {
+	var username string
+	if uname, ok := ctx.MustGet("uname").(string); ok {
+		username = uname
+	}
+	data, err := h.service.Profile(ctx, username, false)
+	if err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK,
+		gin.H{"data": data})
+}
+
+func (h handler) availability(ctx *gin.Context) {
+	var uid int
+	if id, ok := ctx.MustGet("uid").(float64); ok {
+		uid = int(id)
+	}
+	data, err := h.service.Availability(ctx, uid)
+	if err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"data": data})
+}
+
+func (h handler) eventType(ctx *gin.Context) {
+	var uid int
+	if id, ok := ctx.MustGet("uid").(float64); ok {
+		uid = int(id)
+	}
+	var username string
+	if uname, ok := ctx.MustGet("uname").(string); ok {
+		username = uname
+	}
+	data, err := h.service.EventType(ctx, uid, username)
+	if err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"data": data})
+}
+
+func (h handler) updateEventType(ctx *gin.Context) {
+	var uid int
+	if id, ok := ctx.MustGet("uid").(float64); ok {
+		uid = int(id)
+	}
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err.Error()})
+		return
+	}
+	var body EventTypeForm
+	if err := ctx.ShouldBind(&body); err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err.Error()})
+		return
+	}
+	if err := body.Validate(); err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err})
+		return
+	}
+	if err := h.service.UpdateEventType(ctx, uid, id, &body); err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"data": nil})
+}
+
+func (h handler) updateEventTypeQuestions(ctx *gin.Context) {
+	var uid int
+	if id, ok := ctx.MustGet("uid").(float64); ok {
+		uid = int(id)
+	}
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err.Error()})
+		return
+	}
+	var body QuestionsForm
+	if err := ctx.ShouldBindJSON(&body); err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err.Error()})
+		return
+	}
+	if err := body.Validate(); err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err})
+		return
+	}
+	if err := h.service.UpdateEventTypeQuestions(ctx, uid, id, &body); err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"data": nil})
+}
+
+func (h handler) bookings(ctx *gin.Context) {
+	var uid int
+	if id, ok := ctx.MustGet("uid").(float64); ok {
+		uid = int(id)
+	}
+	data, err := h.service.Bookings(ctx, uid)
+	if err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"data": data})
 }
 
-func (h handler) logout(ctx *gin.Context) {
-	http.SetCookie(ctx.Writer, &http.Cookie{
-		Name:    "ACCESS_TOKEN",
-		Value:   "",
-		MaxAge:  -1,
-		Domain:  "http://localhost:8000",
-		Path:    "/",
-		Expires: time.Now().Add(-time.Hour),
-	})
-	ctx.JSON(http.StatusUnauthorized, nil)
+func (h handler) apiKeys(ctx *gin.Context) {
+	var uid int
+	if id, ok := ctx.MustGet("uid").(float64); ok {
+		uid = int(id)
+	}
+	data, err := h.service.APIKeys(ctx, uid)
+	if err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"data": data})
 }
 
-func (h handler) profile(ctx *gin.Context) {
-	var username string
-	if uname, ok := ctx.MustGet("uname").(string); ok {
-		username = uname
+func (h handler) newAPIKey(ctx *gin.Context) {
+	var uid int
+	if id, ok := ctx.MustGet("uid").(float64); ok {
+		uid = int(id)
 	}
-	data, err := h.service.Profile(ctx, username, false)
+	var body APIKeyForm
+	if err := ctx.ShouldBind(&body); err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err.Error()})
+		return
+	}
+	if err := body.Validate(); err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err})
+		return
+	}
+	data, err := h.service.NewAPIKey(ctx, uid, &body)
 	if err != nil {
 		ctx.JSON(http.StatusUnprocessableEntity,
 			gin.H{"error": err.Error()})
 		return
 	}
-	ctx.JSON(http.StatusOK,
-		gin.H{"data": data})
+	ctx.JSON(http.StatusCreated, gin.H{"data": data})
 }
 
-func (h handler) availability(ctx *gin.Context) {
+func (h handler) revokeAPIKey(ctx *gin.Context) {
 	var uid int
 	if id, ok := ctx.MustGet("uid").(float64); ok {
 		uid = int(id)
 	}
-	data, err := h.service.Availability(ctx, uid)
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.service.RevokeAPIKey(ctx, uid, id); err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"data": nil})
+}
+
+func (h handler) webhooks(ctx *gin.Context) {
+	var uid int
+	if id, ok := ctx.MustGet("uid").(float64); ok {
+		uid = int(id)
+	}
+	data, err := h.service.Webhooks(ctx, uid)
 	if err != nil {
 		ctx.JSON(http.StatusUnprocessableEntity,
 			gin.H{"error": err.Error()})
@@ -83,16 +668,82 @@ func (h handler) availability(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, gin.H{"data": data})
 }
 
-func (h handler) eventType(ctx *gin.Context) {
+func (h handler) newWebhook(ctx *gin.Context) {
 	var uid int
 	if id, ok := ctx.MustGet("uid").(float64); ok {
 		uid = int(id)
 	}
-	var username string
-	if uname, ok := ctx.MustGet("uname").(string); ok {
-		username = uname
+	var body WebhookForm
+	if err := ctx.ShouldBind(&body); err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err.Error()})
+		return
 	}
-	data, err := h.service.EventType(ctx, uid, username)
+	if err := body.Validate(); err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err})
+		return
+	}
+	data, err := h.service.NewWebhook(ctx, uid, &body)
+	if err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusCreated, gin.H{"data": data})
+}
+
+func (h handler) deleteWebhook(ctx *gin.Context) {
+	var uid int
+	if id, ok := ctx.MustGet("uid").(float64); ok {
+		uid = int(id)
+	}
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.service.DeleteWebhook(ctx, uid, id); err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"data": nil})
+}
+
+func (h handler) webhookDeliveries(ctx *gin.Context) {
+	var uid int
+	if id, ok := ctx.MustGet("uid").(float64); ok {
+		uid = int(id)
+	}
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err.Error()})
+		return
+	}
+	data, err := h.service.WebhookDeliveries(ctx, uid, id)
+	if err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"data": data})
+}
+
+func (h handler) testWebhook(ctx *gin.Context) {
+	var uid int
+	if id, ok := ctx.MustGet("uid").(float64); ok {
+		uid = int(id)
+	}
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err.Error()})
+		return
+	}
+	data, err := h.service.TestWebhook(ctx, uid, id)
 	if err != nil {
 		ctx.JSON(http.StatusUnprocessableEntity,
 			gin.H{"error": err.Error()})
@@ -101,17 +752,128 @@ func (h handler) eventType(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, gin.H{"data": data})
 }
 
+func (h handler) cancelBooking(ctx *gin.Context) {
+	var uid int
+	if id, ok := ctx.MustGet("uid").(float64); ok {
+		uid = int(id)
+	}
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err.Error()})
+		return
+	}
+	var body CancelBookingForm
+	if err := ctx.ShouldBind(&body); err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.service.CancelBooking(ctx, uid, id, &body); err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"data": nil})
+}
+
+func (h handler) acceptBooking(ctx *gin.Context) {
+	var uid int
+	if id, ok := ctx.MustGet("uid").(float64); ok {
+		uid = int(id)
+	}
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err.Error()})
+		return
+	}
+	target, booking, err := h.service.PendingBooking(ctx, uid, id)
+	if err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.service.ConfirmBooking(ctx, target, booking); err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err.Error()})
+		return
+	}
+	// the calendar event is created in the background like for new bookings
+	if booking.SyncStatus == SyncPending {
+		ctx.JSON(http.StatusOK, gin.H{"data": gin.H{"sync_status": SyncPending}})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"data": nil})
+}
+
+func (h handler) rejectBooking(ctx *gin.Context) {
+	var uid int
+	if id, ok := ctx.MustGet("uid").(float64); ok {
+		uid = int(id)
+	}
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.service.RejectBooking(ctx, uid, id); err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"data": nil})
+}
+
+func (h handler) rescheduleBooking(ctx *gin.Context) {
+	var uid int
+	if id, ok := ctx.MustGet("uid").(float64); ok {
+		uid = int(id)
+	}
+	id, err := strconv.Atoi(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err.Error()})
+		return
+	}
+	var body RescheduleBookingForm
+	if err := ctx.ShouldBind(&body); err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err.Error()})
+		return
+	}
+	if err := body.Validate(); err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err})
+		return
+	}
+	if err := h.service.RescheduleBooking(ctx, uid, id, &body); err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"data": nil})
+}
+
 func (h handler) event(ctx *gin.Context) {
-	var googleEvents []*calendar.Event
 	var username, googleAuthURL, microsoftAuthURL,
 		googleEmail, googleName, microsoftName, microsoftEmail string
 	var wg sync.WaitGroup
 	var mu sync.Mutex
+	// a provider that can not be reached is reported, not failing the rest
+	profileErrors := map[string]string{}
 	googleAuthToken, microsoftAuthToken := &oauth2.Token{}, &oauth2.Token{}
 	// get user internal data
 	if uname, ok := ctx.MustGet("uname").(string); ok {
 		username = uname
 	}
+	from, to, limit, err := eventRange(ctx)
+	if err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err.Error()})
+		return
+	}
 	data, err := h.service.Profile(ctx, username, false)
 	if err != nil {
 		ctx.JSON(http.StatusBadRequest,
@@ -147,22 +909,7 @@ func (h handler) event(ctx *gin.Context) {
 			googleAuthURL, googleAuthToken = hof.GetGoogleOAuthTokenFromWeb(cfg)
 		}
 		if googleAuthURL == "" {
-			wg.Add(2)
-			// get user calendar data
-			go func() {
-				defer wg.Done()
-				calendarService := hof.GetGoogleCalendarService(ctx, googleAuthToken, cfg)
-				event, err := hof.GetGoogleCalendarData(calendarService)
-				if err != nil {
-					mu.Lock()
-					defer mu.Unlock()
-					ctx.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
-					return
-				}
-				mu.Lock()
-				googleEvents = event
-				mu.Unlock()
-			}()
+			wg.Add(1)
 			// get user profile from oauth
 			go func() {
 				defer wg.Done()
@@ -170,7 +917,7 @@ func (h handler) event(ctx *gin.Context) {
 				if err != nil {
 					mu.Lock()
 					defer mu.Unlock()
-					ctx.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+					profileErrors[providerGoogle] = err.Error()
 					return
 				}
 				mu.Lock()
@@ -192,35 +939,97 @@ func (h handler) event(ctx *gin.Context) {
 			// get user profile
 			go func() {
 				defer wg.Done()
-				userData, err := hof.GetMicrosoftUserProfile(microsoftAuthToken.AccessToken)
+				profile, err := h.service.MicrosoftProfile(ctx, microsoftAuthToken)
 				if err != nil {
 					mu.Lock()
 					defer mu.Unlock()
-					ctx.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+					profileErrors[providerMicrosoft] = err.Error()
 					return
 				}
 				mu.Lock()
-				if userData != nil {
-					microsoftName = userData["name"]
-					microsoftEmail = userData["email"]
+				microsoftName = profile.DisplayName
+				// accounts without a mailbox sign in with their principal name
+				microsoftEmail = profile.Mail
+				if microsoftEmail == "" {
+					microsoftEmail = profile.UserPrincipalName
 				}
 				mu.Unlock()
 			}()
 			wg.Wait()
 		}
 	}
+	// get upcoming events of every connected calendar, the calendars that
+	// could not be read are in calendar_errors
+	events, failed := h.service.Events(ctx, data, from, to, limit)
+	for provider, reason := range profileErrors {
+		if failed == nil {
+			failed = map[string]string{}
+		}
+		if _, ok := failed[provider]; !ok {
+			failed[provider] = reason
+		}
+	}
+	// google_scheduled and microsoft_scheduled are kept for older clients
+	var googleEvents, microsoftEvents []*ExternalEvent
+	for _, event := range events {
+		if event.Provider == providerMicrosoft {
+			microsoftEvents = append(microsoftEvents, event)
+		} else {
+			googleEvents = append(googleEvents, event)
+		}
+	}
 	//return data
 	ctx.JSON(http.StatusOK, gin.H{
-		"google_name":        googleName,
-		"google_email":       googleEmail,
-		"google_scheduled":   googleEvents,
-		"google_auth_url":    googleAuthURL,
-		"microsoft_auth_url": microsoftAuthURL,
-		"microsoft_name":     microsoftName,
-		"microsoft_email":    microsoftEmail,
+		"google_name":         googleName,
+		"google_email":        googleEmail,
+		"google_scheduled":    googleEvents,
+		"google_auth_url":     googleAuthURL,
+		"microsoft_auth_url":  microsoftAuthURL,
+		"microsoft_name":      microsoftName,
+		"microsoft_email":     microsoftEmail,
+		"microsoft_scheduled": microsoftEvents,
+		"scheduled":           events,
+		"calendar_errors":     failed,
 	})
 }
 
+const (
+	eventRangeDefault = time.Hour * 24 * 30
+	eventRangeMax     = time.Hour * 24 * 366
+	eventLimitDefault = 10
+	eventLimitMax     = 250
+)
+
+// eventRange reads the from and to unix timestamps and the limit of an
+// events request, by default the next 10 events of the coming 30 days.
+func eventRange(ctx *gin.Context) (from, to time.Time, limit int, err error) {
+	from, to, limit = time.Now(), time.Time{}, eventLimitDefault
+	if value := ctx.Query("from"); value != "" {
+		unix, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return from, to, limit, errors.New("from must be a unix timestamp")
+		}
+		from = time.Unix(unix, 0)
+	}
+	to = from.Add(eventRangeDefault)
+	if value := ctx.Query("to"); value != "" {
+		unix, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return from, to, limit, errors.New("to must be a unix timestamp")
+		}
+		to = time.Unix(unix, 0)
+	}
+	if !to.After(from) || to.Sub(from) > eventRangeMax {
+		return from, to, limit, errors.New("to must be after from and within a year of it")
+	}
+	if value := ctx.Query("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 || limit > eventLimitMax {
+			return from, to, limit, fmt.Errorf("limit must be between 1 and %d", eventLimitMax)
+		}
+	}
+	return from, to, limit, nil
+}
+
 func (h handler) googleExchange(ctx *gin.Context) {
 	var username string
 	if uname, ok := ctx.MustGet("uname").(string); ok {
@@ -243,6 +1052,53 @@ func (h handler) googleExchange(ctx *gin.Context) {
 	ctx.Redirect(http.StatusTemporaryRedirect, "/fe/")
 }
 
+// googleNotification receives the notifications of the channels watching
+// the Google calendars of hosts, they carry no body, only headers.
+func (h handler) googleNotification(ctx *gin.Context) {
+	err := h.service.GoogleNotification(ctx,
+		ctx.GetHeader("X-Goog-Channel-ID"),
+		ctx.GetHeader("X-Goog-Channel-Token"),
+		ctx.GetHeader("X-Goog-Resource-ID"),
+		ctx.GetHeader("X-Goog-Resource-State"))
+	switch {
+	case errors.Is(err, errUnknownChannel):
+		ctx.JSON(http.StatusNotFound,
+			gin.H{"error": err.Error()})
+	case errors.Is(err, errChannelToken):
+		ctx.JSON(http.StatusForbidden,
+			gin.H{"error": err.Error()})
+	case err != nil:
+		ctx.JSON(http.StatusInternalServerError,
+			gin.H{"error": err.Error()})
+	default:
+		ctx.Status(http.StatusOK)
+	}
+}
+
+// microsoftNotification receives the change notifications of the graph
+// subscriptions to the Microsoft calendars of hosts.
+func (h handler) microsoftNotification(ctx *gin.Context) {
+	// graph checks the endpoint echoes the token before it subscribes
+	if token := ctx.Query("validationToken"); token != "" {
+		ctx.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(token))
+		return
+	}
+	var body struct {
+		Value []hof.MSNotification `json:"value"`
+	}
+	if err := ctx.ShouldBindJSON(&body); err != nil {
+		ctx.JSON(http.StatusUnprocessableEntity,
+			gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.service.MicrosoftNotifications(ctx, body.Value); err != nil {
+		ctx.JSON(http.StatusInternalServerError,
+			gin.H{"error": err.Error()})
+		return
+	}
+	ctx.Status(http.StatusAccepted)
+}
+
 func (h handler) microsoftExchange(ctx *gin.Context) {
 	var username string
 	if uname, ok := ctx.MustGet("uname").(string); ok {
@@ -265,17 +1121,80 @@ func (h handler) microsoftExchange(ctx *gin.Context) {
 	ctx.Redirect(http.StatusTemporaryRedirect, "/fe/")
 }
 
+var (
+	loginIPLimit = hof.RateLimit{
+		Name: "login-ip", Burst: 10, Per: time.Minute}
+	loginUsernameLimit = hof.RateLimit{
+		Name: "login-username", Burst: 5, Per: time.Minute}
+	loginTOTPIPLimit = hof.RateLimit{
+		Name: "login-totp-ip", Burst: 10, Per: time.Minute}
+	registerIPLimit = hof.RateLimit{
+		Name: "register-ip", Burst: 5, Per: time.Hour}
+	passwordResetIPLimit = hof.RateLimit{
+		Name: "password-reset-ip", Burst: 5, Per: time.Hour}
+)
+
 func newUserHandler(
 	service IUserService,
 	router *gin.RouterGroup,
+	limiter hof.IRateLimitStore,
 ) {
 	h := &handler{service: service}
-	router.POST("/login", h.login)
-	router.POST("/logout", h.logout)
-	router.GET("/profile", hof.Auth, h.profile)
-	router.GET("/profile/availabilities", hof.Auth, h.availability)
-	router.GET("/profile/event-types", hof.Auth, h.eventType)
-	router.GET("/profile/events", hof.Auth, h.event)
-	router.GET("/profile/google/exchange", hof.Auth, h.googleExchange)
-	router.GET("/profile/microsoft/exchange", hof.Auth, h.microsoftExchange)
+	auth := hof.Auth(service)
+	loginLimit := []gin.HandlerFunc{
+		hof.RateLimiter(limiter, loginIPLimit, hof.RateLimitByIP),
+		hof.RateLimiter(limiter, loginUsernameLimit, hof.RateLimitByBodyField("username")),
+	}
+	router.POST("/login", append(loginLimit, h.login)...)
+	router.POST("/login/totp",
+		hof.RateLimiter(limiter, loginTOTPIPLimit, hof.RateLimitByIP), h.loginTOTP)
+	session := []gin.HandlerFunc{auth, hof.SessionOnly}
+	account := []gin.HandlerFunc{auth, hof.SessionOnly, hof.NotImpersonating}
+	router.POST("/logout", append(session, h.logout)...)
+	router.POST("/logout/all", append(session, h.logoutAll)...)
+	router.POST("/token/refresh", h.refreshToken)
+	router.POST("/register",
+		hof.RateLimiter(limiter, registerIPLimit, hof.RateLimitByIP), h.register)
+	router.GET("/oidc/:provider/login", h.oidcLogin)
+	router.GET("/oidc/:provider/callback", h.oidcCallback)
+	router.POST("/password/forgot",
+		hof.RateLimiter(limiter, passwordResetIPLimit, hof.RateLimitByIP), h.forgotPassword)
+	router.POST("/password/reset",
+		hof.RateLimiter(limiter, passwordResetIPLimit, hof.RateLimitByIP), h.resetPassword)
+	router.GET("/profile", auth, hof.RequireScope(ScopeProfileRead), h.profile)
+	router.DELETE("/profile", append(account, h.deleteAccount)...)
+	router.PATCH("/profile/email", append(account, h.changeEmail)...)
+	router.PATCH("/profile/password", append(account, h.changePassword)...)
+	router.POST("/profile/totp", append(account, h.setupTOTP)...)
+	router.POST("/profile/totp/enable", append(account, h.enableTOTP)...)
+	router.DELETE("/profile/totp", append(account, h.disableTOTP)...)
+	router.POST("/profile/totp/recovery-codes", append(account, h.recoveryCodes)...)
+	router.GET("/profile/api-keys", append(session, h.apiKeys)...)
+	router.POST("/profile/api-keys", append(account, h.newAPIKey)...)
+	router.DELETE("/profile/api-keys/:id", append(account, h.revokeAPIKey)...)
+	router.GET("/profile/webhooks", append(session, h.webhooks)...)
+	router.POST("/profile/webhooks", append(account, h.newWebhook)...)
+	router.DELETE("/profile/webhooks/:id", append(account, h.deleteWebhook)...)
+	router.GET("/profile/webhooks/:id/deliveries", append(session, h.webhookDeliveries)...)
+	router.POST("/profile/webhooks/:id/test", append(session, h.testWebhook)...)
+	router.GET("/profile/availabilities", auth,
+		hof.RequireScope(ScopeAvailabilityRead), h.availability)
+	router.GET("/profile/event-types", auth,
+		hof.RequireScope(ScopeEventTypesRead), h.eventType)
+	router.PUT("/profile/event-types/:id", auth, hof.RequireRole(RoleAdmin, RoleHost),
+		hof.RequireScope(ScopeEventTypesWrite), h.updateEventType)
+	router.PUT("/profile/event-types/:id/questions", auth, hof.RequireRole(RoleAdmin, RoleHost),
+		hof.RequireScope(ScopeEventTypesWrite), h.updateEventTypeQuestions)
+	router.GET("/profile/bookings", auth,
+		hof.RequireScope(ScopeBookingsRead), h.bookings)
+	router.POST("/profile/bookings/:id/cancel", append(session, h.cancelBooking)...)
+	router.POST("/profile/bookings/:id/accept", append(session, h.acceptBooking)...)
+	router.POST("/profile/bookings/:id/reject", append(session, h.rejectBooking)...)
+	router.POST("/profile/bookings/:id/reschedule", append(session, h.rescheduleBooking)...)
+	router.GET("/profile/events", auth,
+		hof.RequireScope(ScopeCalendarRead), h.event)
+	router.GET("/profile/google/exchange", append(account, h.googleExchange)...)
+	router.GET("/profile/microsoft/exchange", append(account, h.microsoftExchange)...)
+	router.POST("/google/notifications", h.googleNotification)
+	router.POST("/microsoft/notifications", h.microsoftNotification)
 }
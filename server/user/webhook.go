@@ -0,0 +1,241 @@
+package user
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+
+	"github.com/0xForked/goca/server/hof"
+)
+
+// jobWebhookDelivery is the job kind sending one webhook delivery, failed
+// attempts are retried with the backoff of the job scheduler.
+const jobWebhookDelivery = "webhook.delivery"
+
+// webhookMaxAttempts is how often a delivery is sent before it is failed.
+const webhookMaxAttempts = 6
+
+// webhookPayload is the json body of a delivery.
+type webhookPayload struct {
+	Event     string      `json:"event"`
+	CreatedAt int64       `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+type webhookBooking struct {
+	Booking   *Booking   `json:"booking"`
+	EventType *EventType `json:"event_type"`
+}
+
+// deliveryJob is the payload of a webhook delivery job.
+type deliveryJob struct {
+	DeliveryID int `json:"delivery_id"`
+}
+
+func (s service) Webhooks(ctx context.Context, uid int) ([]*Webhook, error) {
+	webhooks, err := s.repository.FindUserWebhooks(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	for _, webhook := range webhooks {
+		webhook.Secret = ""
+	}
+	return webhooks, nil
+}
+
+func (s service) NewWebhook(
+	ctx context.Context,
+	uid int,
+	form *WebhookForm,
+) (*Webhook, error) {
+	if err := hof.CheckWebhookURL(ctx, form.URL); err != nil {
+		return nil, err
+	}
+	target, err := url.Parse(form.URL)
+	if err != nil {
+		return nil, err
+	}
+	webhook := &Webhook{
+		UserID:    uid,
+		URL:       target.String(),
+		Secret:    form.Secret,
+		Events:    form.Events,
+		CreatedAt: time.Now().Unix(),
+	}
+	if webhook.Secret == "" {
+		if webhook.Secret, err = hof.GenerateRandomToken(24); err != nil {
+			return nil, err
+		}
+	}
+	if webhook.ID, err = s.repository.InsertWebhook(ctx, webhook); err != nil {
+		return nil, err
+	}
+	// the only time the secret is shown
+	return webhook, nil
+}
+
+func (s service) DeleteWebhook(ctx context.Context, uid, id int) error {
+	return s.repository.DeleteWebhook(ctx, uid, id)
+}
+
+func (s service) WebhookDeliveries(
+	ctx context.Context,
+	uid, id int,
+) ([]*WebhookDelivery, error) {
+	if _, err := s.repository.FindUserWebhook(ctx, uid, id); err != nil {
+		return nil, err
+	}
+	return s.repository.FindWebhookDeliveries(ctx, id)
+}
+
+// TestWebhook sends a webhook.test delivery right away and returns
+// its outcome, it is not retried. Like every test delivery it is kept
+// without the response body.
+func (s service) TestWebhook(
+	ctx context.Context,
+	uid, id int,
+) (*WebhookDelivery, error) {
+	webhook, err := s.repository.FindUserWebhook(ctx, uid, id)
+	if err != nil {
+		return nil, err
+	}
+	delivery, err := s.newDelivery(ctx, webhook, WebhookTest, map[string]interface{}{
+		"webhook_id": webhook.ID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	delivery.Webhook = webhook
+	_ = s.deliver(ctx, delivery, 1)
+	return delivery, nil
+}
+
+// fireWebhooks queues a delivery of event to every webhook of the hosts
+// of booking subscribed to it. The booking already changed, so failures
+// are only logged.
+func (s service) fireWebhooks(
+	ctx context.Context,
+	event string,
+	target *BookingTarget,
+	booking *Booking,
+) {
+	detail := *booking
+	if len(booking.Event) > 0 {
+		_ = json.Unmarshal(booking.Event, &detail.EventDetail)
+		detail.JoinURL = joinURL(booking.Event)
+	}
+	data := &webhookBooking{Booking: &detail, EventType: target.EventType}
+	for _, host := range append([]*User{target.Host}, target.CoHosts...) {
+		webhooks, err := s.repository.FindUserWebhooks(ctx, host.ID)
+		if err != nil {
+			log.Printf("unable to find webhooks of user %d: %s", host.ID, err)
+			continue
+		}
+		for _, webhook := range webhooks {
+			if !subscribed(webhook, event) {
+				continue
+			}
+			delivery, err := s.newDelivery(ctx, webhook, event, data)
+			if err == nil {
+				err = s.jobs.Schedule(ctx, jobWebhookDelivery,
+					&deliveryJob{DeliveryID: delivery.ID}, time.Now())
+			}
+			if err != nil {
+				log.Printf("unable to queue %s delivery to webhook %d: %s",
+					event, webhook.ID, err)
+			}
+		}
+	}
+}
+
+func subscribed(webhook *Webhook, event string) bool {
+	for _, e := range webhook.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// newDelivery stores a pending delivery of event with data to webhook.
+func (s service) newDelivery(
+	ctx context.Context,
+	webhook *Webhook,
+	event string,
+	data interface{},
+) (*WebhookDelivery, error) {
+	now := time.Now().Unix()
+	payload, err := json.Marshal(&webhookPayload{Event: event, CreatedAt: now, Data: data})
+	if err != nil {
+		return nil, err
+	}
+	delivery := &WebhookDelivery{
+		WebhookID: webhook.ID,
+		Event:     event,
+		Payload:   payload,
+		Status:    DeliveryPending,
+		CreatedAt: now,
+	}
+	if delivery.ID, err = s.repository.InsertWebhookDelivery(ctx, delivery); err != nil {
+		return nil, err
+	}
+	return delivery, nil
+}
+
+// DeliverWebhook runs a webhook delivery job, deliveries of deleted
+// webhooks are dropped.
+func (s service) DeliverWebhook(ctx context.Context, payload []byte) error {
+	var job deliveryJob
+	if err := json.Unmarshal(payload, &job); err != nil {
+		return err
+	}
+	delivery, err := s.repository.FindWebhookDelivery(ctx, job.DeliveryID)
+	if err != nil {
+		return err
+	}
+	if delivery == nil || delivery.Status != DeliveryPending {
+		return nil
+	}
+	return s.deliver(ctx, delivery, webhookMaxAttempts)
+}
+
+// deliver sends delivery once and records the outcome, the delivery is
+// failed when the attempt was the last of maxAttempts.
+func (s service) deliver(
+	ctx context.Context,
+	delivery *WebhookDelivery,
+	maxAttempts int,
+) error {
+	delivery.Attempts++
+	resp, err := s.sendWebhook(ctx, delivery.Webhook.URL, delivery.Webhook.Secret,
+		delivery.Event, delivery.ID, delivery.Payload)
+	delivery.ResponseStatus, delivery.ResponseBody, delivery.Error = 0, "", ""
+	if resp != nil {
+		delivery.ResponseStatus = resp.Status
+		// a test is sent on demand to any url, keeping its answer would
+		// let the deliveries be used to read other servers
+		if delivery.Event != WebhookTest {
+			delivery.ResponseBody = resp.Body
+		}
+	}
+	switch {
+	case err == nil:
+		delivery.Status = DeliveryDelivered
+		delivery.DeliveredAt = time.Now().Unix()
+	case delivery.Attempts >= maxAttempts:
+		delivery.Status = DeliveryFailed
+		delivery.Error = err.Error()
+	default:
+		delivery.Error = err.Error()
+	}
+	if updateErr := s.repository.UpdateWebhookDelivery(ctx, delivery); updateErr != nil {
+		return updateErr
+	}
+	if delivery.Status == DeliveryPending {
+		return fmt.Errorf("delivery %d attempt %d: %s", delivery.ID, delivery.Attempts, err)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package user
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/0xForked/goca/server/hof"
+)
+
+func TestWebhookTestKeepsNoResponseBody(t *testing.T) {
+	s, _, _ := newTestService(t)
+	s.sendWebhook = func(context.Context, string, string, string, int, []byte) (*hof.WebhookResponse, error) {
+		return &hof.WebhookResponse{Status: http.StatusOK, Body: "internal answer"}, nil
+	}
+	ctx := context.Background()
+	webhook := &Webhook{UserID: 1, URL: "https://hooks.example.com/goca", Secret: "s3cret",
+		Events: []string{WebhookBookingCreated}, CreatedAt: time.Now().Unix()}
+	var err error
+	if webhook.ID, err = s.repository.InsertWebhook(ctx, webhook); err != nil {
+		t.Fatal(err)
+	}
+	tested, err := s.TestWebhook(ctx, 1, webhook.ID)
+	if err != nil {
+		t.Fatalf("TestWebhook() error = %v", err)
+	}
+	if tested.ResponseStatus != http.StatusOK || tested.ResponseBody != "" {
+		t.Errorf("test delivery = %d %q, want the status only", tested.ResponseStatus, tested.ResponseBody)
+	}
+	// a delivery of a booking keeps the answer of the receiver
+	delivery, err := s.newDelivery(ctx, webhook, WebhookBookingCreated, map[string]interface{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, _ := json.Marshal(&deliveryJob{DeliveryID: delivery.ID})
+	if err := s.DeliverWebhook(ctx, payload); err != nil {
+		t.Fatalf("DeliverWebhook() error = %v", err)
+	}
+	deliveries, err := s.WebhookDeliveries(ctx, 1, webhook.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 2 {
+		t.Fatalf("%d deliveries listed, want 2", len(deliveries))
+	}
+	for _, listed := range deliveries {
+		want := "internal answer"
+		if listed.Event == WebhookTest {
+			want = ""
+		}
+		if listed.ResponseStatus != http.StatusOK || listed.ResponseBody != want {
+			t.Errorf("%s delivery listed with %d %q, want %q", listed.Event,
+				listed.ResponseStatus, listed.ResponseBody, want)
+		}
+	}
+}
@@ -48,6 +48,11 @@ export function LoginDialog(props: LoginDialogProps) {
                     })
                     return
                 }
+                // two-factor accounts confirm with a code first
+                if (resp.data.type == "totp") {
+                    window.location.href = `/fe/login/totp#challenge_token=${resp.data.challenge_token}`
+                    return
+                }
                 if (resp.data.token != "") {
                     window.location.reload()
                 }
@@ -3,6 +3,7 @@ export const API_URL = `http://localhost:8000/api/v1`
 export const API_ENDPOINT = {
     USER: {
         LOGIN: `${API_URL}/login`,
+        LOGIN_TOTP: `${API_URL}/login/totp`,
         PROFILE: `${API_URL}/profile`,
         AVAILABILITY: `${API_URL}/profile/availabilities`,
         EVENT_TYPE: `${API_URL}/profile/event-types`,
@@ -10,6 +11,7 @@ export const API_ENDPOINT = {
         BOOKING: `${API_URL}/booking`,
         SCHEDULE: `${API_URL}/schedule`,
         LOGOUT: `${API_URL}/logout`,
+        RESET_PASSWORD: `${API_URL}/password/reset`,
     },
 }
 
@@ -28,6 +30,24 @@ const signIn = async (username: string, password: string)  => {
     }
 }
 
+const signInTOTP = async (challengeToken: string, code: string)  => {
+    try {
+        const response = await fetch(API_ENDPOINT.USER.LOGIN_TOTP, {
+            method: "POST",
+            headers: {'Content-Type': 'application/json'},
+            credentials: 'include',
+            // anything but six digits is a recovery code
+            body: JSON.stringify(/^\d{6}$/.test(code)
+                ? {challenge_token: challengeToken, code}
+                : {challenge_token: challengeToken, recovery_code: code})
+        })
+        const content = await response.json();
+        return Promise.resolve(content)
+    } catch (e) {
+        return Promise.reject(e)
+    }
+}
+
 const signOut = async () => {
     try {
         const response = await fetch(API_ENDPOINT.USER.LOGOUT, {
@@ -155,8 +175,23 @@ const getSchedule = async (id: string)  => {
     }
 }
 
+const resetPassword = async (token: string, password: string, passwordConfirmation: string) => {
+    try {
+        const response = await fetch(API_ENDPOINT.USER.RESET_PASSWORD, {
+            method: "POST",
+            headers: {'Content-Type': 'application/json'},
+            body: JSON.stringify({token, password, password_confirmation: passwordConfirmation})
+        })
+        const content = await response.json();
+        return Promise.resolve(content)
+    } catch (e) {
+        return Promise.reject(e)
+    }
+}
+
 export  {
     signIn,
+    signInTOTP,
     signOut,
     getProfile,
     getEvent,
@@ -164,5 +199,6 @@ export  {
     getEventType,
     getBookingHost,
     newBooking,
-    getSchedule
+    getSchedule,
+    resetPassword
 }
\ No newline at end of file
@@ -5,6 +5,8 @@ import './index.css'
 import {BrowserRouter, Route, Routes} from "react-router-dom";
 import {Booking} from "@/pages/booking.tsx";
 import {Schedule} from "@/pages/schedule.tsx";
+import {ResetPassword} from "@/pages/reset-password.tsx";
+import {LoginTOTP} from "@/pages/login-totp.tsx";
 
 ReactDOM.createRoot(document.getElementById('root')!).render(
   <React.StrictMode>
@@ -13,6 +15,8 @@ ReactDOM.createRoot(document.getElementById('root')!).render(
               <Route path="/" element={<Home/>} />
               <Route path="/booking/:username" element={<Booking/>} />
               <Route path="/schedule/:id" element={<Schedule/>} />
+              <Route path="/reset-password" element={<ResetPassword/>} />
+              <Route path="/login/totp" element={<LoginTOTP/>} />
               <Route path="*" element={<>
                   <div className="flex flex-col my-12 text-center">
                       <h1 className="text-lg font-bold mb-2">Not Found</h1>
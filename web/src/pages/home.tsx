@@ -23,8 +23,7 @@ function Home() {
   const [googleEmail, setGoogleEmail] = useState<string | null>(null)
   const [microsoftDisplayName, setMicrosoftDisplayName] = useState<string | null>(null)
   const [microsoftEmail, setMicrosoftEmail] = useState<string | null>(null)
-  const [googleScheduledEvents, setGoogleScheduledEvents] = useState([]);
-  const [microsoftScheduledEvents, setMicrosoftScheduledEvents] = useState([]);
+  const [scheduledEvents, setScheduledEvents] = useState([]);
 
   useEffect(() => getUser(), [])
 
@@ -55,11 +54,8 @@ function Home() {
         setMicrosoftDisplayName(resp.microsoft_name)
         setMicrosoftEmail(resp.microsoft_email)
       }
-      if (resp.google_scheduled) {
-        setGoogleScheduledEvents(resp.google_scheduled)
-      }
-      if (resp.microsoft_scheduled) {
-        setMicrosoftScheduledEvents(resp.microsoft_scheduled)
+      if (resp.scheduled) {
+        setScheduledEvents(resp.scheduled)
       }
     }).catch((error) => alert(error))
   }
@@ -144,47 +140,23 @@ function Home() {
           </section>
 
           <section className="border-2 p-4">
-            <h1 className="text-xl font-bold">Incoming Events (Google)</h1>
+            <h1 className="text-xl font-bold">Incoming Events</h1>
             <hr className="my-4"/>
             <div className="flex flex-col">
-              {googleScheduledEvents.length == 0 && <div className="mx-auto">No Events</div>}
-              {googleScheduledEvents && <Accordion type="single" collapsible>
-                {googleScheduledEvents.map((item: any, index) => (
-                  <AccordionItem className="border-b" value={item?.id} key={index}>
+              {scheduledEvents.length == 0 && <div className="mx-auto">No Events</div>}
+              {scheduledEvents && <Accordion type="single" collapsible>
+                {scheduledEvents.map((item: any, index) => (
+                  <AccordionItem className="border-b" value={`${item?.provider}-${item?.id}`} key={index}>
                     <AccordionTrigger>
                       <div className="flex flex-col items-baseline gap-2">
                         <h5 className="text-sm font-bold text-gray-600">
-                          {item?.summary}
+                          {item?.title} ({item?.provider})
                         </h5>
                         <p className="text-xs font-light">
-                          {item?.hangoutLink}
+                          {new Date(item?.start_at * 1000).toLocaleString()}
                         </p>
-                      </div>
-                    </AccordionTrigger>
-                    <AccordionContent className="flex flex-col">
-                      <pre>{JSON.stringify(item, null, 2)}</pre>
-                    </AccordionContent>
-                  </AccordionItem>
-                ))}
-              </Accordion>}
-            </div>
-          </section>
-
-          <section className="border-2 p-4 mt-4">
-            <h1 className="text-xl font-bold">Incoming Events (Microsoft)</h1>
-            <hr className="my-4"/>
-            <div className="flex flex-col">
-              {microsoftScheduledEvents.length == 0 && <div className="mx-auto">No Events</div>}
-              {microsoftScheduledEvents && <Accordion type="single" collapsible>
-                {microsoftScheduledEvents.map((item: any, index) => (
-                  <AccordionItem className="border-b" value={item?.id} key={index}>
-                    <AccordionTrigger>
-                      <div className="flex flex-col items-center gap-2">
-                        <h5 className="text-sm font-bold text-gray-600">
-                          {item?.summary}
-                        </h5>
-                        <p className="ml-[-70px] text-xs font-light">
-                          {item?.hangoutLink}
+                        <p className="text-xs font-light">
+                          {item?.meeting_url}
                         </p>
                       </div>
                     </AccordionTrigger>
@@ -0,0 +1,58 @@
+import {FormEvent, useEffect, useState} from "react";
+import {Label} from "@/components/ui/label.tsx";
+import {Input} from "@/components/ui/input.tsx";
+import {Button} from "@/components/ui/button.tsx";
+import {Loader2} from "lucide-react";
+import {signInTOTP} from "@/lib/api.ts";
+
+export function LoginTOTP() {
+    // the challenge comes in the fragment so it is never sent to a server or logged
+    const [challengeToken] = useState(() =>
+        new URLSearchParams(window.location.hash.slice(1)).get("challenge_token") ?? "")
+    useEffect(() => {
+        window.history.replaceState(null, "", window.location.pathname)
+    }, [])
+    const [code, setCode] = useState("")
+    const [error, setError] = useState("")
+    const [submitting, setSubmitting] = useState(false)
+
+    const onSubmit = (e: FormEvent) => {
+        e.preventDefault()
+        setSubmitting(true)
+        signInTOTP(challengeToken, code)
+            .then((resp) => {
+                if (resp.error) {
+                    setError(typeof resp.error === "string" ? resp.error : JSON.stringify(resp.error))
+                    return
+                }
+                window.location.href = "/fe/"
+            })
+            .catch((err) => setError(err.message))
+            .finally(() => setSubmitting(false))
+    }
+
+    if (!challengeToken) {
+        return <div className="flex flex-col my-12 text-center">
+            <span className="text-sm">the sign in challenge is missing, <a href="/fe/" className="underline text-blue-400">sign in again</a></span>
+        </div>
+    }
+
+    return <div className="max-w-sm mx-auto my-12">
+        <h1 className="text-lg font-bold mb-4">Two-factor authentication</h1>
+        <form onSubmit={onSubmit} className="space-y-4">
+            <div className="space-y-2">
+                <Label htmlFor="code">Code</Label>
+                <Input id="code" autoComplete="one-time-code" placeholder="e.g: 123456"
+                       value={code} onChange={(e) => setCode(e.target.value)}/>
+                <p className="text-sm text-muted-foreground">
+                    Enter the code of your authenticator app or a recovery code
+                </p>
+            </div>
+            {error ? <p className="text-sm text-red-500">{error}</p> : <></>}
+            <Button type="submit" disabled={submitting}>
+                {submitting ? <Loader2 className="mr-2 h-4 w-4 animate-spin"/> : <></>}
+                Verify
+            </Button>
+        </form>
+    </div>
+}
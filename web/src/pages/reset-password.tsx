@@ -0,0 +1,66 @@
+import {useSearchParams} from "react-router-dom";
+import {FormEvent, useState} from "react";
+import {Label} from "@/components/ui/label.tsx";
+import {Input} from "@/components/ui/input.tsx";
+import {Button} from "@/components/ui/button.tsx";
+import {Loader2} from "lucide-react";
+import {resetPassword} from "@/lib/api.ts";
+
+export function ResetPassword() {
+    const [params] = useSearchParams();
+    const token = params.get("token") ?? ""
+    const [password, setPassword] = useState("")
+    const [confirmation, setConfirmation] = useState("")
+    const [error, setError] = useState("")
+    const [done, setDone] = useState(false)
+    const [submitting, setSubmitting] = useState(false)
+
+    const onSubmit = (e: FormEvent) => {
+        e.preventDefault()
+        setSubmitting(true)
+        resetPassword(token, password, confirmation)
+            .then((resp) => {
+                if (resp.error) {
+                    setError(typeof resp.error === "string" ? resp.error : JSON.stringify(resp.error))
+                    return
+                }
+                setDone(true)
+            })
+            .catch((err) => setError(err.message))
+            .finally(() => setSubmitting(false))
+    }
+
+    if (!token) {
+        return <div className="flex flex-col my-12 text-center">
+            <span className="text-sm">the reset link is missing its token</span>
+        </div>
+    }
+
+    if (done) {
+        return <div className="flex flex-col my-12 text-center">
+            <h1 className="text-lg font-bold mb-2">Password changed</h1>
+            <a href="/fe/" className="text-sm underline text-blue-400">sign in with your new password</a>
+        </div>
+    }
+
+    return <div className="max-w-sm mx-auto my-12">
+        <h1 className="text-lg font-bold mb-4">Choose a new password</h1>
+        <form onSubmit={onSubmit} className="space-y-4">
+            <div className="space-y-2">
+                <Label htmlFor="password">Password</Label>
+                <Input id="password" type="password" placeholder="* * * * *"
+                       value={password} onChange={(e) => setPassword(e.target.value)}/>
+            </div>
+            <div className="space-y-2">
+                <Label htmlFor="password_confirmation">Confirm password</Label>
+                <Input id="password_confirmation" type="password" placeholder="* * * * *"
+                       value={confirmation} onChange={(e) => setConfirmation(e.target.value)}/>
+            </div>
+            {error ? <p className="text-sm text-red-500">{error}</p> : <></>}
+            <Button type="submit" disabled={submitting}>
+                {submitting ? <Loader2 className="mr-2 h-4 w-4 animate-spin"/> : <></>}
+                Reset Password
+            </Button>
+        </form>
+    </div>
+}